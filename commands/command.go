@@ -76,10 +76,39 @@ func RegisterModel() {
 		new(models.Star),
 		new(models.Score),
 		new(models.Comments),
+		new(models.Comment),
+		new(models.CommentVote),
 		new(models.Gitee),
 		new(models.Github),
 		new(models.QQ),
 		new(models.DocumentStore),
+		new(models.DocumentPermission),
+		new(models.Team),
+		new(models.TeamMember),
+		new(models.TeamRelationship),
+		new(models.Organization),
+		new(models.OrganizationMember),
+		new(models.BookShare),
+		new(models.BookShareAttempt),
+		new(models.Annotation),
+		new(models.AnnotationReply),
+		new(models.Notification),
+		new(models.NotificationSetting),
+		new(models.Watch),
+		new(models.BookReviewer),
+		new(models.DocumentReview),
+		new(models.BookVersion),
+		new(models.BookVersionDocument),
+		new(models.BookBranch),
+		new(models.BranchDocument),
+		new(models.RecycleBin),
+		new(models.DocumentInclude),
+		new(models.LinkCheck),
+		new(models.DocumentLink),
+		new(models.BookLabel),
+		new(models.DocumentLabel),
+		new(models.DocumentMeta),
+		new(models.DocumentMetaField),
 	)
 	migrate.RegisterMigration()
 }
@@ -126,6 +155,9 @@ func RegisterCommand() {
 	} else if len(os.Args) >= 2 && os.Args[1] == "migrate" {
 		ResolveCommand(os.Args[2:])
 		migrate.RunMigration()
+	} else if len(os.Args) >= 2 && os.Args[1] == "smtp" {
+		RunMailServer(os.Args[2:])
+		os.Exit(0)
 	}
 }
 
@@ -3,10 +3,12 @@ package daemon
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/JermineHu/DocStack/commands"
 	"github.com/JermineHu/DocStack/conf"
 	"github.com/JermineHu/DocStack/controllers"
+	"github.com/JermineHu/DocStack/models"
 	"github.com/astaxie/beego"
 	"github.com/kardianos/service"
 )
@@ -49,12 +51,22 @@ func (d *Daemon) Run() {
 
 	beego.ErrorController(&controllers.ErrorController{})
 
+	models.StartNotificationDigest()
+	models.StartScheduledPublish()
+	models.StartHistoryPruning()
+	models.StartRecyclePurge()
+	models.MigrateBookLabels()
+
 	fmt.Printf("DocStack version => %s\nbuild time => %s\nstart directory => %s\n%s\n", conf.VERSION, conf.BUILD_TIME, os.Args[0], conf.GO_VERSION)
 
 	beego.Run()
 }
 
 func (d *Daemon) Stop(s service.Service) error {
+	//退出前将队列中的日志写入数据库
+	if err := models.CloseLogger(10 * time.Second); err != nil {
+		beego.Error("Close logger error => ", err)
+	}
 	if service.Interactive() {
 		os.Exit(0)
 	}
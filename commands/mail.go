@@ -0,0 +1,168 @@
+package commands
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var mailSequence int64
+
+//本地SMTP调试服务.
+//用于开发和测试环境代替真实的邮件服务器，收到的邮件会保存为 .eml 文件，不会真正投递.
+//使用方式：DocStack smtp -addr 127.0.0.1:1025 -dir cache/mail
+func RunMailServer(args []string) {
+	flagSet := flag.NewFlagSet("DocStack smtp: ", flag.ExitOnError)
+	addr := flagSet.String("addr", "127.0.0.1:1025", "SMTP listen address.")
+	dir := flagSet.String("dir", filepath.Join("cache", "mail"), "Directory to save received mails.")
+	flagSet.Parse(args)
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		log.Fatal("Create mail directory error => ", err)
+	}
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal("SMTP listen error => ", err)
+	}
+	fmt.Printf("SMTP server listening on %s, mails will be saved to %s\n", *addr, *dir)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Println("SMTP accept error => ", err)
+			continue
+		}
+		go handleMailConn(conn, *dir)
+	}
+}
+
+//处理一个SMTP会话.
+func handleMailConn(conn net.Conn, dir string) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(format string, a ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", a...)
+	}
+	var (
+		from string
+		to   []string
+	)
+	reply("220 DocStack SMTP ready")
+
+	for {
+		conn.SetDeadline(time.Now().Add(5 * time.Minute))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			reply("250-DocStack")
+			reply("250-AUTH PLAIN LOGIN")
+			reply("250 8BITMIME")
+		case strings.HasPrefix(cmd, "HELO"):
+			reply("250 DocStack")
+		case strings.HasPrefix(cmd, "AUTH"):
+			//调试服务不校验账号密码
+			if strings.HasPrefix(cmd, "AUTH LOGIN") {
+				prompts := []string{"VXNlcm5hbWU6", "UGFzc3dvcmQ6"}
+				//已携带用户名时只需要询问密码
+				if len(strings.Fields(line)) > 2 {
+					prompts = prompts[1:]
+				}
+				for _, prompt := range prompts {
+					reply("334 %s", prompt)
+					if _, err := reader.ReadString('\n'); err != nil {
+						return
+					}
+				}
+			}
+			reply("235 Authentication successful")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			from = mailAddress(line[10:])
+			to = nil
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			to = append(to, mailAddress(line[8:]))
+			reply("250 OK")
+		case cmd == "DATA":
+			if len(to) == 0 {
+				reply("503 RCPT first")
+				continue
+			}
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			data, err := readMailData(reader)
+			if err != nil {
+				return
+			}
+			if file, err := saveMail(dir, from, to, data); err != nil {
+				log.Println("Save mail error => ", err)
+				reply("451 Save mail failed")
+			} else {
+				fmt.Printf("[%s] %s -> %s saved to %s\n", time.Now().Format("2006-01-02 15:04:05"), from, strings.Join(to, ","), file)
+				reply("250 OK")
+			}
+			from, to = "", nil
+		case cmd == "RSET":
+			from, to = "", nil
+			reply("250 OK")
+		case cmd == "NOOP":
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+//解析 MAIL FROM/RCPT TO 中的地址，忽略 BODY=8BITMIME 等扩展参数.
+func mailAddress(param string) string {
+	fields := strings.Fields(param)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.Trim(fields[0], "<>")
+}
+
+//读取DATA内容，直到单独一行的点号.
+func readMailData(reader *bufio.Reader) (string, error) {
+	var body strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		trimmed := strings.TrimRight(line, "\r\n")
+		if trimmed == "." {
+			return body.String(), nil
+		}
+		//去掉透明处理时添加的点号
+		if strings.HasPrefix(trimmed, "..") {
+			trimmed = trimmed[1:]
+		}
+		body.WriteString(trimmed + "\r\n")
+	}
+}
+
+//将邮件保存为 .eml 文件.
+func saveMail(dir, from string, to []string, data string) (string, error) {
+	name := fmt.Sprintf("%s-%d.eml", time.Now().Format("20060102150405"), atomic.AddInt64(&mailSequence, 1))
+	file := filepath.Join(dir, name)
+
+	content := fmt.Sprintf("X-Envelope-From: %s\r\nX-Envelope-To: %s\r\n%s", from, strings.Join(to, ", "), data)
+
+	return file, os.WriteFile(file, []byte(content), 0644)
+}
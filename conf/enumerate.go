@@ -50,6 +50,171 @@ const (
 	LoggerException = "exception"
 	LoggerDocument  = "document"
 )
+
+//审计日志动作.
+const (
+	AuditMemberCreate = "member.create"
+	AuditMemberStatus = "member.status"
+	AuditMemberRole   = "member.role"
+	AuditMemberUpdate = "member.update"
+	AuditMemberDelete = "member.delete"
+
+	AuditBookCreate    = "book.create"
+	AuditBookUpdate    = "book.update"
+	AuditBookDelete    = "book.delete"
+	AuditBookTransfer  = "book.transfer"
+	AuditBookPrivately = "book.privately"
+	AuditBookToken     = "book.token"
+	AuditBookRelease   = "book.release"
+	AuditBookReviewer  = "book.reviewer"
+	AuditBookClone     = "book.clone"
+
+	AuditBookMemberAdd    = "book.member.add"
+	AuditBookMemberRole   = "book.member.role"
+	AuditBookMemberRemove = "book.member.remove"
+	AuditBookTeamAdd      = "book.team.add"
+	AuditBookTeamRole     = "book.team.role"
+	AuditBookTeamRemove   = "book.team.remove"
+
+	AuditDocumentSave     = "document.save"
+	AuditDocumentContent  = "document.content"
+	AuditDocumentDelete   = "document.delete"
+	AuditDocumentMove     = "document.move"
+	AuditDocumentCopy     = "document.copy"
+	AuditDocumentPublish  = "document.publish"
+	AuditDocumentSchedule = "document.schedule"
+	AuditHistoryRestore   = "history.restore"
+	AuditHistoryDelete    = "history.delete"
+	AuditAttachmentDelete = "attachment.delete"
+	AuditPermissionCreate = "permission.create"
+	AuditPermissionDelete = "permission.delete"
+	AuditCommentDelete    = "comment.delete"
+	AuditCommentModerate  = "comment.moderate"
+	AuditReviewSubmit     = "review.submit"
+	AuditReviewApprove    = "review.approve"
+	AuditReviewReject     = "review.reject"
+	AuditVersionCreate    = "version.create"
+	AuditVersionDelete    = "version.delete"
+	AuditBranchCreate     = "branch.create"
+	AuditBranchMerge      = "branch.merge"
+	AuditRecycleRestore   = "recycle.restore"
+	AuditRecyclePurge     = "recycle.purge"
+	AuditLabelRename      = "label.rename"
+	AuditLabelMerge       = "label.merge"
+	AuditLabelDelete      = "label.delete"
+	AuditSiteSetting      = "site.setting"
+)
+// 用户状态
+const (
+	//正常.
+	MemberStatusNormal = 0
+	//禁用.
+	MemberStatusDisabled = 1
+	//待验证邮箱.
+	MemberStatusUnverified = 2
+)
+
+// 评论状态
+const (
+	//待审核.
+	CommentPending = 0
+	//已审核.
+	CommentApproved = 1
+	//垃圾评论.
+	CommentSpam = 2
+	//已删除.
+	CommentDeleted = 3
+)
+
+// 通知类型
+const (
+	//被提及.
+	NotifyMention = "mention"
+	//收到回复.
+	NotifyReply = "reply"
+	//收藏的项目中文档有变更.
+	NotifyDocument = "document"
+	//项目成员变更.
+	NotifyMember = "member"
+	//审阅请求.
+	NotifyReview = "review"
+)
+
+// 文档发布状态
+const (
+	//草稿，尚未发布.
+	DocumentDraft = 0
+	//已发布.
+	DocumentPublished = 1
+	//已取消发布.
+	DocumentUnpublished = 2
+)
+
+// 文档审阅状态
+const (
+	//待审阅.
+	ReviewPending = 0
+	//已通过.
+	ReviewApproved = 1
+	//需要修改.
+	ReviewRejected = 2
+	//已被新的提交取代.
+	ReviewSuperseded = 3
+)
+
+// 分支文档与源文档的差异
+const (
+	//未修改.
+	BranchUnchanged = "unchanged"
+	//分支中新增的文档.
+	BranchAdded = "added"
+	//分支中修改的文档.
+	BranchModified = "modified"
+	//分支中已删除的文档.
+	BranchDeleted = "deleted"
+)
+
+// 文档历史的操作类型
+const (
+	HistoryCreate  = "create"
+	HistoryModify  = "modify"
+	HistoryRename  = "rename"
+	HistoryMove    = "move"
+	HistoryDelete  = "delete"
+	HistoryImport  = "import"
+	HistoryRestore = "restore"
+	HistoryMerge   = "merge"
+)
+
+// 回收站条目类型
+const (
+	RecycleDocument = "document"
+	RecycleBook     = "book"
+)
+
+// 通知邮件摘要频率
+const (
+	DigestNone   = "none"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// 评论投票
+const (
+	//赞成.
+	CommentVoteAgree = 1
+	//反对.
+	CommentVoteAgainst = 2
+)
+
+// 邮件令牌用途
+const (
+	//找回密码.
+	TokenActionFindPassword = "find_password"
+	//验证邮箱.
+	TokenActionVerifyEmail = "verify_email"
+)
+
 const (
 	//本地账户校验
 	AuthMethodLocal = "local"
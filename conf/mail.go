@@ -27,6 +27,7 @@ func GetMailConfig() *SmtpConf {
 	reply_user_name := beego.AppConfig.String("reply_user_name")
 	enable_mail := beego.AppConfig.String("enable_mail")
 	mail_number := beego.AppConfig.DefaultInt("mail_number", 5)
+	mail_expired := beego.AppConfig.DefaultInt("mail_expired", 30)
 
 	c := &SmtpConf{
 		EnableMail:    strings.EqualFold(enable_mail, "true"),
@@ -37,6 +38,7 @@ func GetMailConfig() *SmtpConf {
 		FormUserName:  form_user_name,
 		ReplyUserName: reply_user_name,
 		SmtpPort:      smtp_port,
+		MailExpired:   mail_expired,
 	}
 	return c
 }
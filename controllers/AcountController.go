@@ -14,7 +14,6 @@ import (
 	"github.com/astaxie/beego/cache"
 	"github.com/astaxie/beego/orm"
 	"github.com/astaxie/beego/utils/captcha"
-	"github.com/lifei6671/gocaptcha"
 )
 
 // AccountController 用户登录与注册.
@@ -24,6 +23,12 @@ type AccountController struct {
 
 var cpt *captcha.Captcha
 
+//发送邮件时的错误，只记录日志，不提示给用户.
+var (
+	errMailDisabled = errors.New("未启用邮件服务")
+	errMailTooMany  = errors.New("发送次数太多，请稍候再试")
+)
+
 func init() {
 	// use beego cache system store the captcha data
 	fc := &cache.FileCache{CachePath: "./cache/captcha"}
@@ -243,6 +248,8 @@ func (this *AccountController) Login() {
 			}
 
 			this.JsonResult(0, "ok")
+		} else if err == models.ErrMemberUnverified {
+			this.JsonResult(6012, "邮箱尚未验证，请先点击验证邮件中的链接完成验证", nil)
 		} else {
 			beego.Error("用户登录 =>", err)
 			this.JsonResult(500, "账号或密码错误", nil)
@@ -305,6 +312,8 @@ func (this *AccountController) Bind() {
 	}
 
 	member := models.NewMember()
+	//开启了邮箱验证并且启用了邮件服务时，新注册用户需要先验证邮箱
+	verifyOn := this.isEmailVerifyOn()
 
 	if isbind == 1 {
 		if member, err = models.NewMember().Login(account, password1); err != nil || member.MemberId == 0 {
@@ -338,13 +347,26 @@ func (this *AccountController) Bind() {
 		member.Avatar = conf.GetDefaultAvatar()
 		member.CreateAt = 0
 		member.Email = email
-		member.Status = 0
+		member.Status = conf.MemberStatusNormal
+		if verifyOn {
+			member.Status = conf.MemberStatusUnverified
+		}
 		if len(avatar) > 0 {
 			member.Avatar = avatar
 		}
 		if err := member.Add(); err != nil {
-			beego.Error(err)
-			this.JsonResult(6006, err.Error())
+			beego.Error("注册用户失败 =>", err)
+			this.JsonResult(6006, "注册失败，用户名、昵称或邮箱可能已被使用")
+		}
+		if verifyOn {
+			if err = ibind(oauthType, oauthId, member.MemberId); err != nil {
+				beego.Error(err)
+			}
+			if err = this.sendVerifyEmail(member); err != nil {
+				beego.Error("发送验证邮件失败 =>", err)
+				this.JsonResult(6010, "注册成功，但验证邮件发送失败，请稍后在登录页重新发送")
+			}
+			this.JsonResult(0, "注册成功，请登录邮箱点击验证链接完成验证")
 		}
 	}
 	if err := this.loginByMemberId(member.MemberId); err == nil {
@@ -359,9 +381,69 @@ func (this *AccountController) Bind() {
 			}
 		}
 	} else {
-		beego.Error(err.Error())
-		this.JsonResult(1, err.Error())
+		beego.Error("登录失败 =>", err)
+		this.JsonResult(1, "登录失败")
+	}
+}
+
+//是否开启了注册邮箱验证.
+func (this *AccountController) isEmailVerifyOn() bool {
+	v, ok := this.Option["ENABLED_EMAIL_VERIFY"]
+	return ok && strings.EqualFold(v, "true") && conf.GetMailConfig().EnableMail
+}
+
+//校验验证码.
+func (this *AccountController) verifyCaptcha() {
+	if v, ok := this.Option["ENABLED_CAPTCHA"]; ok && strings.EqualFold(v, "true") {
+		this.Data["CaptchaOn"] = true
+		if this.Ctx.Input.IsPost() && !cpt.VerifyReq(this.Ctx.Request) {
+			this.JsonResult(6001, "验证码不正确")
+		}
+	}
+}
+
+//生成令牌并发送邮件.
+func (this *AccountController) sendTokenMail(member *models.Member, action, subject, tpl, urlName string) error {
+	mail_conf := conf.GetMailConfig()
+	if !mail_conf.EnableMail {
+		return errMailDisabled
+	}
+
+	count, err := models.NewMemberToken().FindSendCount(member.Email, time.Now().Add(-1*time.Hour), time.Now())
+	if err != nil {
+		return err
+	}
+	if count >= mail_conf.MailNumber {
+		return errMailTooMany
+	}
+
+	member_token := models.NewMemberToken()
+	member_token.Token = string(utils.Krand(32, utils.KC_RAND_KIND_ALL))
+	member_token.Email = member.Email
+	member_token.MemberId = member.MemberId
+	member_token.Action = action
+	member_token.IsValid = false
+	if _, err := member_token.InsertOrUpdate(); err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"SITE_NAME": this.Option["SITE_NAME"],
+		"Nickname":  member.Nickname,
+		"Expired":   mail_conf.MailExpired,
+		"url":       this.BaseUrl() + beego.URLFor(urlName, "token", member_token.Token, "mail", member.Email),
 	}
+
+	body, err := this.ExecuteViewPathTemplate(tpl, data)
+	if err != nil {
+		return err
+	}
+	return utils.SendMail(mail_conf, subject, member.Email, body)
+}
+
+//发送注册验证邮件.
+func (this *AccountController) sendVerifyEmail(member *models.Member) error {
+	return this.sendTokenMail(member, conf.TokenActionVerifyEmail, "验证邮箱", "account/mail_verify_template.html", "AccountController.VerifyEmail")
 }
 
 //找回密码.
@@ -369,10 +451,11 @@ func (this *AccountController) FindPassword() {
 	this.TplName = "account/find_password_setp1.html"
 	mail_conf := conf.GetMailConfig()
 
+	this.verifyCaptcha()
+
 	if this.Ctx.Input.IsPost() {
 
-		email := this.GetString("email")
-		captcha := this.GetString("code")
+		email := strings.TrimSpace(this.GetString("email"))
 
 		if email == "" {
 			this.JsonResult(6005, "邮箱地址不能为空")
@@ -381,60 +464,14 @@ func (this *AccountController) FindPassword() {
 			this.JsonResult(6004, "未启用邮件服务")
 		}
 
-		//如果开启了验证码
-		if v, ok := this.Option["ENABLED_CAPTCHA"]; ok && strings.EqualFold(v, "true") {
-			v, ok := this.GetSession(conf.CaptchaSessionName).(string)
-			if !ok || !strings.EqualFold(v, captcha) {
-				this.JsonResult(6001, "验证码不正确")
-			}
-		}
-
+		//无论邮箱是否注册都返回相同的结果，避免被用来探测账号
 		member, err := models.NewMember().FindByFieldFirst("email", email)
 		if err != nil {
-			beego.Error(err)
-			this.JsonResult(6006, "邮箱不存在")
-		}
-		if member.Status != 0 {
-			this.JsonResult(6007, "账号已被禁用")
-		}
-		if member.AuthMethod == conf.AuthMethodLDAP {
-			this.JsonResult(6011, "当前用户不支持找回密码")
-		}
-
-		count, err := models.NewMemberToken().FindSendCount(email, time.Now().Add(-1*time.Hour), time.Now())
-
-		if err != nil {
-			beego.Error(err)
-			this.JsonResult(6008, "发送邮件失败")
-		}
-		if count > mail_conf.MailNumber {
-			this.JsonResult(6008, "发送次数太多，请稍候再试")
-		}
-
-		member_token := models.NewMemberToken()
-
-		member_token.Token = string(utils.Krand(32, utils.KC_RAND_KIND_ALL))
-		member_token.Email = email
-		member_token.MemberId = member.MemberId
-		member_token.IsValid = false
-		if _, err := member_token.InsertOrUpdate(); err != nil {
-			this.JsonResult(6009, "邮件发送失败")
-		}
-
-		data := map[string]interface{}{
-			"SITE_NAME": this.Option["SITE_NAME"],
-			"url":       this.BaseUrl() + beego.URLFor("AccountController.FindPassword", "token", member_token.Token, "mail", email),
-		}
-
-		body, err := this.ExecuteViewPathTemplate("account/mail_template.html", data)
-		if err != nil {
-			beego.Error(err)
-			this.JsonResult(6003, "邮件发送失败")
-		}
-
-		if err = utils.SendMail(mail_conf, "找回密码", email, body); err != nil {
-			beego.Error(err)
-			this.JsonResult(6003, "邮件发送失败")
+			beego.Error("找回密码 =>", err)
+		} else if member.Status != conf.MemberStatusDisabled && member.AuthMethod != conf.AuthMethodLDAP {
+			if err := this.sendTokenMail(member, conf.TokenActionFindPassword, "找回密码", "account/mail_template.html", "AccountController.FindPassword"); err != nil {
+				beego.Error("找回密码 =>", err)
+			}
 		}
 
 		this.JsonResult(0, "ok", this.BaseUrl()+beego.URLFor("AccountController.Login"))
@@ -450,7 +487,7 @@ func (this *AccountController) FindPassword() {
 	mail := this.GetString("mail")
 
 	if token != "" && mail != "" {
-		member_token, err := models.NewMemberToken().FindByFieldFirst("token", token)
+		member_token, err := models.NewMemberToken().FindByToken(token, conf.TokenActionFindPassword)
 
 		if err != nil {
 			beego.Error(err)
@@ -458,9 +495,8 @@ func (this *AccountController) FindPassword() {
 			this.TplName = "errors/error.html"
 			return
 		}
-		sub_time := member_token.SendTime.Sub(time.Now())
 
-		if !strings.EqualFold(member_token.Email, mail) || sub_time.Minutes() > float64(mail_conf.MailExpired) || !member_token.ValidTime.IsZero() {
+		if !strings.EqualFold(member_token.Email, mail) || member_token.IsExpired(mail_conf.MailExpired) {
 			this.Data["ErrorMessage"] = "验证码已过期，请重新操作。"
 			this.TplName = "errors/error.html"
 			return
@@ -477,7 +513,6 @@ func (this *AccountController) FindPassword() {
 func (this *AccountController) ValidEmail() {
 	password1 := this.GetString("password1")
 	password2 := this.GetString("password2")
-	captcha := this.GetString("code")
 	token := this.GetString("token")
 	mail := this.GetString("mail")
 
@@ -493,25 +528,18 @@ func (this *AccountController) ValidEmail() {
 	if password1 != password2 {
 		this.JsonResult(6003, "确认密码输入不正确")
 	}
-	if captcha == "" {
-		this.JsonResult(6004, "验证码不能为空")
-	}
-	v, ok := this.GetSession(conf.CaptchaSessionName).(string)
-	if !ok || !strings.EqualFold(v, captcha) {
-		this.JsonResult(6001, "验证码不正确")
-	}
+
+	this.verifyCaptcha()
 
 	mail_conf := conf.GetMailConfig()
-	member_token, err := models.NewMemberToken().FindByFieldFirst("token", token)
+	member_token, err := models.NewMemberToken().FindByToken(token, conf.TokenActionFindPassword)
 
 	if err != nil {
 		beego.Error(err)
 		this.JsonResult(6007, "邮件已失效")
 	}
-	sub_time := member_token.SendTime.Sub(time.Now())
-
-	if !strings.EqualFold(member_token.Email, mail) || sub_time.Minutes() > float64(mail_conf.MailExpired) || !member_token.ValidTime.IsZero() {
 
+	if !strings.EqualFold(member_token.Email, mail) || member_token.IsExpired(mail_conf.MailExpired) {
 		this.JsonResult(6008, "验证码已过期，请重新操作。")
 	}
 	member, err := models.NewMember().Find(member_token.MemberId)
@@ -527,11 +555,19 @@ func (this *AccountController) ValidEmail() {
 	}
 
 	member.Password = hash
+	cols := []string{"password"}
+	//通过邮件重置密码同样证明了邮箱的有效性
+	if member.Status == conf.MemberStatusUnverified {
+		member.Status = conf.MemberStatusNormal
+		cols = append(cols, "status")
+	}
 
-	err = member.Update("password")
+	err = member.Update(cols...)
 	member_token.ValidTime = time.Now()
 	member_token.IsValid = true
-	member_token.InsertOrUpdate()
+	if _, err := member_token.InsertOrUpdate(); err != nil {
+		beego.Error("MemberToken.InsertOrUpdate => ", err)
+	}
 
 	if err != nil {
 		beego.Error(err)
@@ -540,38 +576,73 @@ func (this *AccountController) ValidEmail() {
 	this.JsonResult(0, "ok", this.BaseUrl()+beego.URLFor("AccountController.Login"))
 }
 
-// Logout 退出登录.
-func (this *AccountController) Logout() {
-	this.SetMember(models.Member{})
-
-	this.SetSecureCookie(conf.GetAppKey(), "login", "", -3600)
-
-	this.Redirect(beego.URLFor("AccountController.Login"), 302)
-}
-
-//验证码.
-func (this *AccountController) Captcha() {
-	this.Prepare()
+//验证注册邮箱.
+func (this *AccountController) VerifyEmail() {
+	token := this.GetString("token")
+	mail := this.GetString("mail")
+	this.TplName = "errors/error.html"
 
-	captchaImage, err := gocaptcha.NewCaptchaImage(140, 40, gocaptcha.RandLightColor())
+	member_token, err := models.NewMemberToken().FindByToken(token, conf.TokenActionVerifyEmail)
+	if err != nil || token == "" {
+		this.Data["ErrorMessage"] = "验证链接已失效"
+		return
+	}
+	if !strings.EqualFold(member_token.Email, mail) || member_token.IsExpired(conf.GetMailConfig().MailExpired) {
+		this.Data["ErrorMessage"] = "验证链接已过期，请在登录页重新发送验证邮件。"
+		return
+	}
 
+	member, err := models.NewMember().Find(member_token.MemberId)
 	if err != nil {
 		beego.Error(err)
-		this.Abort("500")
+		this.Data["ErrorMessage"] = "用户不存在"
+		return
+	}
+	if member.Status == conf.MemberStatusUnverified {
+		member.Status = conf.MemberStatusNormal
+		if err := member.Update("status"); err != nil {
+			beego.Error(err)
+			this.Data["ErrorMessage"] = "验证邮箱失败"
+			return
+		}
+	}
+	member_token.ValidTime = time.Now()
+	member_token.IsValid = true
+	if _, err := member_token.InsertOrUpdate(); err != nil {
+		beego.Error("MemberToken.InsertOrUpdate => ", err)
 	}
 
-	captchaImage.DrawNoise(gocaptcha.CaptchaComplexLower)
+	if err := this.loginByMemberId(member.MemberId); err != nil {
+		this.Redirect(beego.URLFor("AccountController.Login"), 302)
+		return
+	}
+	this.Redirect(beego.URLFor("HomeController.Index"), 302)
+}
 
-	//captchaImage.DrawTextNoise(gocaptcha.CaptchaComplexHigh)
-	txt := gocaptcha.RandText(4)
+//重新发送注册验证邮件.
+func (this *AccountController) ResendVerifyEmail() {
+	account := this.GetString("account")
+	if account == "" {
+		this.JsonResult(6001, "账号不能为空")
+	}
+	if !this.isEmailVerifyOn() {
+		this.JsonResult(6004, "未启用邮箱验证")
+	}
+
+	//无论账号是否存在、是否已验证都返回相同的结果，避免被用来探测账号
+	if member, err := models.NewMember().FindByFieldFirst("account", account); err == nil && member.Status == conf.MemberStatusUnverified {
+		if err := this.sendVerifyEmail(member); err != nil {
+			beego.Error("发送验证邮件失败 =>", err)
+		}
+	}
+	this.JsonResult(0, "如果账号存在且尚未验证，验证邮件将发送到注册邮箱，请登录邮箱查看")
+}
 
-	this.SetSession(conf.CaptchaSessionName, txt)
+// Logout 退出登录.
+func (this *AccountController) Logout() {
+	this.SetMember(models.Member{})
 
-	captchaImage.DrawText(txt)
-	//captchaImage.Drawline(3);
-	captchaImage.DrawBorder(gocaptcha.ColorToRGB(0x17A7A7A))
-	//captchaImage.DrawHollowLine()
+	this.SetSecureCookie(conf.GetAppKey(), "login", "", -3600)
 
-	captchaImage.SaveImage(this.Ctx.ResponseWriter, gocaptcha.ImageFormatJpeg)
-	this.StopRun()
+	this.Redirect(beego.URLFor("AccountController.Login"), 302)
 }
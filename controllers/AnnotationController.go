@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/models"
+	"github.com/astaxie/beego"
+)
+
+//文档批注，仅项目参与者可见.
+type AnnotationController struct {
+	BaseController
+}
+
+// List 获取文档的批注.
+func (this *AnnotationController) List() {
+	doc_id, _ := this.GetInt("doc_id", 0)
+	resolved, _ := this.GetInt("resolved", -1)
+
+	this.findAccess(doc_id)
+
+	annotations, err := models.NewAnnotation().FindByDocumentId(doc_id, resolved)
+	if err != nil {
+		beego.Error("Annotation.FindByDocumentId => ", err)
+		this.JsonResult(6004, "查询批注失败")
+	}
+	if annotations == nil {
+		annotations = make([]*models.Annotation, 0)
+	}
+	this.JsonResult(0, "ok", annotations)
+}
+
+// Create 给选中的文字添加批注.
+func (this *AnnotationController) Create() {
+	doc_id, _ := this.GetInt("doc_id", 0)
+
+	this.findAccess(doc_id)
+
+	annotation := models.NewAnnotation()
+	annotation.DocumentId = doc_id
+	annotation.MemberId = this.Member.MemberId
+	annotation.Quote = this.GetString("quote")
+	annotation.Prefix = this.GetString("prefix")
+	annotation.Suffix = this.GetString("suffix")
+	annotation.Content = this.GetString("content")
+	annotation.StartOffset, _ = this.GetInt("offset", 0)
+
+	if err := annotation.Insert(); err != nil {
+		beego.Error("Annotation.Insert => ", err)
+		this.JsonResult(6005, err.Error())
+	}
+	if err := annotation.Notify(this.Member, nil); err != nil {
+		beego.Error("Annotation.Notify => ", err)
+	}
+	annotation.Account = this.Member.Account
+	annotation.Avatar = this.Member.Avatar
+	annotation.Replies = make([]*models.AnnotationReply, 0)
+
+	this.JsonResult(0, "ok", annotation)
+}
+
+// Reply 回复批注.
+func (this *AnnotationController) Reply() {
+	annotation := this.findAnnotation()
+	this.findAccess(annotation.DocumentId)
+
+	reply, err := annotation.Reply(this.Member.MemberId, this.GetString("content"))
+	if err != nil {
+		beego.Error("Annotation.Reply => ", err)
+		this.JsonResult(6005, err.Error())
+	}
+	if err := annotation.Notify(this.Member, reply); err != nil {
+		beego.Error("Annotation.Notify => ", err)
+	}
+	this.JsonResult(0, "ok", reply)
+}
+
+// Resolve 将批注标记为已解决或重新打开，批注作者和项目编辑者以上角色可以操作.
+func (this *AnnotationController) Resolve() {
+	annotation := this.findAnnotation()
+	role_id := this.findAccess(annotation.DocumentId)
+
+	if annotation.MemberId != this.Member.MemberId && role_id == conf.BookObserver {
+		this.JsonResult(6003, "您没有权限操作该批注")
+	}
+	resolved, _ := this.GetBool("resolved", true)
+
+	if err := annotation.Resolve(this.Member.MemberId, resolved); err != nil {
+		beego.Error("Annotation.Resolve => ", err)
+		this.JsonResult(6005, "操作失败")
+	}
+	this.JsonResult(0, "ok", annotation)
+}
+
+// Delete 删除批注，批注作者和项目管理者可以删除.
+func (this *AnnotationController) Delete() {
+	annotation := this.findAnnotation()
+	role_id := this.findAccess(annotation.DocumentId)
+
+	if annotation.MemberId != this.Member.MemberId && role_id != conf.BookFounder && role_id != conf.BookAdmin {
+		this.JsonResult(6003, "您没有权限删除该批注")
+	}
+	if err := annotation.Delete(); err != nil {
+		beego.Error("Annotation.Delete => ", err)
+		this.JsonResult(6005, "删除批注失败")
+	}
+	this.JsonResult(0, "ok")
+}
+
+func (this *AnnotationController) findAnnotation() *models.Annotation {
+	annotation_id, _ := this.GetInt("annotation_id", 0)
+
+	annotation, err := models.NewAnnotation().Find(annotation_id)
+	if err != nil {
+		this.JsonResult(6002, "批注不存在")
+	}
+	return annotation
+}
+
+//校验当前用户是否是文档所属项目的参与者，返回用户在项目中的角色，超级管理员视为项目创始人.
+func (this *AnnotationController) findAccess(doc_id int) int {
+	doc, err := models.NewDocument().Find(doc_id)
+	if err != nil {
+		this.JsonResult(6001, "文档不存在")
+	}
+	book, err := models.NewBook().FindByIdentify(this.Ctx.Input.Param(":key"))
+	if err != nil || book.BookId != doc.BookId {
+		this.JsonResult(6001, "项目不存在")
+	}
+	access, err := this.readableAccess(book, "")
+	if err != nil {
+		this.JsonResult(6003, "您没有权限访问该文档")
+	}
+	role_id := conf.BookFounder
+	if !this.Member.IsAdministrator() {
+		if role_id, err = models.NewRelationship().FindEffectiveRoleId(book.BookId, this.Member.MemberId); err != nil {
+			this.JsonResult(6003, "只有项目参与者可以查看批注")
+		}
+	}
+	if !access.CanRead(doc.DocumentId) {
+		this.JsonResult(6003, "您没有权限访问该文档")
+	}
+	return role_id
+}
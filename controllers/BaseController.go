@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"strconv"
 
@@ -23,7 +24,6 @@ import (
 	"github.com/JermineHu/DocStack/models"
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/orm"
-	"github.com/kataras/iris/core/errors"
 )
 
 type BaseController struct {
@@ -34,6 +34,8 @@ type BaseController struct {
 	EnableDocumentHistory bool
 	Sitename              string
 	OssDomain             string
+	//通过分享链接访问时的分享信息
+	share *models.BookShare
 }
 type CookieRemember struct {
 	MemberId int
@@ -68,6 +70,9 @@ func (this *BaseController) Prepare() {
 		this.Member.ResolveRoleName()
 	}
 	this.Data["Member"] = this.Member
+	if this.Member.MemberId > 0 && !this.Ctx.Input.IsAjax() {
+		this.Data["UnreadNotification"] = models.NewNotification().UnreadCount(this.Member.MemberId)
+	}
 	this.Data["BaseUrl"] = this.Ctx.Input.Scheme() + "://" + this.Ctx.Request.Host
 
 	if options, err := models.NewOption().All(); err == nil {
@@ -131,6 +136,45 @@ func (this *BaseController) JsonResult(errCode int, errMsg string, data ...inter
 	this.StopRun()
 }
 
+// AuditLog 记录审计日志，original 和 present 为变更前后的数据.
+func (this *BaseController) AuditLog(action string, book_id, object_id int, content string, original, present interface{}) {
+	if this.Member == nil || this.Member.MemberId <= 0 {
+		return
+	}
+	logger := models.NewLogger()
+	logger.MemberId = this.Member.MemberId
+	logger.Category = conf.LoggerOperate
+	logger.Action = action
+	logger.BookId = book_id
+	logger.ObjectId = object_id
+	logger.Content = content
+	logger.OriginalData = auditData(original)
+	logger.PresentData = auditData(present)
+	logger.IPAddress = this.Ctx.Input.IP()
+	logger.UserAgent = this.Ctx.Input.UserAgent()
+	if len(logger.UserAgent) > 500 {
+		logger.UserAgent = logger.UserAgent[:500]
+	}
+	if err := logger.Add(); err != nil {
+		beego.Error("AuditLog => ", err)
+	}
+}
+
+//将审计数据序列化为字符串.
+func auditData(data interface{}) string {
+	switch v := data.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Sprint(data)
+	}
+	return string(b)
+}
+
 // ExecuteViewPathTemplate 执行指定的模板并返回执行结果.
 func (this *BaseController) ExecuteViewPathTemplate(tplName string, data interface{}) (string, error) {
 	var buf bytes.Buffer
@@ -181,6 +225,129 @@ func (this *BaseController) GetSeoByPage(page string, defSeo map[string]string)
 	this.Data["SeoDescription"] = seo.Description
 }
 
+//从请求参数获取按文档元数据过滤的条件，field 参数为自定义字段的键或 键:值，review_before 格式为 2006-01-02.
+func (this *BaseController) metaFilter() *models.DocumentMetaFilter {
+	filter := &models.DocumentMetaFilter{
+		Author: strings.TrimSpace(this.GetString("author")),
+		Owner:  strings.TrimSpace(this.GetString("owner")),
+		Tag:    strings.TrimSpace(this.GetString("tag")),
+	}
+	if field := strings.TrimSpace(this.GetString("field")); field != "" {
+		parts := strings.SplitN(field, ":", 2)
+		filter.FieldKey = strings.TrimSpace(parts[0])
+		if len(parts) == 2 {
+			filter.FieldValue = strings.TrimSpace(parts[1])
+		}
+	}
+	if date, err := time.ParseInLocation("2006-01-02", this.GetString("review_before"), time.Local); err == nil {
+		filter.ReviewBefore = date
+	}
+	return filter
+}
+
+//查询当前用户可以阅读的项目文档范围，私有项目需要是项目参与者，或者通过分享链接、阅读令牌访问.
+//token 为请求中携带的令牌，分享链接的令牌统一跳转到分享入口，阅读令牌校验通过后记录到 Session 中.
+func (this *BaseController) readableAccess(book *models.Book, token string) (*models.DocumentAccess, error) {
+	if book.PrivatelyOwned == 1 && !this.Member.IsAdministrator() {
+		is_ok := false
+
+		if this.Member.MemberId > 0 {
+			if _, err := models.NewRelationship().FindEffectiveRoleId(book.BookId, this.Member.MemberId); err == nil {
+				is_ok = true
+			}
+		}
+		if !is_ok {
+			if share, err := models.NewBookShare().FindByToken(token); err == nil && share.BookId == book.BookId {
+				//分享链接统一从分享入口进入，以便校验密码和统计访问次数
+				this.Redirect(beego.URLFor("DocumentController.Share", ":token", token), 302)
+				this.StopRun()
+			}
+			if share := this.findShare(book); share != nil {
+				this.share = share
+			} else if book.PrivateToken != "" {
+				//如果有访问的Token，并且该项目设置了访问Token，并且和用户提供的相匹配，则记录到Session中.
+				//如果用户未登录，则从Session中读取Token.
+				if token != "" && strings.EqualFold(token, book.PrivateToken) {
+					this.SetSession(book.Identify, token)
+				} else if token, ok := this.GetSession(book.Identify).(string); !ok || !strings.EqualFold(token, book.PrivateToken) {
+					return nil, models.ErrPermissionDenied
+				}
+			} else {
+				return nil, models.ErrPermissionDenied
+			}
+		}
+	}
+	return this.bookAccess(book.BookId), nil
+}
+
+//从Session中读取已验证的分享链接，撤销或过期的链接立即失效.
+func (this *BaseController) findShare(book *models.Book) *models.BookShare {
+	token, ok := this.GetSession(shareSessionKey(book.Identify)).(string)
+	if !ok || token == "" {
+		return nil
+	}
+	share, err := models.NewBookShare().FindByToken(token)
+	if err != nil || share.BookId != book.BookId || !share.IsValid() {
+		this.DelSession(shareSessionKey(book.Identify))
+		return nil
+	}
+	return share
+}
+
+func shareSessionKey(identify string) string {
+	return "share_" + identify
+}
+
+//获取当前用户的文档访问控制，通过分享链接访问时限制在分享的范围内.
+func (this *BaseController) bookAccess(book_id int) *models.DocumentAccess {
+	access := models.NewDocumentAccessForMember(book_id, this.Member)
+	if this.share != nil && this.share.BookId == book_id {
+		access.LimitTo(this.share.DocumentId)
+	}
+	return access
+}
+
+//当前用户是否可以阅读已发布的文档，用于搜索等跨项目的文档列表，每个项目的权限只加载一次.
+func (this *BaseController) publishedReadable() func(book_id, doc_id int) bool {
+	accesses := make(map[int]*models.DocumentAccess)
+	return func(book_id, doc_id int) bool {
+		access, ok := accesses[book_id]
+		if !ok {
+			access = models.NewDocumentAccessForMember(book_id, this.Member).PublishedOnly()
+			accesses[book_id] = access
+		}
+		return access.CanRead(doc_id)
+	}
+}
+
+//查询项目并校验当前用户是否可以管理项目，只有项目创始人、管理员和超级管理员可以管理.
+func (this *BaseController) bookAdminByIdentify(identify string) (*models.BookResult, error) {
+	if identify == "" {
+		return nil, errors.New("参数错误")
+	}
+	if this.Member.IsAdministrator() {
+		book, err := models.NewBook().FindByIdentify(identify)
+		if err != nil {
+			return nil, errors.New("项目不存在")
+		}
+		return book.ToBookResult(), nil
+	}
+	book, err := models.NewBookResult().FindByIdentify(identify, this.Member.MemberId)
+	if err != nil {
+		if err == models.ErrPermissionDenied {
+			return book, errors.New("权限不足")
+		}
+		if err == orm.ErrNoRows {
+			return book, errors.New("项目不存在")
+		}
+		return book, err
+	}
+	if book.RoleId != conf.BookAdmin && book.RoleId != conf.BookFounder {
+		return book, errors.New("权限不足")
+	}
+	return book, nil
+}
+
 //站点地图
 func (this *BaseController) Sitemap() {
 	this.Data["SeoTitle"] = "站点地图 - " + this.Sitename
@@ -216,6 +383,12 @@ func (this *BaseController) loginByMemberId(memberId int) (err error) {
 	if member.MemberId == 0 {
 		return errors.New("用户不存在")
 	}
+	switch member.Status {
+	case conf.MemberStatusDisabled:
+		return models.ErrMemberDisabled
+	case conf.MemberStatusUnverified:
+		return models.ErrMemberUnverified
+	}
 	//如果没有数据
 	if err == nil {
 		member.LastLoginTime = time.Now()
@@ -288,9 +461,35 @@ func (this *BaseController) sortBySummary(htmlstr string, book_id int) {
 					DocumentId: int(doc_id),
 					Markdown:   "[TOC]\n\r\n\r",
 				})
+				doc.DocumentId = int(doc_id)
+				doc.AddHistory(conf.HistoryCreate, this.Member.MemberId)
 			}
 		}
 	}
+	//记录目录调整前的位置和名称，用于记录移动和重命名历史
+	var existing []*models.Document
+	qs.Limit(-1).All(&existing, "document_id", "identify", "parent_id", "document_name", "member_id")
+	addHistory := func(doc *models.Document, pid int, doc_name string) {
+		if doc == nil || (doc.ParentId == pid && doc.DocumentName == doc_name) {
+			return
+		}
+		action := conf.HistoryRename
+		if doc.ParentId != pid {
+			action = conf.HistoryMove
+		}
+		doc.ParentId, doc.DocumentName = pid, doc_name
+		if err := doc.AddHistory(action, this.Member.MemberId); err != nil {
+			beego.Error("DocumentHistory InsertOrUpdate => ", err)
+		}
+	}
+	findExisting := func(doc_id int, identify string) *models.Document {
+		for _, item := range existing {
+			if (doc_id > 0 && item.DocumentId == doc_id) || (identify != "" && item.Identify == identify) {
+				return item
+			}
+		}
+		return nil
+	}
 
 	doc.Find("a").Each(func(i int, selection *goquery.Selection) {
 		doc_name := selection.Text()
@@ -309,6 +508,7 @@ func (this *BaseController) sortBySummary(htmlstr string, book_id int) {
 					"parent_id": pid, "document_name": doc_name,
 					"order_sort": idx, "modify_time": time.Now(),
 				})
+				addHistory(findExisting(doc_id, ""), pid, doc_name)
 			}
 		} else if href, ok := selection.Attr("href"); ok && strings.HasPrefix(href, "$") {
 			identify := strings.TrimPrefix(href, "$") //文档标识
@@ -327,6 +527,8 @@ func (this *BaseController) sortBySummary(htmlstr string, book_id int) {
 				"order_sort": idx, "modify_time": time.Now(),
 			}); err != nil {
 				beego.Error(err)
+			} else {
+				addHistory(findExisting(0, identify), pid, doc_name)
 			}
 		}
 		idx++
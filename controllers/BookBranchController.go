@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/models"
+	"github.com/JermineHu/DocStack/utils"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+//项目分支，在分支项目中修改文档后逐个文档合并回源项目.
+type BookBranchController struct {
+	BaseController
+}
+
+// Create 从项目创建分支.
+func (this *BookBranchController) Create() {
+	result, err := this.bookAdminByIdentify(this.GetString("identify"))
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	source, err := models.NewBook().Find(result.BookId)
+	if err != nil {
+		this.JsonResult(6001, "项目不存在")
+	}
+	if branch, err := models.NewBookBranch().FindByBookId(source.BookId); err == nil && branch.BranchId > 0 {
+		this.JsonResult(6001, "不能从分支项目创建分支")
+	}
+	book_name := strings.TrimSpace(this.GetString("branch_name"))
+	identify := strings.TrimSpace(this.GetString("branch_identify"))
+
+	if book_name == "" {
+		this.JsonResult(6002, "分支名称不能为空")
+	}
+	if identify == "" {
+		this.JsonResult(6002, "分支标识不能为空")
+	}
+	if ok, err := regexp.MatchString(`^[a-zA-Z0-9_\-]*$`, identify); !ok || err != nil {
+		this.JsonResult(6003, "分支标识只能包含字母、数字，以及“-”和“_”符号，且不能是纯数字")
+	}
+	if num, _ := strconv.Atoi(identify); strconv.Itoa(num) == identify {
+		this.JsonResult(6003, "分支标识只能包含字母、数字，以及“-”和“_”符号，且不能是纯数字")
+	}
+	if strings.Count(identify, "") > 50 {
+		this.JsonResult(6004, "分支标识不能超过50字")
+	}
+	if models.NewBook().ExistIdentify(identify) {
+		this.JsonResult(6006, "项目标识已存在")
+	}
+	//分支沿用源项目的设置，并保持私有
+	book := *source
+	book.BookId = 0
+	book.BookName = book_name
+	book.Identify = identify
+	book.Label = utils.SegWord(book_name)
+	book.PrivatelyOwned = 1
+	book.PrivateToken = ""
+	book.MemberId = this.Member.MemberId
+	book.CommentCount = 0
+	book.Vcnt = 0
+	book.Star = 0
+	book.CntScore = 0
+	book.CntComment = 0
+	book.Version = time.Now().Unix()
+	defaultTime, _ := time.Parse("2006-01-02 15:04:05", "2006-01-02 15:04:05")
+	book.LastClickGenerate = defaultTime
+	book.GenerateTime, _ = time.Parse("2006-01-02 15:04:05", "2000-01-02 15:04:05")
+	book.ReleaseTime = defaultTime
+
+	branch := models.NewBookBranch()
+	if err := branch.Create(source, &book); err != nil {
+		beego.Error("BookBranch.Create => ", err)
+		this.JsonResult(6005, "创建分支失败")
+	}
+	this.AuditLog(conf.AuditBranchCreate, source.BookId, book.BookId, "创建项目分支 "+book.BookName, nil, map[string]interface{}{"identify": book.Identify, "doc_count": book.DocCount})
+
+	this.JsonResult(0, "ok", this.findBranches(source.BookId))
+}
+
+// Compare 获取分支相对源项目的文档变更.
+func (this *BookBranchController) Compare() {
+	_, branch := this.findBranch(this.Ctx.Input.Param(":key"))
+
+	changes, err := branch.Compare()
+	if err != nil {
+		beego.Error("BookBranch.Compare => ", err)
+		this.JsonResult(6005, "比较失败")
+	}
+	this.JsonResult(0, "ok", changes)
+}
+
+// Diff 对比分支文档与源文档的内容.
+func (this *BookBranchController) Diff() {
+	this.TplName = "document/compare.html"
+
+	book, branch := this.findBranch(this.Ctx.Input.Param(":key"))
+	doc_id, _ := strconv.Atoi(this.Ctx.Input.Param(":id"))
+
+	doc, err := models.NewDocument().Find(doc_id)
+	if err != nil || doc.BookId != book.BookId {
+		this.ShowErrorPage(404, "文档不存在")
+	}
+	this.Data["Model"] = book
+
+	ModelStore := new(models.DocumentStore)
+	source_id := 0
+	if row := models.NewBranchDocument(); orm.NewOrm().QueryTable(row.TableNameWithPrefix()).Filter("document_id", doc.DocumentId).One(row) == nil {
+		source_id = row.SourceDocId
+	}
+	field := "markdown"
+	if book.Editor != "markdown" {
+		field = "content"
+	}
+	history, content := "", ModelStore.GetFiledById(doc.DocumentId, field)
+	if source, err := models.NewDocument().Find(source_id); err == nil && source.BookId == branch.SourceBookId {
+		history = ModelStore.GetFiledById(source.DocumentId, field)
+	}
+	if field == "markdown" {
+		this.Data["HistoryContent"] = history
+		this.Data["Content"] = content
+	} else {
+		this.Data["HistoryContent"] = template.HTML(history)
+		this.Data["Content"] = template.HTML(content)
+	}
+}
+
+// Merge 将分支中的文档合并到源项目.
+func (this *BookBranchController) Merge() {
+	book, branch := this.findBranch(this.GetString("identify"))
+	doc_id, _ := this.GetInt("doc_id", 0)
+	force, _ := this.GetBool("force", false)
+
+	source, err := models.NewBook().Find(branch.SourceBookId)
+	if err != nil {
+		this.JsonResult(6002, "源项目不存在")
+	}
+	if _, err := this.bookAdminByIdentify(source.Identify); err != nil {
+		this.JsonResult(6001, "没有源项目的管理权限")
+	}
+	doc, err := branch.Merge(doc_id, this.Member.MemberId, force)
+	if err != nil {
+		if err == models.ErrMergeConflict {
+			this.JsonResult(6007, err.Error())
+		}
+		if err == models.ErrDataNotExist {
+			this.JsonResult(6002, "文档不存在")
+		}
+		if err == models.ErrParentNotMerged {
+			this.JsonResult(6003, err.Error())
+		}
+		beego.Error("BookBranch.Merge => ", err)
+		this.JsonResult(6005, "合并失败")
+	}
+	this.AuditLog(conf.AuditBranchMerge, source.BookId, doc.DocumentId, "合并分支文档 "+doc.DocumentName, nil, map[string]interface{}{"branch": book.Identify, "branch_doc_id": doc_id, "force": force})
+
+	changes, err := branch.Compare()
+	if err != nil {
+		beego.Error("BookBranch.Compare => ", err)
+	}
+	this.JsonResult(0, "ok", changes)
+}
+
+//查询分支项目及其来源.
+func (this *BookBranchController) findBranch(identify string) (*models.Book, *models.BookBranch) {
+	result, err := this.bookAdminByIdentify(identify)
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	book, err := models.NewBook().Find(result.BookId)
+	if err != nil {
+		this.JsonResult(6001, "项目不存在")
+	}
+	branch, err := models.NewBookBranch().FindByBookId(book.BookId)
+	if err != nil {
+		this.JsonResult(6002, "项目不是分支项目")
+	}
+	return book, branch
+}
+
+//查询项目的分支.
+func (this *BookBranchController) findBranches(book_id int) []*models.BookBranch {
+	branches, err := models.NewBookBranch().FindBySourceBookId(book_id)
+	if err != nil {
+		beego.Error("BookBranch.FindBySourceBookId => ", err)
+	}
+	if branches == nil {
+		branches = make([]*models.BookBranch, 0)
+	}
+	return branches
+}
+
@@ -62,6 +62,17 @@ func (this *BookController) Index() {
 	} else {
 		this.Data["Result"] = template.JS(string(b))
 	}
+	this.Data["Organizations"] = this.manageableOrganizations(0)
+
+	member_id := this.Member.MemberId
+	if this.Member.IsAdministrator() {
+		member_id = 0
+	}
+	if templates, err := models.NewBook().FindTemplates(member_id); err == nil {
+		this.Data["Templates"] = templates
+	} else {
+		beego.Error("FindTemplates => ", err)
+	}
 }
 
 //收藏书籍
@@ -93,6 +104,42 @@ func (this *BookController) Star() {
 
 }
 
+// Watch 查询或设置当前用户对项目和文档的关注.
+func (this *BookController) Watch() {
+	book_id, _ := this.GetInt("book_id", 0)
+	doc_id, _ := this.GetInt("doc_id", 0)
+
+	book, err := models.NewBook().Find(book_id)
+	if err != nil {
+		this.JsonResult(6001, "项目不存在")
+	}
+	if doc_id > 0 {
+		if doc, err := models.NewDocument().Find(doc_id); err != nil || doc.BookId != book.BookId {
+			this.JsonResult(6001, "文档不存在")
+		}
+	}
+	if !models.CanWatch(book, this.Member, doc_id) {
+		this.JsonResult(6002, "您没有权限关注该项目")
+	}
+	if this.Ctx.Input.IsPost() {
+		frequency := this.GetString("frequency")
+		if err := models.NewWatch().Save(this.Member.MemberId, book.BookId, doc_id, frequency); err != nil {
+			beego.Error("Watch.Save => ", err)
+			this.JsonResult(6005, "操作失败")
+		}
+	}
+	result := map[string]string{"book": "", "document": ""}
+	if watch, err := models.NewWatch().Find(this.Member.MemberId, book.BookId, 0); err == nil {
+		result["book"] = watch.Frequency
+	}
+	if doc_id > 0 {
+		if watch, err := models.NewWatch().Find(this.Member.MemberId, book.BookId, doc_id); err == nil {
+			result["document"] = watch.Frequency
+		}
+	}
+	this.JsonResult(0, "ok", result)
+}
+
 // Dashboard 项目概要 .
 func (this *BookController) Dashboard() {
 	this.Prepare()
@@ -114,6 +161,9 @@ func (this *BookController) Dashboard() {
 	}
 
 	this.Data["Model"] = *book
+	if check, err := models.NewLinkCheck().FindLatest(book.BookId); err == nil {
+		this.Data["LinkCheck"] = check
+	}
 }
 
 // Setting 项目设置 .
@@ -144,7 +194,39 @@ func (this *BookController) Setting() {
 		book.PrivateToken = this.BaseUrl() + beego.URLFor("DocumentController.Index", ":key", book.Identify, "token", book.PrivateToken)
 	}
 	this.Data["Model"] = book
+	this.Data["Organizations"] = this.manageableOrganizations(book.OrgId)
+	this.Data["Reviewers"] = strings.Join(models.NewBookReviewer().FindAccounts(book.BookId), ",")
+
+}
+
+//获取用户可以将项目归入的组织，包含项目当前所属的组织.
+func (this *BookController) manageableOrganizations(current_org_id int) []*models.Organization {
+	var orgs []*models.Organization
+	if this.Member.IsAdministrator() {
+		orgs, _, _ = models.NewOrganization().FindToPager(1, 1000)
+	} else {
+		orgs, _ = models.NewOrganization().FindManageableByMemberId(this.Member.MemberId)
+	}
+	if current_org_id > 0 {
+		for _, org := range orgs {
+			if org.OrgId == current_org_id {
+				return orgs
+			}
+		}
+		if org, err := models.NewOrganization().Find(current_org_id); err == nil {
+			orgs = append([]*models.Organization{org}, orgs...)
+		}
+	}
+	return orgs
+}
 
+//校验用户是否可以将项目归入指定组织.
+func (this *BookController) canUseOrganization(org_id int) bool {
+	if org_id <= 0 || this.Member.IsAdministrator() {
+		return true
+	}
+	role_id, err := models.NewOrganizationMember().FindRoleId(org_id, this.Member.MemberId)
+	return err == nil && (role_id == models.OrgRoleOwner || role_id == models.OrgRoleAdmin)
 }
 
 //保存项目信息
@@ -173,29 +255,75 @@ func (this *BookController) SaveBook() {
 	if comment_status != "open" && comment_status != "closed" && comment_status != "group_only" && comment_status != "registered_only" {
 		comment_status = "closed"
 	}
-	if tag != "" {
-		tags := strings.Split(tag, ",")
-		if len(tags) > 10 {
-			this.JsonResult(6005, "最多允许添加10个标签")
-		}
+	if len(models.ParseLabels(tag)) > 10 {
+		this.JsonResult(6005, "最多允许添加10个标签")
 	}
 	if editor != "markdown" && editor != "html" {
 		editor = "markdown"
 	}
+	enable_review, _ := this.GetInt("enable_review", 0)
+	if enable_review != 1 {
+		enable_review = 0
+	}
+	is_template, _ := this.GetInt("is_template", 0)
+	if is_template != 1 {
+		is_template = 0
+	}
+	//指定的审阅人必须是项目参与者
+	reviewer_ids := make([]int, 0)
+	reviewers := make([]string, 0)
+	for _, account := range strings.FieldsFunc(this.GetString("reviewers"), func(r rune) bool { return r == ',' || r == '，' || r == ' ' }) {
+		member, err := models.NewMember().FindByAccount(account)
+		if err != nil || member.Status != conf.MemberStatusNormal {
+			this.JsonResult(6008, "审阅人 "+account+" 不存在")
+		}
+		if _, err := models.NewRelationship().FindEffectiveRoleId(book.BookId, member.MemberId); err != nil {
+			this.JsonResult(6008, "审阅人 "+account+" 不是项目参与者")
+		}
+		reviewer_ids = append(reviewer_ids, member.MemberId)
+		reviewers = append(reviewers, member.Account)
+	}
+	original := map[string]interface{}{"book_name": book.BookName, "description": book.Description, "comment_status": book.CommentStatus, "label": book.Label, "editor": book.Editor, "org_id": book.OrgId, "enable_review": book.EnableReview, "is_template": book.IsTemplate}
+	original_reviewers := models.NewBookReviewer().FindAccounts(book.BookId)
+
+	//变更所属组织
+	if org_id, err := this.GetInt("org_id", book.OrgId); err == nil && org_id != book.OrgId {
+		if bookResult.RoleId != conf.BookFounder && !this.Member.IsAdministrator() {
+			this.JsonResult(6007, "只有创始人可以变更项目所属组织")
+		}
+		if !this.canUseOrganization(org_id) {
+			this.JsonResult(6007, "没有该组织的管理权限")
+		}
+		book.OrgId = org_id
+	}
 
 	book.BookName = book_name
 	book.Description = description
 	book.CommentStatus = comment_status
 	book.Label = tag
 	book.Editor = editor
+	book.EnableReview = enable_review
+	book.IsTemplate = is_template
 
 	if err := book.Update(); err != nil {
 		this.JsonResult(6006, "保存失败")
 	}
+	present := map[string]interface{}{"book_name": book.BookName, "description": book.Description, "comment_status": book.CommentStatus, "label": book.Label, "editor": book.Editor, "org_id": book.OrgId, "enable_review": book.EnableReview, "is_template": book.IsTemplate}
+	this.AuditLog(conf.AuditBookUpdate, book.BookId, book.BookId, "修改项目 "+book.BookName, original, present)
+
+	if strings.Join(original_reviewers, ",") != strings.Join(reviewers, ",") {
+		if err := models.NewBookReviewer().Replace(book.BookId, reviewer_ids); err != nil {
+			beego.Error("BookReviewer.Replace => ", err)
+			this.JsonResult(6006, "保存审阅人失败")
+		}
+		this.AuditLog(conf.AuditBookReviewer, book.BookId, book.BookId, "变更项目 "+book.BookName+" 的审阅人", map[string]interface{}{"reviewers": original_reviewers}, map[string]interface{}{"reviewers": reviewers})
+	}
 	bookResult.BookName = book_name
 	bookResult.Description = description
 	bookResult.CommentStatus = comment_status
-	bookResult.Label = tag
+	bookResult.Label = book.Label
+	bookResult.EnableReview = enable_review
+	bookResult.IsTemplate = is_template
 	this.JsonResult(0, "ok", bookResult)
 }
 
@@ -229,6 +357,7 @@ func (this *BookController) PrivatelyOwned() {
 		logs.Error("PrivatelyOwned => ", err)
 		this.JsonResult(6004, "保存失败")
 	}
+	this.AuditLog(conf.AuditBookPrivately, bookResult.BookId, bookResult.BookId, "变更项目 "+bookResult.BookName+" 的可见性", map[string]int{"privately_owned": bookResult.PrivatelyOwned}, map[string]int{"privately_owned": state})
 	this.JsonResult(0, "ok")
 }
 
@@ -264,6 +393,7 @@ func (this *BookController) Transfer() {
 		logs.Error("Transfer => ", err)
 		this.JsonResult(6008, err.Error())
 	}
+	this.AuditLog(conf.AuditBookTransfer, bookResult.BookId, member.MemberId, "将项目 "+bookResult.BookName+" 转让给 "+member.Account, map[string]int{"member_id": this.Member.MemberId}, map[string]int{"member_id": member.MemberId})
 	this.JsonResult(0, "ok")
 }
 
@@ -428,6 +558,240 @@ func (this *BookController) Users() {
 	} else {
 		this.Data["Result"] = template.JS(string(b))
 	}
+
+	teams, _ := models.NewTeamRelationship().FindByBookId(book.BookId)
+	if teams == nil {
+		teams = []*models.TeamRelationship{}
+	}
+	if b, err := json.Marshal(teams); err == nil {
+		this.Data["Teams"] = template.JS(string(b))
+	} else {
+		this.Data["Teams"] = template.JS("[]")
+	}
+}
+
+// Permission 文档权限管理.
+func (this *BookController) Permission() {
+	this.TplName = "book/permission.html"
+
+	key := this.Ctx.Input.Param(":key")
+	if key == "" {
+		this.Abort("404")
+	}
+
+	book, err := models.NewBookResult().FindByIdentify(key, this.Member.MemberId)
+	if err != nil {
+		if err == models.ErrPermissionDenied {
+			this.Abort("403")
+		}
+		this.Abort("500")
+	}
+	if book.RoleId != conf.BookFounder && book.RoleId != conf.BookAdmin {
+		this.Abort("403")
+	}
+	this.Data["Model"] = *book
+
+	rules, err := models.NewDocumentPermission().FindResultByBookId(book.BookId)
+	if err != nil {
+		beego.Error("FindResultByBookId => ", err)
+	}
+	this.Data["Result"] = template.JS("[]")
+	if b, err := json.Marshal(rules); err == nil && len(rules) > 0 {
+		this.Data["Result"] = template.JS(string(b))
+	}
+
+	trees, err := models.NewDocument().FindDocumentTree(book.BookId)
+	if err != nil {
+		beego.Error("FindDocumentTree => ", err)
+	}
+	this.Data["Documents"] = trees
+}
+
+// Share 项目分享链接管理.
+func (this *BookController) Share() {
+	this.TplName = "book/share.html"
+
+	key := this.Ctx.Input.Param(":key")
+	if key == "" {
+		this.Abort("404")
+	}
+
+	book, err := models.NewBookResult().FindByIdentify(key, this.Member.MemberId)
+	if err != nil {
+		if err == models.ErrPermissionDenied {
+			this.Abort("403")
+		}
+		this.Abort("500")
+	}
+	if book.RoleId != conf.BookFounder && book.RoleId != conf.BookAdmin {
+		this.Abort("403")
+	}
+	this.Data["Model"] = *book
+
+	shares, err := models.NewBookShare().FindByBookId(book.BookId)
+	if err != nil {
+		beego.Error("BookShare.FindByBookId => ", err)
+	}
+	for _, share := range shares {
+		share.ShareUrl = this.BaseUrl() + beego.URLFor("DocumentController.Share", ":token", share.Token)
+	}
+	this.Data["Result"] = template.JS("[]")
+	if b, err := json.Marshal(shares); err == nil && len(shares) > 0 {
+		this.Data["Result"] = template.JS(string(b))
+	}
+
+	trees, err := models.NewDocument().FindDocumentTree(book.BookId)
+	if err != nil {
+		beego.Error("FindDocumentTree => ", err)
+	}
+	this.Data["Documents"] = trees
+}
+
+// Reviews 项目的文档审阅.
+func (this *BookController) Reviews() {
+	this.TplName = "book/reviews.html"
+
+	key := this.Ctx.Input.Param(":key")
+	if key == "" {
+		this.Abort("404")
+	}
+	page, _ := this.GetInt("page", 1)
+	status, _ := this.GetInt("status", conf.ReviewPending)
+
+	book, err := models.NewBookResult().FindByIdentify(key, this.Member.MemberId)
+	if err != nil {
+		if err == models.ErrPermissionDenied {
+			this.Abort("403")
+		}
+		beego.Error(err)
+		this.Abort("500")
+	}
+	this.Data["Model"] = *book
+
+	reviews, totalCount, err := models.NewDocumentReview().FindToPager(book.BookId, status, page, conf.PageSize)
+	if err != nil {
+		beego.Error("DocumentReview.FindToPager => ", err)
+	}
+	if totalCount > conf.PageSize {
+		this.Data["PageHtml"] = utils.NewPaginations(conf.RollPage, totalCount, conf.PageSize, page, beego.URLFor("BookController.Reviews", ":key", book.Identify), "", fmt.Sprintf("status=%d", status))
+	}
+	this.Data["Lists"] = reviews
+	this.Data["Status"] = status
+	this.Data["CanReview"] = models.CanReviewBook(book.BookId, this.Member)
+	this.Data["Reviewers"] = strings.Join(models.NewBookReviewer().FindAccounts(book.BookId), ", ")
+}
+
+// Versions 项目版本.
+func (this *BookController) Versions() {
+	this.TplName = "book/versions.html"
+
+	key := this.Ctx.Input.Param(":key")
+	if key == "" {
+		this.Abort("404")
+	}
+
+	book, err := models.NewBookResult().FindByIdentify(key, this.Member.MemberId)
+	if err != nil {
+		if err == models.ErrPermissionDenied {
+			this.Abort("403")
+		}
+		this.Abort("500")
+	}
+	if book.RoleId != conf.BookFounder && book.RoleId != conf.BookAdmin {
+		this.Abort("403")
+	}
+	this.Data["Model"] = *book
+
+	versions, err := models.NewBookVersion().FindByBookId(book.BookId)
+	if err != nil {
+		beego.Error("BookVersion.FindByBookId => ", err)
+	}
+	this.Data["Result"] = template.JS("[]")
+	if b, err := json.Marshal(versions); err == nil && len(versions) > 0 {
+		this.Data["Result"] = template.JS(string(b))
+	}
+}
+
+// Branches 项目分支.
+func (this *BookController) Branches() {
+	this.TplName = "book/branches.html"
+
+	key := this.Ctx.Input.Param(":key")
+	if key == "" {
+		this.Abort("404")
+	}
+
+	book, err := models.NewBookResult().FindByIdentify(key, this.Member.MemberId)
+	if err != nil {
+		if err == models.ErrPermissionDenied {
+			this.Abort("403")
+		}
+		this.Abort("500")
+	}
+	if book.RoleId != conf.BookFounder && book.RoleId != conf.BookAdmin {
+		this.Abort("403")
+	}
+	this.Data["Model"] = *book
+
+	//项目本身是分支时显示与源项目的差异
+	this.Data["Changes"] = template.JS("[]")
+	if branch, err := models.NewBookBranch().FindByBookId(book.BookId); err == nil && branch.BranchId > 0 {
+		if source, err := models.NewBook().Find(branch.SourceBookId); err == nil {
+			this.Data["Source"] = source
+		}
+		changes, err := branch.Compare()
+		if err != nil {
+			beego.Error("BookBranch.Compare => ", err)
+		}
+		if b, err := json.Marshal(changes); err == nil && len(changes) > 0 {
+			this.Data["Changes"] = template.JS(string(b))
+		}
+		return
+	}
+	branches, err := models.NewBookBranch().FindBySourceBookId(book.BookId)
+	if err != nil {
+		beego.Error("BookBranch.FindBySourceBookId => ", err)
+	}
+	this.Data["Result"] = template.JS("[]")
+	if b, err := json.Marshal(branches); err == nil && len(branches) > 0 {
+		this.Data["Result"] = template.JS(string(b))
+	}
+}
+
+// Recycle 项目回收站.
+func (this *BookController) Recycle() {
+	this.TplName = "book/recycle.html"
+
+	key := this.Ctx.Input.Param(":key")
+	if key == "" {
+		this.Abort("404")
+	}
+
+	book, err := models.NewBookResult().FindByIdentify(key, this.Member.MemberId)
+	if err != nil {
+		if err == models.ErrPermissionDenied {
+			this.Abort("403")
+		}
+		this.Abort("500")
+	}
+	if book.RoleId != conf.BookFounder && book.RoleId != conf.BookAdmin {
+		this.Abort("403")
+	}
+	this.Data["Model"] = *book
+
+	pageIndex, _ := this.GetInt("page", 1)
+	items, totalCount, err := models.NewRecycleBin().FindToPager(book.BookId, conf.RecycleDocument, pageIndex, conf.PageSize)
+	if err != nil {
+		beego.Error("RecycleBin.FindToPager => ", err)
+		this.Abort("500")
+	}
+	if totalCount > 0 {
+		this.Data["PageHtml"] = utils.NewPaginations(conf.RollPage, totalCount, conf.PageSize, pageIndex, beego.URLFor("BookController.Recycle", ":key", book.Identify), "")
+	} else {
+		this.Data["PageHtml"] = ""
+	}
+	this.Data["Lists"] = items
+	this.Data["KeepDays"] = models.GetOptionValue("RECYCLE_KEEP_DAYS", "30")
 }
 
 // Create 创建项目.
@@ -439,10 +803,22 @@ func (this *BookController) Create() {
 		description := strings.TrimSpace(this.GetString("description", ""))
 		privately_owned, _ := strconv.Atoi(this.GetString("privately_owned"))
 		comment_status := this.GetString("comment_status")
+		org_id, _ := this.GetInt("org_id", 0)
 
 		if book_name == "" {
 			this.JsonResult(6001, "项目名称不能为空")
 		}
+		if !this.canUseOrganization(org_id) {
+			this.JsonResult(6007, "没有该组织的管理权限")
+		}
+		//未选择可见性时使用组织的默认设置
+		if org_id > 0 && this.GetString("privately_owned") == "" {
+			if org, err := models.NewOrganization().Find(org_id); err == nil {
+				privately_owned = org.PrivatelyOwned
+			} else {
+				this.JsonResult(6007, "组织不存在")
+			}
+		}
 		if identify == "" {
 			this.JsonResult(6002, "项目标识不能为空")
 		}
@@ -467,9 +843,24 @@ func (this *BookController) Create() {
 
 		book := models.NewBook()
 
-		if books, _ := book.FindByField("identify", identify); len(books) > 0 {
+		if book.ExistIdentify(identify) {
 			this.JsonResult(6006, "项目标识已存在")
 		}
+		//以模板或已有项目为起点创建
+		var source *models.Book
+		if template := this.GetString("template"); template != "" {
+			source = this.findCloneSource(template)
+			if source == nil {
+				this.JsonResult(6008, "模板不存在或权限不足")
+			}
+		}
+		option := models.BookCloneOption{
+			WithContent: this.GetString("with_content") == "1",
+			WithMembers: this.GetString("with_members") == "1",
+		}
+		if source != nil && option.WithMembers && !this.canManageBook(source.BookId) {
+			this.JsonResult(6008, "只有模板项目的创始人或管理员可以复制成员")
+		}
 
 		book.Label = utils.SegWord(book_name)
 		book.BookName = book_name
@@ -480,6 +871,7 @@ func (this *BookController) Create() {
 		book.Identify = identify
 		book.DocCount = 0
 		book.MemberId = this.Member.MemberId
+		book.OrgId = org_id
 		book.CommentCount = 0
 		book.Version = time.Now().Unix()
 		book.Cover = conf.GetDefaultCover()
@@ -492,13 +884,23 @@ func (this *BookController) Create() {
 		book.GenerateTime, _ = time.Parse("2006-01-02 15:04:05", "2000-01-02 15:04:05") //默认生成文档的时间
 		book.ReleaseTime = defaultTime
 
-		err := book.Insert()
-
-		if err != nil {
-			logs.Error("Insert => ", err)
-			this.JsonResult(6005, "保存项目失败")
+		if source != nil {
+			result, err := book.InsertFromTemplate(source, option)
+			if err != nil {
+				logs.Error("InsertFromTemplate => ", err)
+				this.JsonResult(6005, "保存项目失败")
+			}
+			this.AuditLog(conf.AuditBookClone, book.BookId, book.BookId, "从项目 "+source.BookName+" 克隆项目 "+book.BookName,
+				map[string]interface{}{"book_id": source.BookId, "identify": source.Identify},
+				map[string]interface{}{"identify": book.Identify, "privately_owned": book.PrivatelyOwned, "org_id": book.OrgId, "with_content": option.WithContent, "with_members": option.WithMembers, "doc_count": result.DocCount})
+		} else {
+			if err := book.Insert(); err != nil {
+				logs.Error("Insert => ", err)
+				this.JsonResult(6005, "保存项目失败")
+			}
+			this.AuditLog(conf.AuditBookCreate, book.BookId, book.BookId, "创建项目 "+book.BookName, nil, map[string]interface{}{"identify": book.Identify, "privately_owned": book.PrivatelyOwned, "org_id": book.OrgId})
 		}
-		bookResult, err := models.NewBookResult().FindByIdentify(book.Identify, this.Member.MemberId)
+		bookResult, err := models.NewBookResult().FindByBookId(book.BookId, this.Member.MemberId)
 
 		if err != nil {
 			beego.Error(err)
@@ -509,6 +911,36 @@ func (this *BookController) Create() {
 	this.JsonResult(6001, "error")
 }
 
+//查询可以作为新项目起点的项目：用户可以阅读的模板，或用户管理的项目.
+func (this *BookController) findCloneSource(identify string) *models.Book {
+	source, err := models.NewBook().FindByIdentify(identify)
+	if err != nil {
+		return nil
+	}
+	if this.Member.IsAdministrator() || this.canManageBook(source.BookId) {
+		return source
+	}
+	if source.IsTemplate != 1 {
+		return nil
+	}
+	if source.PrivatelyOwned == 0 {
+		return source
+	}
+	if _, err := models.NewRelationship().FindEffectiveRoleId(source.BookId, this.Member.MemberId); err != nil {
+		return nil
+	}
+	return source
+}
+
+//当前用户是否是项目的创始人或管理员.
+func (this *BookController) canManageBook(book_id int) bool {
+	if this.Member.IsAdministrator() {
+		return true
+	}
+	role_id, err := models.NewRelationship().FindEffectiveRoleId(book_id, this.Member.MemberId)
+	return err == nil && (role_id == conf.BookFounder || role_id == conf.BookAdmin)
+}
+
 // CreateToken 创建访问来令牌.
 func (this *BookController) CreateToken() {
 
@@ -541,6 +973,7 @@ func (this *BookController) CreateToken() {
 			logs.Error("生成阅读令牌失败 => ", err)
 			this.JsonResult(6003, "生成阅读令牌失败")
 		}
+		this.AuditLog(conf.AuditBookToken, book.BookId, book.BookId, "生成项目 "+book.BookName+" 的访问令牌", nil, nil)
 		this.JsonResult(0, "ok", this.BaseUrl()+beego.URLFor("DocumentController.Index", ":key", book.Identify, "token", book.PrivateToken))
 	} else {
 		book.PrivateToken = ""
@@ -548,6 +981,7 @@ func (this *BookController) CreateToken() {
 			logs.Error("CreateToken => ", err)
 			this.JsonResult(6004, "删除令牌失败")
 		}
+		this.AuditLog(conf.AuditBookToken, book.BookId, book.BookId, "删除项目 "+book.BookName+" 的访问令牌", nil, nil)
 		this.JsonResult(0, "ok", "")
 	}
 }
@@ -564,8 +998,10 @@ func (this *BookController) Delete() {
 	if bookResult.RoleId != conf.BookFounder {
 		this.JsonResult(6002, "只有创始人才能删除项目")
 	}
-	err = models.NewBook().ThoroughDeleteBook(bookResult.BookId)
-
+	book, err := models.NewBook().Find(bookResult.BookId)
+	if err == nil {
+		err = models.NewRecycleBin().DeleteBook(book, this.Member.MemberId)
+	}
 	if err == orm.ErrNoRows {
 		this.JsonResult(6002, "项目不存在")
 	}
@@ -573,6 +1009,8 @@ func (this *BookController) Delete() {
 		logs.Error("删除项目 => ", err)
 		this.JsonResult(6003, "删除失败")
 	}
+	this.AuditLog(conf.AuditBookDelete, bookResult.BookId, bookResult.BookId, "删除项目 "+bookResult.BookName+" 到回收站",
+		map[string]interface{}{"book_id": bookResult.BookId, "identify": bookResult.Identify, "book_name": bookResult.BookName}, nil)
 
 	this.JsonResult(0, "ok")
 }
@@ -583,7 +1021,7 @@ func (this *BookController) Release() {
 	identify := this.GetString("identify")
 	book_id := 0
 	if this.Member.IsAdministrator() {
-		book, err := models.NewBook().FindByFieldFirst("identify", identify)
+		book, err := models.NewBook().FindByIdentify(identify)
 		if err != nil {
 			beego.Error(err)
 		}
@@ -617,8 +1055,9 @@ func (this *BookController) Release() {
 	utils.ReleaseMaps[book_id] = true
 
 	go func(identify string) {
-		models.NewDocument().ReleaseContent(book_id, this.BaseUrl())
+		models.NewDocument().ReleaseContent(book_id, this.Member.MemberId, this.BaseUrl())
 	}(identify)
+	this.AuditLog(conf.AuditBookRelease, book_id, book_id, "发布项目 "+identify, nil, nil)
 
 	this.JsonResult(0, "发布任务已推送到任务队列，稍后将在后台执行。")
 }
@@ -655,7 +1094,7 @@ func (this *BookController) SaveSort() {
 
 	book_id := 0
 	if this.Member.IsAdministrator() {
-		book, err := models.NewBook().FindByFieldFirst("identify", identify)
+		book, err := models.NewBook().FindByIdentify(identify)
 		if err != nil {
 
 		}
@@ -689,12 +1128,27 @@ func (this *BookController) SaveSort() {
 	}
 	qs := orm.NewOrm().QueryTable("md_documents").Filter("book_id", book_id)
 	now := time.Now()
+	access := models.NewDocumentAccessForMember(book_id, this.Member)
 	for _, item := range docs {
+		//没有编辑权限的文档不允许移动，也不允许移动到没有编辑权限的文档下
+		if !access.CanEdit(item.Id) || (item.Parent > 0 && !access.CanEdit(item.Parent)) {
+			continue
+		}
+		doc, err := models.NewDocument().Find(item.Id)
+		if err != nil || doc.BookId != book_id {
+			continue
+		}
 		qs.Filter("document_id", item.Id).Update(orm.Params{
 			"parent_id":   item.Parent,
 			"order_sort":  item.Sort,
 			"modify_time": now,
 		})
+		if doc.ParentId != item.Parent {
+			doc.ParentId = item.Parent
+			if err := doc.AddHistory(conf.HistoryMove, this.Member.MemberId); err != nil {
+				beego.Error("DocumentHistory InsertOrUpdate => ", err)
+			}
+		}
 	}
 	this.JsonResult(0, "ok")
 }
@@ -872,6 +1326,8 @@ func (this *BookController) unzipToData(book_id int, identify, zipfile, originFi
 								}, "markdown"); err != nil {
 									beego.Error(err)
 								}
+								doc.DocumentId = int(doc_id)
+								doc.AddHistory(conf.HistoryImport, this.Member.MemberId)
 							} else {
 								beego.Error(err.Error())
 							}
@@ -2,9 +2,11 @@ package controllers
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/JermineHu/DocStack/conf"
 	"github.com/JermineHu/DocStack/models"
+	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/logs"
 	"github.com/astaxie/beego/orm"
 )
@@ -52,6 +54,8 @@ func (this *BookMemberController) AddMember() {
 		memberRelationshipResult.RelationshipId = relationship.RelationshipId
 		memberRelationshipResult.BookId = book.BookId
 		memberRelationshipResult.ResolveRoleName()
+		this.AuditLog(conf.AuditBookMemberAdd, book.BookId, member.MemberId, "将用户 "+member.Account+" 添加到项目 "+book.BookName, nil, map[string]int{"role_id": role_id})
+		this.notifyMember(book.BookId, member.MemberId, this.Member.Account+" 将你添加到项目《"+book.BookName+"》，角色为 "+memberRelationshipResult.RoleName, beego.URLFor("DocumentController.Index", ":key", book.Identify))
 
 		this.JsonResult(0, "ok", memberRelationshipResult)
 	}
@@ -94,6 +98,7 @@ func (this *BookMemberController) ChangeRole() {
 		this.JsonResult(6004, "用户已被禁用")
 	}
 
+	original, _ := models.NewRelationship().FindForRoleId(book.BookId, member_id)
 	relationship, err := models.NewRelationship().UpdateRoleId(book.BookId, member_id, role)
 
 	if err != nil {
@@ -106,6 +111,10 @@ func (this *BookMemberController) ChangeRole() {
 	memberRelationshipResult.RelationshipId = relationship.RelationshipId
 	memberRelationshipResult.BookId = book.BookId
 	memberRelationshipResult.ResolveRoleName()
+	this.AuditLog(conf.AuditBookMemberRole, book.BookId, member_id, "变更用户 "+member.Account+" 在项目 "+book.BookName+" 中的角色", map[string]int{"role_id": original}, map[string]int{"role_id": relationship.RoleId})
+	if original != relationship.RoleId {
+		this.notifyMember(book.BookId, member_id, this.Member.Account+" 将你在项目《"+book.BookName+"》中的角色变更为 "+memberRelationshipResult.RoleName, beego.URLFor("DocumentController.Index", ":key", book.Identify))
+	}
 
 	this.JsonResult(0, "ok", memberRelationshipResult)
 }
@@ -141,6 +150,94 @@ func (this *BookMemberController) RemoveMember() {
 	if err != nil {
 		this.JsonResult(6007, err.Error())
 	}
+	account := ""
+	if member, err := models.NewMember().Find(member_id); err == nil {
+		account = member.Account
+	}
+	this.AuditLog(conf.AuditBookMemberRemove, book.BookId, member_id, "将用户 "+account+" 移出项目 "+book.BookName, nil, nil)
+	this.notifyMember(book.BookId, member_id, this.Member.Account+" 将你移出了项目《"+book.BookName+"》", "")
+	this.JsonResult(0, "ok")
+}
+
+// AddTeam 将团队加入项目.
+func (this *BookMemberController) AddTeam() {
+	team_name := strings.TrimSpace(this.GetString("team_name"))
+	role_id, _ := this.GetInt("role_id", conf.BookObserver)
+
+	if team_name == "" {
+		this.JsonResult(6001, "参数错误")
+	}
+	book, err := this.IsPermission()
+
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	if role_id < conf.BookAdmin || role_id > conf.BookObserver {
+		this.JsonResult(6002, "角色不正确")
+	}
+	team := models.NewTeam()
+	if err := orm.NewOrm().QueryTable(team.TableNameWithPrefix()).Filter("team_name", team_name).One(team); err != nil {
+		this.JsonResult(404, "团队不存在")
+	}
+
+	rel := models.NewTeamRelationship()
+	rel.TeamId = team.TeamId
+	rel.BookId = book.BookId
+	rel.RoleId = role_id
+
+	if _, err := rel.InsertOrUpdate(); err != nil {
+		this.JsonResult(6005, "添加团队失败")
+	}
+	this.AuditLog(conf.AuditBookTeamAdd, book.BookId, team.TeamId, "将团队 "+team.TeamName+" 添加到项目 "+book.BookName, nil, map[string]int{"role_id": role_id})
+	this.JsonResult(0, "ok", rel)
+}
+
+// ChangeTeamRole 变更团队在项目中的角色.
+func (this *BookMemberController) ChangeTeamRole() {
+	team_id, _ := this.GetInt("team_id", 0)
+	role_id, _ := this.GetInt("role_id", 0)
+
+	book, err := this.IsPermission()
+
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	if team_id <= 0 || role_id < conf.BookAdmin || role_id > conf.BookObserver {
+		this.JsonResult(6002, "参数错误")
+	}
+	team, err := models.NewTeam().Find(team_id)
+	if err != nil {
+		this.JsonResult(404, "团队不存在")
+	}
+	rel := models.NewTeamRelationship()
+	rel.TeamId = team_id
+	rel.BookId = book.BookId
+	rel.RoleId = role_id
+
+	if _, err := rel.InsertOrUpdate(); err != nil {
+		this.JsonResult(6005, "变更团队角色失败")
+	}
+	this.AuditLog(conf.AuditBookTeamRole, book.BookId, team_id, "变更团队 "+team.TeamName+" 在项目 "+book.BookName+" 中的角色", nil, map[string]int{"role_id": role_id})
+	this.JsonResult(0, "ok", rel)
+}
+
+// RemoveTeam 将团队移出项目.
+func (this *BookMemberController) RemoveTeam() {
+	team_id, _ := this.GetInt("team_id", 0)
+
+	book, err := this.IsPermission()
+
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	if team_id <= 0 {
+		this.JsonResult(6002, "参数错误")
+	}
+	if err := models.NewTeamRelationship().Delete(book.BookId, team_id); err != nil {
+		logs.Error("移除项目团队 => ", err)
+		this.JsonResult(6007, "移除团队失败")
+	}
+	this.AuditLog(conf.AuditBookTeamRemove, book.BookId, team_id, "将团队移出项目 "+book.BookName, nil, nil)
 	this.JsonResult(0, "ok")
 }
 
@@ -162,3 +259,16 @@ func (this *BookMemberController) IsPermission() (*models.BookResult, error) {
 	}
 	return book, nil
 }
+
+//发送项目成员变更通知.
+func (this *BookMemberController) notifyMember(book_id, member_id int, title, url string) {
+	notification := models.NewNotification()
+	notification.Type = conf.NotifyMember
+	notification.SenderId = this.Member.MemberId
+	notification.BookId = book_id
+	notification.Title = title
+	notification.Url = url
+	if err := notification.Send([]int{member_id}); err != nil {
+		beego.Error("Notification.Send => ", err)
+	}
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/JermineHu/DocStack/models"
+	"github.com/astaxie/beego"
+)
+
+//项目分享链接管理.
+type BookShareController struct {
+	BaseController
+}
+
+// List 获取项目的分享链接.
+func (this *BookShareController) List() {
+	book, err := this.bookAdminByIdentify(this.Ctx.Input.Param(":key"))
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	this.JsonResult(0, "ok", this.findShares(book.BookId))
+}
+
+// Create 创建分享链接.
+func (this *BookShareController) Create() {
+	book, err := this.bookAdminByIdentify(this.GetString("identify"))
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	if book.PrivatelyOwned == 0 {
+		this.JsonResult(6002, "公开项目不需要创建分享链接")
+	}
+	share := models.NewBookShare()
+	share.BookId = book.BookId
+	share.ShareName = this.GetString("share_name")
+	share.DocumentId, _ = this.GetInt("doc_id", 0)
+	share.MemberId = this.Member.MemberId
+
+	//过期时间精确到天，在当天结束时过期
+	if expire := strings.TrimSpace(this.GetString("expire_time")); expire != "" {
+		t, err := time.ParseInLocation("2006-01-02", expire, time.Local)
+		if err != nil {
+			this.JsonResult(6002, "过期时间格式错误")
+		}
+		share.ExpireTime = t.Add(24*time.Hour - time.Second)
+	}
+
+	if err := share.Insert(strings.TrimSpace(this.GetString("password"))); err != nil {
+		beego.Error("BookShare.Insert => ", err)
+		this.JsonResult(6005, err.Error())
+	}
+	this.JsonResult(0, "ok", this.findShares(book.BookId))
+}
+
+// Revoke 撤销分享链接.
+func (this *BookShareController) Revoke() {
+	share_id, _ := this.GetInt("share_id", 0)
+
+	book, err := this.bookAdminByIdentify(this.GetString("identify"))
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	if share_id <= 0 {
+		this.JsonResult(6002, "参数错误")
+	}
+	if err := models.NewBookShare().Revoke(book.BookId, share_id); err != nil {
+		beego.Error("BookShare.Revoke => ", err)
+		this.JsonResult(6005, "撤销失败")
+	}
+	this.JsonResult(0, "ok", this.findShares(book.BookId))
+}
+
+// Delete 删除分享链接.
+func (this *BookShareController) Delete() {
+	share_id, _ := this.GetInt("share_id", 0)
+
+	book, err := this.bookAdminByIdentify(this.GetString("identify"))
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	if share_id <= 0 {
+		this.JsonResult(6002, "参数错误")
+	}
+	if err := models.NewBookShare().Delete(book.BookId, share_id); err != nil {
+		beego.Error("BookShare.Delete => ", err)
+		this.JsonResult(6005, "删除失败")
+	}
+	this.JsonResult(0, "ok")
+}
+
+//查询项目的分享链接并生成访问地址.
+func (this *BookShareController) findShares(book_id int) []*models.BookShare {
+	shares, err := models.NewBookShare().FindByBookId(book_id)
+	if err != nil {
+		beego.Error("BookShare.FindByBookId => ", err)
+	}
+	for _, share := range shares {
+		share.ShareUrl = this.BaseUrl() + beego.URLFor("DocumentController.Share", ":token", share.Token)
+	}
+	if shares == nil {
+		shares = make([]*models.BookShare, 0)
+	}
+	return shares
+}
+
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/models"
+	"github.com/astaxie/beego"
+)
+
+//项目版本管理，版本创建后内容冻结，项目仍可以继续编辑.
+type BookVersionController struct {
+	BaseController
+}
+
+// List 获取项目的版本.
+func (this *BookVersionController) List() {
+	book, err := this.bookAdminByIdentify(this.Ctx.Input.Param(":key"))
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	this.JsonResult(0, "ok", this.findVersions(book.BookId))
+}
+
+// Create 创建项目版本，并在后台生成版本的下载文档.
+func (this *BookVersionController) Create() {
+	result, err := this.bookAdminByIdentify(this.GetString("identify"))
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	book, err := models.NewBook().Find(result.BookId)
+	if err != nil {
+		this.JsonResult(6001, "项目不存在")
+	}
+	version := models.NewBookVersion()
+	version.VersionName = this.GetString("version_name")
+	version.Description = strings.TrimSpace(this.GetString("description"))
+	version.MemberId = this.Member.MemberId
+
+	if err := version.Create(book); err != nil {
+		beego.Error("BookVersion.Create => ", err)
+		this.JsonResult(6005, err.Error())
+	}
+	this.AuditLog(conf.AuditVersionCreate, book.BookId, version.VersionId, "创建项目版本 "+version.VersionName, nil, map[string]interface{}{"version_name": version.VersionName, "doc_count": version.DocCount})
+
+	go version.Generate(book, "http://localhost:"+beego.AppConfig.String("httpport"))
+
+	this.JsonResult(0, "ok", this.findVersions(book.BookId))
+}
+
+// Generate 重新生成版本的下载文档.
+func (this *BookVersionController) Generate() {
+	book, version := this.findVersion()
+	if version.IsGenerating() {
+		this.JsonResult(6006, "下载文档正在生成，请稍后再试")
+	}
+	go version.Generate(book, "http://localhost:"+beego.AppConfig.String("httpport"))
+
+	this.JsonResult(0, "下载文档生成任务已交由后台执行，请您耐心等待。")
+}
+
+// Delete 删除项目版本.
+func (this *BookVersionController) Delete() {
+	book, version := this.findVersion()
+	if err := version.Delete(book); err != nil {
+		beego.Error("BookVersion.Delete => ", err)
+		this.JsonResult(6005, "删除失败")
+	}
+	this.AuditLog(conf.AuditVersionDelete, book.BookId, version.VersionId, "删除项目版本 "+version.VersionName, map[string]interface{}{"version_name": version.VersionName, "doc_count": version.DocCount}, nil)
+	this.JsonResult(0, "ok")
+}
+
+//查询要操作的项目版本.
+func (this *BookVersionController) findVersion() (*models.Book, *models.BookVersion) {
+	version_id, _ := this.GetInt("version_id", 0)
+
+	result, err := this.bookAdminByIdentify(this.GetString("identify"))
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	book, err := models.NewBook().Find(result.BookId)
+	if err != nil {
+		this.JsonResult(6001, "项目不存在")
+	}
+	version, err := models.NewBookVersion().Find(version_id)
+	if err != nil || version.BookId != book.BookId {
+		this.JsonResult(6002, "版本不存在")
+	}
+	return book, version
+}
+
+//查询项目的版本.
+func (this *BookVersionController) findVersions(book_id int) []*models.BookVersion {
+	versions, err := models.NewBookVersion().FindByBookId(book_id)
+	if err != nil {
+		beego.Error("BookVersion.FindByBookId => ", err)
+	}
+	if versions == nil {
+		versions = make([]*models.BookVersion, 0)
+	}
+	return versions
+}
+
@@ -1,19 +1,169 @@
 package controllers
 
+import (
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/models"
+	"github.com/astaxie/beego"
+)
+
 type CommentController struct {
 	BaseController
 }
 
+// Lists 文档评论列表.
 func (c *CommentController) Lists() {
+	doc_id, _ := c.GetInt("doc_id", 0)
+	pageIndex, _ := c.GetInt("page", 1)
+
+	_, book := c.readableDocument(doc_id)
 
+	comments, totalCount, err := models.NewCommentResult().FindForDocumentToPager(doc_id, c.Member.MemberId, pageIndex, conf.PageSize)
+	if err != nil {
+		beego.Error("CommentResult.FindForDocumentToPager => ", err)
+		c.JsonResult(6004, "查询评论失败")
+	}
+	if comments == nil {
+		comments = make([]*models.CommentResult, 0)
+	}
+	c.JsonResult(0, "ok", map[string]interface{}{
+		"total":          totalCount,
+		"count":          models.NewComment().CountByDocumentId(doc_id),
+		"page":           pageIndex,
+		"size":           conf.PageSize,
+		"comment_status": book.CommentStatus,
+		"can_comment":    models.CheckCommentStatus(book, c.Member.MemberId) == nil,
+		"lists":          comments,
+	})
 }
 
+// Create 发表评论或回复评论.
 func (c *CommentController) Create() {
+	doc_id, _ := c.GetInt("doc_id", 0)
+	parent_id, _ := c.GetInt("parent_id", 0)
+
+	doc, book := c.readableDocument(doc_id)
+
+	comment := models.NewComment()
+	comment.DocumentId = doc.DocumentId
+	comment.ParentId = parent_id
+	comment.MemberId = c.Member.MemberId
+	comment.Author = c.GetString("author")
+	comment.Content = c.GetString("content")
+	comment.IPAddress = c.Ctx.Input.IP()
+	comment.UserAgent = c.Ctx.Input.UserAgent()
+	if len(comment.UserAgent) > 500 {
+		comment.UserAgent = comment.UserAgent[:500]
+	}
+	comment.Approved = conf.CommentApproved
+
+	//开启评论审核后，项目参与者和管理员以外的评论需要审核
+	if c.Option["ENABLED_COMMENT_AUDIT"] == "true" && !c.Member.IsAdministrator() {
+		if _, err := models.NewRelationship().FindEffectiveRoleId(book.BookId, c.Member.MemberId); err != nil || c.Member.MemberId <= 0 {
+			comment.Approved = conf.CommentPending
+		}
+	}
+
+	if err := comment.Insert(); err != nil {
+		beego.Error("Comment.Insert => ", err)
+		if err == models.ErrPermissionDenied {
+			c.JsonResult(6003, "您没有权限评论该文档")
+		}
+		c.JsonResult(6005, err.Error())
+	}
+	result := models.NewCommentResult()
+	result.Comment = *comment
+	result.Avatar = c.Member.Avatar
+	if c.Member.Nickname != "" {
+		result.Author = c.Member.Nickname
+	}
+	if result.Avatar == "" {
+		result.Avatar = conf.GetDefaultAvatar()
+	}
+	result.Replies = make([]*models.CommentResult, 0)
+
+	if err := comment.Notify(); err != nil {
+		beego.Error("Comment.Notify => ", err)
+	}
+	if comment.Approved == conf.CommentPending {
+		c.JsonResult(0, "评论已提交，审核通过后显示", result)
+	}
+	c.JsonResult(0, "ok", result)
+}
 
+// Delete 删除评论，评论作者和项目管理者可以删除.
+func (c *CommentController) Delete() {
+	comment_id, _ := c.GetInt("comment_id", 0)
+
+	if c.Member.MemberId <= 0 {
+		c.JsonResult(6000, "请先登录")
+	}
+	comment := models.NewComment()
+	if _, err := comment.Find(comment_id); err != nil || comment.Approved == conf.CommentDeleted {
+		c.JsonResult(6002, "评论不存在")
+	}
+	if comment.MemberId != c.Member.MemberId && !c.Member.IsAdministrator() {
+		role_id, err := models.NewRelationship().FindEffectiveRoleId(comment.BookId, c.Member.MemberId)
+		if err != nil || (role_id != conf.BookFounder && role_id != conf.BookAdmin) {
+			c.JsonResult(6003, "您没有权限删除该评论")
+		}
+	}
+	if err := comment.SetApproved(conf.CommentDeleted); err != nil {
+		beego.Error("Comment.SetApproved => ", err)
+		c.JsonResult(6004, "删除评论失败")
+	}
+	c.AuditLog(conf.AuditCommentDelete, comment.BookId, comment.CommentId, "删除评论", comment.Content, nil)
 	c.JsonResult(0, "ok")
 }
 
+// Vote 赞成或反对评论，重复投票表示取消.
+func (c *CommentController) Vote() {
+	comment_id, _ := c.GetInt("comment_id", 0)
+	state, _ := c.GetInt("state", conf.CommentVoteAgree)
+
+	if c.Member.MemberId <= 0 {
+		c.JsonResult(6000, "请先登录")
+	}
+	comment := models.NewComment()
+	if _, err := comment.Find(comment_id); err != nil {
+		c.JsonResult(6002, "评论不存在")
+	}
+	c.readableDocument(comment.DocumentId)
+
+	if _, err := comment.Vote(c.Member.MemberId, state); err != nil {
+		beego.Error("Comment.Vote => ", err)
+		c.JsonResult(6005, err.Error())
+	}
+	c.JsonResult(0, "ok", map[string]int{
+		"comment_id":    comment.CommentId,
+		"agree_count":   comment.AgreeCount,
+		"against_count": comment.AgainstCount,
+	})
+}
+
 func (c *CommentController) Index() {
 	c.Prepare()
 	c.TplName = "comment/index.html"
 }
+
+//查询当前用户可以阅读的文档，私有项目需要是项目参与者或者通过分享链接、阅读令牌访问.
+func (c *CommentController) readableDocument(doc_id int) (*models.Document, *models.Book) {
+	if !c.EnableAnonymous && c.Member.MemberId <= 0 {
+		c.JsonResult(6000, "请先登录")
+	}
+	doc, err := models.NewDocument().Find(doc_id)
+	if err != nil {
+		c.JsonResult(6001, "文档不存在")
+	}
+	book, err := models.NewBook().Find(doc.BookId)
+	if err != nil {
+		c.JsonResult(6001, "项目不存在")
+	}
+	access, err := c.readableAccess(book, c.GetString("token"))
+	if err != nil {
+		c.JsonResult(6003, "您没有权限访问该文档")
+	}
+	if !access.PublishedOnly().CanRead(doc.DocumentId) {
+		c.JsonResult(6003, "您没有权限访问该文档")
+	}
+	return doc, book
+}
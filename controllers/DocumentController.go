@@ -32,43 +32,27 @@ import (
 //DocumentController struct.
 type DocumentController struct {
 	BaseController
+	//通过组织地址访问时的组织
+	org *models.Organization
 }
 
 //判断用户是否可以阅读文档.
 func isReadable(identify, token string, this *DocumentController) *models.BookResult {
-	book, err := models.NewBook().FindByFieldFirst("identify", identify)
+	book, err := models.NewBook().FindByIdentify(identify)
 	if err != nil {
 		beego.Error(err)
 		this.Abort("404")
 	}
+	//组织地址只能访问属于该组织的项目
+	if this.org != nil && book.OrgId != this.org.OrgId {
+		this.Abort("404")
+	}
 
-	//如果文档是私有的
-	if book.PrivatelyOwned == 1 && !this.Member.IsAdministrator() {
-
-		is_ok := false
-
-		if this.Member != nil {
-			_, err := models.NewRelationship().FindForRoleId(book.BookId, this.Member.MemberId)
-			if err == nil {
-				is_ok = true
-			}
-		}
-		if book.PrivateToken != "" && !is_ok {
-			//如果有访问的Token，并且该项目设置了访问Token，并且和用户提供的相匹配，则记录到Session中.
-			//如果用户未提供Token且用户登录了，则判断用户是否参与了该项目.
-			//如果用户未登录，则从Session中读取Token.
-			if token != "" && strings.EqualFold(token, book.PrivateToken) {
-				this.SetSession(identify, token)
-
-			} else if token, ok := this.GetSession(identify).(string); !ok || !strings.EqualFold(token, book.PrivateToken) {
-				this.Abort("403")
-			}
-		} else if !is_ok {
-			this.Abort("403")
-		}
-
+	if _, err := this.readableAccess(book, token); err != nil {
+		this.Abort("403")
 	}
 	bookResult := book.ToBookResult()
+	is_member := false
 
 	if this.Member != nil {
 
@@ -78,6 +62,13 @@ func isReadable(identify, token string, this *DocumentController) *models.BookRe
 			bookResult.MemberId = rel.MemberId
 			bookResult.RoleId = rel.RoleId
 			bookResult.RelationshipId = rel.RelationshipId
+			is_member = true
+		}
+		//团队和组织授权
+		if role_id, err := models.NewRelationship().FindIndirectRoleId(bookResult.BookId, this.Member.MemberId); err == nil && (!is_member || role_id < bookResult.RoleId) {
+			bookResult.MemberId = this.Member.MemberId
+			bookResult.RoleId = role_id
+			is_member = true
 		}
 	}
 	//判断是否需要显示评论框
@@ -86,7 +77,7 @@ func isReadable(identify, token string, this *DocumentController) *models.BookRe
 	} else if bookResult.CommentStatus == "open" {
 		bookResult.IsDisplayComment = true
 	} else if bookResult.CommentStatus == "group_only" {
-		bookResult.IsDisplayComment = bookResult.RelationshipId > 0
+		bookResult.IsDisplayComment = is_member
 	} else if bookResult.CommentStatus == "registered_only" {
 		bookResult.IsDisplayComment = true
 	}
@@ -94,6 +85,99 @@ func isReadable(identify, token string, this *DocumentController) *models.BookRe
 	return bookResult
 }
 
+//获取当前用户可以阅读的已发布文档范围.
+func (this *DocumentController) documentAccess(book_id int) *models.DocumentAccess {
+	return this.bookAccess(book_id).PublishedOnly()
+}
+
+//查询读者要阅读的项目版本，未指定版本时返回 nil.
+func (this *DocumentController) findVersion(book_id int) *models.BookVersion {
+	version_id, _ := this.GetInt("version", 0)
+	if version_id <= 0 {
+		return nil
+	}
+	version, err := models.NewBookVersion().Find(version_id)
+	if err != nil || version.BookId != book_id {
+		this.Abort("404")
+	}
+	return version
+}
+
+//获取当前用户在项目版本中的文档访问控制，按版本中的目录结构校验文档权限.
+func (this *DocumentController) versionAccess(book_id int, docs []*models.BookVersionDocument) *models.DocumentAccess {
+	return this.bookAccess(book_id).UseParents(models.VersionDocumentParents(docs))
+}
+
+//当前用户是否可以预览文档草稿，返回用户在项目中的角色，超级管理员视为项目创始人.
+func (this *DocumentController) previewRole(book_id, doc_id int) (int, bool) {
+	if this.Member.MemberId <= 0 {
+		return -1, false
+	}
+	role_id := conf.BookFounder
+	if !this.Member.IsAdministrator() {
+		var err error
+		if role_id, err = models.NewRelationship().FindEffectiveRoleId(book_id, this.Member.MemberId); err != nil {
+			return -1, false
+		}
+	}
+	return role_id, models.NewDocumentAccessForMember(book_id, this.Member).CanRead(doc_id)
+}
+
+// Share 分享链接入口.
+func (this *DocumentController) Share() {
+	token := this.Ctx.Input.Param(":token")
+
+	share, err := models.NewBookShare().FindByToken(token)
+	if err != nil {
+		this.Abort("404")
+	}
+	book, err := models.NewBook().Find(share.BookId)
+	if err != nil {
+		this.Abort("404")
+	}
+	this.TplName = "document/share.html"
+	this.Data["SeoTitle"] = share.ShareName + " - " + this.Sitename
+	this.Data["Model"] = share
+	this.Data["BookName"] = book.BookName
+
+	if !share.IsValid() {
+		this.Data["ErrorMessage"] = "分享链接已失效"
+		return
+	}
+	if share.Password != "" {
+		if !this.Ctx.Input.IsPost() {
+			return
+		}
+		//同一来源每小时只能尝试有限次数，避免猜测密码
+		ip := this.Ctx.Input.IP()
+		attempt := models.NewBookShareAttempt()
+		if count, err := attempt.FindFailCount(share.ShareId, ip); err != nil {
+			beego.Error("BookShareAttempt.FindFailCount => ", err)
+			this.Data["ErrorMessage"] = "系统错误，请稍后再试"
+			return
+		} else if count >= beego.AppConfig.DefaultInt("share_password_attempts", 5) {
+			this.Data["ErrorMessage"] = "密码错误次数太多，请稍后再试"
+			return
+		}
+		if !share.CheckPassword(this.GetString("password")) {
+			if err := attempt.Insert(share.ShareId, ip); err != nil {
+				beego.Error("BookShareAttempt.Insert => ", err)
+			}
+			this.Data["ErrorMessage"] = "访问密码错误"
+			return
+		}
+	}
+	this.SetSession(shareSessionKey(book.Identify), share.Token)
+	if err := share.IncrViewCount(); err != nil {
+		beego.Error("BookShare.IncrViewCount => ", err)
+	}
+	if share.DocumentId > 0 {
+		this.Redirect(beego.URLFor("DocumentController.Read", ":key", book.Identify, ":id", share.DocumentId), 302)
+	} else {
+		this.Redirect(beego.URLFor("DocumentController.Index", ":key", book.Identify), 302)
+	}
+}
+
 //文档首页.
 func (this *DocumentController) Index() {
 	identify := this.Ctx.Input.Param(":key")
@@ -113,6 +197,12 @@ func (this *DocumentController) Index() {
 		this.Redirect(beego.URLFor("HomeController.Index"), 302)
 		return
 	}
+	this.setBookUrls(bookResult.Identify)
+	//只分享了部分文档时直接进入分享的文档
+	if this.share != nil && this.share.DocumentId > 0 {
+		this.Redirect(beego.URLFor("DocumentController.Read", ":key", bookResult.Identify, ":id", this.share.DocumentId), 302)
+		return
+	}
 	this.TplName = "document/intro.html"
 	this.Data["Book"] = bookResult
 
@@ -126,7 +216,17 @@ func (this *DocumentController) Index() {
 	this.Data["Tab"] = tab
 	//当前默认展示30条评论
 	this.Data["Comments"], _ = new(models.Comments).BookComments(1, 30, bookResult.BookId)
-	this.Data["Menu"], _ = new(models.Document).GetMenuTop(bookResult.BookId)
+	this.Data["Versions"], _ = models.NewBookVersion().FindByBookId(bookResult.BookId)
+	if version := this.findVersion(bookResult.BookId); version != nil {
+		docs, err := version.FindDocuments(false)
+		if err != nil {
+			beego.Error("BookVersion.FindDocuments => ", err)
+		}
+		this.Data["Version"] = version
+		this.Data["Menu"] = this.versionAccess(bookResult.BookId, docs).FilterDocuments(models.VersionMenuTop(docs))
+	} else if menu, err := new(models.Document).GetMenuTop(bookResult.BookId); err == nil {
+		this.Data["Menu"] = this.documentAccess(bookResult.BookId).FilterDocuments(menu)
+	}
 	this.GetSeoByPage("book_info", map[string]string{
 		"title":       bookResult.BookName,
 		"keywords":    bookResult.Label,
@@ -134,6 +234,49 @@ func (this *DocumentController) Index() {
 	})
 }
 
+//组织下的文档首页.
+func (this *DocumentController) OrgIndex() {
+	this.checkOrganization()
+	this.Index()
+}
+
+//组织下的阅读文档.
+func (this *DocumentController) OrgRead() {
+	this.checkOrganization()
+	this.Read()
+}
+
+//查询组织地址中的组织，项目标识全站唯一，组织地址是校验项目归属后的别名.
+func (this *DocumentController) checkOrganization() {
+	org, err := models.NewOrganization().FindByIdentify(this.Ctx.Input.Param(":org"))
+	if err != nil {
+		this.Abort("404")
+	}
+	this.org = org
+	this.Data["Organization"] = org
+}
+
+//设置项目首页和阅读地址，通过组织地址访问时保持组织前缀.
+func (this *DocumentController) setBookUrls(identify string) {
+	if this.org != nil {
+		this.Data["IndexUrl"] = beego.URLFor("DocumentController.OrgIndex", ":org", this.org.Identify, ":key", identify)
+		this.Data["ReadUrl"] = strings.TrimSuffix(beego.URLFor("DocumentController.OrgRead", ":org", this.org.Identify, ":key", identify, ":id", "0"), "/0")
+	} else {
+		this.Data["IndexUrl"] = beego.URLFor("DocumentController.Index", ":key", identify)
+		this.Data["ReadUrl"] = strings.TrimSuffix(beego.URLFor("DocumentController.Read", ":key", identify, ":id", "0"), "/0")
+	}
+}
+
+//将目录中的阅读地址替换为组织地址.
+func (this *DocumentController) orgTreeLinks(identify, tree string) string {
+	if this.org == nil {
+		return tree
+	}
+	read := strings.TrimSuffix(beego.URLFor("DocumentController.Read", ":key", identify, ":id", "0"), "0")
+	org_read := strings.TrimSuffix(beego.URLFor("DocumentController.OrgRead", ":org", this.org.Identify, ":key", identify, ":id", "0"), "0")
+	return strings.Replace(tree, `href="`+read, `href="`+org_read, -1)
+}
+
 //阅读文档.
 func (this *DocumentController) Read() {
 	identify := this.Ctx.Input.Param(":key")
@@ -151,8 +294,16 @@ func (this *DocumentController) Read() {
 	}
 
 	bookResult := isReadable(identify, token, this)
+	this.setBookUrls(bookResult.Identify)
 
 	this.TplName = "document/" + bookResult.Theme + "_read.html"
+	this.Data["Versions"], _ = models.NewBookVersion().FindByBookId(bookResult.BookId)
+
+	//阅读项目版本中的文档
+	if version := this.findVersion(bookResult.BookId); version != nil {
+		this.readVersion(bookResult, version, id)
+		return
+	}
 
 	doc := models.NewDocument()
 
@@ -174,31 +325,24 @@ func (this *DocumentController) Read() {
 	if doc.BookId != bookResult.BookId {
 		this.Abort("403")
 	}
+	//未发布的文档只有项目参与者可以预览草稿
+	if doc.PublishStatus != conf.DocumentPublished {
+		if _, ok := this.previewRole(bookResult.BookId, doc.DocumentId); ok {
+			this.Redirect(beego.URLFor("DocumentController.Preview", ":key", bookResult.Identify, ":id", doc.DocumentId), 302)
+			return
+		}
+		this.Abort("404")
+	}
+	access := this.documentAccess(bookResult.BookId)
+	if !access.CanRead(doc.DocumentId) {
+		this.Abort("403")
+	}
 	attach, err := models.NewAttachment().FindListByDocumentId(doc.DocumentId)
 	if err == nil {
 		doc.AttachList = attach
 	}
 
-	cdnimg := beego.AppConfig.String("cdnimg")
-	if doc.Release != "" && cdnimg != "" {
-		query, err := goquery.NewDocumentFromReader(bytes.NewBufferString(doc.Release))
-		if err != nil {
-			beego.Error(err)
-		} else {
-			query.Find("img").Each(func(i int, contentSelection *goquery.Selection) {
-				if src, ok := contentSelection.Attr("src"); ok && strings.HasPrefix(src, "/uploads/") {
-					contentSelection.SetAttr("src", utils.JoinURI(cdnimg, src))
-				}
-			})
-			html, err := query.Html()
-			if err != nil {
-				beego.Error(err)
-			} else {
-				doc.Release = html
-			}
-		}
-
-	}
+	doc.Release = cdnImages(doc.Release)
 
 	//文档阅读人次+1
 	if err := models.SetIncreAndDecre("md_documents", "vcnt",
@@ -215,28 +359,34 @@ func (this *DocumentController) Read() {
 		beego.Error(err.Error())
 	}
 
-	//SEO
-	this.GetSeoByPage("book_read", map[string]string{
+	//SEO，文档元数据中设置了标题、描述和标签时优先使用
+	seo := map[string]string{
 		"title":       doc.DocumentName + " - " + bookResult.BookName,
 		"keywords":    bookResult.Label,
 		"description": bookResult.Description,
-	})
-
-	if this.IsAjax() {
-		var data struct {
-			DocTitle string `json:"doc_title"`
-			Body     string `json:"body"`
-			Title    string `json:"title"`
+		"author":      "",
+	}
+	if meta, err := models.NewDocumentMeta().Find(doc.DocumentId); err != nil {
+		beego.Error("DocumentMeta.Find => ", err)
+	} else {
+		if meta.Title != "" {
+			seo["title"] = meta.Title + " - " + bookResult.BookName
 		}
-		data.DocTitle = doc.DocumentName
-		data.Body = doc.Release
-		//data.Body = doc.Markdown
-		data.Title = this.Data["SeoTitle"].(string)
+		if meta.Description != "" {
+			seo["description"] = meta.Description
+		}
+		if meta.Tags != "" {
+			seo["keywords"] = strings.Trim(meta.Tags+","+bookResult.Label, ",")
+		}
+		seo["author"] = meta.Author
+	}
+	this.GetSeoByPage("book_read", seo)
 
-		this.JsonResult(0, "ok", data)
+	if this.IsAjax() {
+		this.readResult(doc.DocumentId, doc.DocumentName, doc.Release)
 	}
 
-	tree, err := models.NewDocument().CreateDocumentTreeForHtml(bookResult.BookId, doc.DocumentId)
+	tree, err := models.NewDocument().CreateDocumentTreeForHtml(bookResult.BookId, doc.DocumentId, access)
 
 	if err != nil {
 		beego.Error(err)
@@ -245,12 +395,100 @@ func (this *DocumentController) Read() {
 
 	this.Data["Model"] = bookResult
 	this.Data["Book"] = bookResult //文档下载需要用到Book变量
-	this.Data["Result"] = template.HTML(tree)
+	this.Data["Result"] = template.HTML(this.orgTreeLinks(bookResult.Identify, tree))
 	this.Data["Title"] = doc.DocumentName
+	this.Data["DocumentId"] = doc.DocumentId
+	//项目参与者可以查看和添加批注
+	this.Data["IsAnnotator"] = this.Member.IsAdministrator() || (this.Member.MemberId > 0 && access.RoleId >= 0)
 	this.Data["Content"] = template.HTML(doc.Release)
 
 }
 
+//阅读项目版本中的文档，版本内容已冻结，不支持批注和评论.
+func (this *DocumentController) readVersion(bookResult *models.BookResult, version *models.BookVersion, id string) {
+	doc, err := version.FindDocument(id)
+	if err != nil {
+		this.Abort("404")
+	}
+	docs, err := version.FindDocuments(false)
+	if err != nil {
+		beego.Error("BookVersion.FindDocuments => ", err)
+		this.Abort("500")
+	}
+	access := this.versionAccess(bookResult.BookId, docs)
+	if !access.CanRead(doc.DocumentId) {
+		this.Abort("403")
+	}
+	release := cdnImages(doc.Release)
+
+	//项目阅读人次+1
+	if err := models.SetIncreAndDecre("md_books", "vcnt",
+		fmt.Sprintf("book_id=%v", bookResult.BookId),
+		true, 1,
+	); err != nil {
+		beego.Error(err.Error())
+	}
+
+	this.GetSeoByPage("book_read", map[string]string{
+		"title":       doc.DocumentName + " - " + bookResult.BookName + " " + version.VersionName,
+		"keywords":    bookResult.Label,
+		"description": bookResult.Description,
+	})
+
+	if this.IsAjax() {
+		this.readResult(doc.DocumentId, doc.DocumentName, release)
+	}
+	bookResult.IsDisplayComment = false
+
+	this.Data["Model"] = bookResult
+	this.Data["Book"] = bookResult
+	this.Data["Version"] = version
+	this.Data["Result"] = template.HTML(this.orgTreeLinks(bookResult.Identify, version.CreateDocumentTreeForHtml(docs, bookResult.Identify, doc.DocumentId, access)))
+	this.Data["Title"] = doc.DocumentName
+	this.Data["DocumentId"] = doc.DocumentId
+	this.Data["Content"] = template.HTML(release)
+}
+
+//通过ajax阅读文档时返回文档内容.
+func (this *DocumentController) readResult(doc_id int, doc_title, body string) {
+	var data struct {
+		DocId    int    `json:"doc_id"`
+		DocTitle string `json:"doc_title"`
+		Body     string `json:"body"`
+		Title    string `json:"title"`
+	}
+	data.DocId = doc_id
+	data.DocTitle = doc_title
+	data.Body = body
+	data.Title = this.Data["SeoTitle"].(string)
+
+	this.JsonResult(0, "ok", data)
+}
+
+//将发布内容中的本地图片替换为CDN地址.
+func cdnImages(release string) string {
+	cdnimg := beego.AppConfig.String("cdnimg")
+	if release == "" || cdnimg == "" {
+		return release
+	}
+	query, err := goquery.NewDocumentFromReader(bytes.NewBufferString(release))
+	if err != nil {
+		beego.Error(err)
+		return release
+	}
+	query.Find("img").Each(func(i int, contentSelection *goquery.Selection) {
+		if src, ok := contentSelection.Attr("src"); ok && strings.HasPrefix(src, "/uploads/") {
+			contentSelection.SetAttr("src", utils.JoinURI(cdnimg, src))
+		}
+	})
+	html, err := query.Html()
+	if err != nil {
+		beego.Error(err)
+		return release
+	}
+	return html
+}
+
 //编辑文档.
 func (this *DocumentController) Edit() {
 
@@ -263,7 +501,7 @@ func (this *DocumentController) Edit() {
 	var err error
 	//如果是超级管理者，则不判断权限
 	if this.Member.IsAdministrator() {
-		book, err := models.NewBook().FindByFieldFirst("identify", identify)
+		book, err := models.NewBook().FindByIdentify(identify)
 		if err != nil {
 			this.JsonResult(6002, "项目不存在或权限不足")
 		}
@@ -305,6 +543,13 @@ func (this *DocumentController) Edit() {
 	if err != nil {
 		beego.Error("FindDocumentTree => ", err)
 	} else {
+		//只显示有编辑权限的文档
+		if access := models.NewDocumentAccessForMember(bookResult.BookId, this.Member); access.HasRules() {
+			trees = access.FilterEditTree(trees)
+			if len(trees) > 0 && trees[0].State == nil {
+				trees[0].State = &models.DocumentSelected{Selected: true, Opened: true}
+			}
+		}
 		if len(trees) > 0 {
 			if jtree, err := json.Marshal(trees); err == nil {
 				this.Data["Result"] = template.JS(string(jtree))
@@ -362,7 +607,7 @@ func (this *DocumentController) Create() {
 	book_id := 0
 	//如果是超级管理员则不判断权限
 	if this.Member.IsAdministrator() {
-		book, err := models.NewBook().FindByFieldFirst("identify", identify)
+		book, err := models.NewBook().FindByIdentify(identify)
 		if err != nil {
 			beego.Error(err)
 			this.JsonResult(6002, "项目不存在或权限不足")
@@ -383,9 +628,24 @@ func (this *DocumentController) Create() {
 			this.JsonResult(6003, "父分类不存在")
 		}
 	}
+	//未提交标签时保留文档原有的标签
+	_, has_label := this.Input()["doc_label"]
+	doc_label := this.GetString("doc_label")
+	if has_label && len(models.ParseLabels(doc_label)) > 10 {
+		this.JsonResult(6005, "最多允许添加10个标签")
+	}
+	access := models.NewDocumentAccessForMember(book_id, this.Member)
+	if (parent_id > 0 && !access.CanEdit(parent_id)) || (doc_id > 0 && !access.CanEdit(doc_id)) {
+		this.JsonResult(6002, "没有该文档的编辑权限")
+	}
 
 	document, _ := models.NewDocument().Find(doc_id)
 
+	var original interface{}
+	old_name, old_parent_id := document.DocumentName, document.ParentId
+	if document.DocumentId > 0 {
+		original = map[string]interface{}{"doc_name": document.DocumentName, "identify": document.Identify, "parent_id": document.ParentId}
+	}
 	document.MemberId = this.Member.MemberId
 	document.BookId = book_id
 	if doc_identify != "" {
@@ -406,6 +666,26 @@ func (this *DocumentController) Create() {
 				beego.Error(err)
 			}
 		}
+		if has_label {
+			if _, err := models.NewDocumentLabel().Replace(int(doc_id), doc_label); err != nil {
+				beego.Error("DocumentLabel.Replace => ", err)
+			}
+		}
+		action := conf.HistoryCreate
+		if original != nil {
+			action = ""
+			if old_parent_id != document.ParentId {
+				action = conf.HistoryMove
+			} else if old_name != document.DocumentName {
+				action = conf.HistoryRename
+			}
+		}
+		if action != "" {
+			if err := document.AddHistory(action, this.Member.MemberId); err != nil {
+				beego.Error("DocumentHistory InsertOrUpdate => ", err)
+			}
+		}
+		this.AuditLog(conf.AuditDocumentSave, book_id, int(doc_id), "保存文档 "+document.DocumentName, original, map[string]interface{}{"doc_name": document.DocumentName, "identify": document.Identify, "parent_id": document.ParentId})
 		this.JsonResult(0, "ok", document)
 	}
 }
@@ -437,6 +717,8 @@ func (this *DocumentController) CreateMulti() {
 									if err := ModelStore.InsertOrUpdate(models.DocumentStore{DocumentId: int(doc_id), Markdown: "[TOC]\n\r\n\r"}); err != nil {
 										beego.Error(err.Error())
 									}
+									doc.DocumentId = int(doc_id)
+									doc.AddHistory(conf.HistoryCreate, this.Member.MemberId)
 								} else {
 									beego.Error(err)
 								}
@@ -492,7 +774,7 @@ func (this *DocumentController) Upload() {
 	book_id := 0
 	//如果是超级管理员，则不判断权限
 	if this.Member.IsAdministrator() {
-		book, err := models.NewBook().FindByFieldFirst("identify", identify)
+		book, err := models.NewBook().FindByIdentify(identify)
 		if err != nil {
 			this.JsonResult(6006, "文档不存在或权限不足")
 		}
@@ -522,6 +804,9 @@ func (this *DocumentController) Upload() {
 		if doc.BookId != book_id {
 			this.JsonResult(6008, "文档不属于指定的项目")
 		}
+		if !models.NewDocumentAccessForMember(book_id, this.Member).CanEdit(doc_id) {
+			this.JsonResult(6006, "没有该文档的编辑权限")
+		}
 	}
 
 	fileName := strconv.FormatInt(time.Now().UnixNano(), 16)
@@ -593,6 +878,15 @@ func (this *DocumentController) Upload() {
 		}
 		attachment.HttpPath = "/" + osspath
 	}
+	//图片已移动到项目存储目录，记录移动后的地址
+	if !is_attach {
+		if utils.StoreType == utils.StoreLocal {
+			attachment.FilePath = "/" + osspath
+		}
+		if err := attachment.Update(); err != nil {
+			beego.Error("Attachment Update => ", err)
+		}
+	}
 
 	result := map[string]interface{}{
 		"errcode":   0,
@@ -615,34 +909,8 @@ func (this *DocumentController) DownloadAttachment() {
 	attach_id, _ := strconv.Atoi(this.Ctx.Input.Param(":attach_id"))
 	token := this.GetString("token")
 
-	member_id := 0
-
-	if this.Member != nil {
-		member_id = this.Member.MemberId
-	}
-	book_id := 0
-
-	//判断用户是否参与了项目
-	bookResult, err := models.NewBookResult().FindByIdentify(identify, member_id)
-
-	if err != nil {
-		//判断项目公开状态
-		book, err := models.NewBook().FindByFieldFirst("identify", identify)
-		if err != nil {
-			this.Abort("404")
-		}
-		//如果不是超级管理员则判断权限
-		if this.Member == nil || this.Member.Role != conf.MemberSuperRole {
-			//如果项目是私有的，并且token不正确
-			if (book.PrivatelyOwned == 1 && token == "") || (book.PrivatelyOwned == 1 && book.PrivateToken != token) {
-				this.Abort("403")
-			}
-		}
-
-		book_id = book.BookId
-	} else {
-		book_id = bookResult.BookId
-	}
+	//判断用户是否有阅读权限
+	book_id := isReadable(identify, token, this).BookId
 	//查找附件
 	attachment, err := models.NewAttachment().Find(attach_id)
 
@@ -657,6 +925,20 @@ func (this *DocumentController) DownloadAttachment() {
 	if attachment.BookId != book_id {
 		this.Abort("404")
 	}
+	if attachment.DocumentId > 0 {
+		//文档已移入回收站时不再提供下载
+		if doc, err := models.NewDocument().Find(attachment.DocumentId); err != nil || doc.BookId != book_id {
+			this.Abort("404")
+		}
+		access := this.documentAccess(book_id)
+		//项目参与者可以下载草稿中的附件
+		if access.RoleId >= 0 || this.Member.IsAdministrator() {
+			access = models.NewDocumentAccessForMember(book_id, this.Member)
+		}
+		if !access.CanRead(attachment.DocumentId) {
+			this.Abort("403")
+		}
+	}
 	this.Ctx.Output.Download(filepath.Join(commands.WorkingDirectory, attachment.FilePath), attachment.FileName)
 
 	this.StopRun()
@@ -683,15 +965,18 @@ func (this *DocumentController) RemoveAttachment() {
 		this.JsonResult(6003, "文档不存在")
 	}
 	if this.Member.Role != conf.MemberSuperRole {
-		rel, err := models.NewRelationship().FindByBookIdAndMemberId(document.BookId, this.Member.MemberId)
+		role_id, err := models.NewRelationship().FindEffectiveRoleId(document.BookId, this.Member.MemberId)
 		if err != nil {
 			beego.Error(err)
 			this.JsonResult(6004, "权限不足")
 		}
-		if rel.RoleId == conf.BookObserver {
+		if role_id == conf.BookObserver {
 			this.JsonResult(6004, "权限不足")
 		}
 	}
+	if !models.NewDocumentAccessForMember(document.BookId, this.Member).CanEdit(document.DocumentId) {
+		this.JsonResult(6004, "权限不足")
+	}
 	err = attach.Delete()
 
 	if err != nil {
@@ -699,6 +984,7 @@ func (this *DocumentController) RemoveAttachment() {
 		this.JsonResult(6005, "删除失败")
 	}
 	os.Remove(filepath.Join(commands.WorkingDirectory, attach.FilePath))
+	this.AuditLog(conf.AuditAttachmentDelete, document.BookId, attach.AttachmentId, "删除文档 "+document.DocumentName+" 的附件 "+attach.FileName, attach, nil)
 
 	this.JsonResult(0, "ok", attach)
 }
@@ -712,7 +998,7 @@ func (this *DocumentController) Delete() {
 	book_id := 0
 	//如果是超级管理员则忽略权限判断
 	if this.Member.IsAdministrator() {
-		book, err := models.NewBook().FindByFieldFirst("identify", identify)
+		book, err := models.NewBook().FindByIdentify(identify)
 		if err != nil {
 			beego.Error("FindByIdentify => ", err)
 			this.JsonResult(6002, "项目不存在或权限不足")
@@ -742,13 +1028,19 @@ func (this *DocumentController) Delete() {
 	if doc.BookId != book_id {
 		this.JsonResult(6004, "参数错误")
 	}
-	//递归删除项目下的文档以及子文档
-	err = doc.RecursiveDocument(doc.DocumentId)
-	if err != nil {
+	if !models.NewDocumentAccessForMember(book_id, this.Member).CanEditTree(doc.DocumentId) {
+		this.JsonResult(6002, "没有该文档或其子文档的编辑权限")
+	}
+	if err := doc.AddHistory(conf.HistoryDelete, this.Member.MemberId); err != nil {
+		beego.Error("DocumentHistory InsertOrUpdate => ", err)
+	}
+	//将文档以及子文档放入回收站
+	recycle := models.NewRecycleBin()
+	if err := recycle.DeleteDocument(doc, this.Member.MemberId); err != nil {
+		beego.Error("RecycleBin.DeleteDocument => ", err)
 		this.JsonResult(6005, "删除失败")
 	}
-	//重置文档数量统计
-	models.NewBook().ResetDocumentNumber(doc.BookId)
+	this.AuditLog(conf.AuditDocumentDelete, book_id, doc.DocumentId, "删除文档 "+doc.DocumentName+" 及其子文档到回收站", map[string]interface{}{"doc_name": doc.DocumentName, "identify": doc.Identify, "parent_id": doc.ParentId}, map[string]interface{}{"recycle_id": recycle.RecycleId, "doc_count": recycle.DocCount})
 
 	this.JsonResult(0, "ok")
 }
@@ -764,7 +1056,7 @@ func (this *DocumentController) Content() {
 	book_id := 0
 	//如果是超级管理员，则忽略权限
 	if this.Member.IsAdministrator() {
-		book, err := models.NewBook().FindByFieldFirst("identify", identify)
+		book, err := models.NewBook().FindByIdentify(identify)
 		if err != nil {
 			this.JsonResult(6002, "项目不存在或权限不足")
 		}
@@ -782,6 +1074,9 @@ func (this *DocumentController) Content() {
 	if doc_id <= 0 {
 		this.JsonResult(6001, "参数错误")
 	}
+	if !models.NewDocumentAccessForMember(book_id, this.Member).CanEdit(doc_id) {
+		this.JsonResult(6002, "没有该文档的编辑权限")
+	}
 	ModelStore := new(models.DocumentStore)
 	if this.Ctx.Input.IsPost() { //更新文档内容
 		markdown := strings.TrimSpace(this.GetString("markdown", ""))
@@ -832,6 +1127,7 @@ func (this *DocumentController) Content() {
 		content = this.replaceLinks(identify, content, is_summary)
 
 		var ds = models.DocumentStore{}
+		old_markdown := ModelStore.GetFiledById(doc.DocumentId, "markdown")
 
 		if markdown == "" && content != "" {
 			ds.Markdown = content
@@ -848,27 +1144,29 @@ func (this *DocumentController) Content() {
 			if err := ModelStore.InsertOrUpdate(ds, "markdown", "content"); err != nil {
 				beego.Error(err)
 			}
+			//文档内容变化后重新定位批注
+			if err := models.NewAnnotation().Reanchor(ds.DocumentId, ds.Markdown); err != nil {
+				beego.Error("Annotation.Reanchor => ", err)
+			}
+			if err := models.NewDocumentInclude().Replace(ds.DocumentId, ds.Markdown); err != nil {
+				beego.Error("DocumentInclude.Replace => ", err)
+			}
+			if _, err := models.NewDocumentMeta().Replace(ds.DocumentId, ds.Markdown); err != nil {
+				beego.Error("DocumentMeta.Replace => ", err)
+			}
+			if err := models.NotifyDocumentMentions(identify, doc, this.Member, old_markdown, ds.Markdown); err != nil {
+				beego.Error("NotifyDocumentMentions => ", err)
+			}
 		}
 		//如果启用了文档历史，则添加历史文档
 		if this.EnableDocumentHistory {
-
-			history := models.NewDocumentHistory()
-			history.DocumentId = doc_id
-			history.Content = ds.Content
-			history.Markdown = ds.Markdown
-			history.DocumentName = doc.DocumentName
-			history.ModifyAt = this.Member.MemberId
-			history.MemberId = doc.MemberId
-			history.ParentId = doc.ParentId
-			history.Version = time.Now().Unix()
-			history.Action = "modify"
-			history.ActionName = "修改文档"
-			_, err = history.InsertOrUpdate()
-			if err != nil {
+			if err := doc.AddHistory(conf.HistoryModify, this.Member.MemberId); err != nil {
 				beego.Error("DocumentHistory InsertOrUpdate => ", err)
 			}
 		}
 
+		this.AuditLog(conf.AuditDocumentContent, book_id, doc_id, "编辑文档 "+doc.DocumentName+" 的内容", map[string]int64{"version": version}, map[string]int64{"version": doc.Version})
+
 		//doc.Markdown = ""
 		//doc.Content = ""
 		doc.Release = ""
@@ -912,6 +1210,17 @@ func (this *DocumentController) Export() {
 		} else {
 			//查询文档是否存在
 			obj := fmt.Sprintf("projects/%v/books/%v%v", book.Identify, book.GenerateTime.Unix(), ext)
+			//下载项目版本的文档
+			if version_id, _ := this.GetInt("version", 0); version_id > 0 {
+				version, err := models.NewBookVersion().Find(version_id)
+				if err != nil || version.BookId != book.BookId {
+					this.JsonResult(1, "下载失败，版本不存在")
+				}
+				if version.IsGenerating() {
+					this.JsonResult(1, "该版本的下载文档正在生成，请稍后再试")
+				}
+				obj = version.ExportObject(book.Identify) + ext
+			}
 			switch utils.StoreType {
 			case utils.StoreOss:
 				if err := models.ModelStoreOss.IsObjectExist(obj); err != nil {
@@ -1100,7 +1409,7 @@ func (this *DocumentController) Search() {
 	}
 	bookResult := isReadable(identify, token, this)
 
-	docs, err := models.NewDocumentSearchResult().SearchDocument(keyword, bookResult.BookId)
+	docs, err := models.NewDocumentSearchResult().SearchDocument(keyword, bookResult.BookId, this.metaFilter())
 
 	if err != nil {
 		beego.Error(err)
@@ -1109,6 +1418,16 @@ func (this *DocumentController) Search() {
 	if len(docs) < 0 {
 		this.JsonResult(404, "没有数据库")
 	}
+	//过滤没有阅读权限的文档
+	if access := this.documentAccess(bookResult.BookId); access.HasRules() {
+		readable := make([]*models.DocumentSearchResult, 0, len(docs))
+		for _, doc := range docs {
+			if access.CanRead(doc.DocumentId) {
+				readable = append(readable, doc)
+			}
+		}
+		docs = readable
+	}
 	for _, doc := range docs {
 		doc.BookId = bookResult.BookId
 		doc.BookName = bookResult.BookName
@@ -1131,7 +1450,7 @@ func (this *DocumentController) History() {
 	book_id := 0
 	//如果是超级管理员则忽略权限判断
 	if this.Member.IsAdministrator() {
-		book, err := models.NewBook().FindByFieldFirst("identify", identify)
+		book, err := models.NewBook().FindByIdentify(identify)
 		if err != nil {
 			beego.Error("FindByIdentify => ", err)
 			this.Data["ErrorMessage"] = "项目不存在或权限不足"
@@ -1168,6 +1487,10 @@ func (this *DocumentController) History() {
 		this.Data["ErrorMessage"] = "参数错误"
 		return
 	}
+	if !models.NewDocumentAccessForMember(book_id, this.Member).CanEdit(doc_id) {
+		this.Data["ErrorMessage"] = "没有该文档的编辑权限"
+		return
+	}
 
 	historis, totalCount, err := models.NewDocumentHistory().FindToPager(doc_id, pageIndex, conf.PageSize)
 
@@ -1202,7 +1525,7 @@ func (this *DocumentController) DeleteHistory() {
 	book_id := 0
 	//如果是超级管理员则忽略权限判断
 	if this.Member.IsAdministrator() {
-		book, err := models.NewBook().FindByFieldFirst("identify", identify)
+		book, err := models.NewBook().FindByIdentify(identify)
 		if err != nil {
 			beego.Error("FindByIdentify => ", err)
 			this.JsonResult(6002, "项目不存在或权限不足")
@@ -1232,11 +1555,15 @@ func (this *DocumentController) DeleteHistory() {
 	if doc.BookId != book_id {
 		this.JsonResult(6001, "参数错误")
 	}
+	if !models.NewDocumentAccessForMember(book_id, this.Member).CanEdit(doc_id) {
+		this.JsonResult(6002, "没有该文档的编辑权限")
+	}
 	err = models.NewDocumentHistory().Delete(history_id, doc_id)
 	if err != nil {
 		beego.Error(err)
 		this.JsonResult(6002, "删除失败")
 	}
+	this.AuditLog(conf.AuditHistoryDelete, book_id, doc_id, "删除文档 "+doc.DocumentName+" 的历史版本", map[string]int{"history_id": history_id}, nil)
 	this.JsonResult(0, "ok")
 }
 
@@ -1254,7 +1581,7 @@ func (this *DocumentController) RestoreHistory() {
 	book_id := 0
 	//如果是超级管理员则忽略权限判断
 	if this.Member.IsAdministrator() {
-		book, err := models.NewBook().FindByFieldFirst("identify", identify)
+		book, err := models.NewBook().FindByIdentify(identify)
 		if err != nil {
 			beego.Error("FindByIdentify => ", err)
 			this.JsonResult(6002, "项目不存在或权限不足")
@@ -1284,14 +1611,438 @@ func (this *DocumentController) RestoreHistory() {
 	if doc.BookId != book_id {
 		this.JsonResult(6001, "参数错误")
 	}
+	if !models.NewDocumentAccessForMember(book_id, this.Member).CanEdit(doc_id) {
+		this.JsonResult(6002, "没有该文档的编辑权限")
+	}
 	err = models.NewDocumentHistory().Restore(history_id, doc_id, this.Member.MemberId)
 	if err != nil {
 		beego.Error(err)
 		this.JsonResult(6002, "删除失败")
 	}
+	this.AuditLog(conf.AuditHistoryRestore, book_id, doc_id, "将文档 "+doc.DocumentName+" 恢复到历史版本", nil, map[string]int{"history_id": history_id})
 	this.JsonResult(0, "ok", doc)
 }
 
+// RequestReview 请求项目参与者审阅文档.
+func (this *DocumentController) RequestReview() {
+	identify := this.Ctx.Input.Param(":key")
+	doc_id, _ := this.GetInt("doc_id", 0)
+	message := strings.TrimSpace(this.GetString("message"))
+
+	book, err := models.NewBook().FindByIdentify(identify)
+	if err != nil {
+		this.JsonResult(6002, "项目不存在或权限不足")
+	}
+	if !this.Member.IsAdministrator() {
+		if role_id, err := models.NewRelationship().FindEffectiveRoleId(book.BookId, this.Member.MemberId); err != nil || role_id == conf.BookObserver {
+			this.JsonResult(6002, "项目不存在或权限不足")
+		}
+	}
+	doc, err := models.NewDocument().Find(doc_id)
+	if err != nil || doc.BookId != book.BookId {
+		this.JsonResult(6001, "文档不存在")
+	}
+	if !models.NewDocumentAccessForMember(book.BookId, this.Member).CanEdit(doc.DocumentId) {
+		this.JsonResult(6002, "没有该文档的编辑权限")
+	}
+	member_ids := make([]int, 0)
+	for _, account := range strings.FieldsFunc(this.GetString("accounts"), func(r rune) bool { return r == ',' || r == '，' || r == ' ' || r == '@' }) {
+		member, err := models.NewMember().FindByAccount(account)
+		if err != nil || member.Status != conf.MemberStatusNormal {
+			this.JsonResult(6003, "用户 "+account+" 不存在")
+		}
+		if !member.IsAdministrator() {
+			if _, err := models.NewRelationship().FindEffectiveRoleId(book.BookId, member.MemberId); err != nil {
+				this.JsonResult(6003, "用户 "+account+" 不是项目参与者")
+			}
+		}
+		if !models.NewDocumentAccessForMember(book.BookId, member).CanRead(doc.DocumentId) {
+			this.JsonResult(6003, "用户 "+account+" 没有该文档的阅读权限")
+		}
+		if book.EnableReview == 1 && !models.CanReviewBook(book.BookId, member) {
+			this.JsonResult(6003, "用户 "+account+" 不是项目的审阅人")
+		}
+		member_ids = append(member_ids, member.MemberId)
+	}
+	notification := models.NewNotification()
+	notification.Type = conf.NotifyReview
+	notification.SenderId = this.Member.MemberId
+	notification.BookId = book.BookId
+	notification.DocumentId = doc.DocumentId
+	notification.Url = models.NotificationDocumentUrl(book.Identify, doc)
+	notification.Title = this.Member.Account + " 请你审阅文档《" + doc.DocumentName + "》"
+
+	//开启审阅的项目提交文档快照，未填写审阅人时通知全部审阅人
+	if book.EnableReview == 1 {
+		review := models.NewDocumentReview()
+		if err := review.Submit(doc, this.Member.MemberId, message); err != nil {
+			beego.Error("DocumentReview.Submit => ", err)
+			this.JsonResult(6005, err.Error())
+		}
+		this.AuditLog(conf.AuditReviewSubmit, book.BookId, doc.DocumentId, "提交文档 "+doc.DocumentName+" 审阅", nil, map[string]int64{"version": review.Version})
+
+		if len(member_ids) == 0 {
+			for _, member_id := range models.FindBookReviewerIds(book.BookId) {
+				member, err := models.NewMember().Find(member_id)
+				if err == nil && models.NewDocumentAccessForMember(book.BookId, member).CanRead(doc.DocumentId) {
+					member_ids = append(member_ids, member_id)
+				}
+			}
+		}
+		notification.ObjectId = review.ReviewId
+		notification.Url = beego.URLFor("BookController.Reviews", ":key", book.Identify)
+		notification.Title = this.Member.Account + " 提交了文档《" + doc.DocumentName + "》的修订，等待审阅"
+	} else if len(member_ids) == 0 {
+		this.JsonResult(6001, "请填写审阅人")
+	}
+	if message != "" {
+		notification.Title += "：" + models.NotificationSummary(message)
+	}
+	if err := notification.Send(member_ids); err != nil {
+		this.JsonResult(6005, "发送审阅请求失败")
+	}
+	this.JsonResult(0, "ok")
+}
+
+// Preview 预览文档的草稿.
+func (this *DocumentController) Preview() {
+	identify := this.Ctx.Input.Param(":key")
+	doc_id, _ := strconv.Atoi(this.Ctx.Input.Param(":id"))
+
+	if this.Member.MemberId <= 0 {
+		this.Redirect(beego.URLFor("AccountController.Login"), 302)
+		return
+	}
+	book, err := models.NewBook().FindByIdentify(identify)
+	if err != nil {
+		this.Abort("404")
+	}
+	doc, err := models.NewDocument().Find(doc_id)
+	if err != nil || doc.BookId != book.BookId {
+		this.Abort("404")
+	}
+	role_id, ok := this.previewRole(book.BookId, doc.DocumentId)
+	if !ok {
+		this.Abort("403")
+	}
+	this.TplName = "document/preview.html"
+	this.Data["SeoTitle"] = "预览 " + doc.DocumentName + " - " + this.Sitename
+	this.Data["Model"] = book.ToBookResult()
+	this.Data["Document"] = doc
+	this.Data["StatusName"] = models.PublishStatusName(doc.PublishStatus)
+	this.Data["Content"] = template.HTML(new(models.DocumentStore).GetFiledById(doc.DocumentId, "content"))
+	this.Data["CanPublish"] = role_id != conf.BookObserver && models.NewDocumentAccessForMember(book.BookId, this.Member).CanEdit(doc.DocumentId)
+}
+
+// Publish 立即发布或取消发布文档.
+func (this *DocumentController) Publish() {
+	book, doc := this.findPublishable()
+	action := this.GetString("action")
+
+	original := map[string]interface{}{"publish_status": doc.PublishStatus, "publish_version": doc.PublishVersion}
+	if action == "unpublish" {
+		if err := doc.Unpublish(doc.DocumentId); err != nil {
+			beego.Error("Document.Unpublish => ", err)
+			this.JsonResult(6005, "取消发布失败")
+		}
+	} else if err := doc.Publish(doc.DocumentId, this.Member.MemberId); err != nil {
+		beego.Error("Document.Publish => ", err)
+		this.JsonResult(6005, err.Error())
+	}
+	doc, _ = models.NewDocument().Find(doc.DocumentId)
+	present := map[string]interface{}{"publish_status": doc.PublishStatus, "publish_version": doc.PublishVersion}
+	this.AuditLog(conf.AuditDocumentPublish, book.BookId, doc.DocumentId, models.PublishStatusName(doc.PublishStatus)+"文档 "+doc.DocumentName, original, present)
+
+	doc.Release = ""
+	this.JsonResult(0, "ok", doc)
+}
+
+// SchedulePublish 设置文档的定时发布时间，时间为空时取消定时发布.
+func (this *DocumentController) SchedulePublish() {
+	book, doc := this.findPublishable()
+
+	var schedule_time time.Time
+	if value := strings.TrimSpace(this.GetString("schedule_time")); value != "" {
+		t, err := time.ParseInLocation("2006-01-02 15:04", strings.Replace(value, "T", " ", 1), time.Local)
+		if err != nil {
+			this.JsonResult(6004, "定时发布时间格式错误")
+		}
+		schedule_time = t
+	}
+	if err := doc.Schedule(doc.DocumentId, schedule_time); err != nil {
+		this.JsonResult(6005, err.Error())
+	}
+	present := "取消定时发布"
+	if !schedule_time.IsZero() {
+		present = schedule_time.Format("2006-01-02 15:04")
+	}
+	this.AuditLog(conf.AuditDocumentSchedule, book.BookId, doc.DocumentId, "定时发布文档 "+doc.DocumentName, nil, map[string]string{"schedule_time": present})
+
+	doc, _ = models.NewDocument().Find(doc.DocumentId)
+	doc.Release = ""
+	this.JsonResult(0, "ok", doc)
+}
+
+//查询当前用户可以发布的文档，需要是项目编辑者以上角色并且有文档的编辑权限.
+func (this *DocumentController) findPublishable() (*models.Book, *models.Document) {
+	book, err := models.NewBook().FindByIdentify(this.Ctx.Input.Param(":key"))
+	if err != nil {
+		this.JsonResult(6002, "项目不存在或权限不足")
+	}
+	doc_id, _ := this.GetInt("doc_id", 0)
+	doc, err := models.NewDocument().Find(doc_id)
+	if err != nil || doc.BookId != book.BookId {
+		this.JsonResult(6001, "文档不存在")
+	}
+	role_id, ok := this.previewRole(book.BookId, doc.DocumentId)
+	if !ok || role_id == conf.BookObserver {
+		this.JsonResult(6002, "项目不存在或权限不足")
+	}
+	if !models.NewDocumentAccessForMember(book.BookId, this.Member).CanEdit(doc.DocumentId) {
+		this.JsonResult(6002, "没有该文档的编辑权限")
+	}
+	return book, doc
+}
+
+// UsedBy 查询引用了文档的文档，只返回当前用户可以阅读的文档.
+func (this *DocumentController) UsedBy() {
+	_, doc := this.findPublishable()
+
+	includes, err := models.NewDocumentInclude().FindUsedBy(doc.DocumentId)
+	if err != nil {
+		beego.Error("DocumentInclude.FindUsedBy => ", err)
+		this.JsonResult(6005, "查询失败")
+	}
+	result := make([]*models.DocumentInclude, 0, len(includes))
+	accesses := make(map[int]*models.DocumentAccess)
+	for _, include := range includes {
+		if access := this.readerAccess(accesses, include.BookId); access != nil && access.CanRead(include.DocumentId) {
+			key := include.DocIdentify
+			if key == "" {
+				key = strconv.Itoa(include.DocumentId)
+			}
+			include.Url = beego.URLFor("DocumentController.Read", ":key", include.Identify, ":id", key)
+			result = append(result, include)
+		}
+	}
+	this.JsonResult(0, "ok", result)
+}
+
+// Labels 查询文档的标签.
+func (this *DocumentController) Labels() {
+	_, doc := this.findPublishable()
+
+	labels, err := models.NewDocumentLabel().FindLabels(doc.DocumentId)
+	if err != nil {
+		beego.Error("DocumentLabel.FindLabels => ", err)
+		this.JsonResult(6005, "查询失败")
+	}
+	this.JsonResult(0, "ok", labels)
+}
+
+// SaveLabels 设置文档的标签，多个标签以逗号分隔.
+func (this *DocumentController) SaveLabels() {
+	book, doc := this.findPublishable()
+
+	labels := this.GetString("labels")
+	if len(models.ParseLabels(labels)) > 10 {
+		this.JsonResult(6005, "最多允许添加10个标签")
+	}
+	original := models.NewDocumentLabel().FindLabelNames(doc.DocumentId)
+	present, err := models.NewDocumentLabel().Replace(doc.DocumentId, labels)
+	if err != nil {
+		beego.Error("DocumentLabel.Replace => ", err)
+		this.JsonResult(6005, "保存标签失败")
+	}
+	this.AuditLog(conf.AuditDocumentSave, book.BookId, doc.DocumentId, "修改文档 "+doc.DocumentName+" 的标签", map[string]string{"label": original}, map[string]string{"label": present})
+	this.JsonResult(0, "ok", present)
+}
+
+// Meta 查询文档元数据，未发布的文档只有项目参与者可以查询.
+func (this *DocumentController) Meta() {
+	bookResult := isReadable(this.Ctx.Input.Param(":key"), this.GetString("token"), this)
+
+	doc_id, _ := this.GetInt("doc_id", 0)
+	doc, err := models.NewDocument().Find(doc_id)
+	if err != nil || doc.BookId != bookResult.BookId {
+		this.JsonResult(6001, "文档不存在")
+	}
+	if doc.PublishStatus != conf.DocumentPublished {
+		if _, ok := this.previewRole(bookResult.BookId, doc.DocumentId); !ok {
+			this.JsonResult(6001, "文档不存在")
+		}
+	} else if !this.documentAccess(bookResult.BookId).CanRead(doc.DocumentId) {
+		this.JsonResult(6002, "没有该文档的阅读权限")
+	}
+	meta, err := models.NewDocumentMeta().Find(doc.DocumentId)
+	if err != nil {
+		beego.Error("DocumentMeta.Find => ", err)
+		this.JsonResult(6005, "查询失败")
+	}
+	this.JsonResult(0, "ok", meta)
+}
+
+// SaveMeta 修改文档元数据，元数据写入文档内容开头并同步更新文档标签.
+func (this *DocumentController) SaveMeta() {
+	book, doc := this.findPublishable()
+
+	meta := models.NewDocumentMeta()
+	meta.Title = this.GetString("title")
+	meta.Description = this.GetString("description")
+	meta.Author = this.GetString("author")
+	meta.Owner = this.GetString("owner")
+	meta.Tags = this.GetString("tags")
+	if len(models.ParseLabels(meta.Tags)) > 10 {
+		this.JsonResult(6005, "最多允许添加10个标签")
+	}
+	if err := meta.SetReviewDate(this.GetString("review_by")); err != nil {
+		this.JsonResult(6005, "复查日期格式错误")
+	}
+	values := this.GetStrings("field_value")
+	for i, key := range this.GetStrings("field_key") {
+		if i < len(values) {
+			meta.SetField(key, values[i])
+		}
+	}
+
+	original, err := models.NewDocumentMeta().Find(doc.DocumentId)
+	if err != nil {
+		beego.Error("DocumentMeta.Find => ", err)
+		this.JsonResult(6005, "查询失败")
+	}
+	ModelStore := new(models.DocumentStore)
+	markdown := utils.ReplaceFrontMatter(ModelStore.GetFiledById(doc.DocumentId, "markdown"), meta.FrontMatter())
+
+	doc.Version = time.Now().Unix()
+	if _, err := doc.InsertOrUpdate(); err != nil {
+		beego.Error("InsertOrUpdate => ", err)
+		this.JsonResult(6006, "保存失败")
+	}
+	//元数据不会显示在文档中，只需要更新 Markdown 内容
+	if err := ModelStore.InsertOrUpdate(models.DocumentStore{DocumentId: doc.DocumentId, Markdown: markdown}, "markdown"); err != nil {
+		beego.Error(err)
+		this.JsonResult(6006, "保存失败")
+	}
+	if meta.IsEmpty() {
+		err = meta.DeleteByDocumentId(doc.DocumentId)
+	} else {
+		err = meta.Save(doc.DocumentId)
+	}
+	if err != nil {
+		beego.Error("DocumentMeta.Save => ", err)
+		this.JsonResult(6006, "保存失败")
+	}
+	if meta.Tags, err = models.NewDocumentLabel().Replace(doc.DocumentId, meta.Tags); err != nil {
+		beego.Error("DocumentLabel.Replace => ", err)
+	}
+	if this.EnableDocumentHistory {
+		if err := doc.AddHistory(conf.HistoryModify, this.Member.MemberId); err != nil {
+			beego.Error("DocumentHistory InsertOrUpdate => ", err)
+		}
+	}
+	this.AuditLog(conf.AuditDocumentContent, book.BookId, doc.DocumentId, "修改文档 "+doc.DocumentName+" 的元数据", original, meta)
+	this.JsonResult(0, "ok", map[string]interface{}{
+		"meta":     meta,
+		"markdown": markdown,
+		"version":  doc.Version,
+	})
+}
+
+//查询当前用户在项目中的文档权限，无法阅读该项目时返回 nil，结果缓存在 accesses 中.
+func (this *DocumentController) readerAccess(accesses map[int]*models.DocumentAccess, book_id int) *models.DocumentAccess {
+	if access, ok := accesses[book_id]; ok {
+		return access
+	}
+	var access *models.DocumentAccess
+	book, err := models.NewBook().Find(book_id)
+	_, role_err := models.NewRelationship().FindEffectiveRoleId(book_id, this.Member.MemberId)
+	if err == nil && (book.PrivatelyOwned == 0 || role_err == nil || this.Member.IsAdministrator()) {
+		access = models.NewDocumentAccessForMember(book_id, this.Member)
+	}
+	accesses[book_id] = access
+	return access
+}
+
+//过滤掉读者无法阅读的文档并设置阅读地址.
+func (this *DocumentController) readableLinks(accesses map[int]*models.DocumentAccess, docs []*models.LinkedDocument) []*models.LinkedDocument {
+	result := make([]*models.LinkedDocument, 0, len(docs))
+	for _, doc := range docs {
+		if access := this.readerAccess(accesses, doc.BookId); access != nil && access.CanRead(doc.DocumentId) {
+			key := doc.DocIdentify
+			if key == "" {
+				key = strconv.Itoa(doc.DocumentId)
+			}
+			doc.Url = beego.URLFor("DocumentController.Read", ":key", doc.Identify, ":id", key)
+			result = append(result, doc)
+		}
+	}
+	return result
+}
+
+// Backlinks 查询链接了文档的文档和相关文档，只返回读者可以阅读的文档.
+func (this *DocumentController) Backlinks() {
+	bookResult := isReadable(this.Ctx.Input.Param(":key"), this.GetString("token"), this)
+
+	doc_id, _ := this.GetInt("doc_id", 0)
+	doc, err := models.NewDocument().Find(doc_id)
+	if err != nil || doc.BookId != bookResult.BookId || doc.PublishStatus != conf.DocumentPublished {
+		this.JsonResult(6001, "文档不存在")
+	}
+	accesses := map[int]*models.DocumentAccess{bookResult.BookId: this.documentAccess(bookResult.BookId)}
+	if !accesses[bookResult.BookId].CanRead(doc.DocumentId) {
+		this.JsonResult(6002, "没有该文档的阅读权限")
+	}
+	backlinks, err := models.NewDocumentLink().FindBacklinks(doc.DocumentId)
+	if err != nil {
+		beego.Error("DocumentLink.FindBacklinks => ", err)
+		this.JsonResult(6005, "查询失败")
+	}
+	related, err := models.NewDocumentLink().FindRelated(doc.DocumentId, 0)
+	if err != nil {
+		beego.Error("DocumentLink.FindRelated => ", err)
+		this.JsonResult(6005, "查询失败")
+	}
+	related = this.readableLinks(accesses, related)
+	if len(related) > 10 {
+		related = related[:10]
+	}
+	labels, err := models.NewDocumentLabel().FindLabels(doc.DocumentId)
+	if err != nil {
+		beego.Error("DocumentLabel.FindLabels => ", err)
+	}
+	for _, label := range labels {
+		label.Url = beego.URLFor("LabelController.Index", ":key", label.LabelName) + "?type=doc"
+	}
+	this.JsonResult(0, "ok", map[string]interface{}{
+		"backlinks": this.readableLinks(accesses, backlinks),
+		"related":   related,
+		"labels":    labels,
+	})
+}
+
+// LinkGraph 查询项目中读者可以阅读的文档之间的链接图，用于可视化展示.
+func (this *DocumentController) LinkGraph() {
+	bookResult := isReadable(this.Ctx.Input.Param(":key"), this.GetString("token"), this)
+
+	graph, err := models.NewDocumentLink().FindGraph(bookResult.BookId)
+	if err != nil {
+		beego.Error("DocumentLink.FindGraph => ", err)
+		this.JsonResult(6005, "查询失败")
+	}
+	access := this.documentAccess(bookResult.BookId)
+	graph = graph.Filter(func(node *models.LinkGraphNode) bool {
+		return access.CanRead(node.DocumentId)
+	})
+	for _, node := range graph.Nodes {
+		key := node.Identify
+		if key == "" {
+			key = strconv.Itoa(node.DocumentId)
+		}
+		node.Url = beego.URLFor("DocumentController.Read", ":key", bookResult.Identify, ":id", key)
+	}
+	this.JsonResult(0, "ok", graph)
+}
+
 func (this *DocumentController) Compare() {
 	this.Prepare()
 	this.TplName = "document/compare.html"
@@ -1303,7 +2054,7 @@ func (this *DocumentController) Compare() {
 
 	//如果是超级管理员则忽略权限判断
 	if this.Member.IsAdministrator() {
-		book, err := models.NewBook().FindByFieldFirst("identify", identify)
+		book, err := models.NewBook().FindByIdentify(identify)
 		if err != nil {
 			beego.Error("DocumentController.Compare => ", err)
 			this.Abort("403")
@@ -1339,6 +2090,9 @@ func (this *DocumentController) Compare() {
 	if doc.BookId != book_id {
 		this.ShowErrorPage(60002, "参数错误")
 	}
+	if !models.NewDocumentAccessForMember(book_id, this.Member).CanEdit(doc.DocumentId) {
+		this.ShowErrorPage(403, "没有该文档的编辑权限")
+	}
 	this.Data["HistoryId"] = history_id
 	this.Data["DocumentId"] = doc.DocumentId
 	ModelStore := new(models.DocumentStore)
@@ -1351,6 +2105,96 @@ func (this *DocumentController) Compare() {
 	}
 }
 
+// Diff 比较文档的任意两个修订，from 和 to 为历史记录ID，为 0 时表示文档当前内容.
+func (this *DocumentController) Diff() {
+	this.Prepare()
+	this.TplName = "document/diff.html"
+	doc_id, _ := strconv.Atoi(this.Ctx.Input.Param(":id"))
+	identify := this.Ctx.Input.Param(":key")
+	is_json := this.IsAjax() || this.GetString("format") == "json"
+
+	book_id := 0
+	editor := "markdown"
+
+	//如果是超级管理员则忽略权限判断
+	if this.Member.IsAdministrator() {
+		book, err := models.NewBook().FindByIdentify(identify)
+		if err != nil {
+			beego.Error("DocumentController.Diff => ", err)
+			this.Abort("403")
+		}
+		book_id = book.BookId
+		this.Data["Model"] = book
+		editor = book.Editor
+	} else {
+		bookResult, err := models.NewBookResult().FindByIdentify(identify, this.Member.MemberId)
+
+		if err != nil || bookResult.RoleId == conf.BookObserver {
+			beego.Error("FindByIdentify => ", err)
+			this.Abort("403")
+		}
+		book_id = bookResult.BookId
+		this.Data["Model"] = bookResult
+		editor = bookResult.Editor
+	}
+
+	doc, err := models.NewDocument().Find(doc_id)
+	if err != nil || doc.BookId != book_id {
+		if is_json {
+			this.JsonResult(6002, "文档不存在")
+		}
+		this.ShowErrorPage(60002, "文档不存在")
+	}
+	if !models.NewDocumentAccessForMember(book_id, this.Member).CanEdit(doc.DocumentId) {
+		if is_json {
+			this.JsonResult(403, "没有该文档的编辑权限")
+		}
+		this.ShowErrorPage(403, "没有该文档的编辑权限")
+	}
+	histories, err := models.NewDocumentHistory().FindAllByDocumentId(doc.DocumentId)
+	if err != nil {
+		beego.Error("DocumentHistory.FindAllByDocumentId => ", err)
+	}
+	//未指定时比较最近一次历史和当前内容
+	from, _ := this.GetInt("from", -1)
+	to, _ := this.GetInt("to", 0)
+	if from < 0 {
+		from = 0
+		if len(histories) > 0 {
+			from = histories[0].HistoryId
+		}
+	}
+	field := "markdown"
+	if editor != "markdown" {
+		field = "content"
+	}
+	mode := this.GetString("mode", "word")
+	if mode != "line" {
+		mode = "word"
+	}
+	diff, err := models.NewDocumentDiff(doc, from, to, field, mode == "word")
+	if err != nil {
+		if is_json {
+			this.JsonResult(6003, "历史记录不存在")
+		}
+		this.ShowErrorPage(60003, "历史记录不存在")
+	}
+	if is_json {
+		this.JsonResult(0, "ok", diff)
+	}
+	view := this.GetString("view", "side")
+	if view != "inline" {
+		view = "side"
+	}
+	this.Data["Document"] = doc
+	this.Data["Diff"] = diff
+	this.Data["Histories"] = histories
+	this.Data["From"] = from
+	this.Data["To"] = to
+	this.Data["Mode"] = mode
+	this.Data["View"] = view
+}
+
 //递归生成文档序列数组.
 func RecursiveFun(parent_id int, prefix, dpath string, this *DocumentController, book *models.BookResult, docs []*models.Document, paths *list.List) {
 	for _, item := range docs {
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/models"
+	"github.com/astaxie/beego"
+)
+
+//文档权限管理.
+type DocumentPermissionController struct {
+	BaseController
+}
+
+// List 获取项目的文档权限规则.
+func (this *DocumentPermissionController) List() {
+	book, err := this.bookAdminByIdentify(this.Ctx.Input.Param(":key"))
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	rules, err := models.NewDocumentPermission().FindResultByBookId(book.BookId)
+	if err != nil {
+		beego.Error("FindResultByBookId => ", err)
+		this.JsonResult(6002, "获取权限规则失败")
+	}
+	this.JsonResult(0, "ok", rules)
+}
+
+// Create 添加文档权限规则.
+func (this *DocumentPermissionController) Create() {
+	doc_id, _ := this.GetInt("doc_id", 0)
+	action := this.GetString("action")
+	role_id, _ := this.GetInt("role_id", -1)
+	account := this.GetString("account")
+
+	book, err := this.bookAdminByIdentify(this.GetString("identify"))
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	if doc_id <= 0 || (action != models.DocumentPermissionRead && action != models.DocumentPermissionEdit) {
+		this.JsonResult(6002, "参数错误")
+	}
+	doc, err := models.NewDocument().Find(doc_id)
+	if err != nil || doc.BookId != book.BookId {
+		this.JsonResult(6003, "文档不存在")
+	}
+
+	rule := models.NewDocumentPermission()
+	rule.BookId = book.BookId
+	rule.DocumentId = doc_id
+	rule.Action = action
+	rule.RoleId = role_id
+	rule.CreateAt = this.Member.MemberId
+
+	if account != "" {
+		member, err := models.NewMember().FindByAccount(account)
+		if err != nil {
+			this.JsonResult(404, "用户不存在")
+		}
+		if _, err := models.NewRelationship().FindEffectiveRoleId(book.BookId, member.MemberId); err != nil {
+			this.JsonResult(6004, "用户不是该项目的成员")
+		}
+		rule.MemberId = member.MemberId
+	} else if role_id < conf.BookAdmin || role_id > conf.BookObserver {
+		this.JsonResult(6002, "请选择角色或填写用户账号")
+	}
+
+	if err := rule.Insert(); err != nil {
+		beego.Error("DocumentPermission.Insert => ", err)
+		this.JsonResult(6005, "保存失败")
+	}
+	this.AuditLog(conf.AuditPermissionCreate, book.BookId, doc_id, "为文档 "+doc.DocumentName+" 添加权限规则", nil, rule)
+	rules, _ := models.NewDocumentPermission().FindResultByBookId(book.BookId)
+	this.JsonResult(0, "ok", rules)
+}
+
+// Delete 删除文档权限规则.
+func (this *DocumentPermissionController) Delete() {
+	permission_id, _ := this.GetInt("permission_id", 0)
+
+	book, err := this.bookAdminByIdentify(this.GetString("identify"))
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	if permission_id <= 0 {
+		this.JsonResult(6002, "参数错误")
+	}
+	if err := models.NewDocumentPermission().Delete(book.BookId, permission_id); err != nil {
+		beego.Error("DocumentPermission.Delete => ", err)
+		this.JsonResult(6005, "删除失败")
+	}
+	this.AuditLog(conf.AuditPermissionDelete, book.BookId, permission_id, "删除项目 "+book.BookName+" 的文档权限规则", map[string]int{"permission_id": permission_id}, nil)
+	this.JsonResult(0, "ok")
+}
+
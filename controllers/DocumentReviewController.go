@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/models"
+	"github.com/astaxie/beego"
+)
+
+//文档审阅，审阅人通过或退回编辑者提交的修订.
+type DocumentReviewController struct {
+	BaseController
+}
+
+// Approve 审阅通过，下次发布时将发布该修订.
+func (this *DocumentReviewController) Approve() {
+	this.review(true)
+}
+
+// Reject 退回修改.
+func (this *DocumentReviewController) Reject() {
+	this.review(false)
+}
+
+func (this *DocumentReviewController) review(approved bool) {
+	review_id, _ := this.GetInt("review_id", 0)
+	comment := strings.TrimSpace(this.GetString("comment"))
+
+	book, err := models.NewBook().FindByIdentify(this.GetString("identify"))
+	if err != nil {
+		this.JsonResult(6001, "项目不存在")
+	}
+	review, err := models.NewDocumentReview().Find(review_id)
+	if err != nil || review.BookId != book.BookId {
+		this.JsonResult(6002, "审阅不存在")
+	}
+	if !models.CanReviewBook(book.BookId, this.Member) {
+		this.JsonResult(6003, "您不是该项目的审阅人")
+	}
+	//审阅人不能审阅自己提交的修订
+	if review.MemberId == this.Member.MemberId && !this.Member.IsAdministrator() {
+		this.JsonResult(6003, "不能审阅自己提交的修订")
+	}
+	doc, err := models.NewDocument().Find(review.DocumentId)
+	if err != nil {
+		this.JsonResult(6002, "文档不存在")
+	}
+	if !models.NewDocumentAccessForMember(book.BookId, this.Member).CanRead(doc.DocumentId) {
+		this.JsonResult(6003, "您没有权限访问该文档")
+	}
+	if !approved && comment == "" {
+		this.JsonResult(6004, "请填写需要修改的内容")
+	}
+	if strings.Count(comment, "") > 1000 {
+		this.JsonResult(6004, "审阅意见不能超过1000字")
+	}
+	if err := review.Review(this.Member.MemberId, approved, comment); err != nil {
+		beego.Error("DocumentReview.Review => ", err)
+		this.JsonResult(6005, err.Error())
+	}
+
+	action, title := conf.AuditReviewReject, this.Member.Account+" 退回了你提交的文档《"+doc.DocumentName+"》的修订"
+	if approved {
+		action, title = conf.AuditReviewApprove, this.Member.Account+" 审阅通过了你提交的文档《"+doc.DocumentName+"》的修订"
+	}
+	this.AuditLog(action, book.BookId, doc.DocumentId, "审阅文档 "+doc.DocumentName+" 的修订", map[string]int{"status": conf.ReviewPending}, map[string]interface{}{"status": review.Status, "comment": comment})
+
+	notification := models.NewNotification()
+	notification.Type = conf.NotifyReview
+	notification.SenderId = this.Member.MemberId
+	notification.BookId = book.BookId
+	notification.DocumentId = doc.DocumentId
+	notification.ObjectId = review.ReviewId
+	notification.Url = beego.URLFor("BookController.Reviews", ":key", book.Identify, "status", review.Status)
+	notification.Title = title
+	if comment != "" {
+		notification.Title += "：" + models.NotificationSummary(comment)
+	}
+	if err := notification.Send([]int{review.MemberId}); err != nil {
+		beego.Error("Notification.Send => ", err)
+	}
+	review.ReviewerAccount = this.Member.Account
+	review.StatusName = models.ReviewStatusName(review.Status)
+	this.JsonResult(0, "ok", review)
+}
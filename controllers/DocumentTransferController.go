@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/models"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+//在项目之间移动或复制文档.
+type DocumentTransferController struct {
+	BaseController
+}
+
+// Targets 获取可以移动或复制到的项目，指定 target 时获取该项目中可以作为上级文档的文档.
+func (this *DocumentTransferController) Targets() {
+	source := this.editableBook(this.Ctx.Input.Param(":key"))
+	if source == nil {
+		this.JsonResult(6001, "项目不存在或权限不足")
+	}
+	if identify := this.GetString("target"); identify != "" {
+		target := this.editableBook(identify)
+		if target == nil {
+			this.JsonResult(6002, "目标项目不存在或权限不足")
+		}
+		docs, err := models.NewDocument().FindListByBookId(target.BookId)
+		if err != nil {
+			beego.Error("FindListByBookId => ", err)
+			this.JsonResult(6003, "查询文档失败")
+		}
+		access := models.NewDocumentAccessForMember(target.BookId, this.Member)
+		result := make([]map[string]interface{}, 0, len(docs))
+		for _, doc := range docs {
+			if access.CanEdit(doc.DocumentId) {
+				result = append(result, map[string]interface{}{"doc_id": doc.DocumentId, "doc_name": doc.DocumentName, "parent_id": doc.ParentId})
+			}
+		}
+		this.JsonResult(0, "ok", result)
+	}
+	books, err := models.NewBook().FindEditableByMemberId(this.Member.MemberId)
+	if err != nil {
+		beego.Error("FindEditableByMemberId => ", err)
+		this.JsonResult(6003, "查询项目失败")
+	}
+	result := make([]map[string]interface{}, 0, len(books))
+	for _, book := range books {
+		if book.BookId != source.BookId {
+			result = append(result, map[string]interface{}{"book_id": book.BookId, "book_name": book.BookName, "identify": book.Identify})
+		}
+	}
+	this.JsonResult(0, "ok", result)
+}
+
+// Transfer 移动或复制文档及其子文档到其他项目.
+func (this *DocumentTransferController) Transfer() {
+	source := this.editableBook(this.Ctx.Input.Param(":key"))
+	if source == nil {
+		this.JsonResult(6001, "项目不存在或权限不足")
+	}
+	target := this.editableBook(this.GetString("target"))
+	if target == nil {
+		this.JsonResult(6002, "目标项目不存在或权限不足")
+	}
+	if target.BookId == source.BookId {
+		this.JsonResult(6002, "请选择其他项目")
+	}
+	doc_id, _ := this.GetInt("doc_id", 0)
+	parent_id, _ := this.GetInt("parent_id", 0)
+	is_copy := this.GetString("mode") == "copy"
+
+	doc, err := models.NewDocument().Find(doc_id)
+	if err != nil || doc.BookId != source.BookId {
+		this.JsonResult(6003, "文档不存在")
+	}
+	if !models.NewDocumentAccessForMember(source.BookId, this.Member).CanEditTree(doc.DocumentId) {
+		this.JsonResult(6004, "没有该文档或其子文档的编辑权限")
+	}
+	if parent_id > 0 && !models.NewDocumentAccessForMember(target.BookId, this.Member).CanEdit(parent_id) {
+		this.JsonResult(6004, "没有目标文档的编辑权限")
+	}
+
+	transfer := models.NewDocumentTransfer(source, target)
+	transfer.ParentId = parent_id
+	transfer.MemberId = this.Member.MemberId
+
+	var result *models.TransferResult
+	if is_copy {
+		result, err = transfer.Copy(doc.DocumentId)
+	} else {
+		result, err = transfer.Move(doc.DocumentId)
+	}
+	if err == models.ErrDataNotExist {
+		this.JsonResult(6003, "文档或目标上级文档不存在")
+	}
+	if err != nil {
+		beego.Error("DocumentTransfer => ", err)
+		this.JsonResult(6005, "操作失败")
+	}
+	text := "移动文档 " + doc.DocumentName + " 到项目 " + target.BookName
+	action := conf.AuditDocumentMove
+	if is_copy {
+		text = "复制文档 " + doc.DocumentName + " 到项目 " + target.BookName
+		action = conf.AuditDocumentCopy
+	}
+	if moved, err := models.NewDocument().Find(result.DocumentId); err == nil {
+		history_action := conf.HistoryMove
+		if is_copy {
+			history_action = conf.HistoryCreate
+		}
+		if err := moved.AddHistory(history_action, this.Member.MemberId); err != nil {
+			beego.Error("DocumentHistory InsertOrUpdate => ", err)
+		}
+	}
+	this.AuditLog(action, target.BookId, result.DocumentId, text,
+		map[string]interface{}{"book_id": source.BookId, "doc_id": doc.DocumentId, "parent_id": doc.ParentId},
+		map[string]interface{}{"book_id": target.BookId, "doc_id": result.DocumentId, "parent_id": parent_id, "doc_count": result.DocCount, "renamed": result.Renamed})
+
+	this.JsonResult(0, "ok", result)
+}
+
+//查询当前用户可以编辑的项目.
+func (this *DocumentTransferController) editableBook(identify string) *models.Book {
+	if identify == "" {
+		return nil
+	}
+	book, err := models.NewBook().FindByIdentify(identify)
+	if err != nil {
+		if err != orm.ErrNoRows {
+			beego.Error("FindByIdentify => ", err)
+		}
+		return nil
+	}
+	if this.Member.IsAdministrator() {
+		return book
+	}
+	role_id, err := models.NewRelationship().FindEffectiveRoleId(book.BookId, this.Member.MemberId)
+	if err != nil || role_id == conf.BookObserver {
+		return nil
+	}
+	return book
+}
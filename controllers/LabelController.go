@@ -2,6 +2,8 @@ package controllers
 
 import (
 	"math"
+	"strconv"
+	"strings"
 
 	"github.com/JermineHu/DocStack/conf"
 	"github.com/JermineHu/DocStack/models"
@@ -50,21 +52,48 @@ func (this *LabelController) Index() {
 	if this.Member != nil {
 		member_id = this.Member.MemberId
 	}
-	search_result, totalCount, err := models.NewBook().FindForLabelToPager(labelName, pageIndex, pageSize, member_id)
-
-	if err != nil {
-		beego.Error(err)
-		return
-	}
-	if totalCount > 0 {
-		html := utils.NewPaginations(conf.RollPage, totalCount, pageSize, pageIndex, beego.URLFor("LabelController.Index", ":key", labelName), "")
-		this.Data["PageHtml"] = html
+	this.Data["LabelName"] = labelName
+	this.Data["Label"], _ = models.NewLabel().FindFirst("label_name", labelName)
+
+	//文档标签
+	if this.GetString("type") == "doc" {
+		this.Data["Type"] = "doc"
+		this.Data["BaseUrl"] = this.BaseUrl()
+		docs, totalCount, err := models.NewDocumentSearchResult().FindForLabelToPager(labelName, pageIndex, conf.PageSize, member_id, this.publishedReadable())
+		if err != nil {
+			beego.Error("DocumentSearchResult.FindForLabelToPager => ", err)
+			this.Abort("500")
+		}
+		if totalCount > 0 {
+			this.Data["PageHtml"] = utils.NewPaginations(conf.RollPage, totalCount, conf.PageSize, pageIndex, beego.URLFor("LabelController.Index", ":key", labelName), "", "type", "doc")
+		} else {
+			this.Data["PageHtml"] = ""
+		}
+		for _, item := range docs {
+			if item.Identify == "" {
+				item.Identify = strconv.Itoa(item.DocumentId)
+			}
+			if item.ModifyTime.IsZero() {
+				item.ModifyTime = item.CreateTime
+			}
+			item.Description = beego.Substr(strings.TrimSpace(beego.HTML2str(item.Description)), 0, 100)
+		}
+		this.Data["Lists"] = docs
 	} else {
-		this.Data["PageHtml"] = ""
+		search_result, totalCount, err := models.NewBook().FindForLabelToPager(labelName, pageIndex, pageSize, member_id)
+
+		if err != nil {
+			beego.Error(err)
+			return
+		}
+		if totalCount > 0 {
+			html := utils.NewPaginations(conf.RollPage, totalCount, pageSize, pageIndex, beego.URLFor("LabelController.Index", ":key", labelName), "")
+			this.Data["PageHtml"] = html
+		} else {
+			this.Data["PageHtml"] = ""
+		}
+		this.Data["Lists"] = search_result
 	}
-	this.Data["Lists"] = search_result
-
-	this.Data["LabelName"] = labelName
 
 	this.GetSeoByPage("label_list", map[string]string{
 		"title":       "[标签]" + labelName + " - " + this.Sitename,
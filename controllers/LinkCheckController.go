@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/models"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+//检查项目已发布文档中的失效链接和丢失的图片、附件.
+type LinkCheckController struct {
+	BaseController
+}
+
+// Report 获取项目最近一次链接检查的报告.
+func (this *LinkCheckController) Report() {
+	book, _ := this.findBook()
+
+	check, err := models.NewLinkCheck().FindLatest(book.BookId)
+	if err == orm.ErrNoRows {
+		this.JsonResult(6004, "尚未检查过项目中的链接")
+	}
+	if err != nil {
+		beego.Error("LinkCheck.FindLatest => ", err)
+		this.JsonResult(6005, "查询报告失败")
+	}
+	this.JsonResult(0, "ok", check)
+}
+
+// Run 在后台检查项目中的链接，external 为 1 时同时检查外部链接.
+func (this *LinkCheckController) Run() {
+	book, role_id := this.findBook()
+	if role_id == conf.BookObserver {
+		this.JsonResult(6003, "权限不足")
+	}
+	external, _ := this.GetInt("external", 0)
+
+	check, err := models.StartLinkCheck(book.BookId, this.Member.MemberId, this.BaseUrl(), external == 1)
+	if err == models.ErrLinkCheckRunning {
+		this.JsonResult(1, err.Error())
+	}
+	if err != nil {
+		beego.Error("StartLinkCheck => ", err)
+		this.JsonResult(6005, "启动链接检查失败")
+	}
+	this.JsonResult(0, "ok", check)
+}
+
+//查询当前用户参与的项目和角色，超级管理员视为项目创始人.
+func (this *LinkCheckController) findBook() (*models.Book, int) {
+	book, err := models.NewBook().FindByIdentify(this.Ctx.Input.Param(":key"))
+	if err != nil {
+		this.JsonResult(6002, "项目不存在或权限不足")
+	}
+	if this.Member.IsAdministrator() {
+		return book, conf.BookFounder
+	}
+	role_id, err := models.NewRelationship().FindEffectiveRoleId(book.BookId, this.Member.MemberId)
+	if err != nil {
+		this.JsonResult(6002, "项目不存在或权限不足")
+	}
+	return book, role_id
+}
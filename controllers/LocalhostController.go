@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/JermineHu/DocStack/models"
+	"github.com/JermineHu/DocStack/utils"
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/orm"
 )
@@ -26,20 +27,29 @@ func (this *LocalhostController) RenderMarkdown() {
 			o := orm.NewOrm()
 			qs := o.QueryTable("md_documents").Filter("document_id", id)
 			if this.Ctx.Input.IsPost() {
-				qs.One(&doc, "identify", "book_id")
+				qs.One(&doc, "identify", "book_id", "modify_at")
 				var book models.Book
 				o.QueryTable("md_books").Filter("book_id", doc.BookId).One(&book, "identify")
 				content := this.GetString("content")
 				content = this.replaceLinks(book.Identify, content)
+				release := models.ResolveIncludes(id, doc.ModifyAt, content)
 				qs.Update(orm.Params{
-					"release":     content,
+					"release":     release,
 					"modify_time": time.Now(),
 				})
+				if err := models.NewDocumentLink().Replace(id, release); err != nil {
+					beego.Error("DocumentLink.Replace => ", err)
+				}
+				if _, err := models.NewDocumentMeta().Replace(id, ModelStore.GetFiledById(id, "markdown")); err != nil {
+					beego.Error("DocumentMeta.Replace => ", err)
+				}
 				//这里要指定更新字段，否则markdown内容会被置空
 				ModelStore.InsertOrUpdate(models.DocumentStore{DocumentId: id, Content: content}, "content")
 				this.JsonResult(0, "成功")
 			} else {
-				this.Data["Markdown"] = ModelStore.GetFiledById(id, "markdown")
+				//文档开头的元数据不渲染
+				_, markdown, _ := utils.ParseFrontMatter(ModelStore.GetFiledById(id, "markdown"))
+				this.Data["Markdown"] = markdown
 				this.TplName = "widgets/render.html"
 				return
 			}
@@ -1,10 +1,13 @@
 package controllers
 
 import (
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"html/template"
 	"regexp"
 	"strings"
+	"time"
 
 	"path/filepath"
 	"strconv"
@@ -34,6 +37,7 @@ func (this *ManagerController) Prepare() {
 func (this *ManagerController) Index() {
 	this.TplName = "manager/index.html"
 	this.Data["Model"] = models.NewDashboard().Query()
+	this.Data["LoggerStats"] = models.GetLoggerStats()
 	this.GetSeoByPage("manage_dashboard", map[string]string{
 		"title":       "仪表盘 - " + this.Sitename,
 		"keywords":    "仪表盘",
@@ -76,6 +80,64 @@ func (this *ManagerController) Users() {
 
 }
 
+// 团队列表.
+func (this *ManagerController) Teams() {
+	this.TplName = "manager/teams.html"
+	this.Data["IsTeams"] = true
+	pageIndex, _ := this.GetInt("page", 1)
+	this.Data["SeoTitle"] = "团队管理 - " + this.Sitename
+
+	teams, totalCount, err := models.NewTeam().FindToPager(pageIndex, conf.PageSize)
+
+	if err != nil {
+		this.Data["ErrorMessage"] = err.Error()
+		return
+	}
+
+	if totalCount > 0 {
+		this.Data["PageHtml"] = utils.NewPaginations(conf.RollPage, totalCount, conf.PageSize, pageIndex, beego.URLFor("ManagerController.Teams"), "")
+	} else {
+		this.Data["PageHtml"] = ""
+	}
+
+	b, err := json.Marshal(teams)
+
+	if err != nil || teams == nil {
+		this.Data["Result"] = template.JS("[]")
+	} else {
+		this.Data["Result"] = template.JS(string(b))
+	}
+}
+
+// 组织列表.
+func (this *ManagerController) Organizations() {
+	this.TplName = "manager/organizations.html"
+	this.Data["IsOrganizations"] = true
+	pageIndex, _ := this.GetInt("page", 1)
+	this.Data["SeoTitle"] = "组织管理 - " + this.Sitename
+
+	orgs, totalCount, err := models.NewOrganization().FindToPager(pageIndex, conf.PageSize)
+
+	if err != nil {
+		this.Data["ErrorMessage"] = err.Error()
+		return
+	}
+
+	if totalCount > 0 {
+		this.Data["PageHtml"] = utils.NewPaginations(conf.RollPage, totalCount, conf.PageSize, pageIndex, beego.URLFor("ManagerController.Organizations"), "")
+	} else {
+		this.Data["PageHtml"] = ""
+	}
+
+	b, err := json.Marshal(orgs)
+
+	if err != nil || orgs == nil {
+		this.Data["Result"] = template.JS("[]")
+	} else {
+		this.Data["Result"] = template.JS(string(b))
+	}
+}
+
 // 添加用户.
 func (this *ManagerController) CreateMember() {
 
@@ -131,6 +193,7 @@ func (this *ManagerController) CreateMember() {
 		beego.Error(err.Error())
 		this.JsonResult(6006, "注册失败，可能昵称已存在")
 	}
+	this.AuditLog(conf.AuditMemberCreate, 0, member.MemberId, "创建用户 "+member.Account, nil, member)
 
 	this.JsonResult(0, "ok", member)
 }
@@ -158,12 +221,14 @@ func (this *ManagerController) UpdateMemberStatus() {
 	if member.Role == conf.MemberSuperRole {
 		this.JsonResult(6005, "不能变更超级管理员的状态")
 	}
+	original := member.Status
 	member.Status = status
 
 	if err := member.Update(); err != nil {
 		logs.Error("", err)
 		this.JsonResult(6003, "用户状态设置失败")
 	}
+	this.AuditLog(conf.AuditMemberStatus, 0, member.MemberId, "变更用户 "+member.Account+" 的状态", map[string]int{"status": original}, map[string]int{"status": status})
 	this.JsonResult(0, "ok", member)
 }
 
@@ -189,12 +254,14 @@ func (this *ManagerController) ChangeMemberRole() {
 	if member.Role == conf.MemberSuperRole {
 		this.JsonResult(6005, "不能变更超级管理员的权限")
 	}
+	original := member.Role
 	member.Role = role
 
 	if err := member.Update(); err != nil {
 		logs.Error("", err)
 		this.JsonResult(6003, "用户权限设置失败")
 	}
+	this.AuditLog(conf.AuditMemberRole, 0, member.MemberId, "变更用户 "+member.Account+" 的角色", map[string]int{"role": original}, map[string]int{"role": role})
 	member.ResolveRoleName()
 	this.JsonResult(0, "ok", member)
 }
@@ -226,6 +293,7 @@ func (this *ManagerController) EditMember() {
 		email := this.GetString("email")
 		phone := this.GetString("phone")
 		description := this.GetString("description")
+		original := map[string]string{"email": member.Email, "phone": member.Phone, "description": member.Description}
 		member.Email = email
 		member.Phone = phone
 		member.Description = description
@@ -250,6 +318,11 @@ func (this *ManagerController) EditMember() {
 			beego.Error(err)
 			this.JsonResult(6004, "保存失败")
 		}
+		present := map[string]string{"email": email, "phone": phone, "description": description}
+		if password1 != "" {
+			present["password"] = "已修改"
+		}
+		this.AuditLog(conf.AuditMemberUpdate, 0, member.MemberId, "编辑用户 "+member.Account, original, present)
 		this.JsonResult(0, "ok")
 	}
 
@@ -286,6 +359,7 @@ func (this *ManagerController) DeleteMember() {
 		beego.Error(err)
 		this.JsonResult(5002, "删除失败")
 	}
+	this.AuditLog(conf.AuditMemberDelete, 0, member_id, "删除用户 "+member.Account+"，数据转移给 "+superMember.Account, member, nil)
 	this.JsonResult(0, "ok")
 }
 
@@ -333,7 +407,7 @@ func (this *ManagerController) EditBook() {
 	if identify == "" {
 		this.Abort("404")
 	}
-	book, err := models.NewBook().FindByFieldFirst("identify", identify)
+	book, err := models.NewBook().FindByIdentify(identify)
 	if err != nil {
 		this.Abort("500")
 	}
@@ -351,11 +425,8 @@ func (this *ManagerController) EditBook() {
 		if comment_status != "open" && comment_status != "closed" && comment_status != "group_only" && comment_status != "registered_only" {
 			comment_status = "closed"
 		}
-		if tag != "" {
-			tags := strings.Split(tag, ";")
-			if len(tags) > 10 {
-				this.JsonResult(6005, "最多允许添加10个标签")
-			}
+		if len(models.ParseLabels(tag)) > 10 {
+			this.JsonResult(6005, "最多允许添加10个标签")
 		}
 
 		book.BookName = book_name
@@ -383,10 +454,14 @@ func (this *ManagerController) DeleteBook() {
 	if book_id <= 0 {
 		this.JsonResult(6001, "参数错误")
 	}
-	book := models.NewBook()
-
-	err := book.ThoroughDeleteBook(book_id)
+	book, err := models.NewBook().Find(book_id)
 
+	if err == nil && book.Status != 0 {
+		err = orm.ErrNoRows
+	}
+	if err == nil {
+		err = models.NewRecycleBin().DeleteBook(book, this.Member.MemberId)
+	}
 	if err == orm.ErrNoRows {
 		this.JsonResult(6002, "项目不存在")
 	}
@@ -394,9 +469,37 @@ func (this *ManagerController) DeleteBook() {
 		logs.Error("DeleteBook => ", err)
 		this.JsonResult(6003, "删除失败")
 	}
+	this.AuditLog(conf.AuditBookDelete, book_id, book_id, "删除项目 "+book.BookName+" 到回收站",
+		map[string]interface{}{"book_id": book.BookId, "identify": book.Identify, "book_name": book.BookName}, nil)
 	this.JsonResult(0, "ok")
 }
 
+// Recycle 全站回收站.
+func (this *ManagerController) Recycle() {
+	this.TplName = "manager/recycle.html"
+	this.Data["IsRecycle"] = true
+	this.Data["SeoTitle"] = "回收站 - " + this.Sitename
+
+	pageIndex, _ := this.GetInt("page", 1)
+	item_type := this.GetString("type")
+	if item_type != conf.RecycleDocument && item_type != conf.RecycleBook {
+		item_type = ""
+	}
+	items, totalCount, err := models.NewRecycleBin().FindToPager(0, item_type, pageIndex, conf.PageSize)
+	if err != nil {
+		beego.Error("RecycleBin.FindToPager => ", err)
+		this.Abort("500")
+	}
+	if totalCount > 0 {
+		this.Data["PageHtml"] = utils.NewPaginations(conf.RollPage, totalCount, conf.PageSize, pageIndex, beego.URLFor("ManagerController.Recycle"), "", "type", item_type)
+	} else {
+		this.Data["PageHtml"] = ""
+	}
+	this.Data["Lists"] = items
+	this.Data["Type"] = item_type
+	this.Data["KeepDays"] = models.GetOptionValue("RECYCLE_KEEP_DAYS", "30")
+}
+
 // CreateToken 创建访问来令牌.
 func (this *ManagerController) CreateToken() {
 	this.Prepare()
@@ -404,7 +507,7 @@ func (this *ManagerController) CreateToken() {
 
 	identify := this.GetString("identify")
 
-	book, err := models.NewBook().FindByFieldFirst("identify", identify)
+	book, err := models.NewBook().FindByIdentify(identify)
 
 	if err != nil {
 		this.JsonResult(6001, "项目不存在")
@@ -420,6 +523,7 @@ func (this *ManagerController) CreateToken() {
 			logs.Error("生成阅读令牌失败 => ", err)
 			this.JsonResult(6003, "生成阅读令牌失败")
 		}
+		this.AuditLog(conf.AuditBookToken, book.BookId, book.BookId, "生成项目 "+book.BookName+" 的访问令牌", nil, nil)
 		this.JsonResult(0, "ok", this.BaseUrl()+beego.URLFor("DocumentController.Index", ":key", book.Identify, "token", book.PrivateToken))
 	} else {
 		book.PrivateToken = ""
@@ -427,6 +531,7 @@ func (this *ManagerController) CreateToken() {
 			logs.Error("CreateToken => ", err)
 			this.JsonResult(6004, "删除令牌失败")
 		}
+		this.AuditLog(conf.AuditBookToken, book.BookId, book.BookId, "删除项目 "+book.BookName+" 的访问令牌", nil, nil)
 		this.JsonResult(0, "ok", "")
 	}
 }
@@ -439,10 +544,32 @@ func (this *ManagerController) Setting() {
 	options, err := models.NewOption().All()
 
 	if this.Ctx.Input.IsPost() {
+		for _, key := range []string{"HISTORY_KEEP_COUNT", "HISTORY_KEEP_DAYS", "HISTORY_THIN_DAYS"} {
+			if value := this.GetString(key); value != "" {
+				if num, err := strconv.Atoi(value); err != nil || num < 0 {
+					this.JsonResult(6001, "文档历史保留策略必须是不小于 0 的整数")
+				}
+			}
+		}
+		if value := this.GetString("RECYCLE_KEEP_DAYS"); value != "" {
+			if num, err := strconv.Atoi(value); err != nil || num < 0 {
+				this.JsonResult(6001, "回收站保留天数必须是不小于 0 的整数")
+			}
+		}
+		original := make(map[string]string)
+		present := make(map[string]string)
 		for _, item := range options {
-			item.OptionValue = this.GetString(item.OptionName)
+			value := this.GetString(item.OptionName)
+			if value != item.OptionValue {
+				original[item.OptionName] = item.OptionValue
+				present[item.OptionName] = value
+			}
+			item.OptionValue = value
 			item.InsertOrUpdate()
 		}
+		if len(present) > 0 {
+			this.AuditLog(conf.AuditSiteSetting, 0, 0, "修改站点配置", original, present)
+		}
 		this.JsonResult(0, "ok")
 	}
 
@@ -480,7 +607,7 @@ func (this *ManagerController) Transfer() {
 
 	identify := this.GetString("identify")
 
-	book, err := models.NewBook().FindByFieldFirst("identify", identify)
+	book, err := models.NewBook().FindByIdentify(identify)
 	if err != nil {
 		this.JsonResult(6001, err.Error())
 	}
@@ -500,6 +627,7 @@ func (this *ManagerController) Transfer() {
 		logs.Error("Transfer => ", err)
 		this.JsonResult(6008, err.Error())
 	}
+	this.AuditLog(conf.AuditBookTransfer, book.BookId, member.MemberId, "将项目 "+book.BookName+" 转让给 "+member.Account, map[string]int{"member_id": rel.MemberId}, map[string]int{"member_id": member.MemberId})
 	this.JsonResult(0, "ok")
 }
 
@@ -509,7 +637,69 @@ func (this *ManagerController) Comments() {
 	if !this.Member.IsAdministrator() {
 		this.Abort("403")
 	}
+	this.Data["IsComments"] = true
+	this.Data["SeoTitle"] = "评论管理 - " + this.Sitename
+
+	pageIndex, _ := this.GetInt("page", 1)
+	approved, _ := this.GetInt("approved", conf.CommentPending)
+	filter := &models.CommentFilter{Approved: approved, Keyword: strings.TrimSpace(this.GetString("keyword"))}
+	params := []interface{}{"approved", strconv.Itoa(approved)}
+
+	if identify := strings.TrimSpace(this.GetString("book")); identify != "" {
+		params = append(params, "book", identify)
+		if book, err := models.NewBook().FindByIdentify(identify); err == nil {
+			filter.BookId = book.BookId
+		} else {
+			filter.BookId = -1
+		}
+	}
+	if filter.Keyword != "" {
+		params = append(params, "keyword", filter.Keyword)
+	}
+
+	comments, totalCount, err := models.NewCommentResult().FindToPager(filter, pageIndex, conf.PageSize)
+	if err != nil {
+		beego.Error("CommentResult.FindToPager => ", err)
+		this.Abort("500")
+	}
+	if totalCount > 0 {
+		this.Data["PageHtml"] = utils.NewPaginations(conf.RollPage, totalCount, conf.PageSize, pageIndex, beego.URLFor("ManagerController.Comments"), "", params...)
+	} else {
+		this.Data["PageHtml"] = ""
+	}
+	this.Data["Lists"] = comments
+	this.Data["Approved"] = approved
+	this.Data["Book"] = this.GetString("book")
+	this.Data["Keyword"] = filter.Keyword
+}
+
+//ModerateComment 审核评论.
+func (this *ManagerController) ModerateComment() {
+	comment_id, _ := this.GetInt("comment_id", 0)
+	approved, _ := this.GetInt("approved", -1)
+
+	if comment_id <= 0 || (approved != conf.CommentPending && approved != conf.CommentApproved && approved != conf.CommentSpam) {
+		this.JsonResult(6001, "参数错误")
+	}
+	comment := models.NewComment()
+
+	if _, err := comment.Find(comment_id); err != nil {
+		this.JsonResult(6002, "评论不存在")
+	}
+	original := comment.Approved
 
+	if err := comment.SetApproved(approved); err != nil {
+		beego.Error("Comment.SetApproved => ", err)
+		this.JsonResult(6003, "审核评论失败")
+	}
+	this.AuditLog(conf.AuditCommentModerate, comment.BookId, comment.CommentId, "审核评论", map[string]int{"approved": original}, map[string]int{"approved": approved})
+	//待审核的评论通过后再发送通知
+	if original == conf.CommentPending && approved == conf.CommentApproved {
+		if err := comment.Notify(); err != nil {
+			beego.Error("Comment.Notify => ", err)
+		}
+	}
+	this.JsonResult(0, "ok", comment)
 }
 
 //DeleteComment 标记评论为已删除
@@ -528,14 +718,105 @@ func (this *ManagerController) DeleteComment() {
 		this.JsonResult(6002, "评论不存在")
 	}
 
-	comment.Approved = 3
-
-	if err := comment.Update("approved"); err != nil {
+	if err := comment.SetApproved(conf.CommentDeleted); err != nil {
 		this.JsonResult(6003, "删除评论失败")
 	}
+	this.AuditLog(conf.AuditCommentDelete, comment.BookId, comment.CommentId, "删除评论", comment.Content, nil)
 	this.JsonResult(0, "ok", comment)
 }
 
+// Labels 标签管理.
+func (this *ManagerController) Labels() {
+	this.Prepare()
+	this.TplName = "manager/labels.html"
+	this.Data["IsLabels"] = true
+	this.Data["SeoTitle"] = "标签管理 - " + this.Sitename
+
+	pageIndex, _ := this.GetInt("page", 1)
+	keyword := strings.TrimSpace(this.GetString("keyword"))
+
+	labels, totalCount, err := models.NewLabel().FindToPagerByKeyword(keyword, pageIndex, conf.PageSize)
+	if err != nil {
+		beego.Error("Label.FindToPagerByKeyword => ", err)
+		this.Abort("500")
+	}
+	if totalCount > 0 {
+		this.Data["PageHtml"] = utils.NewPaginations(conf.RollPage, totalCount, conf.PageSize, pageIndex, beego.URLFor("ManagerController.Labels"), "", "keyword", keyword)
+	} else {
+		this.Data["PageHtml"] = ""
+	}
+	this.Data["Lists"] = labels
+	this.Data["Keyword"] = keyword
+}
+
+//查询要管理的标签.
+func (this *ManagerController) findLabel() *models.Label {
+	label_id, _ := this.GetInt("label_id", 0)
+	if label_id <= 0 {
+		this.JsonResult(6001, "参数错误")
+	}
+	label, err := models.NewLabel().FindFirst("label_id", label_id)
+	if err != nil {
+		this.JsonResult(6002, "标签不存在")
+	}
+	return label
+}
+
+// RenameLabel 重命名标签.
+func (this *ManagerController) RenameLabel() {
+	label := this.findLabel()
+	original := label.LabelName
+
+	if err := label.Rename(this.GetString("label_name")); err == models.ErrInvalidParameter {
+		this.JsonResult(6003, "标签名称不能为空且不能包含逗号")
+	} else if err == models.ErrLabelExist {
+		this.JsonResult(6004, err.Error())
+	} else if err != nil {
+		beego.Error("Label.Rename => ", err)
+		this.JsonResult(6005, "重命名标签失败")
+	}
+	this.AuditLog(conf.AuditLabelRename, 0, label.LabelId, "重命名标签 "+original, map[string]string{"label_name": original}, map[string]string{"label_name": label.LabelName})
+	this.JsonResult(0, "ok", label)
+}
+
+// MergeLabel 将标签合并到已有的目标标签.
+func (this *ManagerController) MergeLabel() {
+	label := this.findLabel()
+	names := models.ParseLabels(this.GetString("target"))
+	if len(names) != 1 {
+		this.JsonResult(6003, "请输入要合并到的标签")
+	}
+	target, err := models.NewLabel().FindFirst("label_name", names[0])
+	if err == orm.ErrNoRows {
+		this.JsonResult(6002, "目标标签不存在")
+	}
+	if err != nil {
+		beego.Error("Label.FindFirst => ", err)
+		this.JsonResult(6005, "合并标签失败")
+	}
+	if target.LabelId == label.LabelId {
+		this.JsonResult(6003, "不能合并到标签自身")
+	}
+	if err := label.Merge(target); err != nil {
+		beego.Error("Label.Merge => ", err)
+		this.JsonResult(6005, "合并标签失败")
+	}
+	this.AuditLog(conf.AuditLabelMerge, 0, target.LabelId, "将标签 "+label.LabelName+" 合并到 "+target.LabelName, map[string]string{"label_name": label.LabelName}, map[string]string{"label_name": target.LabelName})
+	this.JsonResult(0, "ok", target)
+}
+
+// DeleteLabel 删除标签，同时从项目和文档中移除该标签.
+func (this *ManagerController) DeleteLabel() {
+	label := this.findLabel()
+
+	if err := label.Delete(); err != nil {
+		beego.Error("Label.Delete => ", err)
+		this.JsonResult(6005, "删除标签失败")
+	}
+	this.AuditLog(conf.AuditLabelDelete, 0, label.LabelId, "删除标签 "+label.LabelName, label, nil)
+	this.JsonResult(0, "ok")
+}
+
 //设置项目私有状态.
 func (this *ManagerController) PrivatelyOwned() {
 	this.Prepare()
@@ -556,11 +837,12 @@ func (this *ManagerController) PrivatelyOwned() {
 		this.Abort("403")
 	}
 
-	book, err := models.NewBook().FindByFieldFirst("identify", identify)
+	book, err := models.NewBook().FindByIdentify(identify)
 	if err != nil {
 		this.JsonResult(6001, err.Error())
 	}
 
+	original := book.PrivatelyOwned
 	book.PrivatelyOwned = state
 
 	logs.Info("", state, status)
@@ -571,9 +853,157 @@ func (this *ManagerController) PrivatelyOwned() {
 		logs.Error("PrivatelyOwned => ", err)
 		this.JsonResult(6004, "保存失败")
 	}
+	this.AuditLog(conf.AuditBookPrivately, book.BookId, book.BookId, "变更项目 "+book.BookName+" 的可见性", map[string]int{"privately_owned": original}, map[string]int{"privately_owned": state})
 	this.JsonResult(0, "ok")
 }
 
+//审计日志.
+func (this *ManagerController) Logs() {
+	this.TplName = "manager/logs.html"
+	this.Data["IsLogs"] = true
+	this.Data["SeoTitle"] = "审计日志 - " + this.Sitename
+	pageIndex, _ := this.GetInt("page", 1)
+
+	filter, params := this.logFilter()
+
+	loggers, totalCount, err := models.NewLogger().FindToPager(filter, pageIndex, conf.PageSize)
+	if err != nil {
+		beego.Error("Logger.FindToPager => ", err)
+		this.Data["ErrorMessage"] = "查询日志失败"
+	}
+	if totalCount > 0 {
+		this.Data["PageHtml"] = utils.NewPaginations(conf.RollPage, totalCount, conf.PageSize, pageIndex, beego.URLFor("ManagerController.Logs"), "", params...)
+	} else {
+		this.Data["PageHtml"] = ""
+	}
+	this.Data["Lists"] = loggers
+	this.Data["Actions"] = models.LoggerActions()
+	//回显查询条件
+	query := make(map[string]string, len(params)/2)
+	for i := 0; i+1 < len(params); i += 2 {
+		query[params[i].(string)] = params[i+1].(string)
+	}
+	this.Data["Query"] = query
+}
+
+// LogList 查询审计日志接口.
+func (this *ManagerController) LogList() {
+	pageIndex, _ := this.GetInt("page", 1)
+	pageSize, _ := this.GetInt("size", conf.PageSize)
+	if pageSize <= 0 || pageSize > 500 {
+		pageSize = conf.PageSize
+	}
+	filter, _ := this.logFilter()
+
+	loggers, totalCount, err := models.NewLogger().FindToPager(filter, pageIndex, pageSize)
+	if err != nil {
+		beego.Error("Logger.FindToPager => ", err)
+		this.JsonResult(6002, "查询日志失败")
+	}
+	if loggers == nil {
+		loggers = make([]*models.Logger, 0)
+	}
+	this.JsonResult(0, "ok", map[string]interface{}{"total": totalCount, "page": pageIndex, "size": pageSize, "lists": loggers})
+}
+
+// ExportLogs 导出审计日志，支持 csv 和 json 格式.
+func (this *ManagerController) ExportLogs() {
+	format := strings.ToLower(this.GetString("format", "csv"))
+	filter, _ := this.logFilter()
+
+	loggers, err := models.NewLogger().FindAll(filter, 10000)
+	if err != nil {
+		beego.Error("Logger.FindAll => ", err)
+		this.Abort("500")
+	}
+	filename := "audit-" + time.Now().Format("20060102150405")
+
+	if format == "json" {
+		if loggers == nil {
+			loggers = make([]*models.Logger, 0)
+		}
+		b, err := json.MarshalIndent(loggers, "", "  ")
+		if err != nil {
+			this.Abort("500")
+		}
+		this.Ctx.Output.Header("Content-Type", "application/json; charset=utf-8")
+		this.Ctx.Output.Header("Content-Disposition", "attachment; filename="+filename+".json")
+		this.Ctx.Output.Body(b)
+		this.StopRun()
+	}
+
+	buf := bytes.NewBufferString("\xEF\xBB\xBF") //UTF-8 BOM，避免Excel打开乱码
+	w := csv.NewWriter(buf)
+	w.Write([]string{"ID", "时间", "用户", "动作", "项目", "对象ID", "内容", "变更前", "变更后", "IP", "UserAgent"})
+	for _, item := range loggers {
+		w.Write([]string{
+			strconv.FormatInt(item.LoggerId, 10),
+			item.CreateTime.Local().Format("2006-01-02 15:04:05"),
+			item.Account,
+			item.ActionName,
+			item.BookName,
+			strconv.Itoa(item.ObjectId),
+			item.Content,
+			item.OriginalData,
+			item.PresentData,
+			item.IPAddress,
+			item.UserAgent,
+		})
+	}
+	w.Flush()
+	this.Ctx.Output.Header("Content-Type", "text/csv; charset=utf-8")
+	this.Ctx.Output.Header("Content-Disposition", "attachment; filename="+filename+".csv")
+	this.Ctx.Output.Body(buf.Bytes())
+	this.StopRun()
+}
+
+//解析审计日志的查询条件，同时返回用于分页的查询参数.
+func (this *ManagerController) logFilter() (*models.LoggerFilter, []interface{}) {
+	filter := &models.LoggerFilter{}
+	params := make([]interface{}, 0)
+
+	if account := strings.TrimSpace(this.GetString("account")); account != "" {
+		params = append(params, "account", account)
+		if member, err := models.NewMember().FindByAccount(account); err == nil {
+			filter.MemberId = member.MemberId
+		} else {
+			filter.MemberId = -1
+		}
+	}
+	if identify := strings.TrimSpace(this.GetString("book")); identify != "" {
+		params = append(params, "book", identify)
+		if book, err := models.NewBook().FindByIdentify(identify); err == nil {
+			filter.BookId = book.BookId
+		} else if book_id, err := strconv.Atoi(identify); err == nil && book_id > 0 {
+			filter.BookId = book_id
+		} else {
+			filter.BookId = -1
+		}
+	}
+	if action := strings.TrimSpace(this.GetString("action")); action != "" {
+		params = append(params, "action", action)
+		filter.Action = action
+	}
+	if category := strings.TrimSpace(this.GetString("category")); category != "" {
+		params = append(params, "category", category)
+		filter.Category = category
+	}
+	if start := strings.TrimSpace(this.GetString("start")); start != "" {
+		if t, err := time.ParseInLocation("2006-01-02", start, time.Local); err == nil {
+			params = append(params, "start", start)
+			filter.StartTime = t
+		}
+	}
+	//结束日期包含当天
+	if end := strings.TrimSpace(this.GetString("end")); end != "" {
+		if t, err := time.ParseInLocation("2006-01-02", end, time.Local); err == nil {
+			params = append(params, "end", end)
+			filter.EndTime = t.AddDate(0, 0, 1)
+		}
+	}
+	return filter, params
+}
+
 //附件列表.
 func (this *ManagerController) AttachList() {
 	this.TplName = "manager/attach_list.html"
@@ -652,6 +1082,7 @@ func (this *ManagerController) AttachDelete() {
 		beego.Error("AttachDelete => ", err)
 		this.JsonResult(6002, err.Error())
 	}
+	this.AuditLog(conf.AuditAttachmentDelete, attach.BookId, attach.AttachmentId, "删除附件 "+attach.FileName, attach, nil)
 	this.JsonResult(0, "ok")
 }
 
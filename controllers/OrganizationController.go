@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"math"
+	"strings"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/models"
+	"github.com/JermineHu/DocStack/utils"
+	"github.com/astaxie/beego"
+)
+
+//组织.
+type OrganizationController struct {
+	BaseController
+}
+
+// Index 组织主页，列出组织下的项目.
+func (this *OrganizationController) Index() {
+	if !this.EnableAnonymous && (this.Member == nil || this.Member.MemberId <= 0) {
+		this.Redirect(beego.URLFor("AccountController.Login"), 302)
+		return
+	}
+	org, err := models.NewOrganization().FindByIdentify(this.Ctx.Input.Param(":org"))
+	if err != nil {
+		this.Abort("404")
+	}
+	this.TplName = "organization/index.html"
+
+	pageIndex, _ := this.GetInt("page", 1)
+	pageSize := 24
+
+	books, totalCount, err := models.NewOrganization().FindBooksToPager(org.OrgId, this.Member.MemberId, pageIndex, pageSize, this.Member.IsAdministrator())
+	if err != nil {
+		beego.Error("FindBooksToPager => ", err)
+		this.Abort("500")
+	}
+	if totalCount > 0 {
+		this.Data["PageHtml"] = utils.NewPaginations(conf.RollPage, totalCount, pageSize, pageIndex, beego.URLFor("OrganizationController.Index", ":org", org.Identify), "")
+	} else {
+		this.Data["PageHtml"] = ""
+	}
+	this.Data["TotalPages"] = int(math.Ceil(float64(totalCount) / float64(pageSize)))
+
+	org.RoleId, _ = models.NewOrganizationMember().FindRoleId(org.OrgId, this.Member.MemberId)
+	if this.Member.MemberId <= 0 {
+		org.RoleId = -1
+	}
+	if org.Logo != "" {
+		org.Logo = utils.ShowImg(org.Logo)
+	}
+	this.Data["Model"] = org
+	this.Data["Lists"] = books
+	this.Data["CanManage"] = this.canManage(org)
+	this.Data["SeoTitle"] = org.OrgName + " - " + this.Sitename
+	this.Data["SeoKeywords"] = org.OrgName
+	this.Data["SeoDescription"] = org.Description
+}
+
+// Setting 组织设置.
+func (this *OrganizationController) Setting() {
+	org, err := this.IsPermission(this.Ctx.Input.Param(":org"))
+	if err != nil {
+		if this.Ctx.Input.IsPost() {
+			this.JsonResult(6001, err.Error())
+		}
+		this.Abort("403")
+	}
+
+	if this.Ctx.Input.IsPost() {
+		org.OrgName = this.GetString("org_name")
+		org.Description = strings.TrimSpace(this.GetString("description"))
+		org.Logo = strings.TrimSpace(this.GetString("logo"))
+		org.ThemeColor = strings.TrimSpace(this.GetString("theme_color"))
+		org.PrivatelyOwned, _ = this.GetInt("privately_owned", 1)
+
+		if err := org.Update("org_name", "description", "logo", "theme_color", "privately_owned", "modify_time"); err != nil {
+			this.JsonResult(6005, err.Error())
+		}
+		this.JsonResult(0, "ok", org)
+	}
+
+	this.TplName = "organization/setting.html"
+	this.Data["Model"] = org
+	this.Data["SeoTitle"] = org.OrgName + " - 组织设置 - " + this.Sitename
+
+	members, err := models.NewOrganizationMember().FindByOrgId(org.OrgId)
+	if err != nil {
+		beego.Error("OrganizationMember.FindByOrgId => ", err)
+	}
+	for _, member := range members {
+		member.Avatar = utils.ShowImg(member.Avatar, "avatar")
+	}
+	if b, err := json.Marshal(members); err == nil && members != nil {
+		this.Data["Result"] = template.JS(string(b))
+	} else {
+		this.Data["Result"] = template.JS("[]")
+	}
+}
+
+// Create 创建组织.
+func (this *OrganizationController) Create() {
+	if !this.Member.IsAdministrator() {
+		this.JsonResult(403, "权限不足")
+	}
+	owner := this.Member
+	if account := strings.TrimSpace(this.GetString("account")); account != "" {
+		member, err := models.NewMember().FindByAccount(account)
+		if err != nil {
+			this.JsonResult(404, "用户不存在")
+		}
+		owner = member
+	}
+
+	org := models.NewOrganization()
+	org.OrgName = this.GetString("org_name")
+	org.Identify = this.GetString("identify")
+	org.Description = strings.TrimSpace(this.GetString("description"))
+	org.PrivatelyOwned, _ = this.GetInt("privately_owned", 1)
+	org.MemberId = owner.MemberId
+
+	if err := org.Insert(); err != nil {
+		beego.Error("Organization.Insert => ", err)
+		this.JsonResult(6005, err.Error())
+	}
+	org.MemberCount = 1
+	this.JsonResult(0, "ok", org)
+}
+
+// Delete 删除组织，组织下的项目转为个人项目.
+func (this *OrganizationController) Delete() {
+	if !this.Member.IsAdministrator() {
+		this.JsonResult(403, "权限不足")
+	}
+	org_id, _ := this.GetInt("org_id", 0)
+	if org_id <= 0 {
+		this.JsonResult(6001, "参数错误")
+	}
+	if err := models.NewOrganization().Delete(org_id); err != nil {
+		beego.Error("Organization.Delete => ", err)
+		this.JsonResult(6005, "删除失败")
+	}
+	this.JsonResult(0, "ok")
+}
+
+// AddMember 添加组织成员.
+func (this *OrganizationController) AddMember() {
+	account := strings.TrimSpace(this.GetString("account"))
+	role_id, _ := this.GetInt("role_id", models.OrgRoleMember)
+
+	org, err := this.IsPermission(this.GetString("identify"))
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	if account == "" {
+		this.JsonResult(6002, "账号不能为空")
+	}
+	member, err := models.NewMember().FindByAccount(account)
+	if err != nil {
+		this.JsonResult(404, "用户不存在")
+	}
+	if member.Status == conf.MemberStatusDisabled {
+		this.JsonResult(6003, "用户已被禁用")
+	}
+
+	org_member := models.NewOrganizationMember()
+	org_member.OrgId = org.OrgId
+	org_member.MemberId = member.MemberId
+	org_member.RoleId = role_id
+
+	if err := org_member.Insert(); err != nil {
+		this.JsonResult(6005, err.Error())
+	}
+	org_member.Account = member.Account
+	org_member.Nickname = member.Nickname
+	org_member.Avatar = utils.ShowImg(member.Avatar, "avatar")
+	if role_id == models.OrgRoleAdmin {
+		org_member.RoleName = "管理员"
+	} else {
+		org_member.RoleName = "成员"
+	}
+	this.JsonResult(0, "ok", org_member)
+}
+
+// ChangeRole 变更组织成员角色.
+func (this *OrganizationController) ChangeRole() {
+	member_id, _ := this.GetInt("member_id", 0)
+	role_id, _ := this.GetInt("role_id", models.OrgRoleMember)
+
+	org, err := this.IsPermission(this.GetString("identify"))
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	if member_id <= 0 {
+		this.JsonResult(6002, "参数错误")
+	}
+	if member_id == this.Member.MemberId {
+		this.JsonResult(6006, "不能变更自己的角色")
+	}
+	org_member, err := models.NewOrganizationMember().ChangeRole(org.OrgId, member_id, role_id)
+	if err != nil {
+		this.JsonResult(6005, err.Error())
+	}
+	org_member.Avatar = utils.ShowImg(org_member.Avatar, "avatar")
+	this.JsonResult(0, "ok", org_member)
+}
+
+// RemoveMember 移除组织成员.
+func (this *OrganizationController) RemoveMember() {
+	member_id, _ := this.GetInt("member_id", 0)
+
+	org, err := this.IsPermission(this.GetString("identify"))
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	if member_id <= 0 {
+		this.JsonResult(6002, "参数错误")
+	}
+	if member_id == this.Member.MemberId {
+		this.JsonResult(6006, "不能移除自己")
+	}
+	if err := models.NewOrganizationMember().Delete(org.OrgId, member_id); err != nil {
+		this.JsonResult(6005, err.Error())
+	}
+	this.JsonResult(0, "ok")
+}
+
+//是否可以管理组织.
+func (this *OrganizationController) canManage(org *models.Organization) bool {
+	if this.Member == nil || this.Member.MemberId <= 0 {
+		return false
+	}
+	if this.Member.IsAdministrator() {
+		return true
+	}
+	role_id, err := models.NewOrganizationMember().FindRoleId(org.OrgId, this.Member.MemberId)
+	return err == nil && (role_id == models.OrgRoleOwner || role_id == models.OrgRoleAdmin)
+}
+
+//只有超级管理员和组织的所有者、管理员可以管理组织.
+func (this *OrganizationController) IsPermission(identify string) (*models.Organization, error) {
+	if this.Member == nil || this.Member.MemberId <= 0 {
+		return nil, errors.New("请登录后再操作")
+	}
+	org, err := models.NewOrganization().FindByIdentify(identify)
+	if err != nil {
+		return org, errors.New("组织不存在")
+	}
+	if !this.canManage(org) {
+		return org, errors.New("权限不足")
+	}
+	return org, nil
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/models"
+	"github.com/astaxie/beego"
+)
+
+//回收站，恢复或彻底删除已删除的文档和项目.
+type RecycleBinController struct {
+	BaseController
+}
+
+// Restore 恢复回收站条目.
+func (this *RecycleBinController) Restore() {
+	item := this.findItem()
+
+	if err := item.Restore(); err != nil {
+		beego.Error("RecycleBin.Restore => ", err)
+		if err == models.ErrRecycleBookDeleted {
+			this.JsonResult(6004, err.Error())
+		}
+		this.JsonResult(6005, "恢复失败")
+	}
+	this.AuditLog(conf.AuditRecycleRestore, item.BookId, item.RecycleId, "从回收站恢复 "+item.ItemName, item, nil)
+	this.JsonResult(0, "ok", item)
+}
+
+// Purge 彻底删除回收站条目.
+func (this *RecycleBinController) Purge() {
+	item := this.findItem()
+
+	if err := item.Purge(); err != nil {
+		beego.Error("RecycleBin.Purge => ", err)
+		this.JsonResult(6005, "删除失败")
+	}
+	this.AuditLog(conf.AuditRecyclePurge, item.BookId, item.RecycleId, "从回收站彻底删除 "+item.ItemName, item, nil)
+	this.JsonResult(0, "ok")
+}
+
+//查询回收站条目，项目只有超级管理员可以恢复或删除，文档需要项目创始人或管理员权限.
+func (this *RecycleBinController) findItem() *models.RecycleBin {
+	recycle_id, _ := this.GetInt("recycle_id", 0)
+	if recycle_id <= 0 {
+		this.JsonResult(6001, "参数错误")
+	}
+	item, err := models.NewRecycleBin().Find(recycle_id)
+	if err != nil {
+		this.JsonResult(6002, "回收站条目不存在")
+	}
+	if this.Member.IsAdministrator() {
+		return item
+	}
+	if item.ItemType == conf.RecycleBook {
+		this.JsonResult(6003, "权限不足")
+	}
+	role_id, err := models.NewRelationship().FindEffectiveRoleId(item.BookId, this.Member.MemberId)
+	if err != nil || (role_id != conf.BookFounder && role_id != conf.BookAdmin) {
+		this.JsonResult(6003, "权限不足")
+	}
+	return item
+}
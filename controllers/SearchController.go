@@ -26,78 +26,111 @@ func (this *SearchController) Index() {
 	}
 
 	keyword := this.GetString("keyword")
-	this.Redirect(beego.URLFor("LabelController.Index", ":key", keyword), 302)
-	return
-	//当搜索文档时，直接搜索标签
+	filter := this.metaFilter()
+	//没有按文档元数据过滤时，直接搜索标签
+	if filter.IsEmpty() {
+		this.Redirect(beego.URLFor("LabelController.Index", ":key", keyword), 302)
+		return
+	}
 
 	pageIndex, _ := this.GetInt("page", 1)
 
 	this.Data["BaseUrl"] = this.BaseUrl()
 
-	if keyword != "" {
-		this.Data["Keyword"] = keyword
-		member_id := 0
-		if this.Member != nil {
-			member_id = this.Member.MemberId
-		}
-		search_result, totalCount, err := models.NewDocumentSearchResult().FindToPager(keyword, pageIndex, conf.PageSize, member_id)
+	this.Data["Keyword"] = keyword
+	this.Data["Conditions"] = metaConditions(filter)
+	member_id := 0
+	if this.Member != nil {
+		member_id = this.Member.MemberId
+	}
+	search_result, totalCount, err := models.NewDocumentSearchResult().FindToPager(keyword, filter, pageIndex, conf.PageSize, member_id, this.publishedReadable())
 
-		if err != nil {
-			beego.Error(err)
-			return
-		}
-		if totalCount > 0 {
-			html := utils.GetPagerHtml(this.Ctx.Request.RequestURI, pageIndex, conf.PageSize, totalCount)
+	if err != nil {
+		beego.Error(err)
+		return
+	}
+	if totalCount > 0 {
+		html := utils.GetPagerHtml(this.Ctx.Request.RequestURI, pageIndex, conf.PageSize, totalCount)
 
-			this.Data["PageHtml"] = html
-		} else {
-			this.Data["PageHtml"] = ""
-		}
-		if len(search_result) > 0 {
-			for _, item := range search_result {
+		this.Data["PageHtml"] = html
+	} else {
+		this.Data["PageHtml"] = ""
+	}
+	if len(search_result) > 0 {
+		for _, item := range search_result {
+			//只按元数据过滤时不需要高亮关键字
+			if keyword != "" {
 				item.DocumentName = strings.Replace(item.DocumentName, keyword, "<em>"+keyword+"</em>", -1)
+			}
 
-				if item.Description != "" {
-					src := item.Description
+			if item.Description != "" {
+				src := item.Description
 
-					//将HTML标签全转换成小写
-					re, _ := regexp.Compile("\\<[\\S\\s]+?\\>")
-					src = re.ReplaceAllStringFunc(src, strings.ToLower)
+				//将HTML标签全转换成小写
+				re, _ := regexp.Compile("\\<[\\S\\s]+?\\>")
+				src = re.ReplaceAllStringFunc(src, strings.ToLower)
 
-					//去除STYLE
-					re, _ = regexp.Compile("\\<style[\\S\\s]+?\\</style\\>")
-					src = re.ReplaceAllString(src, "")
+				//去除STYLE
+				re, _ = regexp.Compile("\\<style[\\S\\s]+?\\</style\\>")
+				src = re.ReplaceAllString(src, "")
 
-					//去除SCRIPT
-					re, _ = regexp.Compile("\\<script[\\S\\s]+?\\</script\\>")
-					src = re.ReplaceAllString(src, "")
+				//去除SCRIPT
+				re, _ = regexp.Compile("\\<script[\\S\\s]+?\\</script\\>")
+				src = re.ReplaceAllString(src, "")
 
-					//去除所有尖括号内的HTML代码，并换成换行符
-					re, _ = regexp.Compile("\\<[\\S\\s]+?\\>")
-					src = re.ReplaceAllString(src, "\n")
+				//去除所有尖括号内的HTML代码，并换成换行符
+				re, _ = regexp.Compile("\\<[\\S\\s]+?\\>")
+				src = re.ReplaceAllString(src, "\n")
 
-					//去除连续的换行符
-					re, _ = regexp.Compile("\\s{2,}")
-					src = re.ReplaceAllString(src, "\n")
+				//去除连续的换行符
+				re, _ = regexp.Compile("\\s{2,}")
+				src = re.ReplaceAllString(src, "\n")
 
-					r := []rune(src)
+				r := []rune(src)
 
-					if len(r) > 100 {
-						src = string(r[:100])
-					} else {
-						src = string(r)
-					}
+				if len(r) > 100 {
+					src = string(r[:100])
+				} else {
+					src = string(r)
+				}
+				item.Description = src
+				if keyword != "" {
 					item.Description = strings.Replace(src, keyword, "<em>"+keyword+"</em>", -1)
 				}
+			}
 
-				if item.Identify == "" {
-					item.Identify = strconv.Itoa(item.DocumentId)
-				}
-				if item.ModifyTime.IsZero() {
-					item.ModifyTime = item.CreateTime
-				}
+			if item.Identify == "" {
+				item.Identify = strconv.Itoa(item.DocumentId)
+			}
+			if item.ModifyTime.IsZero() {
+				item.ModifyTime = item.CreateTime
 			}
 		}
-		this.Data["Lists"] = search_result
 	}
+	this.Data["Lists"] = search_result
+}
+
+//搜索页面显示的元数据过滤条件.
+func metaConditions(filter *models.DocumentMetaFilter) []string {
+	conditions := make([]string, 0)
+	if filter.Author != "" {
+		conditions = append(conditions, "作者："+filter.Author)
+	}
+	if filter.Owner != "" {
+		conditions = append(conditions, "负责人："+filter.Owner)
+	}
+	if filter.Tag != "" {
+		conditions = append(conditions, "标签："+filter.Tag)
+	}
+	if filter.FieldKey != "" {
+		if filter.FieldValue != "" {
+			conditions = append(conditions, filter.FieldKey+"："+filter.FieldValue)
+		} else {
+			conditions = append(conditions, "包含字段："+filter.FieldKey)
+		}
+	}
+	if !filter.ReviewBefore.IsZero() {
+		conditions = append(conditions, "复查日期不晚于："+filter.ReviewBefore.Format("2006-01-02"))
+	}
+	return conditions
 }
@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"encoding/json"
+	"html/template"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -111,6 +113,167 @@ func (this *SettingController) Star() {
 	this.Data["Books"] = books
 }
 
+//我的通知
+func (this *SettingController) Notifications() {
+	page, _ := this.GetInt("page", 1)
+	unread, _ := this.GetBool("unread", false)
+
+	this.TplName = "setting/notifications.html"
+	this.Data["SettingNotification"] = true
+	this.Data["SeoTitle"] = "我的通知 - " + this.Sitename
+
+	notifications, totalCount, err := models.NewNotification().FindToPager(this.Member.MemberId, unread, page, conf.PageSize)
+	if err != nil {
+		beego.Error("Notification.FindToPager => ", err)
+	}
+	if totalCount > conf.PageSize {
+		if unread {
+			this.Data["PageHtml"] = utils.NewPaginations(conf.RollPage, totalCount, conf.PageSize, page, beego.URLFor("SettingController.Notifications"), "", "unread=true")
+		} else {
+			this.Data["PageHtml"] = utils.NewPaginations(conf.RollPage, totalCount, conf.PageSize, page, beego.URLFor("SettingController.Notifications"), "")
+		}
+	}
+	this.Data["Lists"] = notifications
+	this.Data["Unread"] = unread
+}
+
+//打开通知并标记为已读
+func (this *SettingController) OpenNotification() {
+	notification_id, _ := strconv.Atoi(this.Ctx.Input.Param(":id"))
+
+	notification, err := models.NewNotification().Find(this.Member.MemberId, notification_id)
+	if err != nil {
+		this.Abort("404")
+	}
+	if err := notification.MarkRead(this.Member.MemberId, notification.NotificationId); err != nil {
+		beego.Error("Notification.MarkRead => ", err)
+	}
+	//只允许跳转到站内地址
+	if !strings.HasPrefix(notification.Url, "/") || strings.HasPrefix(notification.Url, "//") {
+		this.Redirect(beego.URLFor("SettingController.Notifications"), 302)
+		this.StopRun()
+	}
+	this.Redirect(notification.Url, 302)
+}
+
+//将通知标记为已读，不指定通知时标记全部
+func (this *SettingController) ReadNotification() {
+	notification_id, _ := this.GetInt("notification_id", 0)
+
+	if err := models.NewNotification().MarkRead(this.Member.MemberId, notification_id); err != nil {
+		beego.Error("Notification.MarkRead => ", err)
+		this.JsonResult(6004, "操作失败")
+	}
+	this.JsonResult(0, "ok", models.NewNotification().UnreadCount(this.Member.MemberId))
+}
+
+//未读通知数量
+func (this *SettingController) UnreadNotification() {
+	this.JsonResult(0, "ok", models.NewNotification().UnreadCount(this.Member.MemberId))
+}
+
+//通知设置
+func (this *SettingController) NotificationSetting() {
+	this.TplName = "setting/notification_setting.html"
+	this.Data["SettingNotification"] = true
+	this.Data["SeoTitle"] = "通知设置 - " + this.Sitename
+
+	setting := models.NewNotificationSetting().FindByMemberId(this.Member.MemberId)
+
+	if this.Ctx.Input.IsPost() {
+		setting.Digest = this.GetString("digest")
+		setting.DigestTypes = strings.Join(this.GetStrings("types"), ",")
+		if setting.Digest != conf.DigestNone && this.Member.Email == "" {
+			this.JsonResult(6001, "请先在基本信息中设置邮箱")
+		}
+		if err := setting.InsertOrUpdate(); err != nil {
+			beego.Error("NotificationSetting.InsertOrUpdate => ", err)
+			this.JsonResult(6004, "保存失败")
+		}
+		this.JsonResult(0, "ok")
+	}
+	types := models.NotificationTypes()
+	for _, item := range types {
+		if setting.HasType(item["type"]) {
+			item["checked"] = "true"
+		}
+	}
+	this.Data["Setting"] = setting
+	this.Data["Types"] = types
+	this.Data["EnableMail"] = conf.GetMailConfig().EnableMail
+}
+
+//我的关注
+func (this *SettingController) Watches() {
+	this.TplName = "setting/watches.html"
+	this.Data["SettingWatch"] = true
+	this.Data["SeoTitle"] = "我的关注 - " + this.Sitename
+
+	watches, err := models.NewWatch().FindByMemberId(this.Member.MemberId)
+	if err != nil {
+		beego.Error("Watch.FindByMemberId => ", err)
+	}
+	this.Data["Lists"] = watches
+	this.Data["EnableMail"] = conf.GetMailConfig().EnableMail
+}
+
+//我的团队
+func (this *SettingController) Teams() {
+	this.TplName = "setting/teams.html"
+	this.Data["SettingTeam"] = true
+	this.Data["SeoTitle"] = "我的团队 - " + this.Sitename
+
+	teams, err := models.NewTeam().FindByMemberId(this.Member.MemberId)
+	if err != nil {
+		beego.Error("Team.FindByMemberId => ", err)
+	}
+	this.Data["Teams"] = teams
+}
+
+//我的组织
+func (this *SettingController) Organizations() {
+	this.TplName = "setting/organizations.html"
+	this.Data["SettingOrganization"] = true
+	this.Data["SeoTitle"] = "我的组织 - " + this.Sitename
+
+	orgs, err := models.NewOrganization().FindByMemberId(this.Member.MemberId)
+	if err != nil {
+		beego.Error("Organization.FindByMemberId => ", err)
+	}
+	this.Data["Organizations"] = orgs
+}
+
+//团队成员管理
+func (this *SettingController) TeamMembers() {
+	team_id, _ := strconv.Atoi(this.Ctx.Input.Param(":id"))
+	team, err := models.NewTeam().Find(team_id)
+	if err != nil {
+		this.Abort("404")
+	}
+	role_id, err := models.NewTeamMember().FindRoleId(team.TeamId, this.Member.MemberId)
+	if err != nil && !this.Member.IsAdministrator() {
+		this.Abort("403")
+	}
+	this.TplName = "setting/team_members.html"
+	this.Data["SettingTeam"] = true
+	this.Data["SeoTitle"] = team.TeamName + " - 团队成员 - " + this.Sitename
+	this.Data["Model"] = team
+	this.Data["CanManage"] = this.Member.IsAdministrator() || role_id == models.TeamRoleMaintainer
+
+	members, err := models.NewTeamMember().FindByTeamId(team.TeamId)
+	if err != nil {
+		beego.Error("TeamMember.FindByTeamId => ", err)
+	}
+	for _, member := range members {
+		member.Avatar = utils.ShowImg(member.Avatar, "avatar")
+	}
+	if b, err := json.Marshal(members); err == nil && members != nil {
+		this.Data["Result"] = template.JS(string(b))
+	} else {
+		this.Data["Result"] = template.JS("[]")
+	}
+}
+
 //二维码
 func (this *SettingController) Qrcode() {
 	if this.Ctx.Input.IsPost() {
@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/models"
+	"github.com/JermineHu/DocStack/utils"
+	"github.com/astaxie/beego"
+)
+
+//团队管理.
+type TeamController struct {
+	BaseController
+}
+
+// List 团队列表，超级管理员可以看到全部团队，其他用户只能看到自己加入的团队.
+func (this *TeamController) List() {
+	if this.Member.IsAdministrator() {
+		pageIndex, _ := this.GetInt("page", 1)
+		teams, totalCount, err := models.NewTeam().FindToPager(pageIndex, conf.PageSize)
+		if err != nil {
+			beego.Error("Team.FindToPager => ", err)
+			this.JsonResult(6002, "获取团队列表失败")
+		}
+		this.JsonResult(0, "ok", map[string]interface{}{"lists": teams, "total": totalCount})
+	}
+	teams, err := models.NewTeam().FindByMemberId(this.Member.MemberId)
+	if err != nil {
+		beego.Error("Team.FindByMemberId => ", err)
+		this.JsonResult(6002, "获取团队列表失败")
+	}
+	this.JsonResult(0, "ok", map[string]interface{}{"lists": teams, "total": len(teams)})
+}
+
+// Create 创建团队.
+func (this *TeamController) Create() {
+	if !this.Member.IsAdministrator() {
+		this.JsonResult(403, "权限不足")
+	}
+	team := models.NewTeam()
+	team.TeamName = this.GetString("team_name")
+	team.Description = strings.TrimSpace(this.GetString("description"))
+	team.MemberId = this.Member.MemberId
+
+	if strings.Count(team.Description, "") > 500 {
+		this.JsonResult(6004, "团队描述不能超过500字")
+	}
+	if err := team.Insert(); err != nil {
+		beego.Error("Team.Insert => ", err)
+		this.JsonResult(6005, err.Error())
+	}
+	team.MemberCount = 1
+	team.CreateName = this.Member.Account
+	this.JsonResult(0, "ok", team)
+}
+
+// Update 修改团队名称和描述.
+func (this *TeamController) Update() {
+	team, err := this.IsPermission()
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	team.TeamName = this.GetString("team_name")
+	team.Description = strings.TrimSpace(this.GetString("description"))
+
+	if strings.Count(team.Description, "") > 500 {
+		this.JsonResult(6004, "团队描述不能超过500字")
+	}
+	if err := team.Update("team_name", "description", "modify_time"); err != nil {
+		this.JsonResult(6005, err.Error())
+	}
+	this.JsonResult(0, "ok", team)
+}
+
+// Delete 删除团队.
+func (this *TeamController) Delete() {
+	if !this.Member.IsAdministrator() {
+		this.JsonResult(403, "权限不足")
+	}
+	team_id, _ := this.GetInt("team_id", 0)
+	if team_id <= 0 {
+		this.JsonResult(6001, "参数错误")
+	}
+	if err := models.NewTeam().Delete(team_id); err != nil {
+		beego.Error("Team.Delete => ", err)
+		this.JsonResult(6005, "删除失败")
+	}
+	this.JsonResult(0, "ok")
+}
+
+// Members 团队成员列表.
+func (this *TeamController) Members() {
+	team_id, _ := this.GetInt("team_id", 0)
+	team, err := models.NewTeam().Find(team_id)
+	if err != nil {
+		this.JsonResult(404, "团队不存在")
+	}
+	if !this.Member.IsAdministrator() {
+		if _, err := models.NewTeamMember().FindRoleId(team.TeamId, this.Member.MemberId); err != nil {
+			this.JsonResult(403, "权限不足")
+		}
+	}
+	members, err := models.NewTeamMember().FindByTeamId(team.TeamId)
+	if err != nil {
+		beego.Error("TeamMember.FindByTeamId => ", err)
+		this.JsonResult(6002, "获取团队成员失败")
+	}
+	for _, member := range members {
+		member.Avatar = utils.ShowImg(member.Avatar, "avatar")
+	}
+	this.JsonResult(0, "ok", members)
+}
+
+// AddMember 添加团队成员.
+func (this *TeamController) AddMember() {
+	account := strings.TrimSpace(this.GetString("account"))
+	role_id, _ := this.GetInt("role_id", models.TeamRoleMember)
+
+	team, err := this.IsPermission()
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	if account == "" {
+		this.JsonResult(6002, "账号不能为空")
+	}
+	member, err := models.NewMember().FindByAccount(account)
+	if err != nil {
+		this.JsonResult(404, "用户不存在")
+	}
+	if member.Status == conf.MemberStatusDisabled {
+		this.JsonResult(6003, "用户已被禁用")
+	}
+
+	team_member := models.NewTeamMember()
+	team_member.TeamId = team.TeamId
+	team_member.MemberId = member.MemberId
+	team_member.RoleId = role_id
+
+	if err := team_member.Insert(); err != nil {
+		this.JsonResult(6005, err.Error())
+	}
+	team_member.Account = member.Account
+	team_member.Nickname = member.Nickname
+	team_member.Avatar = utils.ShowImg(member.Avatar, "avatar")
+	if role_id == models.TeamRoleMaintainer {
+		team_member.RoleName = "维护者"
+	} else {
+		team_member.RoleName = "成员"
+	}
+	this.JsonResult(0, "ok", team_member)
+}
+
+// ChangeMemberRole 变更团队成员角色.
+func (this *TeamController) ChangeMemberRole() {
+	member_id, _ := this.GetInt("member_id", 0)
+	role_id, _ := this.GetInt("role_id", models.TeamRoleMember)
+
+	team, err := this.IsPermission()
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	if member_id <= 0 {
+		this.JsonResult(6002, "参数错误")
+	}
+	team_member, err := models.NewTeamMember().ChangeRole(team.TeamId, member_id, role_id)
+	if err != nil {
+		this.JsonResult(6005, err.Error())
+	}
+	team_member.Avatar = utils.ShowImg(team_member.Avatar, "avatar")
+	this.JsonResult(0, "ok", team_member)
+}
+
+// RemoveMember 移除团队成员.
+func (this *TeamController) RemoveMember() {
+	member_id, _ := this.GetInt("member_id", 0)
+
+	team, err := this.IsPermission()
+	if err != nil {
+		this.JsonResult(6001, err.Error())
+	}
+	if member_id <= 0 {
+		this.JsonResult(6002, "参数错误")
+	}
+	if err := models.NewTeamMember().Delete(team.TeamId, member_id); err != nil {
+		this.JsonResult(6005, err.Error())
+	}
+	this.JsonResult(0, "ok")
+}
+
+//只有超级管理员和团队维护者可以管理团队.
+func (this *TeamController) IsPermission() (*models.Team, error) {
+	team_id, _ := this.GetInt("team_id", 0)
+	team, err := models.NewTeam().Find(team_id)
+	if err != nil {
+		return team, errors.New("团队不存在")
+	}
+	if this.Member.IsAdministrator() {
+		return team, nil
+	}
+	role_id, err := models.NewTeamMember().FindRoleId(team.TeamId, this.Member.MemberId)
+	if err != nil || role_id != models.TeamRoleMaintainer {
+		return team, errors.New("权限不足")
+	}
+	return team, nil
+}
@@ -0,0 +1,379 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/utils"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+//文档批注，锚定在文档中选中的一段文字上.
+type Annotation struct {
+	AnnotationId int    `orm:"pk;auto;column(annotation_id)" json:"annotation_id"`
+	BookId       int    `orm:"column(book_id);type(int);index" json:"book_id"`
+	DocumentId   int    `orm:"column(document_id);type(int);index" json:"doc_id"`
+	MemberId     int    `orm:"column(member_id);type(int)" json:"member_id"`
+	Quote        string `orm:"column(quote);size(1000)" json:"quote"`  //选中的文字
+	Prefix       string `orm:"column(prefix);size(200)" json:"prefix"` //选中文字之前的上下文
+	Suffix       string `orm:"column(suffix);size(200)" json:"suffix"` //选中文字之后的上下文
+	StartOffset  int    `orm:"column(start_offset);type(int);default(0)" json:"start_offset"`
+	EndOffset    int    `orm:"column(end_offset);type(int);default(0)" json:"end_offset"`
+	Content      string `orm:"column(content);size(2000)" json:"content"`
+	//是否已解决：0 否/1 是
+	Resolved         int       `orm:"column(resolved);type(int);default(0)" json:"resolved"`
+	ResolvedMemberId int       `orm:"column(resolved_member_id);type(int);default(0)" json:"resolved_member_id"`
+	ResolvedTime     time.Time `orm:"column(resolved_time);type(datetime);null" json:"resolved_time"`
+	//文档修改后无法重新定位到原文：0 否/1 是
+	Orphaned   int       `orm:"column(orphaned);type(int);default(0)" json:"orphaned"`
+	CreateTime time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+	ModifyTime time.Time `orm:"column(modify_time);type(datetime);auto_now" json:"modify_time"`
+
+	Account         string             `orm:"-" json:"account"`
+	Avatar          string             `orm:"-" json:"avatar"`
+	ResolvedAccount string             `orm:"-" json:"resolved_account"`
+	Replies         []*AnnotationReply `orm:"-" json:"replies"`
+}
+
+//批注的回复.
+type AnnotationReply struct {
+	ReplyId      int       `orm:"pk;auto;column(reply_id)" json:"reply_id"`
+	AnnotationId int       `orm:"column(annotation_id);type(int);index" json:"annotation_id"`
+	BookId       int       `orm:"column(book_id);type(int);index" json:"book_id"`
+	MemberId     int       `orm:"column(member_id);type(int)" json:"member_id"`
+	Content      string    `orm:"column(content);size(2000)" json:"content"`
+	CreateTime   time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+	Account      string    `orm:"-" json:"account"`
+	Avatar       string    `orm:"-" json:"avatar"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *Annotation) TableName() string {
+	return "document_annotations"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *Annotation) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *Annotation) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewAnnotation() *Annotation {
+	return &Annotation{}
+}
+
+// TableName 获取对应数据库表名.
+func (m *AnnotationReply) TableName() string {
+	return "annotation_replies"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *AnnotationReply) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *AnnotationReply) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewAnnotationReply() *AnnotationReply {
+	return &AnnotationReply{}
+}
+
+func (m *Annotation) Find(id int) (*Annotation, error) {
+	if id <= 0 {
+		return m, ErrInvalidParameter
+	}
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("annotation_id", id).One(m)
+	return m, err
+}
+
+//添加批注，并根据文档当前内容定位选中的文字.
+func (m *Annotation) Insert() error {
+	m.Quote = utils.NormalizeSpace(m.Quote)
+	m.Content = strings.TrimSpace(m.Content)
+	if m.Quote == "" {
+		return errors.New("请选择需要批注的文字")
+	}
+	if utf8.RuneCountInString(m.Quote) > 500 {
+		return errors.New("批注的文字不能超过500个字符")
+	}
+	if err := checkAnnotationContent(m.Content); err != nil {
+		return err
+	}
+	m.Prefix = anchorContext(m.Prefix, true)
+	m.Suffix = anchorContext(m.Suffix, false)
+
+	doc, err := NewDocument().Find(m.DocumentId)
+	if err != nil {
+		return errors.New("文档不存在")
+	}
+	m.BookId = doc.BookId
+	m.Resolved = 0
+	m.Orphaned = 0
+
+	text := utils.MarkdownText(new(DocumentStore).GetFiledById(doc.DocumentId, "markdown"))
+	if anchor, ok := utils.FindAnchor(text, m.Quote, m.Prefix, m.Suffix, m.StartOffset); ok {
+		m.StartOffset, m.EndOffset = anchor.Start, anchor.End
+	} else {
+		//已发布的内容和当前内容可能不一致，保留选中的文字，下次保存文档时重新定位
+		m.StartOffset, m.EndOffset = 0, 0
+	}
+	_, err = orm.NewOrm().Insert(m)
+	return err
+}
+
+//查询文档的批注，resolved 为 -1 表示全部.
+func (m *Annotation) FindByDocumentId(doc_id, resolved int) (annotations []*Annotation, err error) {
+	qs := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("document_id", doc_id)
+	if resolved >= 0 {
+		qs = qs.Filter("resolved", resolved)
+	}
+	if _, err = qs.OrderBy("start_offset", "annotation_id").Limit(-1).All(&annotations); err != nil {
+		return
+	}
+	if len(annotations) == 0 {
+		return
+	}
+	ids := make([]int, 0, len(annotations))
+	index := make(map[int]*Annotation, len(annotations))
+	for _, item := range annotations {
+		item.Replies = make([]*AnnotationReply, 0)
+		ids = append(ids, item.AnnotationId)
+		index[item.AnnotationId] = item
+	}
+	var replies []*AnnotationReply
+	_, err = orm.NewOrm().QueryTable(NewAnnotationReply().TableNameWithPrefix()).Filter("annotation_id__in", ids).OrderBy("reply_id").Limit(-1).All(&replies)
+	if err != nil {
+		return
+	}
+	for _, reply := range replies {
+		if item, ok := index[reply.AnnotationId]; ok {
+			item.Replies = append(item.Replies, reply)
+		}
+	}
+	resolveAnnotations(annotations)
+	return
+}
+
+//统计文档未解决的批注数量.
+func (m *Annotation) CountUnresolved(doc_id int) int {
+	count, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("document_id", doc_id).Filter("resolved", 0).Count()
+	if err != nil {
+		return 0
+	}
+	return int(count)
+}
+
+//回复批注.
+func (m *Annotation) Reply(member_id int, content string) (*AnnotationReply, error) {
+	reply := NewAnnotationReply()
+	reply.Content = strings.TrimSpace(content)
+	if err := checkAnnotationContent(reply.Content); err != nil {
+		return reply, err
+	}
+	reply.AnnotationId = m.AnnotationId
+	reply.BookId = m.BookId
+	reply.MemberId = member_id
+
+	o := orm.NewOrm()
+	if _, err := o.Insert(reply); err != nil {
+		return reply, err
+	}
+	//回复已解决的批注时重新打开
+	if m.Resolved == 1 {
+		m.Resolved = 0
+		m.ResolvedMemberId = 0
+		if _, err := o.Update(m, "resolved", "resolved_member_id", "modify_time"); err != nil {
+			beego.Error("重新打开批注失败 => ", err)
+		}
+	}
+	resolveAnnotationReplies([]*AnnotationReply{reply})
+	return reply, nil
+}
+
+//发送批注中提及用户的通知，reply 不为空时同时通知批注作者.
+func (m *Annotation) Notify(sender *Member, reply *AnnotationReply) error {
+	doc, err := NewDocument().Find(m.DocumentId)
+	if err != nil {
+		return err
+	}
+	book, err := NewBook().Find(m.BookId)
+	if err != nil {
+		return err
+	}
+	notification := NewNotification()
+	notification.SenderId = sender.MemberId
+	notification.BookId = book.BookId
+	notification.DocumentId = doc.DocumentId
+	notification.ObjectId = m.AnnotationId
+	notification.Url = NotificationDocumentUrl(book.Identify, doc)
+
+	content := m.Content
+	if reply != nil {
+		content = reply.Content
+		notification.Type = conf.NotifyReply
+		notification.Title = fmt.Sprintf("%s 回复了你在《%s》中的批注：%s", sender.Account, doc.DocumentName, NotificationSummary(content))
+		if err := notification.Send([]int{m.MemberId}); err != nil {
+			return err
+		}
+	}
+	notification.Title = fmt.Sprintf("%s 在《%s》的批注中提到了你：%s", sender.Account, doc.DocumentName, NotificationSummary(content))
+	if reply != nil {
+		if author, err := NewMember().Find(m.MemberId); err == nil {
+			return notification.SendMentions(content, true, author.Account)
+		}
+	}
+	return notification.SendMentions(content, true)
+}
+
+//标记批注为已解决或重新打开.
+func (m *Annotation) Resolve(member_id int, resolved bool) error {
+	if resolved {
+		m.Resolved = 1
+		m.ResolvedMemberId = member_id
+		m.ResolvedTime = time.Now()
+	} else {
+		m.Resolved = 0
+		m.ResolvedMemberId = 0
+	}
+	_, err := orm.NewOrm().Update(m, "resolved", "resolved_member_id", "resolved_time", "modify_time")
+	return err
+}
+
+//删除批注及其回复.
+func (m *Annotation) Delete() error {
+	o := orm.NewOrm()
+	if _, err := o.QueryTable(NewAnnotationReply().TableNameWithPrefix()).Filter("annotation_id", m.AnnotationId).Delete(); err != nil {
+		return err
+	}
+	_, err := o.Delete(m)
+	return err
+}
+
+//删除文档的全部批注.
+func (m *Annotation) DeleteByDocumentId(doc_id int) error {
+	o := orm.NewOrm()
+	var ids orm.ParamsList
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id", doc_id).ValuesFlat(&ids, "annotation_id"); err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		if _, err := o.QueryTable(NewAnnotationReply().TableNameWithPrefix()).Filter("annotation_id__in", ids).Delete(); err != nil {
+			return err
+		}
+	}
+	_, err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id", doc_id).Delete()
+	return err
+}
+
+//文档内容修改后重新定位批注，无法定位的批注标记为原文已删除.
+func (m *Annotation) Reanchor(doc_id int, markdown string) error {
+	var annotations []*Annotation
+	o := orm.NewOrm()
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id", doc_id).Limit(-1).All(&annotations); err != nil {
+		return err
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	text := utils.MarkdownText(markdown)
+	runes := []rune(text)
+	for _, item := range annotations {
+		orphaned := 1
+		start, end := item.StartOffset, item.EndOffset
+		quote, prefix, suffix := item.Quote, item.Prefix, item.Suffix
+		if anchor, ok := utils.FindAnchor(text, item.Quote, item.Prefix, item.Suffix, item.StartOffset); ok {
+			orphaned = 0
+			start, end = anchor.Start, anchor.End
+			quote = string(runes[start:end])
+			prefix = anchorContext(string(runes[:start]), true)
+			suffix = anchorContext(string(runes[end:]), false)
+		}
+		if orphaned == item.Orphaned && start == item.StartOffset && end == item.EndOffset && quote == item.Quote {
+			continue
+		}
+		item.Orphaned, item.StartOffset, item.EndOffset = orphaned, start, end
+		item.Quote, item.Prefix, item.Suffix = quote, prefix, suffix
+		if _, err := o.Update(item, "orphaned", "start_offset", "end_offset", "quote", "prefix", "suffix"); err != nil {
+			beego.Error("重新定位批注失败 => ", item.AnnotationId, err)
+		}
+	}
+	return nil
+}
+
+func checkAnnotationContent(content string) error {
+	if content == "" {
+		return errors.New("批注内容不能为空")
+	}
+	if utf8.RuneCountInString(content) > 2000 {
+		return errors.New("批注内容不能超过2000个字符")
+	}
+	return nil
+}
+
+//截取用于定位的上下文，before 为 true 时取末尾的文字.
+func anchorContext(text string, before bool) string {
+	runes := []rune(utils.NormalizeSpace(text))
+	if len(runes) <= 32 {
+		return string(runes)
+	}
+	if before {
+		return string(runes[len(runes)-32:])
+	}
+	return string(runes[:32])
+}
+
+//填充批注和回复的用户信息.
+func resolveAnnotations(annotations []*Annotation) {
+	members := make(map[int]*Member)
+	for _, item := range annotations {
+		if member := findCachedMember(members, item.MemberId); member != nil {
+			item.Account = member.Account
+			item.Avatar = member.Avatar
+		}
+		if item.Resolved == 1 {
+			if member := findCachedMember(members, item.ResolvedMemberId); member != nil {
+				item.ResolvedAccount = member.Account
+			}
+		}
+		for _, reply := range item.Replies {
+			if member := findCachedMember(members, reply.MemberId); member != nil {
+				reply.Account = member.Account
+				reply.Avatar = member.Avatar
+			}
+		}
+	}
+}
+
+func resolveAnnotationReplies(replies []*AnnotationReply) {
+	members := make(map[int]*Member)
+	for _, reply := range replies {
+		if member := findCachedMember(members, reply.MemberId); member != nil {
+			reply.Account = member.Account
+			reply.Avatar = member.Avatar
+		}
+	}
+}
+
+func findCachedMember(members map[int]*Member, member_id int) *Member {
+	if member, ok := members[member_id]; ok {
+		return member
+	}
+	member, err := NewMember().Find(member_id)
+	if err != nil {
+		member = nil
+	} else if member.Avatar == "" {
+		member.Avatar = conf.GetDefaultAvatar()
+	}
+	members[member_id] = member
+	return member
+}
@@ -58,7 +58,7 @@ func SitemapData(page, listRows int) (totalRows int64, sitemaps []SitemapDocs) {
 		books_id []interface{}
 	)
 	o := orm.NewOrm()
-	o.QueryTable("md_books").Filter("privately_owned", 0).Limit(100000).All(&books, "book_id", "identify")
+	o.QueryTable("md_books").Filter("privately_owned", 0).Filter("status", 0).Limit(100000).All(&books, "book_id", "identify")
 	if len(books) > 0 {
 		for _, book := range books {
 			books_id = append(books_id, book.BookId)
@@ -96,7 +96,7 @@ func SitemapUpdate(domain string) {
 	domain = strings.TrimSuffix(domain, "/")
 	os.Mkdir("sitemap", os.ModePerm)
 	//查询公开的项目
-	qsBooks := o.QueryTable("md_books").Filter("privately_owned", 0)
+	qsBooks := o.QueryTable("md_books").Filter("privately_owned", 0).Filter("status", 0)
 	limit := 10000
 	for i := 0; i < 10; i++ {
 		var books []Book
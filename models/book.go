@@ -20,7 +20,7 @@ import (
 type Book struct {
 	BookId            int       `orm:"pk;auto;unique;column(book_id)" json:"book_id"`
 	BookName          string    `orm:"column(book_name);size(500)" json:"book_name"`      // BookName 项目名称.
-	Identify          string    `orm:"column(identify);size(100);unique" json:"identify"` // Identify 项目唯一标识.
+	Identify          string    `orm:"column(identify);size(100);unique" json:"identify"` // Identify 项目唯一标识，全站唯一，不按组织划分.
 	OrderIndex        int       `orm:"column(order_index);type(int);default(0)" json:"order_index"`
 	Description       string    `orm:"column(description);size(2000)" json:"description"` // Description 项目描述.
 	Label             string    `orm:"column(label);size(500)" json:"label"`
@@ -35,6 +35,7 @@ type Book struct {
 	Theme             string    `orm:"column(theme);size(255);default(default)" json:"theme"`              //主题风格
 	CreateTime        time.Time `orm:"type(datetime);column(create_time);auto_now_add" json:"create_time"` // CreateTime 创建时间 .
 	MemberId          int       `orm:"column(member_id);size(100)" json:"member_id"`
+	OrgId             int       `orm:"column(org_id);type(int);default(0);index" json:"org_id"` //所属组织，0 表示个人项目
 	ModifyTime        time.Time `orm:"type(datetime);column(modify_time);auto_now_add" json:"modify_time"`
 	ReleaseTime       time.Time `orm:"type(datetime);column(release_time);" json:"release_time"`   //项目发布时间，每次发布都更新一次，如果文档更新时间小于发布时间，则文档不再执行发布
 	GenerateTime      time.Time `orm:"type(datetime);column(generate_time);" json:"generate_time"` //下载文档生成时间
@@ -45,6 +46,9 @@ type Book struct {
 	Score             int       `orm:"column(score);default(40)" json:"score"` //文档项目评分，默认40，即4.0星
 	CntScore          int       //评分人数
 	CntComment        int       //评论人数
+
+	EnableReview int `orm:"column(enable_review);type(int);default(0)" json:"enable_review"` //是否开启发布审阅：0 否/1 是，开启后只发布审阅通过的修订
+	IsTemplate   int `orm:"column(is_template);type(int);default(0)" json:"is_template"`     //是否作为项目模板：0 否/1 是，模板可以在创建项目时作为起点
 }
 
 // TableName 获取对应数据库表名.
@@ -69,7 +73,10 @@ func (m *Book) Insert() error {
 	_, err := o.Insert(m)
 	if err == nil {
 		if m.Label != "" {
-			NewLabel().InsertOrUpdateMulti(m.Label)
+			if m.Label, err = NewBookLabel().Replace(m.BookId, m.Label); err != nil {
+				logs.Error("保存项目标签 => ", err)
+				return err
+			}
 		}
 		relationship := NewRelationship()
 		relationship.BookId = m.BookId
@@ -117,12 +124,10 @@ func (m *Book) Update(cols ...string) error {
 		return err
 	}
 
-	if (m.Label + temp.Label) != "" {
-
-		go NewLabel().InsertOrUpdateMulti(m.Label + "," + temp.Label)
-	}
-
 	_, err := o.Update(m, cols...)
+	if err == nil && m.Label != temp.Label && (len(cols) == 0 || strings.Contains(","+strings.Join(cols, ",")+",", ",label,")) {
+		m.Label, err = NewBookLabel().Replace(m.BookId, m.Label)
+	}
 	return err
 }
 
@@ -140,7 +145,7 @@ func (m *Book) FindByField(field string, value interface{}) ([]*Book, error) {
 func (m *Book) FindByFieldFirst(field string, value interface{}) (*Book, error) {
 	o := orm.NewOrm()
 
-	err := o.QueryTable(m.TableNameWithPrefix()).Filter(field, value).One(m)
+	err := o.QueryTable(m.TableNameWithPrefix()).Filter(field, value).Filter("status", 0).One(m)
 
 	return m, err
 
@@ -149,11 +154,16 @@ func (m *Book) FindByFieldFirst(field string, value interface{}) (*Book, error)
 func (m *Book) FindByIdentify(identify string) (*Book, error) {
 	o := orm.NewOrm()
 
-	err := o.QueryTable(m.TableNameWithPrefix()).Filter("identify", identify).One(m)
+	err := o.QueryTable(m.TableNameWithPrefix()).Filter("identify", identify).Filter("status", 0).One(m)
 
 	return m, err
 }
 
+//项目标识是否已被使用，包括已删除的项目.
+func (m *Book) ExistIdentify(identify string) bool {
+	return orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("identify", identify).Exist()
+}
+
 //分页查询指定用户的项目
 //按照最新的进行排序
 func (m *Book) FindToPager(pageIndex, pageSize, memberId int, PrivatelyOwned ...int) (books []*BookResult, totalCount int, err error) {
@@ -163,11 +173,11 @@ func (m *Book) FindToPager(pageIndex, pageSize, memberId int, PrivatelyOwned ...
 	o := orm.NewOrm()
 
 	sql1 := "SELECT COUNT(book.book_id) AS total_count FROM " + m.TableNameWithPrefix() + " AS book LEFT JOIN " +
-		relationship.TableNameWithPrefix() + " AS rel ON book.book_id=rel.book_id AND rel.member_id = ? WHERE rel.relationship_id > 0 "
+		relationship.TableNameWithPrefix() + " AS rel ON book.book_id=rel.book_id AND rel.member_id = ? WHERE (rel.relationship_id > 0 OR book.book_id IN (" + grantedBookIdSubQuery() + ")) AND book.status = 0 "
 	if len(PrivatelyOwned) > 0 {
 		sql1 = sql1 + " and book.privately_owned=" + strconv.Itoa(PrivatelyOwned[0])
 	}
-	err = o.Raw(sql1, memberId).QueryRow(&totalCount)
+	err = o.Raw(sql1, memberId, memberId, memberId).QueryRow(&totalCount)
 
 	if err != nil {
 		return
@@ -179,11 +189,13 @@ func (m *Book) FindToPager(pageIndex, pageSize, memberId int, PrivatelyOwned ...
 		" LEFT JOIN " + relationship.TableNameWithPrefix() + " AS rel ON book.book_id=rel.book_id AND rel.member_id = ?" +
 		" LEFT JOIN " + relationship.TableNameWithPrefix() + " AS rel1 ON book.book_id=rel1.book_id  AND rel1.role_id=0" +
 		" LEFT JOIN " + NewMember().TableNameWithPrefix() + " AS m ON rel1.member_id=m.member_id " +
-		" WHERE rel.relationship_id > 0 %v ORDER BY book.book_id DESC LIMIT " + fmt.Sprintf("%d,%d", offset, pageSize)
+		" WHERE (rel.relationship_id > 0 OR book.book_id IN (" + grantedBookIdSubQuery() + ")) AND book.status = 0 %v ORDER BY book.book_id DESC LIMIT " + fmt.Sprintf("%d,%d", offset, pageSize)
 	if len(PrivatelyOwned) > 0 {
 		sql2 = fmt.Sprintf(sql2, " and book.privately_owned="+strconv.Itoa(PrivatelyOwned[0]))
+	} else {
+		sql2 = fmt.Sprintf(sql2, "")
 	}
-	_, err = o.Raw(sql2, memberId).QueryRows(&books)
+	_, err = o.Raw(sql2, memberId, memberId, memberId).QueryRows(&books)
 	if err != nil {
 		logs.Error("分页查询项目列表 => ", err)
 		return
@@ -202,6 +214,10 @@ func (m *Book) FindToPager(pageIndex, pageSize, memberId int, PrivatelyOwned ...
 			if err1 == nil {
 				books[index].LastModifyText = text.Account + " 于 " + text.ModifyTime.Format("2006-01-02 15:04:05")
 			}
+			//综合直接授权和团队授权
+			if role_id, err := relationship.FindEffectiveRoleId(book.BookId, memberId); err == nil {
+				book.RoleId = role_id
+			}
 			if book.RoleId == 0 {
 				book.RoleName = "创始人"
 			} else if book.RoleId == 1 {
@@ -216,6 +232,17 @@ func (m *Book) FindToPager(pageIndex, pageSize, memberId int, PrivatelyOwned ...
 	return
 }
 
+//查询用户可以编辑的全部项目，包括直接授权以及通过团队或组织获得的授权.
+func (m *Book) FindEditableByMemberId(member_id int) (books []*Book, err error) {
+	sql := "SELECT book.* FROM " + m.TableNameWithPrefix() + " AS book WHERE book.status = 0 AND (book.book_id IN (SELECT rel.book_id FROM " +
+		NewRelationship().TableNameWithPrefix() + " AS rel WHERE rel.member_id = ? AND rel.role_id <= ?) OR book.book_id IN (" +
+		grantedBookIdSubQuery(conf.BookEditor) + ")) ORDER BY book.book_id DESC"
+
+	_, err = orm.NewOrm().Raw(sql, member_id, conf.BookEditor, member_id, member_id).QueryRows(&books)
+
+	return
+}
+
 // 彻底删除项目.
 func (m *Book) ThoroughDeleteBook(id int) error {
 	if id <= 0 {
@@ -235,6 +262,27 @@ func (m *Book) ThoroughDeleteBook(id int) error {
 		beego.Error(err)
 	}
 
+	//删除项目和项目中文档的标签
+	label_sql := "DELETE FROM " + NewDocumentLabel().TableNameWithPrefix() + " WHERE document_id IN (SELECT document_id FROM " + NewDocument().TableNameWithPrefix() + " WHERE book_id = ?)"
+	if _, err := o.Raw(label_sql, m.BookId).Exec(); err != nil {
+		beego.Error(err)
+	}
+	if _, err := o.QueryTable(NewBookLabel().TableNameWithPrefix()).Filter("book_id", m.BookId).Delete(); err != nil {
+		beego.Error(err)
+	}
+	//删除项目中文档的元数据
+	for _, table := range []string{NewDocumentMeta().TableNameWithPrefix(), NewDocumentMetaField().TableNameWithPrefix()} {
+		meta_sql := "DELETE FROM " + table + " WHERE document_id IN (SELECT document_id FROM " + NewDocument().TableNameWithPrefix() + " WHERE book_id = ?)"
+		if _, err := o.Raw(meta_sql, m.BookId).Exec(); err != nil {
+			beego.Error(err)
+		}
+	}
+	//删除项目中文档的链接关系
+	link_sql := "DELETE FROM " + NewDocumentLink().TableNameWithPrefix() + " WHERE document_id IN (SELECT document_id FROM " + NewDocument().TableNameWithPrefix() + " WHERE book_id = ?) OR target_id IN (SELECT document_id FROM " + NewDocument().TableNameWithPrefix() + " WHERE book_id = ?)"
+	if _, err := o.Raw(link_sql, m.BookId, m.BookId).Exec(); err != nil {
+		beego.Error(err)
+	}
+
 	sql2 := "DELETE FROM " + NewDocument().TableNameWithPrefix() + " WHERE book_id = ?"
 
 	_, err := o.Raw(sql2, m.BookId).Exec()
@@ -259,12 +307,100 @@ func (m *Book) ThoroughDeleteBook(id int) error {
 		o.Rollback()
 		return err
 	}
+	sql5 := "DELETE FROM " + NewTeamRelationship().TableNameWithPrefix() + " WHERE book_id = ?"
+
+	_, err = o.Raw(sql5, m.BookId).Exec()
+
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	sql6 := "DELETE FROM " + NewBookShare().TableNameWithPrefix() + " WHERE book_id = ?"
+
+	_, err = o.Raw(sql6, m.BookId).Exec()
+
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	sql7 := "DELETE FROM " + NewAnnotationReply().TableNameWithPrefix() + " WHERE book_id = ?"
+
+	_, err = o.Raw(sql7, m.BookId).Exec()
 
-	if m.Label != "" {
-		NewLabel().InsertOrUpdateMulti(m.Label)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	sql8 := "DELETE FROM " + NewAnnotation().TableNameWithPrefix() + " WHERE book_id = ?"
+
+	_, err = o.Raw(sql8, m.BookId).Exec()
+
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	sql9 := "DELETE FROM " + NewWatch().TableNameWithPrefix() + " WHERE book_id = ?"
+
+	_, err = o.Raw(sql9, m.BookId).Exec()
+
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	sql10 := "DELETE FROM " + NewDocumentReview().TableNameWithPrefix() + " WHERE book_id = ?"
+
+	_, err = o.Raw(sql10, m.BookId).Exec()
+
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	sql11 := "DELETE FROM " + NewBookReviewer().TableNameWithPrefix() + " WHERE book_id = ?"
+
+	_, err = o.Raw(sql11, m.BookId).Exec()
+
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	sql12 := "DELETE FROM " + NewBookVersionDocument().TableNameWithPrefix() + " WHERE book_id = ?"
+
+	_, err = o.Raw(sql12, m.BookId).Exec()
+
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	sql13 := "DELETE FROM " + NewBookVersion().TableNameWithPrefix() + " WHERE book_id = ?"
+
+	_, err = o.Raw(sql13, m.BookId).Exec()
+
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	//删除项目作为分支或源项目的记录
+	sql14 := "DELETE FROM " + NewBranchDocument().TableNameWithPrefix() + " WHERE book_id = ? OR source_book_id = ?"
+
+	_, err = o.Raw(sql14, m.BookId, m.BookId).Exec()
+
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	sql15 := "DELETE FROM " + NewBookBranch().TableNameWithPrefix() + " WHERE book_id = ? OR source_book_id = ?"
+
+	_, err = o.Raw(sql15, m.BookId, m.BookId).Exec()
+
+	if err != nil {
+		o.Rollback()
+		return err
 	}
 
 	if err = o.Commit(); err == nil {
+		if err := NewLabel().ResetNumber(); err != nil {
+			beego.Error("更新标签数量失败 => ", err)
+		}
 		//删除oss中项目对应的文件夹
 		switch utils.StoreType {
 		case utils.StoreLocal: //删除本地存储，记得加上uploads
@@ -283,9 +419,9 @@ func (m *Book) FindForHomeToPager(pageIndex, pageSize, member_id int) (books []*
 	offset := (pageIndex - 1) * pageSize
 	//如果是登录用户
 	if member_id > 0 {
-		sql1 := "SELECT COUNT(*) FROM md_books AS book LEFT JOIN md_relationship AS rel ON rel.book_id = book.book_id AND rel.member_id = ? WHERE relationship_id > 0 OR book.privately_owned = 0"
+		sql1 := "SELECT COUNT(*) FROM md_books AS book LEFT JOIN md_relationship AS rel ON rel.book_id = book.book_id AND rel.member_id = ? WHERE (relationship_id > 0 OR book.privately_owned = 0 OR book.book_id IN (" + grantedBookIdSubQuery() + ")) AND book.status = 0"
 
-		err = o.Raw(sql1, member_id).QueryRow(&totalCount)
+		err = o.Raw(sql1, member_id, member_id, member_id).QueryRow(&totalCount)
 		if err != nil {
 			return
 		}
@@ -293,14 +429,14 @@ func (m *Book) FindForHomeToPager(pageIndex, pageSize, member_id int) (books []*
 			LEFT JOIN md_relationship AS rel ON rel.book_id = book.book_id AND rel.member_id = ?
 			LEFT JOIN md_relationship AS rel1 ON rel1.book_id = book.book_id AND rel1.role_id = 0
 			LEFT JOIN md_members AS member ON rel1.member_id = member.member_id
-			WHERE rel.relationship_id > 0 OR book.privately_owned = 0 ORDER BY order_index DESC ,book.book_id DESC LIMIT ?,?`
+			WHERE (rel.relationship_id > 0 OR book.privately_owned = 0 OR book.book_id IN (` + grantedBookIdSubQuery() + `)) AND book.status = 0 ORDER BY order_index DESC ,book.book_id DESC LIMIT ?,?`
 
-		_, err = o.Raw(sql2, member_id, offset, pageSize).QueryRows(&books)
+		_, err = o.Raw(sql2, member_id, member_id, member_id, offset, pageSize).QueryRows(&books)
 
 		return
 
 	} else {
-		count, err1 := o.QueryTable(m.TableNameWithPrefix()).Filter("privately_owned", 0).Count()
+		count, err1 := o.QueryTable(m.TableNameWithPrefix()).Filter("privately_owned", 0).Filter("status", 0).Count()
 
 		if err1 != nil {
 			err = err1
@@ -311,7 +447,7 @@ func (m *Book) FindForHomeToPager(pageIndex, pageSize, member_id int) (books []*
 		sql := `SELECT book.*,rel.*,member.account AS create_name FROM md_books AS book
 			LEFT JOIN md_relationship AS rel ON rel.book_id = book.book_id AND rel.role_id = 0
 			LEFT JOIN md_members AS member ON rel.member_id = member.member_id
-			WHERE book.privately_owned = 0 ORDER BY order_index DESC ,book.book_id DESC LIMIT ?,?`
+			WHERE book.privately_owned = 0 AND book.status = 0 ORDER BY order_index DESC ,book.book_id DESC LIMIT ?,?`
 
 		_, err = o.Raw(sql, offset, pageSize).QueryRows(&books)
 
@@ -325,37 +461,39 @@ func (m *Book) FindForHomeToPager(pageIndex, pageSize, member_id int) (books []*
 func (m *Book) FindForLabelToPager(keyword string, pageIndex, pageSize, member_id int) (books []*BookResult, totalCount int, err error) {
 	o := orm.NewOrm()
 
+	label := keyword
 	keyword = "%" + keyword + "%"
 	offset := (pageIndex - 1) * pageSize
+	label_sql := labelBookIdSubQuery()
 	//如果是登录用户
 	if member_id > 0 {
-		sql1 := "SELECT COUNT(*) FROM md_books AS book LEFT JOIN md_relationship AS rel ON rel.book_id = book.book_id AND rel.member_id = ? WHERE (relationship_id > 0 OR book.privately_owned = 0) AND (book.label LIKE ? or book.book_name like ?) limit 1"
+		sql1 := "SELECT COUNT(*) FROM md_books AS book LEFT JOIN md_relationship AS rel ON rel.book_id = book.book_id AND rel.member_id = ? WHERE (relationship_id > 0 OR book.privately_owned = 0 OR book.book_id IN (" + grantedBookIdSubQuery() + ")) AND book.status = 0 AND (book.book_id IN (" + label_sql + ") or book.book_name like ?) limit 1"
 
-		if err = o.Raw(sql1, member_id, keyword, keyword).QueryRow(&totalCount); err != nil {
+		if err = o.Raw(sql1, member_id, member_id, member_id, label, keyword).QueryRow(&totalCount); err != nil {
 			return
 		}
 		sql2 := `SELECT book.*,rel1.*,member.account AS create_name FROM md_books AS book
 			LEFT JOIN md_relationship AS rel ON rel.book_id = book.book_id AND rel.member_id = ?
 			LEFT JOIN md_relationship AS rel1 ON rel1.book_id = book.book_id AND rel1.role_id = 0
 			LEFT JOIN md_members AS member ON rel1.member_id = member.member_id
-			WHERE (rel.relationship_id > 0 OR book.privately_owned = 0) AND  (book.label LIKE ? or book.book_name like ?) ORDER BY order_index DESC ,book.book_id DESC LIMIT ?,?`
+			WHERE (rel.relationship_id > 0 OR book.privately_owned = 0 OR book.book_id IN (` + grantedBookIdSubQuery() + `)) AND book.status = 0 AND (book.book_id IN (` + label_sql + `) or book.book_name like ?) ORDER BY order_index DESC ,book.book_id DESC LIMIT ?,?`
 
-		_, err = o.Raw(sql2, member_id, keyword, keyword, offset, pageSize).QueryRows(&books)
+		_, err = o.Raw(sql2, member_id, member_id, member_id, label, keyword, offset, pageSize).QueryRows(&books)
 
 		return
 
 	} else {
-		sql1 := "select COUNT(*) from md_books where privately_owned=0 and (label LIKE ? or book_name like ?) limit 1"
-		if err = o.Raw(sql1, keyword, keyword).QueryRow(&totalCount); err != nil {
+		sql1 := "select COUNT(*) from md_books where privately_owned=0 and status=0 and (book_id IN (" + label_sql + ") or book_name like ?) limit 1"
+		if err = o.Raw(sql1, label, keyword).QueryRow(&totalCount); err != nil {
 			return
 		}
 
 		sql := `SELECT book.*,rel.*,member.account AS create_name FROM md_books AS book
 			LEFT JOIN md_relationship AS rel ON rel.book_id = book.book_id AND rel.role_id = 0
 			LEFT JOIN md_members AS member ON rel.member_id = member.member_id
-			WHERE book.privately_owned = 0 AND (book.label LIKE ? or book.book_name LIKE ?) ORDER BY order_index DESC ,book.book_id DESC LIMIT ?,?`
+			WHERE book.privately_owned = 0 AND book.status = 0 AND (book.book_id IN (` + label_sql + `) or book.book_name LIKE ?) ORDER BY order_index DESC ,book.book_id DESC LIMIT ?,?`
 
-		_, err = o.Raw(sql, keyword, keyword, offset, pageSize).QueryRows(&books)
+		_, err = o.Raw(sql, label, keyword, offset, pageSize).QueryRows(&books)
 
 		return
 
@@ -380,6 +518,7 @@ func (book *Book) ToBookResult() *BookResult {
 	m.ModifyTime = book.ModifyTime
 	m.Cover = book.Cover
 	m.MemberId = book.MemberId
+	m.OrgId = book.OrgId
 	m.Label = book.Label
 	m.Status = book.Status
 	m.Editor = book.Editor
@@ -390,6 +529,8 @@ func (book *Book) ToBookResult() *BookResult {
 	m.ScoreFloat = utils.ScoreFloat(book.Score)
 	m.CntScore = book.CntScore
 	m.CntComment = book.CntComment
+	m.EnableReview = book.EnableReview
+	m.IsTemplate = book.IsTemplate
 
 	if book.Theme == "" {
 		m.Theme = "default"
@@ -0,0 +1,394 @@
+package models
+
+import (
+	"time"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/utils"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+//项目分支，复制源项目的全部文档，在分支中修改后逐个文档合并回源项目.
+type BookBranch struct {
+	BranchId     int       `orm:"pk;auto;column(branch_id)" json:"branch_id"`
+	BookId       int       `orm:"column(book_id);type(int);unique" json:"book_id"` //分支项目
+	SourceBookId int       `orm:"column(source_book_id);type(int);index" json:"source_book_id"`
+	MemberId     int       `orm:"column(member_id);type(int)" json:"member_id"`
+	CreateTime   time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+	BookName     string    `orm:"-" json:"book_name"`
+	Identify     string    `orm:"-" json:"identify"`
+	Account      string    `orm:"-" json:"account"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *BookBranch) TableName() string {
+	return "book_branches"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *BookBranch) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *BookBranch) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewBookBranch() *BookBranch {
+	return &BookBranch{}
+}
+
+//分支文档与源文档的对应关系，记录上次同步时双方的版本，用于合并时检测冲突.
+type BranchDocument struct {
+	Id            int       `orm:"pk;auto;column(id)" json:"id"`
+	BookId        int       `orm:"column(book_id);type(int);index" json:"book_id"` //分支项目
+	DocumentId    int       `orm:"column(document_id);type(int);unique" json:"doc_id"`
+	SourceBookId  int       `orm:"column(source_book_id);type(int);index" json:"source_book_id"`
+	SourceDocId   int       `orm:"column(source_doc_id);type(int)" json:"source_doc_id"`
+	SourceVersion int64     `orm:"column(source_version);type(bigint);default(0)" json:"source_version"` //上次同步时源文档的版本
+	BranchVersion int64     `orm:"column(branch_version);type(bigint);default(0)" json:"branch_version"` //上次同步时分支文档的版本
+	MergeTime     time.Time `orm:"column(merge_time);type(datetime);null" json:"merge_time"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *BranchDocument) TableName() string {
+	return "branch_documents"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *BranchDocument) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *BranchDocument) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewBranchDocument() *BranchDocument {
+	return &BranchDocument{}
+}
+
+//删除文档的来源记录.
+func (m *BranchDocument) DeleteByDocumentId(doc_id int) error {
+	if doc_id <= 0 {
+		return nil
+	}
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("document_id", doc_id).Delete()
+	return err
+}
+
+//分支文档相对源项目的变更.
+type BranchChange struct {
+	DocumentId    int    `json:"doc_id"` //分支中的文档，0 表示分支中已删除
+	SourceDocId   int    `json:"source_doc_id"`
+	DocumentName  string `json:"doc_name"`
+	Status        string `json:"status"`
+	SourceChanged bool   `json:"source_changed"` //源文档在上次同步后被修改
+	Conflict      bool   `json:"conflict"`
+	Added         int    `json:"added"`
+	Removed       int    `json:"removed"`
+	SourceHistory int    `json:"source_history"` //源文档在上次同步后的历史记录数
+}
+
+//查询项目作为分支的来源信息.
+func (m *BookBranch) FindByBookId(book_id int) (*BookBranch, error) {
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).One(m)
+	return m, err
+}
+
+//查询从项目创建的全部分支.
+func (m *BookBranch) FindBySourceBookId(source_book_id int) (branches []*BookBranch, err error) {
+	_, err = orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("source_book_id", source_book_id).OrderBy("-branch_id").Limit(-1).All(&branches)
+	if err != nil {
+		return
+	}
+	for _, branch := range branches {
+		if book, err := NewBook().Find(branch.BookId); err == nil {
+			branch.BookName = book.BookName
+			branch.Identify = book.Identify
+		}
+		branch.Account = NewMember().GetUsernameByUid(branch.MemberId)
+	}
+	return
+}
+
+//从源项目创建分支项目，复制全部文档并记录每个文档的来源.
+func (m *BookBranch) Create(source *Book, book *Book) error {
+	docs, err := NewDocument().FindListByBookId(source.BookId)
+	if err != nil {
+		return err
+	}
+	o := orm.NewOrm()
+	if err := o.Begin(); err != nil {
+		return err
+	}
+	book.DocCount = len(docs)
+	if _, err := o.Insert(book); err != nil {
+		o.Rollback()
+		return err
+	}
+	if _, err := o.Insert(&Relationship{BookId: book.BookId, MemberId: book.MemberId, RoleId: conf.BookFounder}); err != nil {
+		o.Rollback()
+		return err
+	}
+	exists := make(map[int]bool, len(docs))
+	for _, doc := range docs {
+		exists[doc.DocumentId] = true
+	}
+	//先复制上级文档，子文档挂到复制后的上级文档下
+	ids := make(map[int]int, len(docs))
+	for pending := docs; len(pending) > 0; {
+		next := make([]*Document, 0)
+		for _, doc := range pending {
+			parent_id, ok := ids[doc.ParentId]
+			if doc.ParentId > 0 && exists[doc.ParentId] && !ok {
+				next = append(next, doc)
+				continue
+			}
+			branch_doc := &Document{
+				DocumentName:   doc.DocumentName,
+				Identify:       doc.Identify,
+				BookId:         book.BookId,
+				ParentId:       parent_id,
+				OrderSort:      doc.OrderSort,
+				Release:        doc.Release,
+				MemberId:       book.MemberId,
+				ModifyAt:       book.MemberId,
+				Version:        doc.Version,
+				PublishStatus:  doc.PublishStatus,
+				PublishVersion: doc.PublishVersion,
+				PublishTime:    doc.PublishTime,
+			}
+			if _, err := o.Insert(branch_doc); err != nil {
+				o.Rollback()
+				return err
+			}
+			ids[doc.DocumentId] = branch_doc.DocumentId
+
+			var ds = DocumentStore{DocumentId: doc.DocumentId}
+			o.Read(&ds)
+			if _, err := o.Insert(&DocumentStore{DocumentId: branch_doc.DocumentId, Markdown: ds.Markdown, Content: ds.Content}); err != nil {
+				o.Rollback()
+				return err
+			}
+			lineage := &BranchDocument{
+				BookId:        book.BookId,
+				DocumentId:    branch_doc.DocumentId,
+				SourceBookId:  source.BookId,
+				SourceDocId:   doc.DocumentId,
+				SourceVersion: doc.Version,
+				BranchVersion: branch_doc.Version,
+			}
+			if _, err := o.Insert(lineage); err != nil {
+				o.Rollback()
+				return err
+			}
+		}
+		//上级文档形成循环时挂到根目录，避免死循环
+		if len(next) == len(pending) {
+			next[0].ParentId = 0
+		}
+		pending = next
+	}
+	m.BookId = book.BookId
+	m.SourceBookId = source.BookId
+	m.MemberId = book.MemberId
+	if _, err := o.Insert(m); err != nil {
+		o.Rollback()
+		return err
+	}
+	if err := o.Commit(); err != nil {
+		return err
+	}
+	if book.Label != "" {
+		if _, err := NewBookLabel().Replace(book.BookId, book.Label); err != nil {
+			beego.Error("保存分支项目标签失败 => ", err)
+		}
+	}
+	return nil
+}
+
+//查询分支中文档的来源记录.
+func (m *BookBranch) findLineage() map[int]*BranchDocument {
+	var rows []*BranchDocument
+	orm.NewOrm().QueryTable(NewBranchDocument().TableNameWithPrefix()).Filter("book_id", m.BookId).Limit(-1).All(&rows)
+	lineage := make(map[int]*BranchDocument, len(rows))
+	for _, row := range rows {
+		lineage[row.DocumentId] = row
+	}
+	return lineage
+}
+
+//比较分支与源项目，源文档和分支文档在上次同步后都被修改时视为冲突.
+func (m *BookBranch) Compare() ([]*BranchChange, error) {
+	docs, err := NewDocument().FindListByBookId(m.BookId)
+	if err != nil {
+		return nil, err
+	}
+	source_docs, err := NewDocument().FindListByBookId(m.SourceBookId)
+	if err != nil {
+		return nil, err
+	}
+	sources := make(map[int]*Document, len(source_docs))
+	for _, doc := range source_docs {
+		sources[doc.DocumentId] = doc
+	}
+	lineage := m.findLineage()
+	store := new(DocumentStore)
+	merged := make(map[int]bool)
+	changes := make([]*BranchChange, 0, len(docs))
+
+	for _, doc := range docs {
+		change := &BranchChange{DocumentId: doc.DocumentId, DocumentName: doc.DocumentName, Status: conf.BranchUnchanged}
+		changes = append(changes, change)
+
+		row, ok := lineage[doc.DocumentId]
+		var source *Document
+		if ok {
+			source = sources[row.SourceDocId]
+		}
+		if source == nil {
+			change.Status = conf.BranchAdded
+			change.Added, change.Removed = utils.LineDiffStat("", store.GetFiledById(doc.DocumentId, "markdown"))
+			continue
+		}
+		merged[source.DocumentId] = true
+		change.SourceDocId = source.DocumentId
+		change.SourceChanged = source.Version != row.SourceVersion
+		if doc.Version == row.BranchVersion && (doc.DocumentName == source.DocumentName || change.SourceChanged) {
+			continue
+		}
+		change.Status = conf.BranchModified
+		change.Conflict = change.SourceChanged
+		change.Added, change.Removed = utils.LineDiffStat(store.GetFiledById(source.DocumentId, "markdown"), store.GetFiledById(doc.DocumentId, "markdown"))
+		if change.SourceChanged {
+			count, _ := orm.NewOrm().QueryTable(NewDocumentHistory().TableNameWithPrefix()).
+				Filter("document_id", source.DocumentId).
+				Filter("version__gt", row.SourceVersion).Count()
+			change.SourceHistory = int(count)
+		}
+	}
+	//分支中删除的文档只做提示，不会合并删除源文档
+	for _, doc := range source_docs {
+		if !merged[doc.DocumentId] {
+			changes = append(changes, &BranchChange{SourceDocId: doc.DocumentId, DocumentName: doc.DocumentName, Status: conf.BranchDeleted})
+		}
+	}
+	return changes, nil
+}
+
+//将分支中的文档合并到源项目，源文档在上次同步后被修改时需要 force 才能覆盖.
+func (m *BookBranch) Merge(doc_id, member_id int, force bool) (*Document, error) {
+	doc, err := NewDocument().Find(doc_id)
+	if err != nil || doc.BookId != m.BookId {
+		return nil, ErrDataNotExist
+	}
+	o := orm.NewOrm()
+	var ds = DocumentStore{DocumentId: doc.DocumentId}
+	o.Read(&ds)
+
+	lineage := m.findLineage()
+	row, ok := lineage[doc.DocumentId]
+	if !ok {
+		row = &BranchDocument{BookId: m.BookId, DocumentId: doc.DocumentId, SourceBookId: m.SourceBookId}
+	}
+	source := NewDocument()
+	if ok && row.SourceDocId > 0 {
+		if source, err = NewDocument().Find(row.SourceDocId); err != nil || source.BookId != m.SourceBookId {
+			source = NewDocument()
+		}
+	}
+	if source.DocumentId > 0 && source.Version != row.SourceVersion && !force {
+		return nil, ErrMergeConflict
+	}
+
+	if err := o.Begin(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if source.DocumentId > 0 {
+		//合并前保存源文档当前内容的历史
+		var old = DocumentStore{DocumentId: source.DocumentId}
+		o.Read(&old)
+		history := NewDocumentHistory()
+		history.DocumentId = source.DocumentId
+		history.Content = old.Content
+		history.Markdown = old.Markdown
+		history.DocumentName = source.DocumentName
+		history.ModifyAt = member_id
+		history.MemberId = source.MemberId
+		history.ParentId = source.ParentId
+		history.Version = now.Unix()
+		history.Action = conf.HistoryMerge
+		history.ActionName = historyActionNames[conf.HistoryMerge]
+		history.compress()
+		if _, err := o.Insert(history); err != nil {
+			o.Rollback()
+			return nil, err
+		}
+		source.DocumentName = doc.DocumentName
+		source.ModifyAt = member_id
+		source.Version = now.Unix()
+		if _, err := o.Update(source, "document_name", "modify_at", "modify_time", "version"); err != nil {
+			o.Rollback()
+			return nil, err
+		}
+		if _, err := o.Update(&DocumentStore{DocumentId: source.DocumentId, Markdown: ds.Markdown, Content: ds.Content}); err != nil {
+			o.Rollback()
+			return nil, err
+		}
+	} else {
+		//分支中新增的文档，上级文档需要先合并
+		parent_id := 0
+		if doc.ParentId > 0 {
+			parent, ok := lineage[doc.ParentId]
+			if !ok || parent.SourceDocId <= 0 || !o.QueryTable(source.TableNameWithPrefix()).Filter("document_id", parent.SourceDocId).Filter("book_id", m.SourceBookId).Exist() {
+				o.Rollback()
+				return nil, ErrParentNotMerged
+			}
+			parent_id = parent.SourceDocId
+		}
+		source.BookId = m.SourceBookId
+		source.ParentId = parent_id
+		source.DocumentName = doc.DocumentName
+		source.Identify = doc.Identify
+		source.OrderSort = doc.OrderSort
+		source.MemberId = member_id
+		source.ModifyAt = member_id
+		source.Version = now.Unix()
+		//源项目中已存在相同标识时不使用标识
+		if source.Identify != "" && o.QueryTable(source.TableNameWithPrefix()).Filter("book_id", m.SourceBookId).Filter("identify", source.Identify).Exist() {
+			source.Identify = ""
+		}
+		if _, err := o.Insert(source); err != nil {
+			o.Rollback()
+			return nil, err
+		}
+		if _, err := o.Insert(&DocumentStore{DocumentId: source.DocumentId, Markdown: ds.Markdown, Content: ds.Content}); err != nil {
+			o.Rollback()
+			return nil, err
+		}
+	}
+	row.SourceDocId = source.DocumentId
+	row.SourceVersion = source.Version
+	row.BranchVersion = doc.Version
+	row.MergeTime = now
+	if row.Id > 0 {
+		_, err = o.Update(row)
+	} else {
+		_, err = o.Insert(row)
+	}
+	if err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	if err := o.Commit(); err != nil {
+		return nil, err
+	}
+	NewBook().ResetDocumentNumber(m.SourceBookId)
+	if err := NewAnnotation().Reanchor(source.DocumentId, ds.Markdown); err != nil {
+		beego.Error("Annotation.Reanchor => ", err)
+	}
+	return source, nil
+}
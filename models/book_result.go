@@ -30,6 +30,7 @@ type BookResult struct {
 	Theme            string    `json:"theme"`
 	Label            string    `json:"label"`
 	MemberId         int       `json:"member_id"`
+	OrgId            int       `json:"org_id"`
 	Username         int       `json:"user_name"`
 	Editor           string    `json:"editor"`
 	RelationshipId   int       `json:"relationship_id"`
@@ -44,6 +45,8 @@ type BookResult struct {
 	ScoreFloat       string `json:"score_float"`
 	LastModifyText   string `json:"last_modify_text"`
 	IsDisplayComment bool   `json:"is_display_comment"`
+	EnableReview     int    `json:"enable_review"`
+	IsTemplate       int    `json:"is_template"`
 }
 
 func NewBookResult() *BookResult {
@@ -59,16 +62,44 @@ func (m *BookResult) FindByIdentify(identify string, member_id int) (*BookResult
 
 	book := NewBook()
 
-	err := o.QueryTable(book.TableNameWithPrefix()).Filter("identify", identify).One(book)
+	err := o.QueryTable(book.TableNameWithPrefix()).Filter("identify", identify).Filter("status", 0).One(book)
 
 	if err != nil {
 		return m, err
 	}
+	return m.findByBook(book, member_id)
+}
 
-	relationship := NewRelationship()
+// 根据项目ID查询项目以及指定用户权限的信息.
+func (m *BookResult) FindByBookId(book_id, member_id int) (*BookResult, error) {
+	if book_id <= 0 || member_id <= 0 {
+		return m, ErrInvalidParameter
+	}
+	book, err := NewBook().Find(book_id)
+	if err != nil {
+		return m, err
+	}
+	return m.findByBook(book, member_id)
+}
+
+func (m *BookResult) findByBook(book *Book, member_id int) (*BookResult, error) {
+	o := orm.NewOrm()
 
-	err = o.QueryTable(relationship.TableNameWithPrefix()).Filter("book_id", book.BookId).Filter("member_id", member_id).One(relationship)
+	relationship := NewRelationship()
 
+	err := o.QueryTable(relationship.TableNameWithPrefix()).Filter("book_id", book.BookId).Filter("member_id", member_id).One(relationship)
+
+	//通过团队或组织获得的角色高于直接授权时以其为准
+	if team_role_id, err2 := relationship.FindIndirectRoleId(book.BookId, member_id); err2 == nil {
+		if err == orm.ErrNoRows {
+			relationship.BookId = book.BookId
+			relationship.MemberId = member_id
+			relationship.RoleId = team_role_id
+			err = nil
+		} else if err == nil && team_role_id < relationship.RoleId {
+			relationship.RoleId = team_role_id
+		}
+	}
 	if err != nil {
 		return m, err
 	}
@@ -123,7 +154,7 @@ func (m *BookResult) FindToPager(pageIndex, pageSize int, private ...int) (books
 	if len(private) > 0 {
 		pri = private[0]
 	}
-	count, err := o.QueryTable(NewBook().TableNameWithPrefix()).Filter("privately_owned", pri).Count()
+	count, err := o.QueryTable(NewBook().TableNameWithPrefix()).Filter("privately_owned", pri).Filter("status", 0).Count()
 
 	if err != nil {
 		return
@@ -136,9 +167,9 @@ func (m *BookResult) FindToPager(pageIndex, pageSize int, private ...int) (books
 			LEFT JOIN md_relationship AS rel ON rel.book_id = book.book_id AND rel.role_id = 0
 			LEFT JOIN md_members AS m ON rel.member_id = m.member_id %v
 		ORDER BY book.order_index DESC ,book.book_id DESC  LIMIT ?,?`
-	condition := ""
+	condition := "where book.status=0"
 	if len(private) > 0 {
-		condition = "where book.privately_owned=" + strconv.Itoa(pri)
+		condition += " and book.privately_owned=" + strconv.Itoa(pri)
 	}
 	sql = fmt.Sprintf(sql, condition)
 	offset := (pageIndex - 1) * pageSize
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"time"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/astaxie/beego/orm"
+)
+
+//项目指定的审阅人，未指定时由项目创始人和管理员审阅.
+type BookReviewer struct {
+	ReviewerId int       `orm:"pk;auto;column(reviewer_id)" json:"reviewer_id"`
+	BookId     int       `orm:"column(book_id);type(int);index" json:"book_id"`
+	MemberId   int       `orm:"column(member_id);type(int);index" json:"member_id"`
+	CreateTime time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *BookReviewer) TableName() string {
+	return "book_reviewers"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *BookReviewer) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *BookReviewer) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+// 多字段唯一键
+func (m *BookReviewer) TableUnique() [][]string {
+	return [][]string{
+		[]string{"BookId", "MemberId"},
+	}
+}
+
+func NewBookReviewer() *BookReviewer {
+	return &BookReviewer{}
+}
+
+//查询项目指定的审阅人.
+func (m *BookReviewer) FindMemberIds(book_id int) ([]int, error) {
+	var reviewers []*BookReviewer
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).OrderBy("reviewer_id").Limit(-1).All(&reviewers)
+	member_ids := make([]int, 0, len(reviewers))
+	for _, reviewer := range reviewers {
+		member_ids = append(member_ids, reviewer.MemberId)
+	}
+	return member_ids, err
+}
+
+//查询项目指定的审阅人账号.
+func (m *BookReviewer) FindAccounts(book_id int) []string {
+	accounts := make([]string, 0)
+	member_ids, _ := m.FindMemberIds(book_id)
+	for _, member_id := range member_ids {
+		if member, err := NewMember().Find(member_id); err == nil {
+			accounts = append(accounts, member.Account)
+		}
+	}
+	return accounts
+}
+
+//替换项目指定的审阅人.
+func (m *BookReviewer) Replace(book_id int, member_ids []int) error {
+	o := orm.NewOrm()
+	if err := o.Begin(); err != nil {
+		return err
+	}
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).Delete(); err != nil {
+		o.Rollback()
+		return err
+	}
+	exists := make(map[int]bool)
+	for _, member_id := range member_ids {
+		if exists[member_id] {
+			continue
+		}
+		exists[member_id] = true
+		if _, err := o.Insert(&BookReviewer{BookId: book_id, MemberId: member_id}); err != nil {
+			o.Rollback()
+			return err
+		}
+	}
+	return o.Commit()
+}
+
+//删除项目的审阅人.
+func (m *BookReviewer) DeleteByBookId(book_id int) error {
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).Delete()
+	return err
+}
+
+//查询可以审阅项目的用户：指定的审阅人中仍是项目参与者的用户，未指定时为项目创始人和管理员.
+func FindBookReviewerIds(book_id int) []int {
+	member_ids := make([]int, 0)
+	designated, err := NewBookReviewer().FindMemberIds(book_id)
+	if err == nil && len(designated) > 0 {
+		for _, member_id := range designated {
+			if _, err := NewRelationship().FindEffectiveRoleId(book_id, member_id); err == nil {
+				member_ids = append(member_ids, member_id)
+			}
+		}
+		return member_ids
+	}
+	var relationships []*Relationship
+	orm.NewOrm().QueryTable(NewRelationship().TableNameWithPrefix()).
+		Filter("book_id", book_id).
+		Filter("role_id__in", conf.BookFounder, conf.BookAdmin).Limit(-1).All(&relationships)
+	for _, relationship := range relationships {
+		member_ids = append(member_ids, relationship.MemberId)
+	}
+	return member_ids
+}
+
+//用户是否可以审阅项目的文档，超级管理员总是可以审阅.
+func CanReviewBook(book_id int, member *Member) bool {
+	if member.MemberId <= 0 {
+		return false
+	}
+	if member.IsAdministrator() {
+		return true
+	}
+	role_id, err := NewRelationship().FindEffectiveRoleId(book_id, member.MemberId)
+	if err != nil {
+		return false
+	}
+	designated, err := NewBookReviewer().FindMemberIds(book_id)
+	if err == nil && len(designated) > 0 {
+		for _, member_id := range designated {
+			if member_id == member.MemberId {
+				return true
+			}
+		}
+		return false
+	}
+	return role_id == conf.BookFounder || role_id == conf.BookAdmin
+}
@@ -0,0 +1,223 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/utils"
+	"github.com/astaxie/beego/orm"
+)
+
+//项目分享链接.
+type BookShare struct {
+	ShareId      int       `orm:"pk;auto;column(share_id)" json:"share_id"`
+	BookId       int       `orm:"column(book_id);type(int);index" json:"book_id"`
+	ShareName    string    `orm:"column(share_name);size(100)" json:"share_name"`
+	Token        string    `orm:"column(token);size(100);unique" json:"token"`
+	Password     string    `orm:"column(password);size(1000)" json:"-"`                    //访问密码，为空表示不需要密码
+	DocumentId   int       `orm:"column(document_id);type(int);default(0)" json:"doc_id"`   //只分享该文档及其子文档，0 表示整个项目
+	ExpireTime   time.Time `orm:"column(expire_time);type(datetime);null" json:"expire_time"` //过期时间，为空表示永不过期
+	ViewCount    int       `orm:"column(view_count);type(int);default(0)" json:"view_count"`
+	Revoked      int       `orm:"column(revoked);type(int);default(0)" json:"revoked"` //是否已撤销：0 否/1 是
+	MemberId     int       `orm:"column(member_id);type(int)" json:"member_id"`
+	CreateTime   time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+	HasPassword  bool      `orm:"-" json:"has_password"`
+	DocumentName string    `orm:"-" json:"doc_name"`
+	CreateName   string    `orm:"-" json:"create_name"`
+	Status       string    `orm:"-" json:"status"`
+	ShareUrl     string    `orm:"-" json:"share_url"`
+	ExpireText   string    `orm:"-" json:"expire_text"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *BookShare) TableName() string {
+	return "book_share"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *BookShare) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *BookShare) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewBookShare() *BookShare {
+	return &BookShare{}
+}
+
+//创建分享链接，password 为明文密码.
+func (m *BookShare) Insert(password string) error {
+	m.ShareName = strings.TrimSpace(m.ShareName)
+	if m.ShareName == "" {
+		return errors.New("分享名称不能为空")
+	}
+	if m.BookId <= 0 {
+		return ErrInvalidParameter
+	}
+	o := orm.NewOrm()
+	if m.DocumentId > 0 {
+		doc, err := NewDocument().Find(m.DocumentId)
+		if err != nil || doc.BookId != m.BookId {
+			return errors.New("分享的文档不存在")
+		}
+	}
+	if !m.ExpireTime.IsZero() && m.ExpireTime.Before(time.Now()) {
+		return errors.New("过期时间不能早于当前时间")
+	}
+	if password != "" {
+		hash, err := utils.PasswordHash(password)
+		if err != nil {
+			return err
+		}
+		m.Password = hash
+	}
+	m.Token = string(utils.Krand(conf.GetTokenSize(), utils.KC_RAND_KIND_ALL))
+	m.Revoked = 0
+	m.ViewCount = 0
+
+	_, err := o.Insert(m)
+	return err
+}
+
+func (m *BookShare) Find(id int) (*BookShare, error) {
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("share_id", id).One(m)
+	return m, err
+}
+
+//根据令牌查询分享链接.
+func (m *BookShare) FindByToken(token string) (*BookShare, error) {
+	if token == "" {
+		return m, orm.ErrNoRows
+	}
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("token", token).One(m)
+	return m, err
+}
+
+//查询项目的全部分享链接.
+func (m *BookShare) FindByBookId(book_id int) (shares []*BookShare, err error) {
+	_, err = orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).OrderBy("-share_id").All(&shares)
+	if err != nil {
+		return
+	}
+	for _, share := range shares {
+		share.resolve()
+	}
+	return
+}
+
+//撤销分享链接，撤销后链接立即失效.
+func (m *BookShare) Revoke(book_id, share_id int) error {
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).Filter("share_id", share_id).Update(orm.Params{"revoked": 1})
+	return err
+}
+
+func (m *BookShare) Delete(book_id, share_id int) error {
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).Filter("share_id", share_id).Delete()
+	return err
+}
+
+//访问次数+1.
+func (m *BookShare) IncrViewCount() error {
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("share_id", m.ShareId).Update(orm.Params{"view_count": orm.ColValue(orm.ColAdd, 1)})
+	if err == nil {
+		m.ViewCount++
+	}
+	return err
+}
+
+//分享链接是否已过期.
+func (m *BookShare) IsExpired() bool {
+	return !m.ExpireTime.IsZero() && m.ExpireTime.Before(time.Now())
+}
+
+//分享链接是否可用.
+func (m *BookShare) IsValid() bool {
+	return m.ShareId > 0 && m.Revoked == 0 && !m.IsExpired()
+}
+
+//校验访问密码.
+func (m *BookShare) CheckPassword(password string) bool {
+	if m.Password == "" {
+		return true
+	}
+	ok, err := utils.PasswordVerify(m.Password, password)
+	return err == nil && ok
+}
+
+func (m *BookShare) resolve() *BookShare {
+	m.HasPassword = m.Password != ""
+	if m.Revoked == 1 {
+		m.Status = "已撤销"
+	} else if m.IsExpired() {
+		m.Status = "已过期"
+	} else {
+		m.Status = "有效"
+	}
+	if m.ExpireTime.IsZero() {
+		m.ExpireText = "永不过期"
+	} else {
+		m.ExpireText = m.ExpireTime.Local().Format("2006-01-02 15:04")
+	}
+	if m.DocumentId > 0 {
+		if doc, err := NewDocument().Find(m.DocumentId); err == nil {
+			m.DocumentName = doc.DocumentName
+		} else {
+			m.DocumentName = "文档已删除"
+		}
+	}
+	if member, err := NewMember().Find(m.MemberId); err == nil {
+		m.CreateName = member.Account
+	}
+	return m
+}
+
+//分享链接访问密码的错误尝试，用于限制同一来源猜测密码.
+type BookShareAttempt struct {
+	AttemptId  int       `orm:"pk;auto;column(attempt_id)" json:"attempt_id"`
+	ShareId    int       `orm:"column(share_id);type(int);index" json:"share_id"`
+	Ip         string    `orm:"column(ip);size(100)" json:"ip"`
+	CreateTime time.Time `orm:"column(create_time);type(datetime);auto_now_add;index" json:"create_time"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *BookShareAttempt) TableName() string {
+	return "share_attempts"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *BookShareAttempt) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *BookShareAttempt) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewBookShareAttempt() *BookShareAttempt {
+	return &BookShareAttempt{}
+}
+
+//记录一次密码错误，同时清理一小时之前的记录.
+func (m *BookShareAttempt) Insert(share_id int, ip string) error {
+	o := orm.NewOrm()
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("create_time__lt", time.Now().Add(-1*time.Hour)).Delete(); err != nil {
+		return err
+	}
+	m.ShareId = share_id
+	m.Ip = ip
+	_, err := o.Insert(m)
+	return err
+}
+
+//查询指定来源在一小时内对分享链接的密码错误次数.
+func (m *BookShareAttempt) FindFailCount(share_id int, ip string) (int, error) {
+	c, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).
+		Filter("share_id", share_id).
+		Filter("ip", ip).
+		Filter("create_time__gte", time.Now().Add(-1*time.Hour)).Count()
+	return int(c), err
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/astaxie/beego/orm"
+)
+
+//克隆项目的选项.
+type BookCloneOption struct {
+	WithContent bool //复制文档内容、附件和历史，否则只复制目录结构
+	WithMembers bool //复制项目成员和团队授权
+}
+
+//查询用户可以使用的项目模板，member_id 为 0 时查询全部模板.
+func (m *Book) FindTemplates(member_id int) (books []*Book, err error) {
+	o := orm.NewOrm()
+
+	if member_id <= 0 {
+		_, err = o.QueryTable(m.TableNameWithPrefix()).Filter("is_template", 1).Filter("status", 0).OrderBy("-book_id").Limit(-1).All(&books)
+		return
+	}
+	sql := "SELECT book.* FROM " + m.TableNameWithPrefix() + " AS book LEFT JOIN " + NewRelationship().TableNameWithPrefix() +
+		" AS rel ON rel.book_id = book.book_id AND rel.member_id = ?" +
+		" WHERE book.is_template = 1 AND book.status = 0 AND (rel.relationship_id > 0 OR book.privately_owned = 0 OR book.book_id IN (" + grantedBookIdSubQuery() + "))" +
+		" ORDER BY book.book_id DESC"
+
+	_, err = o.Raw(sql, member_id, member_id, member_id).QueryRows(&books)
+	return
+}
+
+//以源项目为起点创建新项目，复制编辑器、主题、评论设置和标签，复制文档并可选复制成员.
+//新项目的名称、标识、创建人等由调用方设置，复制失败时删除已创建的项目.
+func (m *Book) InsertFromTemplate(source *Book, option BookCloneOption) (*TransferResult, error) {
+	m.Editor = source.Editor
+	m.Theme = source.Theme
+	m.CommentStatus = source.CommentStatus
+	m.Label = source.Label
+	m.EnableReview = source.EnableReview
+	if m.Description == "" {
+		m.Description = source.Description
+	}
+
+	o := orm.NewOrm()
+	if _, err := o.Insert(m); err != nil {
+		return nil, err
+	}
+	var err error
+	if m.Label != "" {
+		m.Label, err = NewBookLabel().Replace(m.BookId, m.Label)
+	}
+	relationship := NewRelationship()
+	relationship.BookId = m.BookId
+	relationship.RoleId = conf.BookFounder
+	relationship.MemberId = m.MemberId
+
+	if err == nil {
+		err = relationship.Insert()
+	}
+	if err == nil && option.WithMembers {
+		err = m.copyMembers(source)
+	}
+	var result *TransferResult
+	if err == nil {
+		transfer := NewDocumentTransfer(source, m)
+		transfer.MemberId = m.MemberId
+		result, err = transfer.CopyBook(option.WithContent)
+	}
+	if err != nil {
+		NewBook().ThoroughDeleteBook(m.BookId)
+		return nil, err
+	}
+	m.DocCount = result.DocCount
+	return result, nil
+}
+
+//复制源项目的成员和团队授权，源项目的创始人在新项目中为管理员.
+func (m *Book) copyMembers(source *Book) error {
+	o := orm.NewOrm()
+
+	var relationships []*Relationship
+	if _, err := o.QueryTable(NewRelationship().TableNameWithPrefix()).Filter("book_id", source.BookId).Exclude("member_id", m.MemberId).Limit(-1).All(&relationships); err != nil {
+		return err
+	}
+	for _, relationship := range relationships {
+		copied := NewRelationship()
+		copied.BookId = m.BookId
+		copied.MemberId = relationship.MemberId
+		copied.RoleId = relationship.RoleId
+		if copied.RoleId == conf.BookFounder {
+			copied.RoleId = conf.BookAdmin
+		}
+		if err := copied.Insert(); err != nil {
+			return err
+		}
+	}
+	var teams []*TeamRelationship
+	if _, err := o.QueryTable(NewTeamRelationship().TableNameWithPrefix()).Filter("book_id", source.BookId).Limit(-1).All(&teams); err != nil {
+		return err
+	}
+	for _, team := range teams {
+		if _, err := o.Insert(&TeamRelationship{TeamId: team.TeamId, BookId: m.BookId, RoleId: team.RoleId}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
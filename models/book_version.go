@@ -0,0 +1,310 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/utils"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+//正在生成下载文档的版本.
+var versionGenerating sync.Map
+
+//项目版本，创建时保存项目已发布内容和目录结构的快照，之后不再修改.
+type BookVersion struct {
+	VersionId    int       `orm:"pk;auto;column(version_id)" json:"version_id"`
+	BookId       int       `orm:"column(book_id);type(int);index" json:"book_id"`
+	VersionName  string    `orm:"column(version_name);size(100)" json:"version_name"`
+	Description  string    `orm:"column(description);size(500)" json:"description"`
+	DocCount     int       `orm:"column(doc_count);type(int);default(0)" json:"doc_count"`
+	MemberId     int       `orm:"column(member_id);type(int)" json:"member_id"`
+	CreateTime   time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+	GenerateTime time.Time `orm:"column(generate_time);type(datetime);null" json:"generate_time"` //下载文档生成时间
+	Account      string    `orm:"-" json:"account"`
+	Generating   bool      `orm:"-" json:"generating"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *BookVersion) TableName() string {
+	return "book_versions"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *BookVersion) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *BookVersion) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+// 多字段唯一键
+func (m *BookVersion) TableUnique() [][]string {
+	return [][]string{
+		[]string{"BookId", "VersionName"},
+	}
+}
+
+func NewBookVersion() *BookVersion {
+	return &BookVersion{}
+}
+
+//项目版本中的文档快照.
+type BookVersionDocument struct {
+	Id           int    `orm:"pk;auto;column(id)" json:"id"`
+	VersionId    int    `orm:"column(version_id);type(int);index" json:"version_id"`
+	BookId       int    `orm:"column(book_id);type(int);index" json:"book_id"`
+	DocumentId   int    `orm:"column(document_id);type(int)" json:"doc_id"`
+	ParentId     int    `orm:"column(parent_id);type(int);default(0)" json:"parent_id"`
+	DocumentName string `orm:"column(document_name);size(500)" json:"doc_name"`
+	Identify     string `orm:"column(identify);size(100);null;default(null)" json:"identify"`
+	OrderSort    int    `orm:"column(order_sort);type(int);default(0)" json:"order_sort"`
+	Release      string `orm:"column(release);type(text);null" json:"release"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *BookVersionDocument) TableName() string {
+	return "book_version_documents"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *BookVersionDocument) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *BookVersionDocument) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+// 多字段唯一键
+func (m *BookVersionDocument) TableUnique() [][]string {
+	return [][]string{
+		[]string{"VersionId", "DocumentId"},
+	}
+}
+
+func NewBookVersionDocument() *BookVersionDocument {
+	return &BookVersionDocument{}
+}
+
+func (m *BookVersion) Find(version_id int) (*BookVersion, error) {
+	if version_id <= 0 {
+		return m, ErrInvalidParameter
+	}
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("version_id", version_id).One(m)
+	return m, err
+}
+
+//查询项目的全部版本，最新创建的在前.
+func (m *BookVersion) FindByBookId(book_id int) (versions []*BookVersion, err error) {
+	_, err = orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).OrderBy("-version_id").Limit(-1).All(&versions)
+	if err != nil {
+		return
+	}
+	for _, version := range versions {
+		version.Account = NewMember().GetUsernameByUid(version.MemberId)
+		version.Generating = version.IsGenerating()
+	}
+	return
+}
+
+//创建项目版本，保存项目中全部已发布文档的发布内容.
+func (m *BookVersion) Create(book *Book) error {
+	m.VersionName = strings.TrimSpace(m.VersionName)
+	if m.VersionName == "" {
+		return errors.New("版本名称不能为空")
+	}
+	if strings.Count(m.VersionName, "") > 100 {
+		return errors.New("版本名称不能超过100字")
+	}
+	if strings.Count(m.Description, "") > 500 {
+		return errors.New("版本描述不能超过500字")
+	}
+	o := orm.NewOrm()
+	if o.QueryTable(m.TableNameWithPrefix()).Filter("book_id", book.BookId).Filter("version_name", m.VersionName).Exist() {
+		return errors.New("版本名称已存在")
+	}
+	docs, err := NewDocument().FindListByBookId(book.BookId)
+	if err != nil {
+		return err
+	}
+	//只保存已发布的文档，文档权限在阅读时校验
+	docs = NewDocumentAccess(book.BookId, 0, conf.BookFounder, true).PublishedOnly().FilterDocuments(docs)
+	if len(docs) == 0 {
+		return errors.New("项目中没有已发布的文档")
+	}
+	if err := o.Begin(); err != nil {
+		return err
+	}
+	m.BookId = book.BookId
+	m.DocCount = len(docs)
+	if _, err := o.Insert(m); err != nil {
+		o.Rollback()
+		return err
+	}
+	snapshots := make([]*BookVersionDocument, 0, len(docs))
+	for _, doc := range docs {
+		snapshots = append(snapshots, &BookVersionDocument{
+			VersionId:    m.VersionId,
+			BookId:       book.BookId,
+			DocumentId:   doc.DocumentId,
+			ParentId:     doc.ParentId,
+			DocumentName: doc.DocumentName,
+			Identify:     doc.Identify,
+			OrderSort:    doc.OrderSort,
+			Release:      doc.Release,
+		})
+	}
+	if _, err := o.InsertMulti(100, snapshots); err != nil {
+		o.Rollback()
+		return err
+	}
+	return o.Commit()
+}
+
+//删除项目版本及其下载文档.
+func (m *BookVersion) Delete(book *Book) error {
+	o := orm.NewOrm()
+	if err := o.Begin(); err != nil {
+		return err
+	}
+	if _, err := o.QueryTable(NewBookVersionDocument().TableNameWithPrefix()).Filter("version_id", m.VersionId).Delete(); err != nil {
+		o.Rollback()
+		return err
+	}
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("version_id", m.VersionId).Delete(); err != nil {
+		o.Rollback()
+		return err
+	}
+	if err := o.Commit(); err != nil {
+		return err
+	}
+	object := m.ExportObject(book.Identify)
+	switch utils.StoreType {
+	case utils.StoreOss:
+		if err := ModelStoreOss.DelFromOss(object+".pdf", object+".epub", object+".mobi"); err != nil {
+			beego.Error(err)
+		}
+	case utils.StoreLocal:
+		if err := ModelStoreLocal.DelFiles("uploads/"+object+".pdf", "uploads/"+object+".epub", "uploads/"+object+".mobi"); err != nil {
+			beego.Error(err)
+		}
+	}
+	return nil
+}
+
+//查询版本中的文档，with_release 为 false 时不查询发布内容.
+func (m *BookVersion) FindDocuments(with_release bool) (docs []*BookVersionDocument, err error) {
+	cols := []string{"id", "version_id", "book_id", "document_id", "parent_id", "document_name", "identify", "order_sort"}
+	if with_release {
+		cols = append(cols, "release")
+	}
+	_, err = orm.NewOrm().QueryTable(NewBookVersionDocument().TableNameWithPrefix()).
+		Filter("version_id", m.VersionId).
+		OrderBy("order_sort", "identify").Limit(-1).All(&docs, cols...)
+	return
+}
+
+//根据文档ID或文档标识查询版本中的文档.
+func (m *BookVersion) FindDocument(id string) (*BookVersionDocument, error) {
+	doc := NewBookVersionDocument()
+	qs := orm.NewOrm().QueryTable(doc.TableNameWithPrefix()).Filter("version_id", m.VersionId)
+	if doc_id, err := strconv.Atoi(id); err == nil {
+		qs = qs.Filter("document_id", doc_id)
+	} else {
+		qs = qs.Filter("identify", id)
+	}
+	err := qs.One(doc)
+	return doc, err
+}
+
+//版本中文档的层级关系，用于校验文档权限.
+func VersionDocumentParents(docs []*BookVersionDocument) map[int]int {
+	parents := make(map[int]int, len(docs))
+	for _, doc := range docs {
+		parents[doc.DocumentId] = doc.ParentId
+	}
+	return parents
+}
+
+//版本中的一级目录.
+func VersionMenuTop(docs []*BookVersionDocument) []*Document {
+	menu := make([]*Document, 0)
+	for _, doc := range docs {
+		if doc.ParentId == 0 {
+			menu = append(menu, &Document{DocumentId: doc.DocumentId, DocumentName: doc.DocumentName, Identify: doc.Identify, BookId: doc.BookId})
+		}
+	}
+	return menu
+}
+
+//生成版本的阅读目录.
+func (m *BookVersion) CreateDocumentTreeForHtml(docs []*BookVersionDocument, book_identify string, selected_id int, access *DocumentAccess) string {
+	trees := make([]*DocumentTree, 0, len(docs))
+	for _, doc := range docs {
+		tree := &DocumentTree{
+			DocumentId:   doc.DocumentId,
+			DocumentName: doc.DocumentName,
+			ParentId:     "#",
+			Identify:     doc.Identify,
+			BookIdentify: book_identify,
+			VersionId:    m.VersionId,
+		}
+		if doc.ParentId > 0 {
+			tree.ParentId = doc.ParentId
+		}
+		trees = append(trees, tree)
+	}
+	if access != nil {
+		trees = access.FilterTree(trees)
+	}
+	return documentTreeHtml(trees, selected_id)
+}
+
+//版本下载文档的存储路径，不包含扩展名.
+func (m *BookVersion) ExportObject(book_identify string) string {
+	return fmt.Sprintf("projects/%v/versions/%v", book_identify, m.VersionId)
+}
+
+//是否正在生成版本的下载文档.
+func (m *BookVersion) IsGenerating() bool {
+	_, ok := versionGenerating.Load(m.VersionId)
+	return ok
+}
+
+//生成版本的下载文档，版本内容不会变化，生成一次即可.
+func (m *BookVersion) Generate(book *Book, base_url string) {
+	if _, loaded := versionGenerating.LoadOrStore(m.VersionId, true); loaded {
+		return
+	}
+	defer versionGenerating.Delete(m.VersionId)
+
+	docs, err := m.FindDocuments(true)
+	if err != nil {
+		beego.Error("BookVersion.FindDocuments => ", err)
+		return
+	}
+	//下载文档对所有人公开，排除设置了权限限制的文档
+	access := NewDocumentAccess(book.BookId, 0, -1, false).UseParents(VersionDocumentParents(docs))
+	list := make([]*Document, 0, len(docs))
+	for _, doc := range docs {
+		if access.CanRead(doc.DocumentId) {
+			list = append(list, &Document{DocumentId: doc.DocumentId, ParentId: doc.ParentId, DocumentName: doc.DocumentName, BookId: doc.BookId, Release: doc.Release})
+		}
+	}
+	export := *book
+	export.BookName = book.BookName + " " + m.VersionName
+	exportBook(&export, list, base_url, m.CreateTime, fmt.Sprintf("cache/versions/%v/", m.VersionId), m.ExportObject(book.Identify))
+
+	m.GenerateTime = time.Now()
+	if _, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("version_id", m.VersionId).Update(orm.Params{"generate_time": m.GenerateTime}); err != nil {
+		beego.Error(err)
+	}
+}
@@ -2,9 +2,13 @@ package models
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/JermineHu/DocStack/conf"
+	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/orm"
 )
 
@@ -12,32 +16,35 @@ import (
 type Comment struct {
 	CommentId int `orm:"pk;auto;unique;column(comment_id)" json:"comment_id"`
 	Floor     int `orm:"column(floor);type(unsigned);default(0)" json:"floor"`
-	BookId    int `orm:"column(book_id);type(int)" json:"book_id"`
+	BookId    int `orm:"column(book_id);type(int);index" json:"book_id"`
 	// DocumentId 评论所属的文档.
-	DocumentId int `orm:"column(document_id);type(int)" json:"document_id"`
+	DocumentId int `orm:"column(document_id);type(int);index" json:"document_id"`
 	// Author 评论作者.
 	Author string `orm:"column(author);size(100)" json:"author"`
 	//MemberId 评论用户ID.
-	MemberId int `orm:"column(member_id);type(int)" json:"member_id"`
+	MemberId int `orm:"column(member_id);type(int);index" json:"member_id"`
 	// IPAddress 评论者的IP地址
-	IPAddress string `orm:"column(ip_address);size(100)" json:"ip_address"`
+	IPAddress string `orm:"column(ip_address);size(100)" json:"-"`
 	// 评论日期.
 	CommentDate time.Time `orm:"type(datetime);column(comment_date);auto_now_add" json:"comment_date"`
 	//Content 评论内容.
 	Content string `orm:"column(content);size(2000)" json:"content"`
 	// Approved 评论状态：0 待审核/1 已审核/2 垃圾评论/ 3 已删除
-	Approved int `orm:"column(approved);type(int)" json:"approved"`
+	Approved int `orm:"column(approved);type(int);index" json:"approved"`
 	// UserAgent 评论者浏览器内容
-	UserAgent string `orm:"column(user_agent);size(500)" json:"user_agent"`
+	UserAgent string `orm:"column(user_agent);size(500)" json:"-"`
 	// Parent 评论所属父级
-	ParentId     int `orm:"column(parent_id);type(int);default(0)" json:"parent_id"`
+	ParentId int `orm:"column(parent_id);type(int);default(0)" json:"parent_id"`
+	// RootId 回复所属的楼层评论，楼层评论为 0
+	RootId       int `orm:"column(root_id);type(int);default(0);index" json:"root_id"`
 	AgreeCount   int `orm:"column(agree_count);type(int);default(0)" json:"agree_count"`
 	AgainstCount int `orm:"column(against_count);type(int);default(0)" json:"against_count"`
 }
 
 // TableName 获取对应数据库表名.
+//项目评论已使用 comments 表，文档评论使用单独的表.
 func (m *Comment) TableName() string {
-	return "comments"
+	return "document_comments"
 }
 
 // TableEngine 获取数据使用的引擎.
@@ -57,6 +64,7 @@ func (m *Comment) Find(id int) (*Comment, error) {
 		return m, ErrInvalidParameter
 	}
 	o := orm.NewOrm()
+	m.CommentId = id
 	err := o.Read(m)
 
 	return m, err
@@ -75,46 +83,41 @@ func (m *Comment) Insert() error {
 	if m.DocumentId <= 0 {
 		return errors.New("评论文档不存在")
 	}
+	m.Content = strings.TrimSpace(m.Content)
 	if m.Content == "" {
 		return ErrCommentContentNotEmpty
 	}
+	if utf8.RuneCountInString(m.Content) > 2000 {
+		return errors.New("评论内容不能超过2000个字符")
+	}
 
 	o := orm.NewOrm()
 
-	if m.CommentId > 0 {
-		comment := NewComment()
-		//如果父评论不存在
-		if err := o.Read(comment); err != nil {
-			return err
-		}
-	}
-
 	document := NewDocument()
 	//如果评论的文档不存在
 	if _, err := document.Find(m.DocumentId); err != nil {
 		return err
 	}
+	m.RootId = 0
+	if m.ParentId > 0 {
+		parent := NewComment()
+		//如果父评论不存在
+		if _, err := parent.Find(m.ParentId); err != nil || parent.DocumentId != m.DocumentId || parent.Approved != conf.CommentApproved {
+			return errors.New("回复的评论不存在")
+		}
+		if parent.RootId > 0 {
+			m.RootId = parent.RootId
+		} else {
+			m.RootId = parent.CommentId
+		}
+	}
 	book, err := NewBook().Find(document.BookId)
 	//如果评论的项目不存在
 	if err != nil {
 		return err
 	}
-	//如果已关闭评论
-	if book.CommentStatus == "closed" {
-		return ErrCommentClosed
-	}
-	if book.CommentStatus == "registered_only" && m.MemberId <= 0 {
-		return ErrPermissionDenied
-	}
-	//如果仅参与者评论
-	if book.CommentStatus == "group_only" {
-		if m.MemberId <= 0 {
-			return ErrPermissionDenied
-		}
-		rel := NewRelationship()
-		if _, err := rel.FindForRoleId(book.BookId, m.MemberId); err != nil {
-			return ErrPermissionDenied
-		}
+	if err := CheckCommentStatus(book, m.MemberId); err != nil {
+		return err
 	}
 
 	if m.MemberId > 0 {
@@ -127,11 +130,187 @@ func (m *Comment) Insert() error {
 		if member.Status == 1 {
 			return ErrMemberDisabled
 		}
-	} else if m.Author == "" {
+		m.Author = member.Account
+	} else if m.Author = strings.TrimSpace(m.Author); m.Author == "" {
 		m.Author = "[匿名用户]"
 	}
+	if err := m.checkInterval(); err != nil {
+		return err
+	}
+	//楼层只对直接评论文档的评论计数
+	if m.RootId == 0 {
+		var last Comment
+		err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id", m.DocumentId).Filter("root_id", 0).OrderBy("-floor").One(&last, "floor")
+		if err != nil && err != orm.ErrNoRows {
+			return err
+		}
+		m.Floor = last.Floor + 1
+	} else {
+		m.Floor = 0
+	}
 	m.BookId = book.BookId
-	_, err = o.Insert(m)
+	m.AgreeCount = 0
+	m.AgainstCount = 0
+	if _, err = o.Insert(m); err != nil {
+		return err
+	}
+	if m.Approved == conf.CommentApproved {
+		m.Recount()
+	}
+	return nil
+}
 
-	return err
+//发送评论被回复和评论中提及用户的通知，只有已审核的评论才会通知.
+func (m *Comment) Notify() error {
+	if m.Approved != conf.CommentApproved {
+		return nil
+	}
+	doc, err := NewDocument().Find(m.DocumentId)
+	if err != nil {
+		return err
+	}
+	book, err := NewBook().Find(m.BookId)
+	if err != nil {
+		return err
+	}
+	notification := NewNotification()
+	notification.SenderId = m.MemberId
+	notification.BookId = book.BookId
+	notification.DocumentId = doc.DocumentId
+	notification.ObjectId = m.CommentId
+	notification.Url = NotificationDocumentUrl(book.Identify, doc) + "#comments"
+
+	exclude := make([]string, 0, 1)
+	if m.ParentId > 0 {
+		parent := NewComment()
+		if _, err := parent.Find(m.ParentId); err == nil && parent.MemberId > 0 {
+			notification.Type = conf.NotifyReply
+			notification.Title = fmt.Sprintf("%s 回复了你在《%s》中的评论：%s", m.Author, doc.DocumentName, NotificationSummary(m.Content))
+			if err := notification.Send([]int{parent.MemberId}); err != nil {
+				return err
+			}
+			exclude = append(exclude, parent.Author)
+		}
+	}
+	notification.Title = fmt.Sprintf("%s 在《%s》的评论中提到了你：%s", m.Author, doc.DocumentName, NotificationSummary(m.Content))
+	return notification.SendMentions(m.Content, false, exclude...)
+}
+
+//校验项目是否允许该用户评论.
+func CheckCommentStatus(book *Book, member_id int) error {
+	switch book.CommentStatus {
+	case "open":
+		return nil
+	case "registered_only":
+		if member_id <= 0 {
+			return ErrPermissionDenied
+		}
+		return nil
+	case "group_only":
+		//如果仅参与者评论
+		if member_id <= 0 {
+			return ErrPermissionDenied
+		}
+		if _, err := NewRelationship().FindEffectiveRoleId(book.BookId, member_id); err != nil {
+			return ErrPermissionDenied
+		}
+		return nil
+	}
+	//如果已关闭评论
+	return ErrCommentClosed
+}
+
+//检查评论间隔，登录用户按用户限制，匿名用户按IP限制.
+func (m *Comment) checkInterval() error {
+	second := beego.AppConfig.DefaultInt("CommentInterval", 10)
+	if second <= 0 {
+		return nil
+	}
+	qs := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("comment_date__gt", time.Now().Add(-time.Duration(second)*time.Second))
+	if m.MemberId > 0 {
+		qs = qs.Filter("member_id", m.MemberId)
+	} else {
+		qs = qs.Filter("member_id", 0).Filter("ip_address", m.IPAddress)
+	}
+	if qs.Exist() {
+		return fmt.Errorf("您距离上次发表评论时间小于 %v 秒，请歇会儿再发。", second)
+	}
+	return nil
+}
+
+//修改评论状态，并重新统计评论数量.
+func (m *Comment) SetApproved(approved int) error {
+	if approved < conf.CommentPending || approved > conf.CommentDeleted {
+		return ErrInvalidParameter
+	}
+	m.Approved = approved
+	if err := m.Update("approved"); err != nil {
+		return err
+	}
+	m.Recount()
+	return nil
+}
+
+//重新统计评论所在项目的评论数量.
+func (m *Comment) Recount() {
+	o := orm.NewOrm()
+	count, err := o.QueryTable(m.TableNameWithPrefix()).Filter("book_id", m.BookId).Filter("approved", conf.CommentApproved).Count()
+	if err != nil {
+		beego.Error("统计评论数量失败 => ", err)
+		return
+	}
+	if _, err := o.QueryTable(NewBook().TableNameWithPrefix()).Filter("book_id", m.BookId).Update(orm.Params{"comment_count": count}); err != nil {
+		beego.Error("更新评论数量失败 => ", err)
+	}
+}
+
+//查询文档已审核的评论数量.
+func (m *Comment) CountByDocumentId(doc_id int) int {
+	count, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("document_id", doc_id).Filter("approved", conf.CommentApproved).Count()
+	if err != nil {
+		return 0
+	}
+	return int(count)
+}
+
+//给评论投票，重复投相同的票表示取消投票.
+func (m *Comment) Vote(member_id, state int) (*Comment, error) {
+	if state != conf.CommentVoteAgree && state != conf.CommentVoteAgainst {
+		return m, ErrInvalidParameter
+	}
+	if m.Approved != conf.CommentApproved {
+		return m, errors.New("评论不存在")
+	}
+	if m.MemberId > 0 && m.MemberId == member_id {
+		return m, errors.New("不能给自己的评论投票")
+	}
+	o := orm.NewOrm()
+	vote := NewCommentVote()
+	err := o.QueryTable(vote.TableNameWithPrefix()).Filter("comment_id", m.CommentId).Filter("vote_member_id", member_id).One(vote)
+
+	if err == orm.ErrNoRows {
+		vote.CommentId = m.CommentId
+		vote.CommentMemberId = m.MemberId
+		vote.VoteMemberId = member_id
+		vote.VoteState = state
+		_, err = vote.InsertOrUpdate()
+	} else if err == nil {
+		if vote.VoteState == state {
+			_, err = o.Delete(vote)
+		} else {
+			vote.VoteState = state
+			_, err = vote.InsertOrUpdate()
+		}
+	}
+	if err != nil {
+		return m, err
+	}
+	qs := o.QueryTable(vote.TableNameWithPrefix()).Filter("comment_id", m.CommentId)
+	agree, _ := qs.Filter("vote_state", conf.CommentVoteAgree).Count()
+	against, _ := qs.Filter("vote_state", conf.CommentVoteAgainst).Count()
+	m.AgreeCount = int(agree)
+	m.AgainstCount = int(against)
+
+	err = m.Update("agree_count", "against_count")
+	return m, err
 }
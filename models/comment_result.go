@@ -1,41 +1,182 @@
 package models
 
-import "github.com/astaxie/beego/orm"
+import (
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/astaxie/beego/orm"
+)
 
 type CommentResult struct {
 	Comment
-	Author string		`json:"author"`
-	ReplyAccount string 	`json:"reply_account"`
+	Avatar       string           `json:"avatar"`
+	ReplyAuthor  string           `json:"reply_author"`
+	BookName     string           `json:"book_name,omitempty"`
+	Identify     string           `json:"identify,omitempty"`
+	DocumentName string           `json:"doc_name,omitempty"`
+	VoteState    int              `json:"vote_state"`
+	Replies      []*CommentResult `json:"replies"`
 }
 
-func (m *CommentResult) FindForDocumentToPager(doc_id, page_index,page_size int) (comments []*CommentResult,totalCount int,err error)  {
+//评论查询条件，BookId 为 0 表示不限制项目，Approved 为 -1 表示不限制状态.
+type CommentFilter struct {
+	BookId   int
+	Approved int
+	Keyword  string
+}
 
-	o := orm.NewOrm()
+func NewCommentResult() *CommentResult {
+	return &CommentResult{}
+}
 
-	sql1 := `
-SELECT
-  comment.* ,
-  parent.* ,
-  member.account AS author,
-  p_member.account AS reply_account
-FROM md_comments AS comment
-  LEFT JOIN md_members AS member ON comment.member_id = member.member_id
-  LEFT JOIN md_comments AS parent ON comment.parent_id = parent.comment_id
-  LEFT JOIN md_members AS p_member ON p_member.member_id = parent.member_id
+//分页查询文档的楼层评论及其回复，member_id 用户可以看到自己待审核的评论.
+func (m *CommentResult) FindForDocumentToPager(doc_id, member_id, page_index, page_size int) (comments []*CommentResult, totalCount int, err error) {
+	if page_index <= 0 {
+		page_index = 1
+	}
+	offset := (page_index - 1) * page_size
 
-WHERE comment.document_id = ? ORDER BY comment.comment_id DESC LIMIT 0,10`
+	qs := m.visible(doc_id, member_id).Filter("root_id", 0)
 
+	count, err := qs.Count()
+	if err != nil {
+		return
+	}
+	totalCount = int(count)
 
-	offset := (page_index - 1) * page_size
+	var roots []*Comment
+	if _, err = qs.OrderBy("-floor").Offset(offset).Limit(page_size).All(&roots); err != nil {
+		return
+	}
+	ids := make([]int, 0, len(roots))
+	for _, item := range roots {
+		ids = append(ids, item.CommentId)
+	}
+	var replies []*Comment
+	if len(ids) > 0 {
+		if _, err = m.visible(doc_id, member_id).Filter("root_id__in", ids).OrderBy("comment_id").Limit(-1).All(&replies); err != nil {
+			return
+		}
+	}
 
-	_,err = o.Raw(sql1,doc_id,offset, page_size).QueryRows(&comments)
+	results := resolveComments(append(roots, replies...), member_id)
+	index := make(map[int]*CommentResult, len(results))
+	for _, item := range results {
+		item.Replies = make([]*CommentResult, 0)
+		index[item.CommentId] = item
+	}
+	comments = make([]*CommentResult, 0, len(roots))
+	for _, item := range results {
+		if item.RootId == 0 {
+			comments = append(comments, item)
+		} else if root, ok := index[item.RootId]; ok {
+			if parent, ok := index[item.ParentId]; ok && parent.CommentId != root.CommentId {
+				item.ReplyAuthor = parent.Author
+			}
+			root.Replies = append(root.Replies, item)
+		}
+	}
+	return
+}
 
-	v,err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id",doc_id).Count()
+//文档中当前用户可见的评论.
+func (m *CommentResult) visible(doc_id, member_id int) orm.QuerySeter {
+	cond := orm.NewCondition().Or("approved", conf.CommentApproved)
+	if member_id > 0 {
+		cond = cond.OrCond(orm.NewCondition().And("member_id", member_id).And("approved", conf.CommentPending))
+	}
+	return orm.NewOrm().QueryTable(m.TableNameWithPrefix()).SetCond(orm.NewCondition().And("document_id", doc_id).AndCond(cond))
+}
 
-	if err == nil {
-		totalCount = int(v)
+//分页查询评论，用于后台审核.
+func (m *CommentResult) FindToPager(filter *CommentFilter, page_index, page_size int) (comments []*CommentResult, totalCount int, err error) {
+	if page_index <= 0 {
+		page_index = 1
 	}
+	offset := (page_index - 1) * page_size
+
+	qs := orm.NewOrm().QueryTable(m.TableNameWithPrefix())
+	if filter.BookId != 0 {
+		qs = qs.Filter("book_id", filter.BookId)
+	}
+	if filter.Approved >= 0 {
+		qs = qs.Filter("approved", filter.Approved)
+	}
+	if filter.Keyword != "" {
+		qs = qs.Filter("content__icontains", filter.Keyword)
+	}
+	count, err := qs.Count()
+	if err != nil {
+		return
+	}
+	totalCount = int(count)
+
+	var list []*Comment
+	if _, err = qs.OrderBy("-comment_id").Offset(offset).Limit(page_size).All(&list); err != nil {
+		return
+	}
+	comments = resolveComments(list, 0)
 
+	books := make(map[int]*Book)
+	for _, item := range comments {
+		book, ok := books[item.BookId]
+		if !ok {
+			if b, err := NewBook().Find(item.BookId); err == nil {
+				book = b
+			}
+			books[item.BookId] = book
+		}
+		if book != nil {
+			item.BookName = book.BookName
+			item.Identify = book.Identify
+		}
+		if doc, err := NewDocument().Find(item.DocumentId); err == nil {
+			item.DocumentName = doc.DocumentName
+		}
+	}
 	return
 }
 
+//填充评论的用户头像和当前用户的投票状态.
+func resolveComments(list []*Comment, member_id int) []*CommentResult {
+	results := make([]*CommentResult, 0, len(list))
+	if len(list) == 0 {
+		return results
+	}
+	ids := make([]int, 0, len(list))
+	members := make(map[int]*Member)
+	for _, item := range list {
+		ids = append(ids, item.CommentId)
+		result := &CommentResult{Comment: *item, Avatar: conf.GetDefaultAvatar()}
+		if item.MemberId > 0 {
+			member, ok := members[item.MemberId]
+			if !ok {
+				if found, err := NewMember().Find(item.MemberId); err == nil {
+					member = found
+				}
+				members[item.MemberId] = member
+			}
+			if member != nil {
+				if member.Nickname != "" {
+					result.Author = member.Nickname
+				}
+				if member.Avatar != "" {
+					result.Avatar = member.Avatar
+				}
+			}
+		}
+		results = append(results, result)
+	}
+	if member_id > 0 {
+		var votes []*CommentVote
+		vote := NewCommentVote()
+		orm.NewOrm().QueryTable(vote.TableNameWithPrefix()).Filter("vote_member_id", member_id).Filter("comment_id__in", ids).All(&votes)
+
+		states := make(map[int]int, len(votes))
+		for _, item := range votes {
+			states[item.CommentId] = item.VoteState
+		}
+		for _, item := range results {
+			item.VoteState = states[item.CommentId]
+		}
+	}
+	return results
+}
@@ -1,6 +1,9 @@
 package models
 
-import "github.com/astaxie/beego/orm"
+import (
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/astaxie/beego/orm"
+)
 
 type Dashboard struct {
 	BookNumber int64                `json:"book_number"`
@@ -27,8 +30,8 @@ func (m *Dashboard) Query() (*Dashboard) {
 	member_number,_ := o.QueryTable(NewMember().TableNameWithPrefix()).Count()
 	m.MemberNumber = member_number
 
-	//comment_number,_ := o.QueryTable(NewComment().TableNameWithPrefix()).Count()
-	m.CommentNumber = 0
+	comment_number,_ := o.QueryTable(NewComment().TableNameWithPrefix()).Filter("approved", conf.CommentApproved).Count()
+	m.CommentNumber = comment_number
 
 	attachment_number,_ := o.QueryTable(NewAttachment().TableNameWithPrefix()).Count()
 
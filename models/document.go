@@ -44,6 +44,11 @@ type Document struct {
 	AttachList []*Attachment `orm:"-" json:"attach"`
 	Vcnt       int           `orm:"column(vcnt);default(0)" json:"vcnt"` //文档项目被浏览次数
 	Markdown   string        `orm:"-" json:"markdown"`
+	//发布状态：0 草稿/1 已发布/2 已取消发布，已有文档升级后默认为已发布
+	PublishStatus  int       `orm:"column(publish_status);type(int);default(1)" json:"publish_status"`
+	PublishVersion int64     `orm:"column(publish_version);type(bigint);default(0)" json:"publish_version"` //已发布内容对应的草稿版本
+	PublishTime    time.Time `orm:"column(publish_time);type(datetime);null" json:"publish_time"`
+	ScheduleTime   time.Time `orm:"column(schedule_time);type(datetime);null" json:"schedule_time"` //定时发布时间
 }
 
 // 多字段唯一键
@@ -130,10 +135,19 @@ func (m *Document) RecursiveDocument(doc_id int) error {
 	o := orm.NewOrm()
 	modelStore := new(DocumentStore)
 
+	//文档历史保留删除记录，由历史清理任务删除
 	if doc, err := m.Find(doc_id); err == nil {
 		o.Delete(doc)
 		modelStore.DeleteById(doc_id)
-		NewDocumentHistory().Clear(doc_id)
+		NewDocumentPermission().DeleteByDocumentId(doc_id)
+		NewAnnotation().DeleteByDocumentId(doc_id)
+		NewWatch().DeleteByDocumentId(doc_id)
+		NewDocumentReview().DeleteByDocumentId(doc_id)
+		NewBranchDocument().DeleteByDocumentId(doc_id)
+		NewDocumentInclude().DeleteByDocumentId(doc_id)
+		NewDocumentLink().DeleteByDocumentId(doc_id)
+		NewDocumentLabel().DeleteByDocumentId(doc_id)
+		NewDocumentMeta().DeleteByDocumentId(doc_id)
 	}
 
 	var docs []*Document
@@ -150,14 +164,23 @@ func (m *Document) RecursiveDocument(doc_id int) error {
 		o.QueryTable(m.TableNameWithPrefix()).Filter("document_id", doc_id).Delete()
 		//删除document_store表的文档
 		modelStore.DeleteById(doc_id)
+		NewDocumentPermission().DeleteByDocumentId(doc_id)
+		NewAnnotation().DeleteByDocumentId(doc_id)
+		NewWatch().DeleteByDocumentId(doc_id)
+		NewDocumentReview().DeleteByDocumentId(doc_id)
+		NewBranchDocument().DeleteByDocumentId(doc_id)
+		NewDocumentInclude().DeleteByDocumentId(doc_id)
+		NewDocumentLink().DeleteByDocumentId(doc_id)
+		NewDocumentLabel().DeleteByDocumentId(doc_id)
+		NewDocumentMeta().DeleteByDocumentId(doc_id)
 		m.RecursiveDocument(doc_id)
 	}
 
 	return nil
 }
 
-//发布文档
-func (m *Document) ReleaseContent(book_id int, base_url string) {
+//发布文档，member_id 为发布项目的用户.
+func (m *Document) ReleaseContent(book_id, member_id int, base_url string) {
 	o := orm.NewOrm()
 	var (
 		docs       []*Document
@@ -167,12 +190,22 @@ func (m *Document) ReleaseContent(book_id int, base_url string) {
 	)
 	qs := o.QueryTable(tableBooks).Filter("book_id", book_id)
 	qs.One(&book)
-	//查询更新时间大于项目发布时间的文档
-	_, err := o.QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).Filter("modify_time__gt", book.ReleaseTime).All(&docs, "document_id")
-	//_, err := o.QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).All(&docs, "document_id", "content")
-	if err != nil {
-		beego.Error("发布失败 => ", err)
-		return
+	var err error
+	if book.EnableReview == 1 {
+		//开启审阅的项目只发布审阅通过的修订
+		releaseNum = m.releaseReviewed(&book, member_id)
+	} else {
+		//查询更新时间大于项目发布时间的文档，已取消发布和定时发布的文档不随项目发布
+		_, err = o.QueryTable(m.TableNameWithPrefix()).
+			Filter("book_id", book_id).
+			Filter("modify_time__gt", book.ReleaseTime).
+			Filter("publish_status__in", conf.DocumentDraft, conf.DocumentPublished).
+			Filter("schedule_time__isnull", true).All(&docs, "document_id", "version")
+		//_, err := o.QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).All(&docs, "document_id", "content")
+		if err != nil {
+			beego.Error("发布失败 => ", err)
+			return
+		}
 	}
 	idx := 1
 	ModelStore := new(DocumentStore)
@@ -185,21 +218,16 @@ func (m *Document) ReleaseContent(book_id int, base_url string) {
 			//}
 			//采用单线程去发布，避免用户多操作，避免Chrome启动过多导致内存、CPU等资源耗费致使服务器宕机
 			utils.RenderDocumentById(item.DocumentId)
+			if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id", item.DocumentId).Update(orm.Params{
+				"publish_status":  conf.DocumentPublished,
+				"publish_version": item.Version,
+				"publish_time":    time.Now(),
+			}); err != nil {
+				beego.Error("更新文档发布状态失败 => ", err)
+			}
 			idx++
 		} else {
-			item.Release = content
-			attach_list, err := NewAttachment().FindListByDocumentId(item.DocumentId)
-			if err == nil && len(attach_list) > 0 {
-				content := bytes.NewBufferString("<div class=\"attach-list\"><strong>附件</strong><ul>")
-				for _, attach := range attach_list {
-					li := fmt.Sprintf("<li><a href=\"%s\" target=\"_blank\" title=\"%s\">%s</a></li>", attach.HttpPath, attach.FileName, attach.FileName)
-
-					content.WriteString(li)
-				}
-				content.WriteString("</ul></div>")
-				item.Release += content.String()
-			}
-			_, err = o.Update(item, "release")
+			err = markPublished(o, item.DocumentId, member_id, content+releaseAttachList(item.DocumentId), item.Version)
 			if err != nil {
 				beego.Error(fmt.Sprintf("发布失败 => %+v", item), err)
 			} else {
@@ -220,6 +248,59 @@ func (m *Document) ReleaseContent(book_id int, base_url string) {
 	utils.ReleaseMapsLock.Lock()
 	delete(utils.ReleaseMaps, book_id)
 	utils.ReleaseMapsLock.Unlock()
+
+	if GetOptionValue("LINK_CHECK_AFTER_RELEASE", "false") == "true" {
+		if _, err := StartLinkCheck(book_id, 0, base_url, GetOptionValue("LINK_CHECK_EXTERNAL", "false") == "true"); err != nil {
+			beego.Error("发布后检查链接失败 => ", err)
+		}
+	}
+}
+
+//发布审阅通过的文档快照，返回发布的文档数量.
+func (m *Document) releaseReviewed(book *Book, member_id int) int {
+	o := orm.NewOrm()
+	releaseNum := 0
+	reviews, err := NewDocumentReview().FindApprovedSince(book.BookId, book.ReleaseTime)
+	if err != nil {
+		beego.Error("查询审阅通过的文档失败 => ", err)
+		return releaseNum
+	}
+	for _, review := range reviews {
+		content := strings.TrimSpace(review.Content)
+		if content == "" {
+			beego.Error("审阅通过的文档内容为空，跳过发布 => ", review.DocumentId)
+			continue
+		}
+		//已取消发布和定时发布的文档不随项目发布
+		var doc Document
+		err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id", review.DocumentId).One(&doc, "document_id", "publish_status", "schedule_time")
+		if err != nil || doc.PublishStatus == conf.DocumentUnpublished || doc.IsScheduled() {
+			continue
+		}
+		err = markPublished(o, review.DocumentId, member_id, content+releaseAttachList(review.DocumentId), review.Version)
+		if err != nil {
+			beego.Error(fmt.Sprintf("发布失败 => %+v", review.DocumentId), err)
+		} else {
+			releaseNum++
+		}
+	}
+	return releaseNum
+}
+
+//发布内容末尾的附件列表.
+func releaseAttachList(doc_id int) string {
+	attach_list, err := NewAttachment().FindListByDocumentId(doc_id)
+	if err != nil || len(attach_list) == 0 {
+		return ""
+	}
+	content := bytes.NewBufferString("<div class=\"attach-list\"><strong>附件</strong><ul>")
+	for _, attach := range attach_list {
+		li := fmt.Sprintf("<li><a href=\"%s\" target=\"_blank\" title=\"%s\">%s</a></li>", attach.HttpPath, attach.FileName, attach.FileName)
+
+		content.WriteString(li)
+	}
+	content.WriteString("</ul></div>")
+	return content.String()
 }
 
 func (m *Document) GenerateBook(book *Book, base_url string) {
@@ -232,12 +313,6 @@ func (m *Document) GenerateBook(book *Book, base_url string) {
 	qs.Update(orm.Params{
 		"last_click_generate": time.Now(),
 	})
-	//公开文档，才生成文档文件
-	debug := true
-	if beego.AppConfig.String("runmode") == "prod" {
-		debug = false
-	}
-	Nickname := new(Member).GetNicknameByUid(book.MemberId)
 
 	docs, err := NewDocument().FindListByBookId(book.BookId)
 
@@ -245,12 +320,55 @@ func (m *Document) GenerateBook(book *Book, base_url string) {
 		beego.Error(err)
 		return
 	}
+	//下载文档对所有人公开，排除设置了权限限制的文档
+	docs = NewDocumentAccess(book.BookId, 0, -1, false).PublishedOnly().FilterDocuments(docs)
+	ModelStore := new(DocumentStore)
+	for _, doc := range docs {
+		content := strings.TrimSpace(ModelStore.GetFiledById(doc.DocumentId, "content"))
+		if content == "" { //内容为空，渲染文档内容，并再重新获取文档内容
+			utils.RenderDocumentById(doc.DocumentId)
+			orm.NewOrm().Read(doc, "document_id")
+		}
+	}
+
+	//将文档移动到oss
+	//将PDF文档移动到oss
+	newBook := fmt.Sprintf("projects/%v/books/%v", book.Identify, book.ReleaseTime.Unix())
+	oldBook := fmt.Sprintf("projects/%v/books/%v", book.Identify, book.GenerateTime.Unix())
+	exportBook(book, docs, base_url, book.ReleaseTime, fmt.Sprintf("cache/books/%v/", book.Identify), newBook)
+
+	//删除旧文件
+	switch utils.StoreType {
+	case utils.StoreOss:
+		if err := ModelStoreOss.DelFromOss(oldBook+".pdf", oldBook+".epub", oldBook+".mobi"); err != nil { //删除旧版
+			beego.Error(err)
+		}
+	case utils.StoreLocal: //本地存储
+		if err := ModelStoreLocal.DelFiles(oldBook+".pdf", oldBook+".epub", oldBook+".mobi"); err != nil { //删除旧版
+			beego.Error(err)
+		}
+	}
+
+	//最后再更新文档生成时间
+	if _, err = qs.Update(orm.Params{"generate_time": book.ReleaseTime}); err != nil {
+		beego.Error(err.Error())
+	}
+}
+
+//将文档导出为 pdf、epub 和 mobi 文件并保存到 dest，dest 不包含扩展名.
+func exportBook(book *Book, docs []*Document, base_url string, date time.Time, folder, dest string) {
+	debug := true
+	if beego.AppConfig.String("runmode") == "prod" {
+		debug = false
+	}
+	Nickname := new(Member).GetNicknameByUid(book.MemberId)
+
 	var ExpCfg = converter.Config{
 		Contributor: beego.AppConfig.String("exportCreator"),
 		Cover:       "",
 		Charset:     "utf8",
 		Creator:     beego.AppConfig.String("exportCreator"),
-		Timestamp:   book.ReleaseTime.Format("2006-01-02"),
+		Timestamp:   date.Format("2006-01-02"),
 		Description: book.Description,
 		Header:      beego.AppConfig.String("exportHeader"),
 		Footer:      beego.AppConfig.String("exportFooter"),
@@ -268,7 +386,6 @@ func (m *Document) GenerateBook(book *Book, base_url string) {
 			"--pdf-page-margin-top", beego.AppConfig.DefaultString("exportMarginTop", "72"),
 		},
 	}
-	folder := fmt.Sprintf("cache/books/%v/", book.Identify)
 	os.MkdirAll(folder, os.ModePerm)
 	if !debug {
 		defer os.RemoveAll(folder)
@@ -290,14 +407,7 @@ func (m *Document) GenerateBook(book *Book, base_url string) {
 		ioutil.WriteFile(htmlname, []byte(htmlstr), os.ModePerm)
 		ExpCfg.Toc = append(ExpCfg.Toc, toc)
 	}
-	ModelStore := new(DocumentStore)
 	for _, doc := range docs {
-		content := strings.TrimSpace(ModelStore.GetFiledById(doc.DocumentId, "content"))
-		if content == "" { //内容为空，渲染文档内容，并再重新获取文档内容
-			utils.RenderDocumentById(doc.DocumentId)
-			orm.NewOrm().Read(doc, "document_id")
-		}
-
 		//将图片链接更换成绝对链接
 		toc := converter.Toc{
 			Id:    doc.DocumentId,
@@ -366,43 +476,22 @@ func (m *Document) GenerateBook(book *Book, base_url string) {
 		beego.Error(err.Error())
 	}
 
-	//将文档移动到oss
-	//将PDF文档移动到oss
-	newBook := fmt.Sprintf("projects/%v/books/%v", book.Identify, book.ReleaseTime.Unix())
-	oldBook := fmt.Sprintf("projects/%v/books/%v", book.Identify, book.GenerateTime.Unix())
 	exts := []string{".pdf", ".epub", ".mobi"}
 
 	for _, ext := range exts {
 		switch utils.StoreType {
 		case utils.StoreOss:
 			//不要开启gzip压缩，否则会出现文件损坏的情况
-			if err := ModelStoreOss.MoveToOss(folder+"output/book"+ext, newBook+ext, true, false); err != nil {
+			if err := ModelStoreOss.MoveToOss(folder+"output/book"+ext, dest+ext, true, false); err != nil {
 				beego.Error(err)
 			} else { //设置下载头
-				ModelStoreOss.SetObjectMeta(newBook+ext, book.BookName+ext)
+				ModelStoreOss.SetObjectMeta(dest+ext, book.BookName+ext)
 			}
 		case utils.StoreLocal: //本地存储
-			ModelStoreLocal.MoveToStore(folder+"output/book"+ext, "uploads/"+newBook+ext)
-		}
-
-	}
-	//删除旧文件
-	switch utils.StoreType {
-	case utils.StoreOss:
-		if err := ModelStoreOss.DelFromOss(oldBook+".pdf", oldBook+".epub", oldBook+".mobi"); err != nil { //删除旧版
-			beego.Error(err)
-		}
-	case utils.StoreLocal: //本地存储
-		if err := ModelStoreLocal.DelFiles(oldBook+".pdf", oldBook+".epub", oldBook+".mobi"); err != nil { //删除旧版
-			beego.Error(err)
+			ModelStoreLocal.MoveToStore(folder+"output/book"+ext, "uploads/"+dest+ext)
 		}
-	}
 
-	//最后再更新文档生成时间
-	if _, err = qs.Update(orm.Params{"generate_time": book.ReleaseTime}); err != nil {
-		beego.Error(err.Error())
 	}
-
 }
 
 //根据项目ID查询文档列表.
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/JermineHu/DocStack/utils"
+	"github.com/astaxie/beego/orm"
+)
+
+//比较时显示的差异上下文行数.
+const diffContextLines = 3
+
+//参与比较的文档修订，HistoryId 为 0 表示文档当前内容.
+type DocumentRevision struct {
+	HistoryId    int       `json:"history_id"`
+	Version      int64     `json:"version"`
+	ActionName   string    `json:"action_name"`
+	DocumentName string    `json:"doc_name"`
+	ParentId     int       `json:"parent_id"`
+	ParentName   string    `json:"parent_name"`
+	ModifyTime   time.Time `json:"modify_time"`
+	Content      string    `json:"-"`
+}
+
+//两个文档修订之间的差异.
+type DocumentDiff struct {
+	DocumentId int               `json:"doc_id"`
+	Old        *DocumentRevision `json:"old"`
+	New        *DocumentRevision `json:"new"`
+	Renamed    bool              `json:"renamed"`
+	Moved      bool              `json:"moved"`
+	Added      int               `json:"added"`
+	Removed    int               `json:"removed"`
+	Hunks      []*utils.DiffHunk `json:"hunks"`
+}
+
+//查询文档的修订，history_id 为 0 时返回文档当前内容，field 为 markdown 或 content.
+func FindDocumentRevision(doc *Document, history_id int, field string) (*DocumentRevision, error) {
+	revision := &DocumentRevision{}
+	if history_id <= 0 {
+		revision.Version = doc.Version
+		revision.ActionName = "当前版本"
+		revision.DocumentName = doc.DocumentName
+		revision.ParentId = doc.ParentId
+		revision.ModifyTime = doc.ModifyTime
+		revision.Content = new(DocumentStore).GetFiledById(doc.DocumentId, field)
+	} else {
+		history, err := NewDocumentHistory().Find(history_id)
+		if err != nil {
+			return nil, err
+		}
+		if history.DocumentId != doc.DocumentId {
+			return nil, ErrDataNotExist
+		}
+		revision.HistoryId = history.HistoryId
+		revision.Version = history.Version
+		revision.ActionName = history.ActionName
+		revision.DocumentName = history.DocumentName
+		revision.ParentId = history.ParentId
+		revision.ModifyTime = history.ModifyTime
+		revision.Content = history.Markdown
+		if field == "content" {
+			revision.Content = history.Content
+		}
+	}
+	revision.ParentName = "根目录"
+	if revision.ParentId > 0 {
+		var parent Document
+		err := orm.NewOrm().QueryTable(NewDocument().TableNameWithPrefix()).Filter("document_id", revision.ParentId).One(&parent, "document_id", "document_name")
+		if err == nil {
+			revision.ParentName = parent.DocumentName
+		} else {
+			revision.ParentName = fmt.Sprintf("已删除的文档 #%d", revision.ParentId)
+		}
+	}
+	return revision, nil
+}
+
+//比较文档的两个修订，from 和 to 为历史记录ID，0 表示文档当前内容，words 为 true 时对修改的行按词比较.
+func NewDocumentDiff(doc *Document, from, to int, field string, words bool) (*DocumentDiff, error) {
+	old, err := FindDocumentRevision(doc, from, field)
+	if err != nil {
+		return nil, err
+	}
+	current, err := FindDocumentRevision(doc, to, field)
+	if err != nil {
+		return nil, err
+	}
+	diff := &DocumentDiff{
+		DocumentId: doc.DocumentId,
+		Old:        old,
+		New:        current,
+		Renamed:    old.DocumentName != current.DocumentName,
+		Moved:      old.ParentId != current.ParentId,
+		Hunks:      utils.LineDiff(old.Content, current.Content, diffContextLines, words),
+	}
+	for _, hunk := range diff.Hunks {
+		for _, line := range hunk.Lines {
+			if line.Type == utils.DiffInsert {
+				diff.Added++
+			} else if line.Type == utils.DiffDelete {
+				diff.Removed++
+			}
+		}
+	}
+	return diff, nil
+}
+
+//查询文档的全部历史记录，不包含内容.
+func (m *DocumentHistory) FindAllByDocumentId(doc_id int) (histories []*DocumentHistory, err error) {
+	_, err = orm.NewOrm().QueryTable(m.TableNameWithPrefix()).
+		Filter("document_id", doc_id).
+		OrderBy("-history_id").Limit(-1).
+		All(&histories, "history_id", "action_name", "document_id", "document_name", "parent_id", "modify_time", "modify_at", "version")
+	return
+}
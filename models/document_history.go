@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/utils"
 	"github.com/astaxie/beego/orm"
 )
 
@@ -20,6 +21,22 @@ type DocumentHistory struct {
 	ModifyTime   time.Time `orm:"column(modify_time);type(datetime);auto_now" json:"modify_time"`
 	ModifyAt     int       `orm:"column(modify_at);type(int)" json:"-"`
 	Version      int64     `orm:"type(bigint);column(version)" json:"version"`
+	BaseId       int       `orm:"column(base_id);type(int);default(0)" json:"-"` //不为 0 时内容为相对该历史的差异
+}
+
+//连续按差异存储的历史数量上限，超过后保存完整内容，避免还原时查询过多.
+const historyMaxDeltaDepth = 20
+
+//文档历史操作的名称.
+var historyActionNames = map[string]string{
+	conf.HistoryCreate:  "创建文档",
+	conf.HistoryModify:  "修改文档",
+	conf.HistoryRename:  "重命名文档",
+	conf.HistoryMove:    "移动文档",
+	conf.HistoryDelete:  "删除文档",
+	conf.HistoryImport:  "导入文档",
+	conf.HistoryRestore: "恢复文档",
+	conf.HistoryMerge:   "合并分支",
 }
 
 type DocumentHistorySimpleResult struct {
@@ -53,10 +70,96 @@ func NewDocumentHistory() *DocumentHistory {
 func (m *DocumentHistory) Find(id int) (*DocumentHistory, error) {
 	o := orm.NewOrm()
 	err := o.QueryTable(m.TableNameWithPrefix()).Filter("history_id", id).One(m)
-
+	if err == nil {
+		_, err = m.resolve()
+	}
 	return m, err
 }
 
+//按差异存储的历史还原为完整内容，返回差异链的长度.
+func (m *DocumentHistory) resolve() (int, error) {
+	if m.BaseId <= 0 {
+		return 0, nil
+	}
+	base := NewDocumentHistory()
+	if err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("history_id", m.BaseId).One(base); err != nil {
+		return 0, err
+	}
+	depth, err := base.resolve()
+	if err != nil {
+		return 0, err
+	}
+	if m.Markdown, err = utils.ApplyLineDelta(base.Markdown, m.Markdown); err != nil {
+		return 0, err
+	}
+	if m.Content, err = utils.ApplyLineDelta(base.Content, m.Content); err != nil {
+		return 0, err
+	}
+	m.BaseId = 0
+	return depth + 1, nil
+}
+
+//相对文档的上一条历史按差异存储，差异不比完整内容小时保存完整内容.
+func (m *DocumentHistory) compress() {
+	if m.BaseId > 0 {
+		return
+	}
+	last := NewDocumentHistory()
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("document_id", m.DocumentId).OrderBy("-history_id").One(last)
+	if err != nil {
+		return
+	}
+	history_id := last.HistoryId
+	depth, err := last.resolve()
+	if err != nil || depth >= historyMaxDeltaDepth {
+		return
+	}
+	markdown := utils.LineDelta(last.Markdown, m.Markdown)
+	content := utils.LineDelta(last.Content, m.Content)
+	if len(markdown)+len(content) < (len(m.Markdown)+len(m.Content))/2 {
+		m.Markdown, m.Content, m.BaseId = markdown, content, history_id
+	}
+}
+
+//保存为完整内容，删除作为差异基准的历史前调用.
+func (m *DocumentHistory) materialize() error {
+	if m.BaseId <= 0 {
+		return nil
+	}
+	if _, err := m.resolve(); err != nil {
+		return err
+	}
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("history_id", m.HistoryId).Update(orm.Params{
+		"markdown": m.Markdown,
+		"content":  m.Content,
+		"base_id":  0,
+	})
+	return err
+}
+
+//记录文档当前状态的历史.
+func (m *Document) AddHistory(action string, member_id int) error {
+	if m.DocumentId <= 0 {
+		return ErrInvalidParameter
+	}
+	var ds = DocumentStore{DocumentId: m.DocumentId}
+	orm.NewOrm().Read(&ds)
+
+	history := NewDocumentHistory()
+	history.DocumentId = m.DocumentId
+	history.Content = ds.Content
+	history.Markdown = ds.Markdown
+	history.DocumentName = m.DocumentName
+	history.ModifyAt = member_id
+	history.MemberId = m.MemberId
+	history.ParentId = m.ParentId
+	history.Version = time.Now().Unix()
+	history.Action = action
+	history.ActionName = historyActionNames[action]
+	_, err := history.InsertOrUpdate()
+	return err
+}
+
 //清空指定文档的历史.
 func (m *DocumentHistory) Clear(doc_id int) error {
 	o := orm.NewOrm()
@@ -70,6 +173,14 @@ func (m *DocumentHistory) Clear(doc_id int) error {
 func (m *DocumentHistory) Delete(history_id, doc_id int) error {
 	o := orm.NewOrm()
 
+	//以该历史为基准的差异需要先保存为完整内容
+	var dependents []*DocumentHistory
+	o.QueryTable(m.TableNameWithPrefix()).Filter("base_id", history_id).Filter("document_id", doc_id).All(&dependents)
+	for _, dependent := range dependents {
+		if err := dependent.materialize(); err != nil {
+			return err
+		}
+	}
 	_, err := o.QueryTable(m.TableNameWithPrefix()).Filter("history_id", history_id).Filter("document_id", doc_id).Delete()
 
 	return err
@@ -84,6 +195,9 @@ func (m *DocumentHistory) Restore(history_id, doc_id, uid int) error {
 	if err != nil {
 		return err
 	}
+	if _, err := m.resolve(); err != nil {
+		return err
+	}
 	doc, err := NewDocument().Find(m.DocumentId)
 
 	if err != nil {
@@ -100,15 +214,14 @@ func (m *DocumentHistory) Restore(history_id, doc_id, uid int) error {
 	history.MemberId = doc.MemberId
 	history.ParentId = doc.ParentId
 	history.Version = time.Now().Unix()
-	history.Action = "restore"
-	history.ActionName = "恢复文档"
+	history.Action = conf.HistoryRestore
+	history.ActionName = historyActionNames[conf.HistoryRestore]
 
 	history.InsertOrUpdate()
 
 	doc.DocumentName = m.DocumentName
 	ds.Content = m.Content
 	ds.Markdown = m.Markdown
-	doc.Release = m.Content
 	doc.Version = time.Now().Unix()
 
 	_, err = o.Update(doc)
@@ -124,6 +237,7 @@ func (m *DocumentHistory) InsertOrUpdate() (history *DocumentHistory, err error)
 	if m.HistoryId > 0 {
 		_, err = o.Update(m)
 	} else {
+		m.compress()
 		_, err = o.Insert(m)
 	}
 	return
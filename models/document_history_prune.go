@@ -0,0 +1,141 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+var historyPruneOnce sync.Once
+
+//文档历史保留策略，各项为 0 时表示不限制.
+type HistoryRetention struct {
+	KeepCount int //每个文档最多保留的历史数量
+	KeepDays  int //历史保留的天数
+	ThinDays  int //超过该天数的历史每天只保留最后一条
+}
+
+//从站点配置中读取文档历史保留策略.
+func GetHistoryRetention() *HistoryRetention {
+	retention := &HistoryRetention{}
+	retention.KeepCount, _ = strconv.Atoi(GetOptionValue("HISTORY_KEEP_COUNT", "0"))
+	retention.KeepDays, _ = strconv.Atoi(GetOptionValue("HISTORY_KEEP_DAYS", "0"))
+	retention.ThinDays, _ = strconv.Atoi(GetOptionValue("HISTORY_THIN_DAYS", "0"))
+	return retention
+}
+
+func (r *HistoryRetention) IsEmpty() bool {
+	return r.KeepCount <= 0 && r.KeepDays <= 0 && r.ThinDays <= 0
+}
+
+//按时间顺序排列的历史中需要删除的历史，最新的一条始终保留.
+func (r *HistoryRetention) Expired(histories []*DocumentHistory, now time.Time) []*DocumentHistory {
+	expired := make([]*DocumentHistory, 0)
+	n := len(histories)
+	for i, history := range histories[:n-1] {
+		if r.KeepCount > 0 && i < n-r.KeepCount {
+			expired = append(expired, history)
+			continue
+		}
+		if r.KeepDays > 0 && history.ModifyTime.Before(now.AddDate(0, 0, -r.KeepDays)) {
+			expired = append(expired, history)
+			continue
+		}
+		if r.ThinDays > 0 && history.ModifyTime.Before(now.AddDate(0, 0, -r.ThinDays)) &&
+			history.ModifyTime.Format("2006-01-02") == histories[i+1].ModifyTime.Format("2006-01-02") {
+			expired = append(expired, history)
+		}
+	}
+	return expired
+}
+
+//按保留策略清理文档历史，并删除已不存在的文档的历史.
+func PruneDocumentHistory(now time.Time) error {
+	o := orm.NewOrm()
+	table := NewDocumentHistory().TableNameWithPrefix()
+
+	if _, err := o.Raw("DELETE FROM " + table + " WHERE document_id NOT IN (SELECT document_id FROM " + NewDocument().TableNameWithPrefix() + ")").Exec(); err != nil {
+		return err
+	}
+	retention := GetHistoryRetention()
+	if retention.IsEmpty() {
+		return nil
+	}
+	var doc_ids orm.ParamsList
+	if _, err := o.QueryTable(table).Distinct().Limit(-1).ValuesFlat(&doc_ids, "document_id"); err != nil {
+		return err
+	}
+	for _, value := range doc_ids {
+		doc_id, _ := strconv.Atoi(fmt.Sprint(value))
+		if err := pruneHistories(doc_id, retention, now); err != nil {
+			beego.Error("清理文档历史失败 => ", doc_id, err)
+		}
+	}
+	return nil
+}
+
+//清理一个文档的历史，被删除的历史作为差异基准时先将依赖它的历史保存为完整内容.
+func pruneHistories(doc_id int, retention *HistoryRetention, now time.Time) error {
+	o := orm.NewOrm()
+	table := NewDocumentHistory().TableNameWithPrefix()
+
+	var histories []*DocumentHistory
+	if _, err := o.QueryTable(table).Filter("document_id", doc_id).OrderBy("history_id").Limit(-1).All(&histories, "history_id", "base_id", "modify_time"); err != nil {
+		return err
+	}
+	if len(histories) <= 1 {
+		return nil
+	}
+	expired := retention.Expired(histories, now)
+	if len(expired) == 0 {
+		return nil
+	}
+	deleted := make(map[int]bool, len(expired))
+	ids := make([]interface{}, 0, len(expired))
+	for _, history := range expired {
+		deleted[history.HistoryId] = true
+		ids = append(ids, history.HistoryId)
+	}
+	for _, history := range histories {
+		if deleted[history.HistoryId] || !deleted[history.BaseId] {
+			continue
+		}
+		dependent := NewDocumentHistory()
+		if err := o.QueryTable(table).Filter("history_id", history.HistoryId).One(dependent); err != nil {
+			return err
+		}
+		if err := dependent.materialize(); err != nil {
+			return err
+		}
+	}
+	for len(ids) > 0 {
+		size := len(ids)
+		if size > 500 {
+			size = 500
+		}
+		if _, err := o.QueryTable(table).Filter("history_id__in", ids[:size]...).Delete(); err != nil {
+			return err
+		}
+		ids = ids[size:]
+	}
+	return nil
+}
+
+//启动文档历史清理任务，每小时按保留策略清理一次.
+func StartHistoryPruning() {
+	historyPruneOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				if err := PruneDocumentHistory(now); err != nil {
+					beego.Error("清理文档历史失败 => ", err)
+				}
+			}
+		}()
+	})
+}
@@ -0,0 +1,326 @@
+package models
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+//文档引用语法：!include(文档标识)、!include(项目标识/文档标识) 以及 !include(文档标识#章节).
+var (
+	includeRegexp        = regexp.MustCompile(`!include\(\s*(?:([A-Za-z0-9_\-]+)/)?([A-Za-z0-9_\-\.]+)(?:#([^)\s<>"]+))?\s*\)`)
+	includeBlockRegexp   = regexp.MustCompile(`<p>\s*` + includeRegexp.String() + `\s*</p>|` + includeRegexp.String())
+	includeCodeRegexp    = regexp.MustCompile("(?is)<pre[\\s>].*?</pre>|<code[\\s>].*?</code>|```.*?```|`[^`\\n]*`")
+	includeHeadingRegexp = regexp.MustCompile(`^h([1-6])$`)
+)
+
+//引用嵌套的最大层数，引用的文档发布内容中已包含其引用的内容.
+const includeMaxDepth = 20
+
+//文档之间的引用关系，编辑器保存文档时更新，用于检测循环引用和列出引用了某个文档的文档.
+type DocumentInclude struct {
+	IncludeId   int       `orm:"pk;auto;column(include_id)" json:"include_id"`
+	DocumentId  int       `orm:"column(document_id);type(int);index" json:"doc_id"`  //引用方文档
+	TargetId    int       `orm:"column(target_id);type(int);index" json:"target_id"` //被引用的文档
+	Section     string    `orm:"column(section);size(200);null" json:"section"` //引用的章节，为空时引用整个文档
+	CreateTime  time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+	DocName     string    `orm:"-" json:"doc_name"`
+	DocIdentify string    `orm:"-" json:"doc_identify"`
+	BookId      int       `orm:"-" json:"book_id"`
+	BookName    string    `orm:"-" json:"book_name"`
+	Identify    string    `orm:"-" json:"identify"`
+	Url         string    `orm:"-" json:"url"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *DocumentInclude) TableName() string {
+	return "document_include"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *DocumentInclude) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *DocumentInclude) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewDocumentInclude() *DocumentInclude {
+	return &DocumentInclude{}
+}
+
+//内容中的一处引用.
+type includeRef struct {
+	Text     string
+	Book     string
+	Document string
+	Section  string
+}
+
+func newIncludeRef(parts []string) includeRef {
+	return includeRef{Text: parts[0], Book: parts[1], Document: parts[2], Section: parts[3]}
+}
+
+//解析内容中的引用，跳过代码块中的示例.
+func parseIncludes(text string) []includeRef {
+	var refs []includeRef
+	for _, parts := range includeRegexp.FindAllStringSubmatch(includeCodeRegexp.ReplaceAllString(text, ""), -1) {
+		refs = append(refs, newIncludeRef(parts))
+	}
+	return refs
+}
+
+//查询引用的项目和文档，未指定项目时在引用方所在项目中查询.
+func findIncludeTarget(book *Book, ref includeRef) (*Book, *Document, error) {
+	if ref.Book != "" && !strings.EqualFold(ref.Book, book.Identify) {
+		target, err := NewBook().FindByIdentify(ref.Book)
+		if err != nil {
+			return nil, nil, ErrDataNotExist
+		}
+		book = target
+	}
+	doc := NewDocument()
+	var err error
+	if id, e := strconv.Atoi(ref.Document); e == nil {
+		err = orm.NewOrm().QueryTable(doc.TableNameWithPrefix()).Filter("book_id", book.BookId).Filter("document_id", id).One(doc)
+	} else {
+		_, err = doc.FindByBookIdAndDocIdentify(book.BookId, ref.Document)
+	}
+	if err != nil {
+		return book, nil, ErrDataNotExist
+	}
+	return book, doc, nil
+}
+
+//根据文档内容重建文档的引用关系.
+func (m *DocumentInclude) Replace(doc_id int, text string) error {
+	doc, err := NewDocument().Find(doc_id)
+	if err != nil {
+		return err
+	}
+	book, err := NewBook().Find(doc.BookId)
+	if err != nil {
+		return err
+	}
+	o := orm.NewOrm()
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id", doc_id).Delete(); err != nil {
+		return err
+	}
+	added := make(map[string]bool)
+	for _, ref := range parseIncludes(text) {
+		_, target, err := findIncludeTarget(book, ref)
+		if err != nil {
+			continue
+		}
+		key := strconv.Itoa(target.DocumentId) + "#" + ref.Section
+		if added[key] {
+			continue
+		}
+		added[key] = true
+		if _, err := o.Insert(&DocumentInclude{DocumentId: doc_id, TargetId: target.DocumentId, Section: ref.Section}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//删除文档的引用关系，包括引用其他文档和被其他文档引用.
+func (m *DocumentInclude) DeleteByDocumentId(doc_id ...interface{}) error {
+	if len(doc_id) == 0 {
+		return nil
+	}
+	o := orm.NewOrm()
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id__in", doc_id...).Delete(); err != nil {
+		return err
+	}
+	_, err := o.QueryTable(m.TableNameWithPrefix()).Filter("target_id__in", doc_id...).Delete()
+	return err
+}
+
+//查询引用了指定文档的文档，不包含回收站中的文档和已删除项目中的文档.
+func (m *DocumentInclude) FindUsedBy(doc_id int) (includes []*DocumentInclude, err error) {
+	sql := "SELECT inc.*,doc.document_name AS doc_name,doc.identify AS doc_identify,book.book_id,book.book_name,book.identify FROM " + m.TableNameWithPrefix() + " AS inc" +
+		" INNER JOIN " + NewDocument().TableNameWithPrefix() + " AS doc ON inc.document_id = doc.document_id" +
+		" INNER JOIN " + NewBook().TableNameWithPrefix() + " AS book ON doc.book_id = book.book_id" +
+		" WHERE inc.target_id = ? AND book.status = 0 ORDER BY book.book_id,doc.document_id"
+
+	_, err = orm.NewOrm().Raw(sql, doc_id).QueryRows(&includes)
+	return
+}
+
+//是否存在从 from 到 to 的引用路径.
+func (m *DocumentInclude) hasPath(from, to int) bool {
+	o := orm.NewOrm()
+
+	visited := map[int]bool{from: true}
+	current := []interface{}{from}
+	for depth := 0; len(current) > 0 && depth < includeMaxDepth; depth++ {
+		if visited[to] {
+			return true
+		}
+		var values orm.ParamsList
+		if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id__in", current...).Limit(-1).ValuesFlat(&values, "target_id"); err != nil {
+			beego.Error("查询文档引用关系失败 => ", err)
+			return false
+		}
+		current = make([]interface{}, 0, len(values))
+		for _, value := range values {
+			id, _ := strconv.Atoi(fmt.Sprint(value))
+			if !visited[id] {
+				visited[id] = true
+				current = append(current, id)
+			}
+		}
+	}
+	return visited[to]
+}
+
+//校验引用方项目的读者是否都可以阅读被引用的文档.
+//同一项目中，能被非成员阅读的文档不能引用非成员无法阅读的文档；
+//跨项目时，被引用的项目需要是公开的，或引用方是私有项目且发布文档的用户可以阅读被引用的文档.
+func canInclude(book *Book, doc *Document, target_book *Book, target *Document, member_id int) bool {
+	if target_book.Status != 0 {
+		return false
+	}
+	if target_book.BookId == book.BookId {
+		access := NewDocumentAccess(book.BookId, 0, -1, false).PublishedOnly()
+		return access.CanRead(target.DocumentId) || !access.CanRead(doc.DocumentId)
+	}
+	if target_book.PrivatelyOwned == 0 {
+		return NewDocumentAccess(target_book.BookId, 0, -1, false).PublishedOnly().CanRead(target.DocumentId)
+	}
+	if book.PrivatelyOwned == 0 {
+		return false
+	}
+	member, err := NewMember().Find(member_id)
+	if err != nil {
+		return false
+	}
+	if _, err := NewRelationship().FindEffectiveRoleId(target_book.BookId, member.MemberId); err != nil && !member.IsAdministrator() {
+		return false
+	}
+	return NewDocumentAccessForMember(target_book.BookId, member).PublishedOnly().CanRead(target.DocumentId)
+}
+
+//提取发布内容中的章节，章节可以是标题的ID、锚点名称或标题文字，包含标题到下一个同级或更高级标题之前的内容.
+func extractSection(release, section string) (string, bool) {
+	gq, err := goquery.NewDocumentFromReader(strings.NewReader(release))
+	if err != nil {
+		return "", false
+	}
+	gq.Find(".attach-list").Remove()
+	body := gq.Find("body")
+	if section == "" {
+		html, _ := body.Html()
+		return html, true
+	}
+	var heading *goquery.Selection
+	body.Find("h1,h2,h3,h4,h5,h6").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		id, _ := s.Attr("id")
+		name, _ := s.Find("a[name]").Attr("name")
+		if strings.EqualFold(id, section) || strings.EqualFold(strings.TrimPrefix(id, goquery.NodeName(s)+"-"), section) ||
+			strings.EqualFold(name, section) || strings.EqualFold(strings.TrimSpace(s.Text()), section) {
+			heading = s
+			return false
+		}
+		return true
+	})
+	if heading == nil {
+		return "", false
+	}
+	level, _ := strconv.Atoi(includeHeadingRegexp.FindStringSubmatch(goquery.NodeName(heading))[1])
+	html, _ := goquery.OuterHtml(heading)
+	for next := heading.Next(); next.Length() > 0; next = next.Next() {
+		if match := includeHeadingRegexp.FindStringSubmatch(goquery.NodeName(next)); match != nil {
+			if n, _ := strconv.Atoi(match[1]); n <= level {
+				break
+			}
+		}
+		outer, _ := goquery.OuterHtml(next)
+		html += outer
+	}
+	return html, true
+}
+
+//无法引用时显示的提示.
+func includeError(ref includeRef, reason string) string {
+	return fmt.Sprintf(`<blockquote class="include-error">无法引用 %s：%s</blockquote>`, template.HTMLEscapeString(ref.Text), reason)
+}
+
+//将发布内容中的引用替换为被引用文档的发布内容，被引用的文档需要已发布且发布文档的用户可以阅读.
+func ResolveIncludes(doc_id, member_id int, content string) string {
+	if !strings.Contains(content, "!include(") {
+		return content
+	}
+	doc, err := NewDocument().Find(doc_id)
+	if err != nil {
+		return content
+	}
+	book, err := NewBook().Find(doc.BookId)
+	if err != nil {
+		return content
+	}
+	resolve := func(ref includeRef) string {
+		target_book, target, err := findIncludeTarget(book, ref)
+		if err != nil {
+			return includeError(ref, "文档不存在")
+		}
+		if target.DocumentId == doc.DocumentId || NewDocumentInclude().hasPath(target.DocumentId, doc.DocumentId) {
+			return includeError(ref, "存在循环引用")
+		}
+		if !canInclude(book, doc, target_book, target, member_id) {
+			return includeError(ref, "没有阅读权限或文档未发布")
+		}
+		if target.PublishStatus != conf.DocumentPublished || strings.TrimSpace(target.Release) == "" {
+			return includeError(ref, "文档未发布")
+		}
+		html, ok := extractSection(target.Release, ref.Section)
+		if !ok {
+			return includeError(ref, "章节不存在")
+		}
+		return fmt.Sprintf(`<div class="include" data-include="%s">%s</div>`, template.HTMLEscapeString(ref.Text), html)
+	}
+
+	//代码块中的引用保持原样
+	codes := includeCodeRegexp.FindAllStringIndex(content, -1)
+	var buf strings.Builder
+	last := 0
+	for _, loc := range includeBlockRegexp.FindAllStringSubmatchIndex(content, -1) {
+		in_code := false
+		for _, code := range codes {
+			if loc[0] >= code[0] && loc[0] < code[1] {
+				in_code = true
+				break
+			}
+		}
+		if in_code {
+			continue
+		}
+		//独占一段的引用替换整个段落，避免块级内容嵌套在段落中
+		offset := 2
+		if loc[4] < 0 {
+			offset = 8
+		}
+		parts := make([]string, 4)
+		for i := 0; i < 3; i++ {
+			if start := loc[offset+2*i]; start >= 0 {
+				parts[i+1] = content[start:loc[offset+2*i+1]]
+			}
+		}
+		parts[0] = includeRegexp.FindString(content[loc[0]:loc[1]])
+		buf.WriteString(content[last:loc[0]])
+		buf.WriteString(resolve(newIncludeRef(parts)))
+		last = loc[1]
+	}
+	buf.WriteString(content[last:])
+	return buf.String()
+}
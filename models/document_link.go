@@ -0,0 +1,364 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/astaxie/beego/orm"
+)
+
+//相关文档的评分权重.
+const (
+	relatedDirectScore = 3 //直接链接或被链接
+	relatedSharedScore = 1 //链接了相同的文档或被相同的文档链接
+	relatedLabelScore  = 1 //文档或所在项目有相同的标签
+)
+
+//文档之间的链接关系，文档发布时根据发布内容更新，用于展示反向链接、相关文档和项目的链接图.
+type DocumentLink struct {
+	LinkId     int       `orm:"pk;auto;column(link_id)" json:"link_id"`
+	DocumentId int       `orm:"column(document_id);type(int);index" json:"doc_id"`  //链接所在的文档
+	TargetId   int       `orm:"column(target_id);type(int);index" json:"target_id"` //被链接的文档
+	LinkCount  int       `orm:"column(link_count);type(int);default(1)" json:"link_count"`
+	CreateTime time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *DocumentLink) TableName() string {
+	return "document_link"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *DocumentLink) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *DocumentLink) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewDocumentLink() *DocumentLink {
+	return &DocumentLink{}
+}
+
+//反向链接或相关文档中的一篇文档.
+type LinkedDocument struct {
+	DocumentId   int    `json:"doc_id"`
+	DocumentName string `json:"doc_name"`
+	DocIdentify  string `json:"doc_identify"`
+	BookId       int    `json:"book_id"`
+	BookName     string `json:"book_name"`
+	Identify     string `json:"identify"`
+	Label        string `json:"-"`
+	Score        int    `json:"score,omitempty"`
+	Url          string `json:"url"`
+}
+
+//项目的链接图，节点为项目中已发布的文档，边为文档之间的链接.
+type LinkGraph struct {
+	Nodes []*LinkGraphNode `json:"nodes"`
+	Edges []*LinkGraphEdge `json:"edges"`
+}
+
+type LinkGraphNode struct {
+	DocumentId   int    `json:"id"`
+	ParentId     int    `json:"parent_id"`
+	DocumentName string `json:"name"`
+	Identify     string `json:"identify"`
+	Url          string `json:"url"`
+	Outgoing     int    `json:"outgoing"`
+	Incoming     int    `json:"incoming"`
+}
+
+type LinkGraphEdge struct {
+	Source int `json:"source"`
+	Target int `json:"target"`
+	Count  int `json:"count"`
+}
+
+//解析发布内容中指向站内文档的链接，返回被链接的文档ID和链接次数.
+//同项目的链接由 replaceLinks 标记了 data-DocStack 属性，其他链接按阅读地址 /read/项目标识/文档标识 解析.
+func parseDocumentLinks(book *Book, release string) map[int]int {
+	links := make(map[int]int)
+	gq, err := goquery.NewDocumentFromReader(strings.NewReader(release))
+	if err != nil {
+		return links
+	}
+	//引用的内容和附件列表中的链接不计入
+	gq.Find(".include,.include-error,.attach-list").Remove()
+
+	books := map[string]*Book{strings.ToLower(book.Identify): book}
+	gq.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		if value, ok := s.Attr("data-docstack"); ok {
+			if id, err := strconv.Atoi(value); err == nil && id > 0 {
+				links[id]++
+				return
+			}
+		}
+		href, _ := s.Attr("href")
+		u, err := url.Parse(strings.TrimSpace(href))
+		if err != nil || u.Scheme != "" || u.Host != "" {
+			return
+		}
+		segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(segments) == 5 && segments[0] == "org" {
+			segments = segments[2:]
+		}
+		if len(segments) != 3 || segments[0] != "read" {
+			return
+		}
+		key := strings.ToLower(segments[1])
+		target_book, ok := books[key]
+		if !ok {
+			target_book, _ = NewBook().FindByIdentify(segments[1])
+			books[key] = target_book
+		}
+		if target_book == nil {
+			return
+		}
+		if _, target, err := findIncludeTarget(target_book, includeRef{Document: segments[2]}); err == nil {
+			links[target.DocumentId]++
+		}
+	})
+	return links
+}
+
+//根据文档的发布内容重建文档的链接关系.
+func (m *DocumentLink) Replace(doc_id int, release string) error {
+	doc, err := NewDocument().Find(doc_id)
+	if err != nil {
+		return err
+	}
+	book, err := NewBook().Find(doc.BookId)
+	if err != nil {
+		return err
+	}
+	o := orm.NewOrm()
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id", doc_id).Delete(); err != nil {
+		return err
+	}
+	for target_id, count := range parseDocumentLinks(book, release) {
+		if target_id == doc_id {
+			continue
+		}
+		if _, err := o.Insert(&DocumentLink{DocumentId: doc_id, TargetId: target_id, LinkCount: count}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//删除文档的链接关系，包括链接其他文档和被其他文档链接.
+func (m *DocumentLink) DeleteByDocumentId(doc_id ...interface{}) error {
+	if len(doc_id) == 0 {
+		return nil
+	}
+	o := orm.NewOrm()
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id__in", doc_id...).Delete(); err != nil {
+		return err
+	}
+	_, err := o.QueryTable(m.TableNameWithPrefix()).Filter("target_id__in", doc_id...).Delete()
+	return err
+}
+
+//查询已发布的文档及其项目，不包含回收站中的文档和已删除项目中的文档.
+func findLinkedDocuments(ids []int) (map[int]*LinkedDocument, error) {
+	docs := make(map[int]*LinkedDocument, len(ids))
+	if len(ids) == 0 {
+		return docs, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	sql := "SELECT doc.document_id,doc.document_name,doc.identify AS doc_identify,book.book_id,book.book_name,book.identify,book.label FROM " + NewDocument().TableNameWithPrefix() + " AS doc" +
+		" INNER JOIN " + NewBook().TableNameWithPrefix() + " AS book ON doc.book_id = book.book_id" +
+		" WHERE doc.document_id IN (" + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + ") AND doc.publish_status = ? AND book.status = 0"
+
+	var list []*LinkedDocument
+	if _, err := orm.NewOrm().Raw(sql, append(args, conf.DocumentPublished)...).QueryRows(&list); err != nil {
+		return nil, err
+	}
+	for _, doc := range list {
+		docs[doc.DocumentId] = doc
+	}
+	return docs, nil
+}
+
+//查询链接了指定文档的已发布文档.
+func (m *DocumentLink) FindBacklinks(doc_id int) ([]*LinkedDocument, error) {
+	var values orm.ParamsList
+	if _, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("target_id", doc_id).OrderBy("document_id").Limit(-1).ValuesFlat(&values, "document_id"); err != nil {
+		return nil, err
+	}
+	ids := paramsToIds(values)
+	docs, err := findLinkedDocuments(ids)
+	if err != nil {
+		return nil, err
+	}
+	backlinks := make([]*LinkedDocument, 0, len(docs))
+	for _, id := range ids {
+		if doc, ok := docs[id]; ok {
+			backlinks = append(backlinks, doc)
+		}
+	}
+	return backlinks, nil
+}
+
+//查询与指定文档相关的已发布文档，按评分从高到低排列.
+//直接链接或被链接、链接了相同的文档、被相同的文档链接以及文档或所在项目有相同的标签都会增加评分.
+func (m *DocumentLink) FindRelated(doc_id, limit int) ([]*LinkedDocument, error) {
+	o := orm.NewOrm()
+	table := m.TableNameWithPrefix()
+	scores := make(map[int]int)
+
+	label_table := NewDocumentLabel().TableNameWithPrefix()
+	queries := []struct {
+		sql   string
+		score int
+	}{
+		{"SELECT target_id FROM " + table + " WHERE document_id = ?", relatedDirectScore},
+		{"SELECT document_id FROM " + table + " WHERE target_id = ?", relatedDirectScore},
+		{"SELECT l2.document_id FROM " + table + " AS l1 INNER JOIN " + table + " AS l2 ON l1.target_id = l2.target_id WHERE l1.document_id = ?", relatedSharedScore},
+		{"SELECT l2.target_id FROM " + table + " AS l1 INNER JOIN " + table + " AS l2 ON l1.document_id = l2.document_id WHERE l1.target_id = ?", relatedSharedScore},
+		{"SELECT d2.document_id FROM " + label_table + " AS d1 INNER JOIN " + label_table + " AS d2 ON d1.label_id = d2.label_id WHERE d1.document_id = ?", relatedLabelScore},
+	}
+	for _, query := range queries {
+		var values orm.ParamsList
+		if _, err := o.Raw(query.sql, doc_id).ValuesFlat(&values); err != nil {
+			return nil, err
+		}
+		for _, id := range paramsToIds(values) {
+			if id > 0 && id != doc_id {
+				scores[id] += query.score
+			}
+		}
+	}
+	ids := make([]int, 0, len(scores)+1)
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	docs, err := findLinkedDocuments(append(ids, doc_id))
+	if err != nil {
+		return nil, err
+	}
+	current, ok := docs[doc_id]
+	if !ok {
+		return []*LinkedDocument{}, nil
+	}
+	labels := splitLabels(current.Label)
+	related := make([]*LinkedDocument, 0, len(ids))
+	for _, id := range ids {
+		doc, ok := docs[id]
+		if !ok {
+			continue
+		}
+		doc.Score = scores[id]
+		if doc.BookId != current.BookId {
+			for label := range splitLabels(doc.Label) {
+				if labels[label] {
+					doc.Score += relatedLabelScore
+					break
+				}
+			}
+		}
+		related = append(related, doc)
+	}
+	sort.SliceStable(related, func(i, j int) bool {
+		if related[i].Score != related[j].Score {
+			return related[i].Score > related[j].Score
+		}
+		return related[i].DocumentId < related[j].DocumentId
+	})
+	if limit > 0 && len(related) > limit {
+		related = related[:limit]
+	}
+	return related, nil
+}
+
+//查询项目的链接图，只包含项目中的文档之间的链接.
+func (m *DocumentLink) FindGraph(book_id int) (*LinkGraph, error) {
+	o := orm.NewOrm()
+
+	var docs []*Document
+	if _, err := o.QueryTable(NewDocument().TableNameWithPrefix()).Filter("book_id", book_id).Filter("publish_status", conf.DocumentPublished).OrderBy("order_sort", "document_id").Limit(-1).All(&docs, "document_id", "parent_id", "document_name", "identify"); err != nil {
+		return nil, err
+	}
+	graph := &LinkGraph{Nodes: make([]*LinkGraphNode, 0, len(docs)), Edges: make([]*LinkGraphEdge, 0)}
+	if len(docs) == 0 {
+		return graph, nil
+	}
+	nodes := make(map[int]*LinkGraphNode, len(docs))
+	ids := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		node := &LinkGraphNode{DocumentId: doc.DocumentId, ParentId: doc.ParentId, DocumentName: doc.DocumentName, Identify: doc.Identify}
+		nodes[doc.DocumentId] = node
+		graph.Nodes = append(graph.Nodes, node)
+		ids = append(ids, doc.DocumentId)
+	}
+	var links []*DocumentLink
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id__in", ids...).OrderBy("document_id", "target_id").Limit(-1).All(&links); err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		target, ok := nodes[link.TargetId]
+		if !ok {
+			continue
+		}
+		nodes[link.DocumentId].Outgoing++
+		target.Incoming++
+		graph.Edges = append(graph.Edges, &LinkGraphEdge{Source: link.DocumentId, Target: link.TargetId, Count: link.LinkCount})
+	}
+	return graph, nil
+}
+
+//只保留图中指定的节点及其之间的边.
+func (g *LinkGraph) Filter(keep func(node *LinkGraphNode) bool) *LinkGraph {
+	graph := &LinkGraph{Nodes: make([]*LinkGraphNode, 0, len(g.Nodes)), Edges: make([]*LinkGraphEdge, 0, len(g.Edges))}
+	kept := make(map[int]*LinkGraphNode)
+	for _, node := range g.Nodes {
+		if keep(node) {
+			node.Outgoing, node.Incoming = 0, 0
+			kept[node.DocumentId] = node
+			graph.Nodes = append(graph.Nodes, node)
+		}
+	}
+	for _, edge := range g.Edges {
+		source, ok1 := kept[edge.Source]
+		target, ok2 := kept[edge.Target]
+		if ok1 && ok2 {
+			source.Outgoing++
+			target.Incoming++
+			graph.Edges = append(graph.Edges, edge)
+		}
+	}
+	return graph
+}
+
+//将查询结果转换为ID列表.
+func paramsToIds(values orm.ParamsList) []int {
+	ids := make([]int, 0, len(values))
+	for _, value := range values {
+		if id, err := strconv.Atoi(fmt.Sprint(value)); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+//拆分逗号分隔的标签.
+func splitLabels(label string) map[string]bool {
+	labels := make(map[string]bool)
+	for _, item := range strings.Split(strings.Replace(label, "，", ",", -1), ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			labels[item] = true
+		}
+	}
+	return labels
+}
@@ -0,0 +1,384 @@
+package models
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/utils"
+	"github.com/astaxie/beego/orm"
+)
+
+//自定义字段的最大数量.
+const metaFieldMaxCount = 50
+
+//复查日期的格式.
+const metaDateLayout = "2006-01-02"
+
+//文档元数据，保存文档时从 Markdown 开头的 YAML 元数据解析，元数据中的 tags 同步为文档标签.
+type DocumentMeta struct {
+	MetaId      int       `orm:"pk;auto;column(meta_id)" json:"-"`
+	DocumentId  int       `orm:"column(document_id);type(int);unique" json:"doc_id"`
+	Title       string    `orm:"column(title);size(500)" json:"title"`
+	Description string    `orm:"column(description);size(2000)" json:"description"`
+	Author      string    `orm:"column(author);size(100);index" json:"author"`
+	Owner       string    `orm:"column(owner);size(100);index" json:"owner"`
+	ReviewBy    time.Time `orm:"column(review_by);type(date);null" json:"-"` //复查日期
+	ModifyTime  time.Time `orm:"column(modify_time);type(datetime);auto_now" json:"modify_time"`
+	ReviewDate  string    `orm:"-" json:"review_by"`
+	Tags        string    `orm:"-" json:"tags"`
+	//元数据中是否设置了 tags，未设置时不修改文档标签
+	HasTags bool                 `orm:"-" json:"-"`
+	Fields  []*DocumentMetaField `orm:"-" json:"fields"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *DocumentMeta) TableName() string {
+	return "document_meta"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *DocumentMeta) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *DocumentMeta) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewDocumentMeta() *DocumentMeta {
+	return &DocumentMeta{Fields: make([]*DocumentMetaField, 0)}
+}
+
+//文档元数据中的自定义字段.
+type DocumentMetaField struct {
+	FieldId    int    `orm:"pk;auto;column(field_id)" json:"-"`
+	DocumentId int    `orm:"column(document_id);type(int);index" json:"-"`
+	FieldKey   string `orm:"column(field_key);size(100)" json:"key"`
+	FieldValue string `orm:"column(field_value);size(1000)" json:"value"`
+	OrderSort  int    `orm:"column(order_sort);default(0)" json:"-"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *DocumentMetaField) TableName() string {
+	return "document_meta_field"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *DocumentMetaField) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *DocumentMetaField) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+// 多字段唯一键
+func (m *DocumentMetaField) TableUnique() [][]string {
+	return [][]string{{"document_id", "field_key"}}
+}
+
+func NewDocumentMetaField() *DocumentMetaField {
+	return &DocumentMetaField{}
+}
+
+//统一元数据的键名，忽略大小写并将 - 和空格视为 _.
+func metaKey(key string) string {
+	return strings.Replace(strings.Replace(strings.ToLower(strings.TrimSpace(key)), "-", "_", -1), " ", "_", -1)
+}
+
+//截断超长的值.
+func metaValue(value string, length int) string {
+	value = strings.TrimSpace(value)
+	if utf8.RuneCountInString(value) > length {
+		value = string([]rune(value)[:length])
+	}
+	return value
+}
+
+//元数据项的文本值，列表以逗号连接.
+func frontMatterText(field utils.FrontMatterField) string {
+	if field.IsList {
+		return strings.Join(field.List, ", ")
+	}
+	return field.Value
+}
+
+//从 Markdown 开头的元数据解析文档元数据，没有元数据时返回 nil.
+//title、description、tags、author、owner 和 review_by 为预定义字段，custom 下的键值对和其它字段作为自定义字段.
+func ParseDocumentMeta(markdown string) *DocumentMeta {
+	fields, _, ok := utils.ParseFrontMatter(markdown)
+	if !ok {
+		return nil
+	}
+	meta := NewDocumentMeta()
+	for _, field := range fields {
+		switch metaKey(field.Key) {
+		case "title":
+			meta.Title = frontMatterText(field)
+		case "description", "summary":
+			meta.Description = frontMatterText(field)
+		case "tags", "labels":
+			meta.HasTags = true
+			meta.Tags = strings.Join(field.List, ",")
+			if !field.IsList {
+				meta.Tags = field.Value
+			}
+		case "author":
+			meta.Author = frontMatterText(field)
+		case "owner":
+			meta.Owner = frontMatterText(field)
+		case "review_by", "review_date":
+			meta.ReviewDate = field.Value
+		case "custom", "fields":
+			for _, child := range field.Children {
+				meta.SetField(child.Key, child.Value)
+			}
+		default:
+			if len(field.Children) == 0 {
+				meta.SetField(field.Key, frontMatterText(field))
+			}
+		}
+	}
+	meta.normalize()
+	return meta
+}
+
+//设置自定义字段，键名相同时覆盖原来的值.
+func (m *DocumentMeta) SetField(key, value string) {
+	key = metaValue(key, 100)
+	if key == "" {
+		return
+	}
+	for _, field := range m.Fields {
+		if strings.EqualFold(field.FieldKey, key) {
+			field.FieldValue = metaValue(value, 1000)
+			return
+		}
+	}
+	if len(m.Fields) < metaFieldMaxCount {
+		m.Fields = append(m.Fields, &DocumentMetaField{FieldKey: key, FieldValue: metaValue(value, 1000)})
+	}
+}
+
+//截断超长的值并解析复查日期，日期格式错误时忽略.
+func (m *DocumentMeta) normalize() {
+	m.Title = metaValue(m.Title, 500)
+	m.Description = metaValue(m.Description, 2000)
+	m.Author = metaValue(m.Author, 100)
+	m.Owner = metaValue(m.Owner, 100)
+	m.ReviewBy = time.Time{}
+	if t, err := time.ParseInLocation(metaDateLayout, strings.TrimSpace(m.ReviewDate), time.Local); err == nil {
+		m.ReviewBy = t
+	}
+	m.formatReviewDate()
+}
+
+func (m *DocumentMeta) formatReviewDate() {
+	m.ReviewDate = ""
+	if !m.ReviewBy.IsZero() {
+		m.ReviewDate = m.ReviewBy.Format(metaDateLayout)
+	}
+}
+
+//设置复查日期，格式为 2006-01-02，为空时清除.
+func (m *DocumentMeta) SetReviewDate(date string) error {
+	date = strings.TrimSpace(date)
+	if date == "" {
+		m.ReviewBy = time.Time{}
+	} else if t, err := time.ParseInLocation(metaDateLayout, date, time.Local); err != nil {
+		return ErrInvalidParameter
+	} else {
+		m.ReviewBy = t
+	}
+	m.formatReviewDate()
+	return nil
+}
+
+//是否没有任何元数据.
+func (m *DocumentMeta) IsEmpty() bool {
+	return m.Title == "" && m.Description == "" && m.Author == "" && m.Owner == "" &&
+		m.ReviewBy.IsZero() && len(m.Fields) == 0 && m.Tags == ""
+}
+
+//转换为 Markdown 开头的元数据.
+func (m *DocumentMeta) FrontMatter() []utils.FrontMatterField {
+	fields := make([]utils.FrontMatterField, 0)
+	for _, item := range []struct{ key, value string }{
+		{"title", m.Title},
+		{"description", m.Description},
+		{"author", m.Author},
+		{"owner", m.Owner},
+		{"review_by", m.ReviewDate},
+	} {
+		if item.value != "" {
+			fields = append(fields, utils.FrontMatterField{Key: item.key, Value: item.value})
+		}
+	}
+	if tags := ParseLabels(m.Tags); len(tags) > 0 {
+		fields = append(fields, utils.FrontMatterField{Key: "tags", IsList: true, List: tags})
+	}
+	if len(m.Fields) > 0 {
+		custom := utils.FrontMatterField{Key: "custom"}
+		for _, field := range m.Fields {
+			custom.Children = append(custom.Children, utils.FrontMatterField{Key: field.FieldKey, Value: field.FieldValue})
+		}
+		fields = append(fields, custom)
+	}
+	return fields
+}
+
+//根据文档内容更新文档元数据，元数据中设置了 tags 时同步更新文档标签.
+func (m *DocumentMeta) Replace(doc_id int, markdown string) (*DocumentMeta, error) {
+	meta := ParseDocumentMeta(markdown)
+	if meta == nil {
+		return nil, m.DeleteByDocumentId(doc_id)
+	}
+	if err := meta.Save(doc_id); err != nil {
+		return meta, err
+	}
+	if meta.HasTags {
+		//与编辑器中设置标签的限制一致，最多保留10个标签
+		names := ParseLabels(meta.Tags)
+		if len(names) > 10 {
+			names = names[:10]
+		}
+		tags, err := NewDocumentLabel().Replace(doc_id, strings.Join(names, ","))
+		if err != nil {
+			return meta, err
+		}
+		meta.Tags = tags
+	}
+	return meta, nil
+}
+
+//保存文档元数据和自定义字段.
+func (m *DocumentMeta) Save(doc_id int) error {
+	o := orm.NewOrm()
+	m.DocumentId = doc_id
+	if err := o.Begin(); err != nil {
+		return err
+	}
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id", doc_id).Delete(); err != nil {
+		o.Rollback()
+		return err
+	}
+	if _, err := o.QueryTable(NewDocumentMetaField().TableNameWithPrefix()).Filter("document_id", doc_id).Delete(); err != nil {
+		o.Rollback()
+		return err
+	}
+	m.MetaId = 0
+	if _, err := o.Insert(m); err != nil {
+		o.Rollback()
+		return err
+	}
+	for i, field := range m.Fields {
+		field.FieldId = 0
+		field.DocumentId = doc_id
+		field.OrderSort = i
+		if _, err := o.Insert(field); err != nil {
+			o.Rollback()
+			return err
+		}
+	}
+	return o.Commit()
+}
+
+//查询文档元数据，没有元数据时返回空的元数据.
+func (m *DocumentMeta) Find(doc_id int) (*DocumentMeta, error) {
+	o := orm.NewOrm()
+	meta := NewDocumentMeta()
+	if err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id", doc_id).One(meta); err != nil && err != orm.ErrNoRows {
+		return nil, err
+	}
+	meta.DocumentId = doc_id
+	meta.formatReviewDate()
+	if _, err := o.QueryTable(NewDocumentMetaField().TableNameWithPrefix()).Filter("document_id", doc_id).OrderBy("order_sort", "field_id").Limit(-1).All(&meta.Fields); err != nil {
+		return nil, err
+	}
+	meta.Tags = NewDocumentLabel().FindLabelNames(doc_id)
+	return meta, nil
+}
+
+//删除文档元数据.
+func (m *DocumentMeta) DeleteByDocumentId(doc_id ...interface{}) error {
+	if len(doc_id) == 0 {
+		return nil
+	}
+	o := orm.NewOrm()
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id__in", doc_id...).Delete(); err != nil {
+		return err
+	}
+	_, err := o.QueryTable(NewDocumentMetaField().TableNameWithPrefix()).Filter("document_id__in", doc_id...).Delete()
+	return err
+}
+
+//复制文档元数据到新文档，ids 为源文档ID到新文档ID的映射.
+func (m *DocumentMeta) Copy(ids map[int]int) error {
+	for id, new_id := range ids {
+		meta, err := m.Find(id)
+		if err != nil {
+			return err
+		}
+		if meta.MetaId == 0 {
+			continue
+		}
+		if err := meta.Save(new_id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//按元数据过滤文档的条件.
+type DocumentMetaFilter struct {
+	Author     string
+	Owner      string
+	Tag        string
+	FieldKey   string
+	FieldValue string
+	//复查日期不晚于该日期
+	ReviewBefore time.Time
+}
+
+//是否没有设置任何条件.
+func (f *DocumentMetaFilter) IsEmpty() bool {
+	return f == nil || (f.Author == "" && f.Owner == "" && f.Tag == "" && f.FieldKey == "" && f.ReviewBefore.IsZero())
+}
+
+//生成过滤文档的 SQL 条件，以 AND 开头，column 为文档ID的列名.
+func (f *DocumentMetaFilter) where(column string) (string, []interface{}) {
+	if f.IsEmpty() {
+		return "", nil
+	}
+	meta_table := NewDocumentMeta().TableNameWithPrefix()
+	sql := ""
+	args := make([]interface{}, 0)
+	if f.Author != "" {
+		sql += " AND " + column + " IN (SELECT document_id FROM " + meta_table + " WHERE author = ?)"
+		args = append(args, f.Author)
+	}
+	if f.Owner != "" {
+		sql += " AND " + column + " IN (SELECT document_id FROM " + meta_table + " WHERE owner = ?)"
+		args = append(args, f.Owner)
+	}
+	if !f.ReviewBefore.IsZero() {
+		sql += " AND " + column + " IN (SELECT document_id FROM " + meta_table + " WHERE review_by <= ?)"
+		args = append(args, f.ReviewBefore.Format(metaDateLayout))
+	}
+	if f.Tag != "" {
+		sql += " AND " + column + " IN (" + labelDocumentIdSubQuery() + ")"
+		args = append(args, f.Tag)
+	}
+	if f.FieldKey != "" {
+		sql += " AND " + column + " IN (SELECT document_id FROM " + NewDocumentMetaField().TableNameWithPrefix() + " WHERE field_key = ?"
+		args = append(args, f.FieldKey)
+		if f.FieldValue != "" {
+			sql += " AND field_value = ?"
+			args = append(args, f.FieldValue)
+		}
+		sql += ")"
+	}
+	return sql, args
+}
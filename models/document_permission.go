@@ -0,0 +1,353 @@
+package models
+
+import (
+	"time"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/astaxie/beego/orm"
+)
+
+const (
+	//阅读权限.
+	DocumentPermissionRead = "read"
+	//编辑权限.
+	DocumentPermissionEdit = "edit"
+)
+
+//文档权限规则.
+//规则作用于文档及其所有子文档，文档路径上的所有规则都需要满足才能访问.
+//同一个文档同一种权限的多条规则之间是"或"的关系：满足角色要求或在授权用户列表中即可.
+type DocumentPermission struct {
+	PermissionId int       `orm:"pk;auto;column(permission_id)" json:"permission_id"`
+	BookId       int       `orm:"column(book_id);type(int);index" json:"book_id"`
+	DocumentId   int       `orm:"column(document_id);type(int);index" json:"doc_id"`
+	Action       string    `orm:"column(action);size(20)" json:"action"`                  //权限类型：read 阅读/edit 编辑
+	RoleId       int       `orm:"column(role_id);type(int);default(-1)" json:"role_id"`   //允许的最低项目角色：1 管理员/2 编辑者/3 观察者，-1 表示按用户授权
+	MemberId     int       `orm:"column(member_id);type(int);default(0)" json:"member_id"` //单独授权的用户
+	CreateAt     int       `orm:"column(create_at);type(int)" json:"create_at"`
+	CreateTime   time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+	DocumentName string    `orm:"-" json:"doc_name"`
+	Account      string    `orm:"-" json:"account"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *DocumentPermission) TableName() string {
+	return "document_permission"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *DocumentPermission) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *DocumentPermission) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewDocumentPermission() *DocumentPermission {
+	return &DocumentPermission{}
+}
+
+//添加权限规则.
+func (m *DocumentPermission) Insert() error {
+	if m.Action != DocumentPermissionRead && m.Action != DocumentPermissionEdit {
+		return ErrInvalidParameter
+	}
+	if m.MemberId <= 0 && (m.RoleId < conf.BookAdmin || m.RoleId > conf.BookObserver) {
+		return ErrInvalidParameter
+	}
+	if m.MemberId > 0 {
+		m.RoleId = -1
+	}
+	o := orm.NewOrm()
+	qs := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id", m.DocumentId).Filter("action", m.Action)
+	//角色规则每个文档每种权限只保留一条
+	if m.MemberId <= 0 {
+		qs.Filter("member_id", 0).Delete()
+	} else if qs.Filter("member_id", m.MemberId).Exist() {
+		return nil
+	}
+	_, err := o.Insert(m)
+	return err
+}
+
+//删除权限规则.
+func (m *DocumentPermission) Delete(book_id, permission_id int) error {
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).Filter("permission_id", permission_id).Delete()
+	return err
+}
+
+//删除文档的全部权限规则.
+func (m *DocumentPermission) DeleteByDocumentId(doc_id ...interface{}) error {
+	if len(doc_id) == 0 {
+		return nil
+	}
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("document_id__in", doc_id...).Delete()
+	return err
+}
+
+//查询项目下的全部权限规则.
+func (m *DocumentPermission) FindByBookId(book_id int) (rules []*DocumentPermission, err error) {
+	_, err = orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).OrderBy("document_id", "action", "permission_id").All(&rules)
+	return
+}
+
+//查询项目下的权限规则，并填充文档名称和用户账号.
+func (m *DocumentPermission) FindResultByBookId(book_id int) ([]*DocumentPermission, error) {
+	rules, err := m.FindByBookId(book_id)
+	if err != nil || len(rules) == 0 {
+		return rules, err
+	}
+	o := orm.NewOrm()
+	for _, rule := range rules {
+		var doc Document
+		if o.QueryTable(NewDocument().TableNameWithPrefix()).Filter("document_id", rule.DocumentId).One(&doc, "document_name") == nil {
+			rule.DocumentName = doc.DocumentName
+		}
+		if rule.MemberId > 0 {
+			var member Member
+			if o.QueryTable(NewMember().TableNameWithPrefix()).Filter("member_id", rule.MemberId).One(&member, "account") == nil {
+				rule.Account = member.Account
+			}
+		}
+	}
+	return rules, nil
+}
+
+//用户在项目中的文档访问控制.
+type DocumentAccess struct {
+	BookId   int
+	MemberId int
+	//用户在项目中的角色，-1 表示不是项目成员
+	RoleId int
+	//超级管理员和项目创始人不受文档权限限制
+	bypass  bool
+	rules   map[int][]*DocumentPermission
+	parents map[int]int
+	//通过分享链接访问时只能阅读该文档及其子文档
+	root int
+	//读者不能访问的未发布文档
+	hidden map[int]bool
+}
+
+//创建文档访问控制，role_id 为 -1 表示用户不是项目成员.
+func NewDocumentAccess(book_id, member_id, role_id int, is_admin bool) *DocumentAccess {
+	access := &DocumentAccess{
+		BookId:   book_id,
+		MemberId: member_id,
+		RoleId:   role_id,
+		bypass:   is_admin || role_id == conf.BookFounder,
+		rules:    make(map[int][]*DocumentPermission),
+		parents:  make(map[int]int),
+	}
+	if access.bypass {
+		return access
+	}
+	rules, err := NewDocumentPermission().FindByBookId(book_id)
+	if err != nil || len(rules) == 0 {
+		return access
+	}
+	for _, rule := range rules {
+		access.rules[rule.DocumentId] = append(access.rules[rule.DocumentId], rule)
+	}
+	access.loadParents()
+	return access
+}
+
+//加载项目的文档层级关系.
+func (m *DocumentAccess) loadParents() {
+	var docs []*Document
+	orm.NewOrm().QueryTable(NewDocument().TableNameWithPrefix()).Filter("book_id", m.BookId).Limit(-1).All(&docs, "document_id", "parent_id")
+	for _, doc := range docs {
+		m.parents[doc.DocumentId] = doc.ParentId
+	}
+}
+
+//限制只能阅读指定文档及其子文档，doc_id 为 0 表示不限制.
+func (m *DocumentAccess) LimitTo(doc_id int) *DocumentAccess {
+	m.root = doc_id
+	if doc_id > 0 && len(m.parents) == 0 {
+		m.loadParents()
+	}
+	return m
+}
+
+//只允许访问已发布的文档，未发布文档的子文档同样不能访问.
+func (m *DocumentAccess) PublishedOnly() *DocumentAccess {
+	var docs []*Document
+	orm.NewOrm().QueryTable(NewDocument().TableNameWithPrefix()).
+		Filter("book_id", m.BookId).
+		Exclude("publish_status", conf.DocumentPublished).Limit(-1).All(&docs, "document_id")
+	if len(docs) == 0 {
+		return m
+	}
+	m.hidden = make(map[int]bool, len(docs))
+	for _, doc := range docs {
+		m.hidden[doc.DocumentId] = true
+	}
+	if len(m.parents) == 0 {
+		m.loadParents()
+	}
+	return m
+}
+
+//使用指定的文档层级关系校验权限，用于项目版本中已移动或删除的文档.
+func (m *DocumentAccess) UseParents(parents map[int]int) *DocumentAccess {
+	for doc_id, parent_id := range parents {
+		m.parents[doc_id] = parent_id
+	}
+	return m
+}
+
+//文档或其上级文档是否未发布.
+func (m *DocumentAccess) isHidden(doc_id int) bool {
+	for depth := 0; len(m.hidden) > 0 && doc_id > 0 && depth < 100; depth++ {
+		if m.hidden[doc_id] {
+			return true
+		}
+		doc_id = m.parents[doc_id]
+	}
+	return false
+}
+
+//文档是否在允许访问的范围内.
+func (m *DocumentAccess) inScope(doc_id int) bool {
+	if m.root <= 0 {
+		return true
+	}
+	for depth := 0; doc_id > 0 && depth < 100; depth++ {
+		if doc_id == m.root {
+			return true
+		}
+		doc_id = m.parents[doc_id]
+	}
+	return false
+}
+
+//是否不受任何限制.
+func (m *DocumentAccess) unrestricted() bool {
+	return m.root <= 0 && len(m.hidden) == 0 && (m.bypass || len(m.rules) == 0)
+}
+
+//根据用户在项目中的角色创建文档访问控制，member 为 nil 表示匿名用户.
+func NewDocumentAccessForMember(book_id int, member *Member) *DocumentAccess {
+	member_id, role_id, is_admin := 0, -1, false
+	if member != nil && member.MemberId > 0 {
+		member_id = member.MemberId
+		is_admin = member.IsAdministrator()
+		if rid, err := NewRelationship().FindEffectiveRoleId(book_id, member_id); err == nil {
+			role_id = rid
+		}
+	}
+	return NewDocumentAccess(book_id, member_id, role_id, is_admin)
+}
+
+//是否存在文档权限规则.
+func (m *DocumentAccess) HasRules() bool {
+	return len(m.rules) > 0 || m.root > 0 || len(m.hidden) > 0
+}
+
+//校验单个文档上的规则.
+func (m *DocumentAccess) allow(doc_id int, action string) bool {
+	restricted := false
+	for _, rule := range m.rules[doc_id] {
+		if rule.Action != action {
+			continue
+		}
+		restricted = true
+		if rule.MemberId > 0 && rule.MemberId == m.MemberId {
+			return true
+		}
+		if rule.MemberId <= 0 && m.RoleId >= 0 && m.RoleId <= rule.RoleId {
+			return true
+		}
+	}
+	return !restricted
+}
+
+//沿文档路径逐级校验规则.
+func (m *DocumentAccess) check(doc_id int, actions ...string) bool {
+	if !m.inScope(doc_id) || m.isHidden(doc_id) {
+		return false
+	}
+	if m.bypass || len(m.rules) == 0 {
+		return true
+	}
+	//防止数据异常导致死循环
+	for depth := 0; doc_id > 0 && depth < 100; depth++ {
+		for _, action := range actions {
+			if !m.allow(doc_id, action) {
+				return false
+			}
+		}
+		doc_id = m.parents[doc_id]
+	}
+	return true
+}
+
+//是否可以阅读文档.
+func (m *DocumentAccess) CanRead(doc_id int) bool {
+	return m.check(doc_id, DocumentPermissionRead)
+}
+
+//是否可以编辑文档，编辑文档同时需要阅读权限.
+func (m *DocumentAccess) CanEdit(doc_id int) bool {
+	return m.check(doc_id, DocumentPermissionRead, DocumentPermissionEdit)
+}
+
+//是否可以编辑文档及其全部子文档.
+func (m *DocumentAccess) CanEditTree(doc_id int) bool {
+	if !m.CanEdit(doc_id) {
+		return false
+	}
+	if m.unrestricted() {
+		return true
+	}
+	for id, pid := range m.parents {
+		if pid == doc_id && !m.CanEditTree(id) {
+			return false
+		}
+	}
+	return true
+}
+
+//过滤没有阅读权限的文档树节点.
+func (m *DocumentAccess) FilterTree(trees []*DocumentTree) []*DocumentTree {
+	return m.filterTree(trees, m.CanRead)
+}
+
+//过滤没有编辑权限的文档树节点.
+func (m *DocumentAccess) FilterEditTree(trees []*DocumentTree) []*DocumentTree {
+	return m.filterTree(trees, m.CanEdit)
+}
+
+func (m *DocumentAccess) filterTree(trees []*DocumentTree, can func(int) bool) []*DocumentTree {
+	if m.unrestricted() {
+		return trees
+	}
+	result := make([]*DocumentTree, 0, len(trees))
+	for _, tree := range trees {
+		if can(tree.DocumentId) {
+			//限制访问范围时将该文档作为顶级节点
+			if tree.DocumentId == m.root {
+				tree.ParentId = "#"
+			}
+			result = append(result, tree)
+		}
+	}
+	return result
+}
+
+//过滤没有阅读权限的文档.
+func (m *DocumentAccess) FilterDocuments(docs []*Document) []*Document {
+	if m.unrestricted() {
+		return docs
+	}
+	result := make([]*Document, 0, len(docs))
+	for _, doc := range docs {
+		if m.CanRead(doc.DocumentId) {
+			result = append(result, doc)
+		}
+	}
+	return result
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+var publishOnce sync.Once
+
+//发布状态名称.
+func PublishStatusName(status int) string {
+	switch status {
+	case conf.DocumentDraft:
+		return "草稿"
+	case conf.DocumentPublished:
+		return "已发布"
+	case conf.DocumentUnpublished:
+		return "已取消发布"
+	}
+	return ""
+}
+
+//草稿是否有尚未发布的修改.
+func (m *Document) HasUnpublishedChanges() bool {
+	return m.PublishStatus != conf.DocumentPublished || m.Version != m.PublishVersion
+}
+
+//是否设置了定时发布.
+func (m *Document) IsScheduled() bool {
+	return !m.ScheduleTime.IsZero()
+}
+
+//立即发布文档的草稿，开启审阅的项目发布最后一次审阅通过的修订.
+//member_id 为发布文档的用户，用于校验文档引用的内容.
+func (m *Document) Publish(doc_id, member_id int) error {
+	doc, err := NewDocument().Find(doc_id)
+	if err != nil {
+		return err
+	}
+	book, err := NewBook().Find(doc.BookId)
+	if err != nil {
+		return err
+	}
+	content, version := "", doc.Version
+	if book.EnableReview == 1 {
+		review, err := NewDocumentReview().FindLatestApproved(doc.DocumentId)
+		if err != nil {
+			return errors.New("文档没有审阅通过的修订")
+		}
+		content, version = review.Content, review.Version
+	} else {
+		content = new(DocumentStore).GetFiledById(doc.DocumentId, "content")
+	}
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return errors.New("文档内容为空，请先在编辑器中保存文档")
+	}
+	return markPublished(orm.NewOrm(), doc.DocumentId, member_id, content+releaseAttachList(doc.DocumentId), version)
+}
+
+//取消发布文档，读者将无法访问该文档及其子文档.
+func (m *Document) Unpublish(doc_id int) error {
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("document_id", doc_id).Update(orm.Params{
+		"publish_status": conf.DocumentUnpublished,
+		"schedule_time":  nil,
+	})
+	return err
+}
+
+//设置定时发布时间，时间为零值时取消定时发布.
+func (m *Document) Schedule(doc_id int, t time.Time) error {
+	var value interface{}
+	if !t.IsZero() {
+		if t.Before(time.Now()) {
+			return errors.New("定时发布时间不能早于当前时间")
+		}
+		value = t
+	}
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("document_id", doc_id).Update(orm.Params{
+		"schedule_time": value,
+	})
+	return err
+}
+
+//更新文档的发布内容和发布状态.
+func markPublished(o orm.Ormer, doc_id, member_id int, release string, version int64) error {
+	release = ResolveIncludes(doc_id, member_id, release)
+	_, err := o.QueryTable(NewDocument().TableNameWithPrefix()).Filter("document_id", doc_id).Update(orm.Params{
+		"release":         release,
+		"publish_status":  conf.DocumentPublished,
+		"publish_version": version,
+		"publish_time":    time.Now(),
+		"schedule_time":   nil,
+	})
+	if err == nil {
+		if err := NewDocumentLink().Replace(doc_id, release); err != nil {
+			beego.Error("更新文档链接关系失败 => ", err)
+		}
+		if err := NotifyDocumentPublish(doc_id, member_id); err != nil {
+			beego.Error("发送文档发布通知失败 => ", err)
+		}
+	}
+	return err
+}
+
+//发布到期的定时发布文档，以最后修改文档的用户发布，发布失败时取消定时，避免反复重试.
+func PublishScheduledDocuments(now time.Time) error {
+	var docs []*Document
+	_, err := orm.NewOrm().QueryTable(NewDocument().TableNameWithPrefix()).
+		Filter("schedule_time__isnull", false).
+		Filter("schedule_time__lte", now).Limit(-1).All(&docs, "document_id", "document_name", "modify_at")
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err := NewDocument().Publish(doc.DocumentId, doc.ModifyAt); err != nil {
+			beego.Error("定时发布文档失败 => ", doc.DocumentId, doc.DocumentName, err)
+			if err := NewDocument().Schedule(doc.DocumentId, time.Time{}); err != nil {
+				beego.Error("取消定时发布失败 => ", err)
+			}
+		}
+	}
+	return nil
+}
+
+//启动定时发布任务，每分钟检查一次到期的文档.
+func StartScheduledPublish() {
+	publishOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				if err := PublishScheduledDocuments(now); err != nil {
+					beego.Error("定时发布文档失败 => ", err)
+				}
+			}
+		}()
+	})
+}
@@ -0,0 +1,232 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/astaxie/beego/orm"
+)
+
+//文档审阅，提交时保存文档内容的快照，开启审阅的项目只发布审阅通过的快照.
+type DocumentReview struct {
+	ReviewId   int       `orm:"pk;auto;column(review_id)" json:"review_id"`
+	BookId     int       `orm:"column(book_id);type(int);index" json:"book_id"`
+	DocumentId int       `orm:"column(document_id);type(int);index" json:"doc_id"`
+	MemberId   int       `orm:"column(member_id);type(int)" json:"member_id"` //提交人
+	Markdown   string    `orm:"column(markdown);type(text);null" json:"-"`
+	Content    string    `orm:"column(content);type(text);null" json:"-"`
+	Version    int64     `orm:"column(version);type(bigint);default(0)" json:"version"`
+	Message    string    `orm:"column(message);size(500)" json:"message"`
+	Status     int       `orm:"column(status);type(int);default(0)" json:"status"` //状态：0 待审阅/1 已通过/2 需要修改/3 已被取代
+	ReviewerId int       `orm:"column(reviewer_id);type(int);default(0)" json:"reviewer_id"`
+	Comment    string    `orm:"column(comment);size(1000)" json:"comment"` //审阅意见
+	CreateTime time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+	ReviewTime time.Time `orm:"column(review_time);type(datetime);null" json:"review_time"`
+
+	DocumentName    string `orm:"-" json:"doc_name"`
+	Account         string `orm:"-" json:"account"`
+	ReviewerAccount string `orm:"-" json:"reviewer_account"`
+	StatusName      string `orm:"-" json:"status_name"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *DocumentReview) TableName() string {
+	return "document_reviews"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *DocumentReview) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *DocumentReview) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewDocumentReview() *DocumentReview {
+	return &DocumentReview{}
+}
+
+//审阅状态名称.
+func ReviewStatusName(status int) string {
+	switch status {
+	case conf.ReviewPending:
+		return "待审阅"
+	case conf.ReviewApproved:
+		return "已通过"
+	case conf.ReviewRejected:
+		return "需要修改"
+	case conf.ReviewSuperseded:
+		return "已取代"
+	}
+	return ""
+}
+
+func (m *DocumentReview) Find(review_id int) (*DocumentReview, error) {
+	if review_id <= 0 {
+		return m, ErrInvalidParameter
+	}
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("review_id", review_id).One(m)
+	return m, err
+}
+
+//提交文档当前内容进行审阅，同一文档尚未审阅的提交会被新的提交取代.
+func (m *DocumentReview) Submit(doc *Document, member_id int, message string) error {
+	var ds = DocumentStore{DocumentId: doc.DocumentId}
+	o := orm.NewOrm()
+	if err := o.Read(&ds); err != nil {
+		return errors.New("文档内容为空，请先保存文档")
+	}
+	if err := o.Begin(); err != nil {
+		return err
+	}
+	_, err := o.QueryTable(m.TableNameWithPrefix()).
+		Filter("document_id", doc.DocumentId).
+		Filter("status", conf.ReviewPending).
+		Update(orm.Params{"status": conf.ReviewSuperseded})
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	m.BookId = doc.BookId
+	m.DocumentId = doc.DocumentId
+	m.MemberId = member_id
+	m.Markdown = ds.Markdown
+	m.Content = ds.Content
+	m.Version = doc.Version
+	m.Message = message
+	m.Status = conf.ReviewPending
+	if _, err := o.Insert(m); err != nil {
+		o.Rollback()
+		return err
+	}
+	return o.Commit()
+}
+
+//审阅通过或退回修改，只能审阅待审阅的提交.
+func (m *DocumentReview) Review(reviewer_id int, approved bool, comment string) error {
+	if m.Status != conf.ReviewPending {
+		return errors.New("该提交已审阅或已被新的提交取代")
+	}
+	m.Status = conf.ReviewRejected
+	if approved {
+		m.Status = conf.ReviewApproved
+	}
+	m.ReviewerId = reviewer_id
+	m.Comment = comment
+	m.ReviewTime = time.Now()
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).
+		Filter("review_id", m.ReviewId).
+		Filter("status", conf.ReviewPending).
+		Update(orm.Params{
+			"status":      m.Status,
+			"reviewer_id": m.ReviewerId,
+			"comment":     m.Comment,
+			"review_time": m.ReviewTime,
+		})
+	return err
+}
+
+//分页查询项目的审阅，status 小于 0 时查询全部状态.
+func (m *DocumentReview) FindToPager(book_id, status, pageIndex, pageSize int) (reviews []*DocumentReview, totalCount int, err error) {
+	if pageIndex <= 0 {
+		pageIndex = 1
+	}
+	qs := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id)
+	if status >= 0 {
+		qs = qs.Filter("status", status)
+	}
+	count, err := qs.Count()
+	if err != nil {
+		return
+	}
+	totalCount = int(count)
+
+	_, err = qs.OrderBy("-review_id").Offset((pageIndex-1)*pageSize).Limit(pageSize).All(&reviews, "review_id", "book_id", "document_id", "member_id", "version", "message", "status", "reviewer_id", "comment", "create_time", "review_time")
+	if err != nil {
+		return
+	}
+	accounts := make(map[int]string)
+	account := func(member_id int) string {
+		if member_id <= 0 {
+			return ""
+		}
+		if _, ok := accounts[member_id]; !ok {
+			accounts[member_id] = NewMember().GetUsernameByUid(member_id)
+		}
+		return accounts[member_id]
+	}
+	for _, review := range reviews {
+		if doc, err := NewDocument().Find(review.DocumentId); err == nil {
+			review.DocumentName = doc.DocumentName
+		}
+		review.Account = account(review.MemberId)
+		review.ReviewerAccount = account(review.ReviewerId)
+		review.StatusName = ReviewStatusName(review.Status)
+	}
+	return
+}
+
+//查询项目中每个文档最新一次提交的审阅状态，不包含已被取代的提交.
+func (m *DocumentReview) FindDocumentStates(book_id int) map[int]int {
+	states := make(map[int]int)
+	var reviews []*DocumentReview
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).
+		Filter("book_id", book_id).
+		Filter("status__in", conf.ReviewPending, conf.ReviewApproved, conf.ReviewRejected).
+		OrderBy("review_id").Limit(-1).All(&reviews, "review_id", "document_id", "status")
+	if err != nil {
+		return states
+	}
+	for _, review := range reviews {
+		states[review.DocumentId] = review.Status
+	}
+	return states
+}
+
+//查询指定时间后审阅通过的提交，每个文档只返回最后通过的一次.
+func (m *DocumentReview) FindApprovedSince(book_id int, since time.Time) ([]*DocumentReview, error) {
+	var reviews []*DocumentReview
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).
+		Filter("book_id", book_id).
+		Filter("status", conf.ReviewApproved).
+		Filter("review_time__gt", since).
+		OrderBy("-review_time", "-review_id").Limit(-1).All(&reviews)
+	if err != nil {
+		return nil, err
+	}
+	latest := make([]*DocumentReview, 0, len(reviews))
+	exists := make(map[int]bool)
+	for _, review := range reviews {
+		if !exists[review.DocumentId] {
+			exists[review.DocumentId] = true
+			latest = append(latest, review)
+		}
+	}
+	return latest, nil
+}
+
+//查询文档最后一次审阅通过的提交.
+func (m *DocumentReview) FindLatestApproved(doc_id int) (*DocumentReview, error) {
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).
+		Filter("document_id", doc_id).
+		Filter("status", conf.ReviewApproved).
+		OrderBy("-review_time", "-review_id").One(m)
+	return m, err
+}
+
+//删除项目的全部审阅.
+func (m *DocumentReview) DeleteByBookId(book_id int) error {
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).Delete()
+	return err
+}
+
+//删除文档的全部审阅.
+func (m *DocumentReview) DeleteByDocumentId(doc_id int) error {
+	if doc_id <= 0 {
+		return nil
+	}
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("document_id", doc_id).Delete()
+	return err
+}
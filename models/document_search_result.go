@@ -1,8 +1,10 @@
 package models
 
 import (
+	"strings"
 	"time"
 
+	"github.com/JermineHu/DocStack/conf"
 	"github.com/astaxie/beego/orm"
 )
 
@@ -24,68 +26,100 @@ func NewDocumentSearchResult() *DocumentSearchResult {
 	return &DocumentSearchResult{}
 }
 
-//分页全局搜索.
-func (m *DocumentSearchResult) FindToPager(keyword string, page_index, page_size, member_id int) (search_result []*DocumentSearchResult, total_count int, err error) {
-	o := orm.NewOrm()
-
-	offset := (page_index - 1) * page_size
+//分页全局搜索，filter 为按文档元数据过滤的条件，readable 用于过滤没有阅读权限的文档.
+func (m *DocumentSearchResult) FindToPager(keyword string, filter *DocumentMetaFilter, page_index, page_size, member_id int, readable func(book_id, doc_id int) bool) (search_result []*DocumentSearchResult, total_count int, err error) {
 	keyword = "%" + keyword + "%"
+	filter_sql, filter_args := filter.where("doc.document_id")
 
+	var sql string
+	var args []interface{}
 	if member_id <= 0 {
-		sql1 := `SELECT count(doc.document_id) as total_count FROM md_documents AS doc
-  LEFT JOIN md_books as book ON doc.book_id = book.book_id
-WHERE book.privately_owned = 0 AND (doc.document_name LIKE ? OR doc.release LIKE ?) `
-
-		sql2 := `SELECT doc.document_id,doc.modify_time,doc.create_time,doc.document_name,doc.identify,doc.release as description,doc.modify_time,book.identify as book_identify,book.book_name,rel.member_id,member.account AS author FROM md_documents AS doc
+		sql = `SELECT doc.document_id,doc.book_id FROM md_documents AS doc
   LEFT JOIN md_books as book ON doc.book_id = book.book_id
-  LEFT JOIN md_relationship AS rel ON book.book_id = rel.book_id AND rel.role_id = 0
-  LEFT JOIN md_members as member ON rel.member_id = member.member_id
-WHERE book.privately_owned = 0 AND (doc.document_name LIKE ? OR doc.release LIKE ?)
- ORDER BY doc.document_id DESC LIMIT ?,? `
+WHERE book.privately_owned = 0 AND book.status = 0 AND (doc.document_name LIKE ? OR doc.release LIKE ?)` + filter_sql + `
+ ORDER BY doc.document_id DESC`
 
-		err = o.Raw(sql1, keyword, keyword).QueryRow(&total_count)
-		if err != nil {
-			return
-		}
-		_, err = o.Raw(sql2, keyword, keyword, offset, page_size).QueryRows(&search_result)
-		if err != nil {
-			return
-		}
+		args = append([]interface{}{keyword, keyword}, filter_args...)
 	} else {
-		sql1 := `SELECT count(doc.document_id) as total_count FROM md_documents AS doc
+		sql = `SELECT doc.document_id,doc.book_id FROM md_documents AS doc
   LEFT JOIN md_books as book ON doc.book_id = book.book_id
-  LEFT JOIN md_relationship AS rel ON doc.book_id = rel.book_id AND rel.role_id = 0
   LEFT JOIN md_relationship AS rel1 ON doc.book_id = rel1.book_id AND rel1.member_id = ?
-WHERE (book.privately_owned = 0 OR rel1.relationship_id > 0)  AND (doc.document_name LIKE ? OR doc.release LIKE ?) `
+WHERE (book.privately_owned = 0 OR rel1.relationship_id > 0 OR book.book_id IN (` + grantedBookIdSubQuery() + `)) AND book.status = 0 AND (doc.document_name LIKE ? OR doc.release LIKE ?)` + filter_sql + `
+ ORDER BY doc.document_id DESC`
+
+		args = append([]interface{}{member_id, member_id, member_id, keyword, keyword}, filter_args...)
+	}
+	ids, err := readableDocumentIds(sql, args, readable)
+	if err != nil {
+		return
+	}
+	return m.findPage(ids, page_index, page_size, "doc.document_id DESC")
+}
+
+//查询符合条件的文档并在分页前过滤没有阅读权限的文档，保证总数和分页准确.
+//sql 需要按顺序返回 document_id 和 book_id，readable 为 nil 时不过滤.
+func readableDocumentIds(sql string, args []interface{}, readable func(book_id, doc_id int) bool) ([]interface{}, error) {
+	var docs []*DocumentSearchResult
+	if _, err := orm.NewOrm().Raw(sql, args...).QueryRows(&docs); err != nil {
+		return nil, err
+	}
+	ids := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		if readable == nil || readable(doc.BookId, doc.DocumentId) {
+			ids = append(ids, doc.DocumentId)
+		}
+	}
+	return ids, nil
+}
+
+//查询一页文档的详细信息，ids 为全部符合条件的文档ID.
+func (m *DocumentSearchResult) findPage(ids []interface{}, page_index, page_size int, order string) (search_result []*DocumentSearchResult, total_count int, err error) {
+	total_count = len(ids)
+	offset := (page_index - 1) * page_size
+	if offset < 0 || offset >= total_count {
+		return
+	}
+	end := offset + page_size
+	if end > total_count {
+		end = total_count
+	}
+	page := ids[offset:end]
 
-		sql2 := `SELECT doc.document_id,doc.modify_time,doc.create_time,doc.document_name,doc.identify,doc.release as description,doc.modify_time,book.identify as book_identify,book.book_name,rel.member_id,member.account AS author FROM md_documents AS doc
+	sql := `SELECT doc.document_id,doc.book_id,doc.modify_time,doc.create_time,doc.document_name,doc.identify,doc.release as description,book.identify as book_identify,book.book_name,rel.member_id,member.account AS author FROM md_documents AS doc
   LEFT JOIN md_books as book ON doc.book_id = book.book_id
   LEFT JOIN md_relationship AS rel ON book.book_id = rel.book_id AND rel.role_id = 0
   LEFT JOIN md_members as member ON rel.member_id = member.member_id
-  LEFT JOIN md_relationship AS rel1 ON doc.book_id = rel1.book_id AND rel1.member_id = ?
-WHERE (book.privately_owned = 0 OR rel1.relationship_id > 0)  AND (doc.document_name LIKE ? OR doc.release LIKE ?)
- ORDER BY doc.document_id DESC LIMIT ?,? `
+WHERE doc.document_id IN (` + strings.TrimSuffix(strings.Repeat("?,", len(page)), ",") + `)
+ ORDER BY ` + order
 
-		err = o.Raw(sql1, member_id, keyword, keyword).QueryRow(&total_count)
-		if err != nil {
-			return
-		}
-		_, err = o.Raw(sql2, member_id, keyword, keyword, offset, page_size).QueryRows(&search_result)
-		if err != nil {
-			return
-		}
-	}
+	_, err = orm.NewOrm().Raw(sql, page...).QueryRows(&search_result)
 	return
 }
 
-//项目内搜索.
-func (m *DocumentSearchResult) SearchDocument(keyword string, book_id int) (docs []*DocumentSearchResult, err error) {
+//项目内搜索，filter 为按文档元数据过滤的条件.
+func (m *DocumentSearchResult) SearchDocument(keyword string, book_id int, filter *DocumentMetaFilter) (docs []*DocumentSearchResult, err error) {
 	o := orm.NewOrm()
 
-	sql := "SELECT * FROM md_documents WHERE book_id = ? AND (document_name LIKE ? OR `release` LIKE ?) "
+	filter_sql, filter_args := filter.where("document_id")
+	sql := "SELECT * FROM md_documents WHERE book_id = ? AND (document_name LIKE ? OR `release` LIKE ?) " + filter_sql
 	keyword = "%" + keyword + "%"
 
-	_, err = o.Raw(sql, book_id, keyword, keyword).QueryRows(&docs)
+	_, err = o.Raw(sql, append([]interface{}{book_id, keyword, keyword}, filter_args...)...).QueryRows(&docs)
 
 	return
 }
+
+//分页查询使用指定标签的已发布文档，readable 用于过滤没有阅读权限的文档.
+func (m *DocumentSearchResult) FindForLabelToPager(label string, page_index, page_size, member_id int, readable func(book_id, doc_id int) bool) (search_result []*DocumentSearchResult, total_count int, err error) {
+	sql := `SELECT doc.document_id,doc.book_id FROM md_documents AS doc
+  LEFT JOIN md_books as book ON doc.book_id = book.book_id
+  LEFT JOIN md_relationship AS rel1 ON doc.book_id = rel1.book_id AND rel1.member_id = ?
+WHERE (book.privately_owned = 0 OR rel1.relationship_id > 0 OR book.book_id IN (` + grantedBookIdSubQuery() + `)) AND book.status = 0 AND doc.publish_status = ? AND doc.document_id IN (` + labelDocumentIdSubQuery() + `)
+ ORDER BY doc.modify_time DESC,doc.document_id DESC`
+
+	ids, err := readableDocumentIds(sql, []interface{}{member_id, member_id, member_id, conf.DocumentPublished, label}, readable)
+	if err != nil {
+		return
+	}
+	return m.findPage(ids, page_index, page_size, "doc.modify_time DESC,doc.document_id DESC")
+}
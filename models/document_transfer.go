@@ -0,0 +1,532 @@
+package models
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JermineHu/DocStack/utils"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+//文档内容中 $identify 形式的文档链接，分别匹配 Markdown 和 HTML.
+var (
+	markdownDocLinkRegexp = regexp.MustCompile(`\]\(\$([^)\s#]+)(#[^)\s]*)?\)`)
+	htmlDocLinkRegexp     = regexp.MustCompile(`href="\$([^"#]+)(#[^"]*)?"`)
+)
+
+//在项目之间移动或复制文档，文档连同子文档、附件和历史一起转移.
+type DocumentTransfer struct {
+	Source   *Book
+	Target   *Book
+	ParentId int //目标项目中的上级文档，0 表示根目录
+	MemberId int
+
+	docs     []*Document
+	ids      map[int]int       //源文档ID => 目标文档ID
+	refs     map[string]string //转移的文档在源项目中的链接 => 目标项目中的标识或ID
+	existing map[string]bool   //源项目中未转移的文档链接
+	attaches map[int]int       //源附件ID => 目标附件ID
+	objects  map[string]bool   //需要复制到目标项目存储目录的文件
+}
+
+//移动或复制的结果.
+type TransferResult struct {
+	DocumentId int               `json:"doc_id"` //目标项目中的顶层文档
+	Identify   string            `json:"identify"`
+	DocCount   int               `json:"doc_count"`
+	Renamed    map[string]string `json:"renamed"` //因与目标项目中的文档冲突而修改的文档标识
+}
+
+func NewDocumentTransfer(source, target *Book) *DocumentTransfer {
+	return &DocumentTransfer{Source: source, Target: target}
+}
+
+//查询要转移的文档并按上级文档在前的顺序排列.
+func (t *DocumentTransfer) prepare(doc_id int) error {
+	if t.Source.BookId == t.Target.BookId {
+		return ErrInvalidParameter
+	}
+	o := orm.NewOrm()
+	table := NewDocument().TableNameWithPrefix()
+
+	if t.ParentId > 0 {
+		if n, _ := o.QueryTable(table).Filter("book_id", t.Target.BookId).Filter("document_id", t.ParentId).Count(); n == 0 {
+			return ErrDataNotExist
+		}
+	}
+	ids, err := findSubtreeIds(t.Source.BookId, doc_id)
+	if err != nil {
+		return err
+	}
+	if _, err := o.QueryTable(table).Filter("book_id", t.Source.BookId).Filter("document_id__in", ids...).Limit(-1).All(&t.docs); err != nil {
+		return err
+	}
+	if len(t.docs) == 0 {
+		return ErrDataNotExist
+	}
+	order := make(map[int]int, len(ids))
+	for i, id := range ids {
+		n, _ := strconv.Atoi(fmt.Sprint(id))
+		order[n] = i
+	}
+	sort.Slice(t.docs, func(i, j int) bool {
+		return order[t.docs[i].DocumentId] < order[t.docs[j].DocumentId]
+	})
+
+	t.ids = make(map[int]int, len(t.docs))
+	t.refs = make(map[string]string, len(t.docs)*2)
+	t.attaches = make(map[int]int)
+	t.objects = make(map[string]bool)
+	t.existing = make(map[string]bool)
+
+	var others []*Document
+	if _, err := o.QueryTable(table).Filter("book_id", t.Source.BookId).Exclude("document_id__in", ids...).Limit(-1).All(&others, "document_id", "identify"); err != nil {
+		return err
+	}
+	for _, doc := range others {
+		t.existing[strconv.Itoa(doc.DocumentId)] = true
+		if doc.Identify != "" {
+			t.existing[strings.ToLower(doc.Identify)] = true
+		}
+	}
+	return nil
+}
+
+//为转移的文档分配目标项目中不冲突的标识.
+func (t *DocumentTransfer) rename(result *TransferResult) error {
+	var values orm.ParamsList
+	_, err := orm.NewOrm().QueryTable(NewDocument().TableNameWithPrefix()).Filter("book_id", t.Target.BookId).Limit(-1).ValuesFlat(&values, "identify")
+	if err != nil {
+		return err
+	}
+	taken := make(map[string]bool, len(values))
+	for _, value := range values {
+		if value != nil {
+			taken[strings.ToLower(fmt.Sprint(value))] = true
+		}
+	}
+	for _, doc := range t.docs {
+		if doc.Identify == "" {
+			continue
+		}
+		identify := doc.Identify
+		for n := 1; taken[strings.ToLower(identify)]; n++ {
+			identify = fmt.Sprintf("%s-%d", doc.Identify, n)
+		}
+		taken[strings.ToLower(identify)] = true
+		if identify != doc.Identify {
+			result.Renamed[doc.Identify] = identify
+		}
+	}
+	return nil
+}
+
+//记录转移的文档在目标项目中的链接，需要在文档ID确定后调用.
+func (t *DocumentTransfer) mapRefs(result *TransferResult) {
+	for _, doc := range t.docs {
+		ref := strconv.Itoa(t.ids[doc.DocumentId])
+		if doc.Identify != "" {
+			ref = doc.Identify
+			if identify, ok := result.Renamed[doc.Identify]; ok {
+				ref = identify
+			}
+			t.refs[strings.ToLower(doc.Identify)] = ref
+		}
+		t.refs[strconv.Itoa(doc.DocumentId)] = ref
+	}
+}
+
+//替换内容中的文档链接，replace 返回空字符串时保持原链接.
+func replaceDocLinks(text string, replace func(key string) string) string {
+	rewrite := func(re *regexp.Regexp, format string) {
+		text = re.ReplaceAllStringFunc(text, func(match string) string {
+			parts := re.FindStringSubmatch(match)
+			href := replace(strings.ToLower(parts[1]))
+			if href == "" {
+				return match
+			}
+			return fmt.Sprintf(format, href+parts[2])
+		})
+	}
+	rewrite(markdownDocLinkRegexp, "](%s)")
+	rewrite(htmlDocLinkRegexp, `href="%s"`)
+	return text
+}
+
+//改写转移的文档内容：转移的文档之间的链接指向目标项目，指向源项目其他文档的链接改为绝对链接，
+//附件和图片改为目标项目存储目录中的地址.
+func (t *DocumentTransfer) rewrite(text string) string {
+	if text == "" {
+		return text
+	}
+	text = replaceDocLinks(text, func(key string) string {
+		if ref, ok := t.refs[key]; ok {
+			return "$" + ref
+		}
+		if t.existing[key] {
+			return beego.URLFor("DocumentController.Read", ":key", t.Source.Identify, ":id", key)
+		}
+		return ""
+	})
+	for from, to := range t.attaches {
+		text = strings.Replace(text,
+			beego.URLFor("DocumentController.DownloadAttachment", ":key", t.Source.Identify, ":attach_id", from),
+			beego.URLFor("DocumentController.DownloadAttachment", ":key", t.Target.Identify, ":attach_id", to), -1)
+	}
+	prefix := "projects/" + t.Source.Identify + "/"
+	re := regexp.MustCompile(regexp.QuoteMeta(prefix) + `([^\s"'()<>\[\]]+)`)
+	for _, match := range re.FindAllStringSubmatch(text, -1) {
+		t.objects[match[1]] = true
+	}
+	return strings.Replace(text, prefix, "projects/"+t.Target.Identify+"/", -1)
+}
+
+//复制内容引用的文件到目标项目的存储目录，源文件保留，由源项目删除时清理.
+func (t *DocumentTransfer) copyObjects() {
+	for object := range t.objects {
+		var err error
+		switch utils.StoreType {
+		case utils.StoreOss:
+			err = ModelStoreOss.CopyOssObject("projects/"+t.Source.Identify+"/"+object, "projects/"+t.Target.Identify+"/"+object)
+		case utils.StoreLocal:
+			err = ModelStoreLocal.CopyToStore("uploads/projects/"+t.Source.Identify+"/"+object, "uploads/projects/"+t.Target.Identify+"/"+object)
+		}
+		if err != nil {
+			beego.Error("复制文件失败 => ", object, err)
+		}
+	}
+}
+
+//目标上级文档下的下一个排序值.
+func (t *DocumentTransfer) nextOrderSort() int {
+	var last Document
+	err := orm.NewOrm().QueryTable(NewDocument().TableNameWithPrefix()).
+		Filter("book_id", t.Target.BookId).Filter("parent_id", t.ParentId).
+		OrderBy("-order_sort").One(&last, "order_sort")
+	if err != nil {
+		return 0
+	}
+	return last.OrderSort + 1
+}
+
+//移动文档及其子文档到目标项目，源项目中其他文档指向这些文档的链接改为目标项目中的绝对链接.
+func (t *DocumentTransfer) Move(doc_id int) (*TransferResult, error) {
+	if err := t.prepare(doc_id); err != nil {
+		return nil, err
+	}
+	result := &TransferResult{Identify: t.Target.Identify, DocCount: len(t.docs), Renamed: make(map[string]string)}
+	if err := t.rename(result); err != nil {
+		return nil, err
+	}
+	ids := make([]interface{}, 0, len(t.docs))
+	for _, doc := range t.docs {
+		t.ids[doc.DocumentId] = doc.DocumentId
+		ids = append(ids, doc.DocumentId)
+	}
+	t.mapRefs(result)
+	root := t.docs[0]
+	result.DocumentId = root.DocumentId
+
+	var attaches []*Attachment
+	o := orm.NewOrm()
+	if _, err := o.QueryTable(NewAttachment().TableNameWithPrefix()).Filter("document_id__in", ids...).Limit(-1).All(&attaches); err != nil {
+		return nil, err
+	}
+	for _, attach := range attaches {
+		t.attaches[attach.AttachmentId] = attach.AttachmentId
+	}
+	order_sort := t.nextOrderSort()
+
+	if err := o.Begin(); err != nil {
+		return nil, err
+	}
+	table := NewDocument().TableNameWithPrefix()
+	for _, doc := range t.docs {
+		params := orm.Params{"book_id": t.Target.BookId, "release": t.rewrite(doc.Release)}
+		if identify, ok := result.Renamed[doc.Identify]; ok {
+			params["identify"] = identify
+		}
+		if doc.DocumentId == root.DocumentId {
+			params["parent_id"] = t.ParentId
+			params["order_sort"] = order_sort
+		}
+		if _, err := o.QueryTable(table).Filter("document_id", doc.DocumentId).Update(params); err != nil {
+			o.Rollback()
+			return nil, err
+		}
+		ds := DocumentStore{DocumentId: doc.DocumentId}
+		if err := o.Read(&ds); err == nil {
+			params := orm.Params{"markdown": t.rewrite(ds.Markdown), "content": t.rewrite(ds.Content)}
+			if _, err := o.QueryTable(TableDocumentStore).Filter("document_id", doc.DocumentId).Update(params); err != nil {
+				o.Rollback()
+				return nil, err
+			}
+		}
+	}
+	for _, attach := range attaches {
+		params := orm.Params{"book_id": t.Target.BookId, "http_path": t.rewrite(attach.HttpPath)}
+		if _, err := o.QueryTable(NewAttachment().TableNameWithPrefix()).Filter("attachment_id", attach.AttachmentId).Update(params); err != nil {
+			o.Rollback()
+			return nil, err
+		}
+	}
+	//批注、评论、关注和文档权限跟随文档转移
+	for _, table := range []string{
+		NewAnnotation().TableNameWithPrefix(),
+		NewComment().TableNameWithPrefix(),
+		NewWatch().TableNameWithPrefix(),
+		NewDocumentPermission().TableNameWithPrefix(),
+	} {
+		if _, err := o.QueryTable(table).Filter("document_id__in", ids...).Update(orm.Params{"book_id": t.Target.BookId}); err != nil {
+			o.Rollback()
+			return nil, err
+		}
+	}
+	sql := "UPDATE " + NewAnnotationReply().TableNameWithPrefix() + " SET book_id = ? WHERE annotation_id IN (SELECT annotation_id FROM " + NewAnnotation().TableNameWithPrefix() + " WHERE book_id = ? AND document_id IN (" + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + "))"
+	if _, err := o.Raw(sql, append([]interface{}{t.Target.BookId, t.Target.BookId}, ids...)...).Exec(); err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	//审阅和分支来源记录只在源项目中有效
+	for _, table := range []string{NewDocumentReview().TableNameWithPrefix(), NewBranchDocument().TableNameWithPrefix()} {
+		if _, err := o.QueryTable(table).Filter("document_id__in", ids...).Delete(); err != nil {
+			o.Rollback()
+			return nil, err
+		}
+	}
+	if err := t.relinkSource(o); err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	if err := o.Commit(); err != nil {
+		return nil, err
+	}
+	t.copyObjects()
+	NewBook().ResetDocumentNumber(t.Source.BookId)
+	NewBook().ResetDocumentNumber(t.Target.BookId)
+	(&Comment{BookId: t.Source.BookId}).Recount()
+	(&Comment{BookId: t.Target.BookId}).Recount()
+	return result, nil
+}
+
+//将源项目中指向已移动文档的链接改为目标项目中的绝对链接.
+func (t *DocumentTransfer) relinkSource(o orm.Ormer) error {
+	replace := func(key string) string {
+		if ref, ok := t.refs[key]; ok {
+			return beego.URLFor("DocumentController.Read", ":key", t.Target.Identify, ":id", ref)
+		}
+		return ""
+	}
+	var docs []*Document
+	if _, err := o.QueryTable(NewDocument().TableNameWithPrefix()).Filter("book_id", t.Source.BookId).Limit(-1).All(&docs, "document_id", "release"); err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if release := replaceDocLinks(doc.Release, replace); release != doc.Release {
+			if _, err := o.QueryTable(NewDocument().TableNameWithPrefix()).Filter("document_id", doc.DocumentId).Update(orm.Params{"release": release}); err != nil {
+				return err
+			}
+		}
+		ds := DocumentStore{DocumentId: doc.DocumentId}
+		if err := o.Read(&ds); err != nil {
+			continue
+		}
+		markdown, content := replaceDocLinks(ds.Markdown, replace), replaceDocLinks(ds.Content, replace)
+		if markdown != ds.Markdown || content != ds.Content {
+			if _, err := o.QueryTable(TableDocumentStore).Filter("document_id", doc.DocumentId).Update(orm.Params{"markdown": markdown, "content": content}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//复制文档及其子文档到目标项目，附件文件和历史记录一起复制.
+func (t *DocumentTransfer) Copy(doc_id int) (*TransferResult, error) {
+	if err := t.prepare(doc_id); err != nil {
+		return nil, err
+	}
+	return t.copyDocuments(true, true)
+}
+
+//复制源项目的全部文档到目标项目，with_content 为 false 时只复制目录结构.
+func (t *DocumentTransfer) CopyBook(with_content bool) (*TransferResult, error) {
+	if err := t.prepare(0); err != nil {
+		if err == ErrDataNotExist && len(t.docs) == 0 {
+			return &TransferResult{Identify: t.Target.Identify, Renamed: make(map[string]string)}, nil
+		}
+		return nil, err
+	}
+	return t.copyDocuments(false, with_content)
+}
+
+//复制已查询的文档，subtree 为 true 时复制的是一个文档及其子文档，放到目标上级文档的最后.
+func (t *DocumentTransfer) copyDocuments(subtree, with_content bool) (*TransferResult, error) {
+	result := &TransferResult{Identify: t.Target.Identify, DocCount: len(t.docs), Renamed: make(map[string]string)}
+	if err := t.rename(result); err != nil {
+		return nil, err
+	}
+	order_sort := -1
+	if subtree {
+		order_sort = t.nextOrderSort()
+	}
+
+	o := orm.NewOrm()
+	if err := o.Begin(); err != nil {
+		return nil, err
+	}
+	for _, doc := range t.docs {
+		parent_id, ok := t.ids[doc.ParentId]
+		if !ok {
+			parent_id = t.ParentId
+		}
+		copied := &Document{
+			DocumentName:   doc.DocumentName,
+			Identify:       doc.Identify,
+			BookId:         t.Target.BookId,
+			ParentId:       parent_id,
+			OrderSort:      doc.OrderSort,
+			MemberId:       t.MemberId,
+			ModifyAt:       t.MemberId,
+			Version:        doc.Version,
+			PublishStatus:  doc.PublishStatus,
+			PublishVersion: doc.PublishVersion,
+			PublishTime:    doc.PublishTime,
+			ScheduleTime:   doc.ScheduleTime,
+		}
+		if identify, ok := result.Renamed[doc.Identify]; ok {
+			copied.Identify = identify
+		}
+		if order_sort >= 0 && len(t.ids) == 0 {
+			copied.OrderSort = order_sort
+		}
+		if _, err := o.Insert(copied); err != nil {
+			o.Rollback()
+			return nil, err
+		}
+		t.ids[doc.DocumentId] = copied.DocumentId
+	}
+	result.DocumentId = t.ids[t.docs[0].DocumentId]
+	t.mapRefs(result)
+
+	if !with_content {
+		for _, doc := range t.docs {
+			if _, err := o.Insert(&DocumentStore{DocumentId: t.ids[doc.DocumentId]}); err != nil {
+				o.Rollback()
+				return nil, err
+			}
+		}
+		if err := o.Commit(); err != nil {
+			return nil, err
+		}
+		NewBook().ResetDocumentNumber(t.Target.BookId)
+		return result, nil
+	}
+	if err := t.copyAttachments(o); err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	for _, doc := range t.docs {
+		new_id := t.ids[doc.DocumentId]
+		if _, err := o.QueryTable(NewDocument().TableNameWithPrefix()).Filter("document_id", new_id).Update(orm.Params{"release": t.rewrite(doc.Release)}); err != nil {
+			o.Rollback()
+			return nil, err
+		}
+		ds := DocumentStore{DocumentId: doc.DocumentId}
+		o.Read(&ds)
+		if _, err := o.Insert(&DocumentStore{DocumentId: new_id, Markdown: t.rewrite(ds.Markdown), Content: t.rewrite(ds.Content)}); err != nil {
+			o.Rollback()
+			return nil, err
+		}
+		if err := t.copyHistories(o, doc.DocumentId, new_id); err != nil {
+			o.Rollback()
+			return nil, err
+		}
+	}
+	if err := o.Commit(); err != nil {
+		return nil, err
+	}
+	t.copyObjects()
+	if err := NewDocumentLabel().Copy(t.ids); err != nil {
+		beego.Error("复制文档标签失败 => ", err)
+	}
+	if err := NewDocumentMeta().Copy(t.ids); err != nil {
+		beego.Error("复制文档元数据失败 => ", err)
+	}
+	NewBook().ResetDocumentNumber(t.Target.BookId)
+	return result, nil
+}
+
+//复制文档的附件，附件文件复制为新文件，避免删除一方时影响另一方.
+func (t *DocumentTransfer) copyAttachments(o orm.Ormer) error {
+	ids := make([]interface{}, 0, len(t.ids))
+	for id := range t.ids {
+		ids = append(ids, id)
+	}
+	var attaches []*Attachment
+	if _, err := o.QueryTable(NewAttachment().TableNameWithPrefix()).Filter("document_id__in", ids...).OrderBy("attachment_id").Limit(-1).All(&attaches); err != nil {
+		return err
+	}
+	for _, attach := range attaches {
+		copied := *attach
+		copied.AttachmentId = 0
+		copied.BookId = t.Target.BookId
+		copied.DocumentId = t.ids[attach.DocumentId]
+		copied.CreateAt = t.MemberId
+		//图片上传时已移动到项目存储目录 projects/<identify>/，文件由 copyObjects 按内容引用复制
+		if attach.HttpPath != beego.URLFor("DocumentController.DownloadAttachment", ":key", t.Source.Identify, ":attach_id", attach.AttachmentId) {
+			copied.FilePath = t.rewrite(attach.FilePath)
+		} else {
+			copied.FilePath = fmt.Sprintf("/uploads/%s/%s%s", time.Now().Format("200601"), strconv.FormatInt(time.Now().UnixNano(), 16), filepath.Ext(attach.FilePath))
+			if err := ModelStoreLocal.CopyToStore(attach.FilePath, copied.FilePath); err != nil {
+				return err
+			}
+		}
+		if _, err := o.Insert(&copied); err != nil {
+			return err
+		}
+		t.attaches[attach.AttachmentId] = copied.AttachmentId
+	}
+	//附件ID确定后再改写下载地址
+	for _, attach := range attaches {
+		copied_id := t.attaches[attach.AttachmentId]
+		if _, err := o.QueryTable(NewAttachment().TableNameWithPrefix()).Filter("attachment_id", copied_id).Update(orm.Params{"http_path": t.rewrite(attach.HttpPath)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//复制文档的历史记录，保留差异存储的基准关系和原修改时间.
+func (t *DocumentTransfer) copyHistories(o orm.Ormer, doc_id, new_id int) error {
+	table := NewDocumentHistory().TableNameWithPrefix()
+
+	var histories []*DocumentHistory
+	if _, err := o.QueryTable(table).Filter("document_id", doc_id).OrderBy("history_id").Limit(-1).All(&histories); err != nil {
+		return err
+	}
+	ids := make(map[int]int, len(histories))
+	for _, history := range histories {
+		copied := *history
+		copied.HistoryId = 0
+		copied.DocumentId = new_id
+		copied.BaseId = ids[history.BaseId]
+		if parent_id, ok := t.ids[history.ParentId]; ok {
+			copied.ParentId = parent_id
+		}
+		if _, err := o.Insert(&copied); err != nil {
+			return err
+		}
+		ids[history.HistoryId] = copied.HistoryId
+		if _, err := o.QueryTable(table).Filter("history_id", copied.HistoryId).Update(orm.Params{"modify_time": history.ModifyTime}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"html/template"
 	"strconv"
 
+	"github.com/JermineHu/DocStack/conf"
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/orm"
 )
@@ -15,6 +16,7 @@ type DocumentTree struct {
 	ParentId     interface{}       `json:"parent"`
 	Identify     string            `json:"identify"`
 	BookIdentify string            `json:"-"`
+	VersionId    int               `json:"-"`
 	Version      int64             `json:"version"`
 	State        *DocumentSelected `json:"state,omitempty"`
 }
@@ -31,7 +33,7 @@ func (m *Document) FindDocumentTree(book_id int, isEdit ...bool) ([]*DocumentTre
 
 	var docs []*Document
 
-	count, err := o.QueryTable(m).Filter("book_id", book_id).OrderBy("order_sort", "identify").Limit(5000).All(&docs, "document_id", "version", "document_name", "parent_id", "identify")
+	count, err := o.QueryTable(m).Filter("book_id", book_id).OrderBy("order_sort", "identify").Limit(5000).All(&docs, "document_id", "version", "document_name", "parent_id", "identify", "publish_status", "schedule_time")
 	if err != nil {
 		return trees, err
 	}
@@ -39,6 +41,12 @@ func (m *Document) FindDocumentTree(book_id int, isEdit ...bool) ([]*DocumentTre
 
 	trees = make([]*DocumentTree, count)
 
+	//开启审阅的项目在编辑目录中标记文档的审阅状态
+	var review_states map[int]int
+	if len(isEdit) > 0 && isEdit[0] == true && book.EnableReview == 1 {
+		review_states = NewDocumentReview().FindDocumentStates(book_id)
+	}
+
 	for index, item := range docs {
 		tree := &DocumentTree{}
 		if index == 0 {
@@ -59,6 +67,16 @@ func (m *Document) FindDocumentTree(book_id int, isEdit ...bool) ([]*DocumentTre
 		}
 		if len(isEdit) > 0 && isEdit[0] == true {
 			tree.DocumentName = item.DocumentName + "<small class='text-danger'>(" + idf + ")</small>"
+			if item.IsScheduled() {
+				tree.DocumentName += "<small class='text-info'>[定时发布]</small>"
+			} else if item.PublishStatus == conf.DocumentUnpublished {
+				tree.DocumentName += "<small class='text-muted'>[已取消发布]</small>"
+			}
+			if state, ok := review_states[item.DocumentId]; ok && state == conf.ReviewPending {
+				tree.DocumentName += "<small class='text-warning'>[待审阅]</small>"
+			} else if ok && state == conf.ReviewRejected {
+				tree.DocumentName += "<small class='text-warning'>[需修改]</small>"
+			}
 		} else {
 			tree.DocumentName = item.DocumentName
 		}
@@ -69,19 +87,28 @@ func (m *Document) FindDocumentTree(book_id int, isEdit ...bool) ([]*DocumentTre
 	return trees, nil
 }
 
-func (m *Document) CreateDocumentTreeForHtml(book_id, selected_id int) (string, error) {
+func (m *Document) CreateDocumentTreeForHtml(book_id, selected_id int, access ...*DocumentAccess) (string, error) {
 	trees, err := m.FindDocumentTree(book_id)
 	if err != nil {
 		return "", err
 	}
+	//过滤没有阅读权限的文档
+	if len(access) > 0 && access[0] != nil {
+		trees = access[0].FilterTree(trees)
+	}
+	return documentTreeHtml(trees, selected_id), nil
+
+}
+
+//生成阅读页的目录.
+func documentTreeHtml(trees []*DocumentTree, selected_id int) string {
 	parent_id := getSelectedNode(trees, selected_id)
 
 	buf := bytes.NewBufferString("")
 
 	getDocumentTree(trees, 0, selected_id, parent_id, buf)
 
-	return buf.String(), nil
-
+	return buf.String()
 }
 
 //使用递归的方式获取指定ID的顶级ID
@@ -128,6 +155,10 @@ func getDocumentTree(array []*DocumentTree, parent_id int, selected_id int, sele
 				uri := beego.URLFor("DocumentController.Read", ":key", item.BookIdentify, ":id", item.DocumentId)
 				buf.WriteString(uri)
 			}
+			//项目版本中的文档
+			if item.VersionId > 0 {
+				buf.WriteString("?version=" + strconv.Itoa(item.VersionId))
+			}
 			buf.WriteString("\" title=\"")
 			buf.WriteString(template.HTMLEscapeString(item.DocumentName) + "\"")
 			buf.WriteString(selected + ">")
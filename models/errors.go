@@ -8,6 +8,7 @@ var (
 	ErrMemberNoExist  = errors.New("用户不存在")
 	ErrMemberExist  = errors.New("用户已存在")
 	ErrMemberDisabled = errors.New("用户被禁用")
+	ErrMemberUnverified = errors.New("用户邮箱尚未验证")
 	ErrMemberEmailEmpty = errors.New("用户邮箱不能为空")
 	ErrMemberEmailExist = errors.New("用户邮箱已被使用")
 	ErrMemberDescriptionTooLong = errors.New("用户描述必须小于500字")
@@ -36,6 +37,17 @@ var (
 
 	ErrPermissionDenied = errors.New("Permission denied")
 
+	// ErrMergeConflict 源文档在分支创建或上次合并后已被修改.
+	ErrMergeConflict = errors.New("源文档在分支创建或上次合并后已被修改")
+	// ErrParentNotMerged 分支中新增文档的上级文档尚未合并.
+	ErrParentNotMerged = errors.New("请先合并上级文档")
+	// ErrRecycleBookDeleted 恢复文档时所属项目已被删除.
+	ErrRecycleBookDeleted = errors.New("文档所属项目已被删除，请先恢复项目")
+	// ErrLinkCheckRunning 项目的链接检查正在执行.
+	ErrLinkCheckRunning = errors.New("上次链接检查正在执行中，请稍后再操作")
+	// ErrLabelExist 重命名标签时新名称已被其他标签使用.
+	ErrLabelExist = errors.New("标签已存在，请使用合并标签")
+
 	ErrCommentClosed          = errors.New("评论已关闭")
 	ErrCommentContentNotEmpty = errors.New("评论内容不能为空")
 )
@@ -2,15 +2,21 @@ package models
 
 import (
 	"strings"
+	"unicode/utf8"
 
 	"github.com/JermineHu/DocStack/conf"
 	"github.com/astaxie/beego/orm"
 )
 
+//标签名称的最大长度.
+const labelNameMaxLength = 50
+
 type Label struct {
 	LabelId    int    `orm:"column(label_id);pk;auto;unique;" json:"label_id"`
 	LabelName  string `orm:"column(label_name);size(50);unique" json:"label_name"`
 	BookNumber int    `orm:"column(book_number)" json:"book_number"`
+	DocNumber  int    `orm:"column(doc_number);default(0)" json:"doc_number"`
+	Url        string `orm:"-" json:"url,omitempty"`
 }
 
 // TableName 获取对应数据库表名.
@@ -39,46 +45,186 @@ func (m *Label) FindFirst(field string, value interface{}) (*Label, error) {
 	return m, err
 }
 
-//插入或更新标签.
-func (m *Label) InsertOrUpdate(labelName string) error {
-	o := orm.NewOrm()
+//拆分逗号分隔的标签，去除空白和重复的标签.
+func ParseLabels(labels string) []string {
+	names := make([]string, 0)
+	added := make(map[string]bool)
+	for _, name := range strings.FieldsFunc(labels, func(r rune) bool { return r == ',' || r == '，' }) {
+		name = strings.TrimSpace(name)
+		if utf8.RuneCountInString(name) > labelNameMaxLength {
+			name = string([]rune(name)[:labelNameMaxLength])
+		}
+		if name == "" || added[strings.ToLower(name)] {
+			continue
+		}
+		added[strings.ToLower(name)] = true
+		names = append(names, name)
+	}
+	return names
+}
 
-	err := o.QueryTable(m.TableNameWithPrefix()).Filter("label_name", labelName).One(m)
-	if err != nil && err != orm.ErrNoRows {
+//插入标签并更新标签的项目和文档数量.
+func (m *Label) InsertOrUpdate(labelName string) error {
+	if _, err := m.findOrInsert(orm.NewOrm(), labelName); err != nil {
 		return err
 	}
-	count, _ := o.QueryTable(NewBook().TableNameWithPrefix()).Filter("label__icontains", labelName).Count()
-	m.BookNumber = int(count)
-	m.LabelName = labelName
+	return m.ResetNumber(m.LabelId)
+}
 
+//查询标签，不存在时插入.
+func (m *Label) findOrInsert(o orm.Ormer, labelName string) (*Label, error) {
+	err := o.QueryTable(m.TableNameWithPrefix()).Filter("label_name", labelName).One(m)
 	if err == orm.ErrNoRows {
-		err = nil
 		m.LabelName = labelName
 		_, err = o.Insert(m)
-	} else {
-		_, err = o.Update(m)
 	}
-	return err
+	return m, err
 }
 
 //批量插入或更新标签.
 func (m *Label) InsertOrUpdateMulti(labels string) {
-	if labels != "" {
-		labelArray := strings.Split(labels, ",")
+	for _, label := range ParseLabels(labels) {
+		NewLabel().InsertOrUpdate(label)
+	}
+}
 
-		for _, label := range labelArray {
-			if label != "" {
-				NewLabel().InsertOrUpdate(label)
+//重新统计标签关联的项目和文档数量，不包含回收站中的项目和文档，未指定标签时统计全部标签.
+func (m *Label) ResetNumber(label_id ...interface{}) error {
+	table := m.TableNameWithPrefix()
+	sql := "UPDATE " + table + " SET book_number = (SELECT COUNT(*) FROM " + NewBookLabel().TableNameWithPrefix() + " AS bl" +
+		" INNER JOIN " + NewBook().TableNameWithPrefix() + " AS book ON bl.book_id = book.book_id" +
+		" WHERE bl.label_id = " + table + ".label_id AND book.status = 0)," +
+		" doc_number = (SELECT COUNT(*) FROM " + NewDocumentLabel().TableNameWithPrefix() + " AS dl" +
+		" INNER JOIN " + NewDocument().TableNameWithPrefix() + " AS doc ON dl.document_id = doc.document_id" +
+		" INNER JOIN " + NewBook().TableNameWithPrefix() + " AS book ON doc.book_id = book.book_id" +
+		" WHERE dl.label_id = " + table + ".label_id AND book.status = 0)"
+	if len(label_id) > 0 {
+		sql += " WHERE label_id IN (" + strings.TrimSuffix(strings.Repeat("?,", len(label_id)), ",") + ")"
+	}
+	_, err := orm.NewOrm().Raw(sql, label_id...).Exec()
+	return err
+}
+
+//重命名标签，同时更新使用该标签的项目.
+func (m *Label) Rename(labelName string) error {
+	names := ParseLabels(labelName)
+	if len(names) != 1 {
+		return ErrInvalidParameter
+	}
+	o := orm.NewOrm()
+	var exist Label
+	if err := o.QueryTable(m.TableNameWithPrefix()).Filter("label_name", names[0]).One(&exist); err == nil && exist.LabelId != m.LabelId {
+		return ErrLabelExist
+	}
+	m.LabelName = names[0]
+	if _, err := o.Update(m, "label_name"); err != nil {
+		return err
+	}
+	book_ids, err := m.findBookIds(o)
+	if err != nil {
+		return err
+	}
+	return refreshBookLabel(book_ids...)
+}
+
+//将标签合并到目标标签，合并后删除该标签.
+func (m *Label) Merge(target *Label) error {
+	if target.LabelId == m.LabelId {
+		return ErrInvalidParameter
+	}
+	o := orm.NewOrm()
+	book_ids, err := m.findBookIds(o)
+	if err != nil {
+		return err
+	}
+	if err := o.Begin(); err != nil {
+		return err
+	}
+	for _, table := range []struct{ name, column string }{
+		{NewBookLabel().TableNameWithPrefix(), "book_id"},
+		{NewDocumentLabel().TableNameWithPrefix(), "document_id"},
+	} {
+		var values orm.ParamsList
+		if _, err := o.QueryTable(table.name).Filter("label_id", target.LabelId).Limit(-1).ValuesFlat(&values, table.column); err != nil {
+			o.Rollback()
+			return err
+		}
+		//已有目标标签的项目和文档直接删除该标签
+		if len(values) > 0 {
+			if _, err := o.QueryTable(table.name).Filter("label_id", m.LabelId).Filter(table.column+"__in", values...).Delete(); err != nil {
+				o.Rollback()
+				return err
 			}
 		}
+		if _, err := o.QueryTable(table.name).Filter("label_id", m.LabelId).Update(orm.Params{"label_id": target.LabelId}); err != nil {
+			o.Rollback()
+			return err
+		}
+	}
+	if _, err := o.Delete(m); err != nil {
+		o.Rollback()
+		return err
+	}
+	if err := o.Commit(); err != nil {
+		return err
 	}
+	if err := refreshBookLabel(book_ids...); err != nil {
+		return err
+	}
+	return target.ResetNumber(target.LabelId)
+}
+
+//删除标签及其与项目和文档的关联.
+func (m *Label) Delete() error {
+	o := orm.NewOrm()
+	book_ids, err := m.findBookIds(o)
+	if err != nil {
+		return err
+	}
+	if err := o.Begin(); err != nil {
+		return err
+	}
+	if _, err := o.QueryTable(NewBookLabel().TableNameWithPrefix()).Filter("label_id", m.LabelId).Delete(); err != nil {
+		o.Rollback()
+		return err
+	}
+	if _, err := o.QueryTable(NewDocumentLabel().TableNameWithPrefix()).Filter("label_id", m.LabelId).Delete(); err != nil {
+		o.Rollback()
+		return err
+	}
+	if _, err := o.Delete(m); err != nil {
+		o.Rollback()
+		return err
+	}
+	if err := o.Commit(); err != nil {
+		return err
+	}
+	return refreshBookLabel(book_ids...)
+}
+
+//查询使用该标签的项目.
+func (m *Label) findBookIds(o orm.Ormer) ([]int, error) {
+	var values orm.ParamsList
+	if _, err := o.QueryTable(NewBookLabel().TableNameWithPrefix()).Filter("label_id", m.LabelId).Limit(-1).ValuesFlat(&values, "book_id"); err != nil {
+		return nil, err
+	}
+	return paramsToIds(values), nil
 }
 
 //分页查找标签.
 func (m *Label) FindToPager(pageIndex, pageSize int) (labels []*Label, totalCount int, err error) {
+	return m.FindToPagerByKeyword("", pageIndex, pageSize)
+}
+
+//按名称分页查找标签，关键字为空时查找全部标签.
+func (m *Label) FindToPagerByKeyword(keyword string, pageIndex, pageSize int) (labels []*Label, totalCount int, err error) {
 	o := orm.NewOrm()
 
-	count, err := o.QueryTable(m.TableNameWithPrefix()).Count()
+	qs := o.QueryTable(m.TableNameWithPrefix())
+	if keyword != "" {
+		qs = qs.Filter("label_name__icontains", keyword)
+	}
+	count, err := qs.Count()
 
 	if err != nil {
 		return
@@ -87,7 +233,7 @@ func (m *Label) FindToPager(pageIndex, pageSize int) (labels []*Label, totalCoun
 
 	offset := (pageIndex - 1) * pageSize
 
-	_, err = o.QueryTable(m.TableNameWithPrefix()).OrderBy("-book_number").Offset(offset).Limit(pageSize).All(&labels)
+	_, err = qs.OrderBy("-book_number", "-doc_number", "label_id").Offset(offset).Limit(pageSize).All(&labels)
 
 	return
 }
@@ -0,0 +1,265 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+//项目与标签的关联.
+type BookLabel struct {
+	BookLabelId int `orm:"pk;auto;column(book_label_id)" json:"book_label_id"`
+	BookId      int `orm:"column(book_id);type(int);index" json:"book_id"`
+	LabelId     int `orm:"column(label_id);type(int);index" json:"label_id"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *BookLabel) TableName() string {
+	return "book_label"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *BookLabel) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *BookLabel) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+// 多字段唯一键
+func (m *BookLabel) TableUnique() [][]string {
+	return [][]string{{"book_id", "label_id"}}
+}
+
+func NewBookLabel() *BookLabel {
+	return &BookLabel{}
+}
+
+//文档与标签的关联.
+type DocumentLabel struct {
+	DocumentLabelId int `orm:"pk;auto;column(document_label_id)" json:"document_label_id"`
+	DocumentId      int `orm:"column(document_id);type(int);index" json:"doc_id"`
+	LabelId         int `orm:"column(label_id);type(int);index" json:"label_id"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *DocumentLabel) TableName() string {
+	return "document_label"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *DocumentLabel) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *DocumentLabel) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+// 多字段唯一键
+func (m *DocumentLabel) TableUnique() [][]string {
+	return [][]string{{"document_id", "label_id"}}
+}
+
+func NewDocumentLabel() *DocumentLabel {
+	return &DocumentLabel{}
+}
+
+//替换关联的标签，返回规范化后的标签.
+func replaceLabels(table, column string, owner_id int, labels string) ([]string, error) {
+	o := orm.NewOrm()
+
+	var values orm.ParamsList
+	if _, err := o.QueryTable(table).Filter(column, owner_id).Limit(-1).ValuesFlat(&values, "label_id"); err != nil {
+		return nil, err
+	}
+	names := ParseLabels(labels)
+	label_ids := make([]interface{}, 0, len(values)+len(names))
+	label_ids = append(label_ids, values...)
+
+	if err := o.Begin(); err != nil {
+		return nil, err
+	}
+	if _, err := o.QueryTable(table).Filter(column, owner_id).Delete(); err != nil {
+		o.Rollback()
+		return nil, err
+	}
+	for i, name := range names {
+		label, err := NewLabel().findOrInsert(o, name)
+		if err != nil {
+			o.Rollback()
+			return nil, err
+		}
+		//标签名称不区分大小写，使用已有标签的名称
+		names[i] = label.LabelName
+		label_ids = append(label_ids, label.LabelId)
+		if _, err := o.Raw("INSERT INTO "+table+" ("+column+",label_id) VALUES (?,?)", owner_id, label.LabelId).Exec(); err != nil {
+			o.Rollback()
+			return nil, err
+		}
+	}
+	if err := o.Commit(); err != nil {
+		return nil, err
+	}
+	if len(label_ids) > 0 {
+		if err := NewLabel().ResetNumber(label_ids...); err != nil {
+			beego.Error("更新标签数量失败 => ", err)
+		}
+	}
+	return names, nil
+}
+
+//设置项目的标签，同时更新项目的标签字段.
+func (m *BookLabel) Replace(book_id int, labels string) (string, error) {
+	names, err := replaceLabels(m.TableNameWithPrefix(), "book_id", book_id, labels)
+	if err != nil {
+		return "", err
+	}
+	label := strings.Join(names, ",")
+	_, err = orm.NewOrm().QueryTable(NewBook().TableNameWithPrefix()).Filter("book_id", book_id).Update(orm.Params{"label": label})
+	return label, err
+}
+
+//删除项目的标签.
+func (m *BookLabel) DeleteByBookId(book_id int) error {
+	return deleteLabelRelations(m.TableNameWithPrefix(), "book_id", book_id)
+}
+
+//设置文档的标签，返回规范化后的标签.
+func (m *DocumentLabel) Replace(doc_id int, labels string) (string, error) {
+	names, err := replaceLabels(m.TableNameWithPrefix(), "document_id", doc_id, labels)
+	return strings.Join(names, ","), err
+}
+
+//删除文档的标签.
+func (m *DocumentLabel) DeleteByDocumentId(doc_id ...interface{}) error {
+	return deleteLabelRelations(m.TableNameWithPrefix(), "document_id", doc_id...)
+}
+
+//删除关联并更新相关标签的数量.
+func deleteLabelRelations(table, column string, value ...interface{}) error {
+	if len(value) == 0 {
+		return nil
+	}
+	o := orm.NewOrm()
+	var values orm.ParamsList
+	if _, err := o.QueryTable(table).Filter(column+"__in", value...).Limit(-1).ValuesFlat(&values, "label_id"); err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	if _, err := o.QueryTable(table).Filter(column+"__in", value...).Delete(); err != nil {
+		return err
+	}
+	return NewLabel().ResetNumber(values...)
+}
+
+//查询文档的标签.
+func (m *DocumentLabel) FindLabels(doc_id int) (labels []*Label, err error) {
+	sql := "SELECT label.* FROM " + NewLabel().TableNameWithPrefix() + " AS label INNER JOIN " + m.TableNameWithPrefix() +
+		" AS dl ON dl.label_id = label.label_id WHERE dl.document_id = ? ORDER BY dl.document_label_id"
+
+	_, err = orm.NewOrm().Raw(sql, doc_id).QueryRows(&labels)
+	return
+}
+
+//查询文档的标签名称，以逗号分隔.
+func (m *DocumentLabel) FindLabelNames(doc_id int) string {
+	labels, err := m.FindLabels(doc_id)
+	if err != nil {
+		beego.Error("DocumentLabel.FindLabels => ", err)
+		return ""
+	}
+	names := make([]string, len(labels))
+	for i, label := range labels {
+		names[i] = label.LabelName
+	}
+	return strings.Join(names, ",")
+}
+
+//复制文档的标签到新文档，ids 为源文档ID到新文档ID的映射.
+func (m *DocumentLabel) Copy(ids map[int]int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	o := orm.NewOrm()
+	doc_ids := make([]interface{}, 0, len(ids))
+	for id := range ids {
+		doc_ids = append(doc_ids, id)
+	}
+	var items []*DocumentLabel
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("document_id__in", doc_ids...).Limit(-1).All(&items); err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	label_ids := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if _, err := o.Insert(&DocumentLabel{DocumentId: ids[item.DocumentId], LabelId: item.LabelId}); err != nil {
+			return err
+		}
+		label_ids = append(label_ids, item.LabelId)
+	}
+	return NewLabel().ResetNumber(label_ids...)
+}
+
+//使用指定标签的项目ID子查询，需要一个标签名称参数.
+func labelBookIdSubQuery() string {
+	return "SELECT bl.book_id FROM " + NewBookLabel().TableNameWithPrefix() + " AS bl INNER JOIN " + NewLabel().TableNameWithPrefix() +
+		" AS label ON bl.label_id = label.label_id WHERE label.label_name = ?"
+}
+
+//使用指定标签的文档ID子查询，需要一个标签名称参数.
+func labelDocumentIdSubQuery() string {
+	return "SELECT dl.document_id FROM " + NewDocumentLabel().TableNameWithPrefix() + " AS dl INNER JOIN " + NewLabel().TableNameWithPrefix() +
+		" AS label ON dl.label_id = label.label_id WHERE label.label_name = ?"
+}
+
+//根据关联表更新项目的标签字段.
+func refreshBookLabel(book_ids ...int) error {
+	o := orm.NewOrm()
+	sql := "SELECT label.label_name FROM " + NewLabel().TableNameWithPrefix() + " AS label INNER JOIN " + NewBookLabel().TableNameWithPrefix() +
+		" AS bl ON bl.label_id = label.label_id WHERE bl.book_id = ? ORDER BY bl.book_label_id"
+
+	for _, book_id := range book_ids {
+		var values orm.ParamsList
+		if _, err := o.Raw(sql, book_id).ValuesFlat(&values); err != nil {
+			return err
+		}
+		names := make([]string, len(values))
+		for i, value := range values {
+			names[i] = fmt.Sprint(value)
+		}
+		if _, err := o.QueryTable(NewBook().TableNameWithPrefix()).Filter("book_id", book_id).Update(orm.Params{"label": strings.Join(names, ",")}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//将项目标签字段中的标签导入关联表，关联表为空时执行一次.
+func MigrateBookLabels() {
+	o := orm.NewOrm()
+	if o.QueryTable(NewBookLabel().TableNameWithPrefix()).Exist() {
+		return
+	}
+	var books []*Book
+	if _, err := o.QueryTable(NewBook().TableNameWithPrefix()).Exclude("label", "").Limit(-1).All(&books, "book_id", "label"); err != nil {
+		beego.Error("查询项目标签失败 => ", err)
+		return
+	}
+	for _, book := range books {
+		if _, err := NewBookLabel().Replace(book.BookId, book.Label); err != nil {
+			beego.Error("导入项目标签失败 => ", book.BookId, err)
+		}
+	}
+	if err := NewLabel().ResetNumber(); err != nil {
+		beego.Error("更新标签数量失败 => ", err)
+	}
+}
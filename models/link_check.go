@@ -0,0 +1,470 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/utils"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/httplib"
+	"github.com/astaxie/beego/orm"
+)
+
+//链接检查发现的问题类型.
+const (
+	LinkIssueDeadLink          = "dead_link"          //指向不存在或未发布文档的站内链接
+	LinkIssueUnresolved        = "unresolved"         //未能解析的 $ 文档链接
+	LinkIssueMissingAttachment = "missing_attachment" //附件不存在或附件文件丢失
+	LinkIssueMissingImage      = "missing_image"      //站内图片文件丢失
+	LinkIssueExternal          = "external"           //无法访问的外部链接
+)
+
+//链接检查的状态.
+const (
+	LinkCheckRunning = 0
+	LinkCheckDone    = 1
+	LinkCheckFailed  = 2
+)
+
+const (
+	//每个项目保留的检查报告数量
+	linkCheckKeepCount = 10
+	//每次检查的外部链接数量上限和请求间隔
+	linkCheckExternalLimit    = 200
+	linkCheckExternalInterval = 500 * time.Millisecond
+)
+
+//外部链接指向内网地址时返回的错误.
+var errLinkCheckForbiddenAddress = errors.New("不允许访问内网地址")
+
+//运营商级 NAT 使用的共享地址段.
+var linkCheckSharedNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+var linkCheckRunning = struct {
+	sync.Mutex
+	books map[int]bool
+}{books: make(map[int]bool)}
+
+//项目的链接检查报告.
+type LinkCheck struct {
+	CheckId    int          `orm:"pk;auto;column(check_id)" json:"check_id"`
+	BookId     int          `orm:"column(book_id);type(int);index" json:"book_id"`
+	Status     int          `orm:"column(status);type(int);default(0)" json:"status"`       //状态：0 检查中/1 已完成/2 失败
+	External   int          `orm:"column(external);type(int);default(0)" json:"external"`   //是否检查外部链接：0 否/1 是
+	MemberId   int          `orm:"column(member_id);type(int);default(0)" json:"member_id"` //发起检查的用户，0 表示发布后自动检查
+	DocCount   int          `orm:"column(doc_count);type(int);default(0)" json:"doc_count"`
+	LinkCount  int          `orm:"column(link_count);type(int);default(0)" json:"link_count"`
+	IssueCount int          `orm:"column(issue_count);type(int);default(0)" json:"issue_count"`
+	Issues     string       `orm:"column(issues);type(text);null" json:"-"`
+	StartTime  time.Time    `orm:"column(start_time);type(datetime);auto_now_add" json:"start_time"`
+	FinishTime time.Time    `orm:"column(finish_time);type(datetime);null" json:"finish_time"`
+	IssueList  []*LinkIssue `orm:"-" json:"issues"`
+}
+
+//链接检查发现的问题.
+type LinkIssue struct {
+	DocumentId int    `json:"doc_id"`
+	DocName    string `json:"doc_name"`
+	Identify   string `json:"identify"`
+	Type       string `json:"type"`
+	TypeName   string `json:"type_name"`
+	Url        string `json:"url"`
+	Text       string `json:"text"`
+	Message    string `json:"message"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *LinkCheck) TableName() string {
+	return "link_check"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *LinkCheck) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *LinkCheck) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewLinkCheck() *LinkCheck {
+	return &LinkCheck{}
+}
+
+//问题类型的名称.
+func LinkIssueTypeName(issue_type string) string {
+	switch issue_type {
+	case LinkIssueDeadLink:
+		return "失效链接"
+	case LinkIssueUnresolved:
+		return "未解析的文档链接"
+	case LinkIssueMissingAttachment:
+		return "附件丢失"
+	case LinkIssueMissingImage:
+		return "图片丢失"
+	case LinkIssueExternal:
+		return "外部链接无法访问"
+	}
+	return issue_type
+}
+
+//查询项目最近一次链接检查的报告.
+func (m *LinkCheck) FindLatest(book_id int) (*LinkCheck, error) {
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).OrderBy("-check_id").One(m)
+	if err != nil {
+		return m, err
+	}
+	if m.Issues != "" {
+		if err := json.Unmarshal([]byte(m.Issues), &m.IssueList); err != nil {
+			beego.Error("解析链接检查报告失败 => ", m.CheckId, err)
+		}
+	}
+	if m.IssueList == nil {
+		m.IssueList = make([]*LinkIssue, 0)
+	}
+	for _, issue := range m.IssueList {
+		issue.TypeName = LinkIssueTypeName(issue.Type)
+	}
+	return m, nil
+}
+
+//后台检查项目的链接，同一项目同时只能有一个检查任务.
+func StartLinkCheck(book_id, member_id int, base_url string, external bool) (*LinkCheck, error) {
+	linkCheckRunning.Lock()
+	defer linkCheckRunning.Unlock()
+
+	if linkCheckRunning.books[book_id] {
+		return nil, ErrLinkCheckRunning
+	}
+	check := NewLinkCheck()
+	check.BookId = book_id
+	check.MemberId = member_id
+	if external {
+		check.External = 1
+	}
+	if _, err := orm.NewOrm().Insert(check); err != nil {
+		return nil, err
+	}
+	linkCheckRunning.books[book_id] = true
+
+	go func() {
+		defer func() {
+			linkCheckRunning.Lock()
+			delete(linkCheckRunning.books, book_id)
+			linkCheckRunning.Unlock()
+		}()
+		if err := check.run(base_url); err != nil {
+			beego.Error("链接检查失败 => ", book_id, err)
+		}
+	}()
+	return check, nil
+}
+
+//检查项目已发布文档中的链接和图片，保存报告并清理旧报告.
+func (m *LinkCheck) run(base_url string) error {
+	o := orm.NewOrm()
+
+	checker, err := newLinkChecker(m.BookId, base_url, m.External == 1)
+	if err == nil {
+		err = checker.check()
+	}
+	params := orm.Params{"finish_time": time.Now(), "status": LinkCheckFailed}
+	if err == nil {
+		b, _ := json.Marshal(checker.issues)
+		params = orm.Params{
+			"finish_time": time.Now(),
+			"status":      LinkCheckDone,
+			"doc_count":   checker.doc_count,
+			"link_count":  checker.link_count,
+			"issue_count": len(checker.issues),
+			"issues":      string(b),
+		}
+	}
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("check_id", m.CheckId).Update(params); err != nil {
+		return err
+	}
+
+	var ids orm.ParamsList
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("book_id", m.BookId).OrderBy("-check_id").Offset(linkCheckKeepCount).Limit(-1).ValuesFlat(&ids, "check_id"); err == nil && len(ids) > 0 {
+		o.QueryTable(m.TableNameWithPrefix()).Filter("check_id__in", ids...).Delete()
+	}
+	return err
+}
+
+//项目链接检查器.
+type linkChecker struct {
+	book       *Book
+	base_url   string
+	external   bool
+	docs       []*Document
+	refs       map[string]*Document //本项目文档的标识和ID
+	books      map[string]*linkCheckBook
+	externals  map[string]string //外部链接的检查结果，空字符串表示可以访问
+	transport  *http.Transport
+	issues     []*LinkIssue
+	doc_count  int
+	link_count int
+}
+
+//被链接的其他项目.
+type linkCheckBook struct {
+	book *Book
+	refs map[string]*Document
+}
+
+func newLinkChecker(book_id int, base_url string, external bool) (*linkChecker, error) {
+	book, err := NewBook().Find(book_id)
+	if err != nil {
+		return nil, err
+	}
+	c := &linkChecker{
+		book:      book,
+		base_url:  strings.TrimRight(base_url, "/"),
+		external:  external,
+		books:     make(map[string]*linkCheckBook),
+		externals: make(map[string]string),
+		issues:    make([]*LinkIssue, 0),
+	}
+	var docs []*Document
+	if _, err := orm.NewOrm().QueryTable(NewDocument().TableNameWithPrefix()).Filter("book_id", book_id).Limit(-1).All(&docs); err != nil {
+		return nil, err
+	}
+	c.refs = documentRefs(docs)
+	//读者只能访问已发布的文档
+	access := NewDocumentAccess(book_id, 0, -1, true).PublishedOnly()
+	for _, doc := range docs {
+		if access.CanRead(doc.DocumentId) && strings.TrimSpace(doc.Release) != "" {
+			c.docs = append(c.docs, doc)
+		}
+	}
+	c.doc_count = len(c.docs)
+	return c, nil
+}
+
+//文档的标识和ID到文档的映射.
+func documentRefs(docs []*Document) map[string]*Document {
+	refs := make(map[string]*Document, len(docs)*2)
+	for _, doc := range docs {
+		refs[strconv.Itoa(doc.DocumentId)] = doc
+		if doc.Identify != "" {
+			refs[strings.ToLower(doc.Identify)] = doc
+		}
+	}
+	return refs
+}
+
+func (c *linkChecker) check() error {
+	for _, doc := range c.docs {
+		gq, err := goquery.NewDocumentFromReader(strings.NewReader(doc.Release))
+		if err != nil {
+			return err
+		}
+		gq.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+			href, _ := s.Attr("href")
+			c.checkLink(doc, strings.TrimSpace(href), strings.TrimSpace(s.Text()), false)
+		})
+		gq.Find("img[src]").Each(func(i int, s *goquery.Selection) {
+			src, _ := s.Attr("src")
+			alt, _ := s.Attr("alt")
+			c.checkLink(doc, strings.TrimSpace(src), alt, true)
+		})
+	}
+	return nil
+}
+
+func (c *linkChecker) addIssue(doc *Document, issue_type, link, text, message string) {
+	c.issues = append(c.issues, &LinkIssue{
+		DocumentId: doc.DocumentId,
+		DocName:    doc.DocumentName,
+		Identify:   doc.Identify,
+		Type:       issue_type,
+		Url:        link,
+		Text:       text,
+		Message:    message,
+	})
+}
+
+//检查一个链接或图片地址.
+func (c *linkChecker) checkLink(doc *Document, link, text string, is_image bool) {
+	if link == "" || strings.HasPrefix(link, "#") || strings.HasPrefix(link, "data:") ||
+		strings.HasPrefix(link, "mailto:") || strings.HasPrefix(link, "javascript:") {
+		return
+	}
+	c.link_count++
+	if strings.HasPrefix(link, "$") {
+		c.addIssue(doc, LinkIssueUnresolved, link, text, "链接的文档不存在")
+		return
+	}
+	if c.base_url != "" && strings.HasPrefix(link, c.base_url+"/") {
+		link = strings.TrimPrefix(link, c.base_url)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		c.addIssue(doc, LinkIssueDeadLink, link, text, "链接格式错误")
+		return
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		if c.external {
+			if message := c.checkExternal(link); message != "" {
+				c.addIssue(doc, LinkIssueExternal, link, text, message)
+			}
+		}
+		return
+	}
+	if u.Scheme != "" || u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+		return
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case len(segments) == 3 && segments[0] == "read":
+		if message := c.checkDocument(segments[1], segments[2]); message != "" {
+			c.addIssue(doc, LinkIssueDeadLink, link, text, message)
+		}
+	case len(segments) == 2 && segments[0] == "books":
+		if c.findBook(segments[1]) == nil {
+			c.addIssue(doc, LinkIssueDeadLink, link, text, "项目不存在")
+		}
+	case len(segments) == 3 && segments[0] == "attach_files":
+		if message := c.checkAttachment(segments[2]); message != "" {
+			c.addIssue(doc, LinkIssueMissingAttachment, link, text, message)
+		}
+	case segments[0] == "uploads" && utils.StoreType == utils.StoreLocal:
+		if ModelStoreLocal.IsObjectExist(strings.TrimPrefix(u.Path, "/")) != nil {
+			issue_type := LinkIssueMissingAttachment
+			if is_image {
+				issue_type = LinkIssueMissingImage
+			}
+			c.addIssue(doc, issue_type, link, text, "文件不存在")
+		}
+	}
+}
+
+//查询被链接的项目，不存在或已删除时返回 nil.
+func (c *linkChecker) findBook(identify string) *linkCheckBook {
+	key := strings.ToLower(identify)
+	if strings.EqualFold(identify, c.book.Identify) {
+		return &linkCheckBook{book: c.book, refs: c.refs}
+	}
+	if item, ok := c.books[key]; ok {
+		return item
+	}
+	var item *linkCheckBook
+	if book, err := NewBook().FindByIdentify(identify); err == nil {
+		var docs []*Document
+		orm.NewOrm().QueryTable(NewDocument().TableNameWithPrefix()).Filter("book_id", book.BookId).Limit(-1).All(&docs, "document_id", "identify", "publish_status")
+		item = &linkCheckBook{book: book, refs: documentRefs(docs)}
+	}
+	c.books[key] = item
+	return item
+}
+
+//检查站内文档链接.
+func (c *linkChecker) checkDocument(identify, doc_key string) string {
+	item := c.findBook(identify)
+	if item == nil {
+		return "项目不存在"
+	}
+	target, ok := item.refs[strings.ToLower(doc_key)]
+	if !ok {
+		return "文档不存在"
+	}
+	if target.PublishStatus != conf.DocumentPublished {
+		return "文档未发布"
+	}
+	return ""
+}
+
+//检查附件下载链接.
+func (c *linkChecker) checkAttachment(attach_id string) string {
+	id, _ := strconv.Atoi(attach_id)
+	attach, err := NewAttachment().Find(id)
+	if err != nil {
+		return "附件不存在"
+	}
+	if ModelStoreLocal.IsObjectExist(strings.TrimLeft(attach.FilePath, "./")) != nil {
+		return "附件文件不存在"
+	}
+	return ""
+}
+
+//检查外部链接是否可以访问，同一地址只检查一次，超过数量上限的链接不再检查.
+func (c *linkChecker) checkExternal(link string) string {
+	if message, ok := c.externals[link]; ok {
+		return message
+	}
+	if len(c.externals) >= linkCheckExternalLimit {
+		return ""
+	}
+	if len(c.externals) > 0 {
+		time.Sleep(linkCheckExternalInterval)
+	}
+	if c.transport == nil {
+		c.transport = newLinkCheckTransport()
+	}
+	message := ""
+	resp, err := httplib.Head(link).SetTransport(c.transport).Response()
+	//部分网站不支持 HEAD 请求
+	if err == nil && (resp.StatusCode == 405 || resp.StatusCode == 403) {
+		resp.Body.Close()
+		resp, err = httplib.Get(link).SetTransport(c.transport).Response()
+	}
+	if errors.Is(err, errLinkCheckForbiddenAddress) {
+		message = "不允许检查内网地址"
+	} else if err != nil {
+		message = "无法访问"
+	} else {
+		if resp.StatusCode >= 400 {
+			message = "HTTP " + strconv.Itoa(resp.StatusCode)
+		}
+		resp.Body.Close()
+	}
+	c.externals[link] = message
+	return message
+}
+
+//外部链接检查使用的连接，建立连接时校验解析后的 IP，重定向后的地址同样会重新校验，
+//避免通过文档中的链接探测服务器所在的内网.
+func newLinkCheckTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isLinkCheckForbiddenIP(ip) {
+				return errLinkCheckForbiddenAddress
+			}
+			return nil
+		},
+	}
+	return &http.Transport{
+		//不使用环境变量中的代理，保证校验的是实际连接的地址
+		Proxy: nil,
+		Dial: func(network, address string) (net.Conn, error) {
+			conn, err := dialer.Dial(network, address)
+			if err == nil {
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+			}
+			return conn, err
+		},
+		TLSHandshakeTimeout: 5 * time.Second,
+	}
+}
+
+//是否为回环、私有、链路本地等不允许检查的地址.
+func isLinkCheckForbiddenIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || linkCheckSharedNet.Contains(ip)
+}
@@ -2,18 +2,52 @@ package models
 
 import (
 	"errors"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/JermineHu/DocStack/conf"
+	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/orm"
 )
 
-var loggerQueue = &logQueue{channel: make(chan *Logger, 100), isRuning: 0}
+const (
+	//日志队列已满时直接丢弃日志.
+	LoggerPolicyDrop = "drop"
+	//日志队列已满时等待写入，超时后丢弃日志.
+	LoggerPolicyBlock = "block"
+)
+
+var ErrLoggerQueueFull = errors.New("日志队列已满，日志已丢弃")
+
+var loggerQueue = &logQueue{}
 
+//异步批量写入日志的队列.
 type logQueue struct {
+	once     sync.Once
+	mu       sync.RWMutex
 	channel  chan *Logger
-	isRuning int32
+	stop     chan struct{}
+	done     chan struct{}
+	isClosed bool
+
+	batchSize     int
+	flushInterval time.Duration
+	policy        string
+	blockTimeout  time.Duration
+
+	written int64
+	dropped int64
+	failed  int64
+}
+
+//日志队列的运行状态.
+type LoggerStats struct {
+	Queued  int   `json:"queued"`
+	Written int64 `json:"written"`
+	Dropped int64 `json:"dropped"`
+	Failed  int64 `json:"failed"`
 }
 
 // Logger struct .
@@ -28,6 +62,74 @@ type Logger struct {
 	CreateTime   time.Time `orm:"type(datetime);column(create_time);auto_now_add" json:"create_time"`
 	UserAgent    string    `orm:"column(user_agent);size(500)" json:"user_agent"`
 	IPAddress    string    `orm:"column(ip_address);size(255)" json:"ip_address"`
+	// 审计动作，例如 book.delete，见 conf.Audit* 常量
+	Action     string `orm:"column(action);size(100);index" json:"action"`
+	BookId     int    `orm:"column(book_id);type(int);default(0);index" json:"book_id"`
+	ObjectId   int    `orm:"column(object_id);type(int);default(0)" json:"object_id"` //被操作对象的ID，例如文档ID、用户ID
+	Account    string `orm:"-" json:"account"`
+	BookName   string `orm:"-" json:"book_name"`
+	ActionName string `orm:"-" json:"action_name"`
+}
+
+//审计日志查询条件，MemberId 和 BookId 为 0 表示不限制.
+type LoggerFilter struct {
+	MemberId  int
+	BookId    int
+	Action    string
+	Category  string
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+//审计动作名称.
+var loggerActionNames = map[string]string{
+	conf.AuditMemberCreate:     "创建用户",
+	conf.AuditMemberStatus:     "变更用户状态",
+	conf.AuditMemberRole:       "变更用户角色",
+	conf.AuditMemberUpdate:     "编辑用户",
+	conf.AuditMemberDelete:     "删除用户",
+	conf.AuditBookCreate:       "创建项目",
+	conf.AuditBookUpdate:       "修改项目",
+	conf.AuditBookDelete:       "删除项目",
+	conf.AuditBookTransfer:     "转让项目",
+	conf.AuditBookPrivately:    "变更项目可见性",
+	conf.AuditBookToken:        "变更访问令牌",
+	conf.AuditBookRelease:      "发布项目",
+	conf.AuditBookReviewer:     "变更项目审阅人",
+	conf.AuditBookClone:        "克隆项目",
+	conf.AuditBookMemberAdd:    "添加项目成员",
+	conf.AuditBookMemberRole:   "变更成员角色",
+	conf.AuditBookMemberRemove: "移除项目成员",
+	conf.AuditBookTeamAdd:      "添加项目团队",
+	conf.AuditBookTeamRole:     "变更团队角色",
+	conf.AuditBookTeamRemove:   "移除项目团队",
+	conf.AuditDocumentSave:     "保存文档",
+	conf.AuditDocumentContent:  "编辑文档内容",
+	conf.AuditDocumentDelete:   "删除文档",
+	conf.AuditDocumentMove:     "移动文档到其他项目",
+	conf.AuditDocumentCopy:     "复制文档到其他项目",
+	conf.AuditDocumentPublish:  "发布文档",
+	conf.AuditDocumentSchedule: "定时发布文档",
+	conf.AuditHistoryRestore:   "恢复历史版本",
+	conf.AuditHistoryDelete:    "删除历史版本",
+	conf.AuditAttachmentDelete: "删除附件",
+	conf.AuditPermissionCreate: "添加文档权限",
+	conf.AuditPermissionDelete: "删除文档权限",
+	conf.AuditCommentDelete:    "删除评论",
+	conf.AuditCommentModerate:  "审核评论",
+	conf.AuditReviewSubmit:     "提交文档审阅",
+	conf.AuditReviewApprove:    "审阅通过",
+	conf.AuditReviewReject:     "审阅退回",
+	conf.AuditVersionCreate:    "创建项目版本",
+	conf.AuditVersionDelete:    "删除项目版本",
+	conf.AuditBranchCreate:     "创建项目分支",
+	conf.AuditBranchMerge:      "合并分支文档",
+	conf.AuditRecycleRestore:   "恢复回收站条目",
+	conf.AuditRecyclePurge:     "彻底删除回收站条目",
+	conf.AuditLabelRename:      "重命名标签",
+	conf.AuditLabelMerge:       "合并标签",
+	conf.AuditLabelDelete:      "删除标签",
+	conf.AuditSiteSetting:      "修改站点配置",
 }
 
 // TableName 获取对应数据库表名.
@@ -57,21 +159,270 @@ func (m *Logger) Add() error {
 	if m.Content == "" {
 		return errors.New("日志内容不能为空")
 	}
-	loggerQueue.channel <- m
-	if atomic.LoadInt32(&(loggerQueue.isRuning)) <= 0 {
-		atomic.AddInt32(&(loggerQueue.isRuning), 1)
-		go addLoggerAsync()
+	return loggerQueue.push(m)
+}
+
+//获取日志队列的运行状态.
+func GetLoggerStats() LoggerStats {
+	loggerQueue.mu.RLock()
+	defer loggerQueue.mu.RUnlock()
+	return LoggerStats{
+		Queued:  len(loggerQueue.channel),
+		Written: atomic.LoadInt64(&loggerQueue.written),
+		Dropped: atomic.LoadInt64(&loggerQueue.dropped),
+		Failed:  atomic.LoadInt64(&loggerQueue.failed),
 	}
-	return nil
 }
 
-func addLoggerAsync() {
-	defer atomic.AddInt32(&(loggerQueue.isRuning), -1)
-	o := orm.NewOrm()
+//关闭日志队列，将队列中剩余的日志写入数据库，最多等待 timeout.
+//关闭后写入的日志会直接同步写入数据库.
+func CloseLogger(timeout time.Duration) error {
+	q := loggerQueue
+	//与 push 共用 once，未启动的队列不会在关闭后再启动，已启动时等待初始化完成
+	q.once.Do(func() {})
+	q.mu.Lock()
+	if q.isClosed || q.channel == nil {
+		q.isClosed = true
+		q.mu.Unlock()
+		return nil
+	}
+	q.isClosed = true
+	q.mu.Unlock()
+
+	close(q.stop)
+	select {
+	case <-q.done:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("等待日志写入超时")
+	}
+}
+
+//按配置初始化日志队列并启动写入协程.
+func (q *logQueue) start() {
+	size := beego.AppConfig.DefaultInt("log_queue_size", 1000)
+	if size <= 0 {
+		size = 1000
+	}
+	q.batchSize = beego.AppConfig.DefaultInt("log_batch_size", 100)
+	if q.batchSize <= 0 {
+		q.batchSize = 100
+	}
+	q.flushInterval = time.Duration(beego.AppConfig.DefaultInt("log_flush_interval", 3)) * time.Second
+	if q.flushInterval <= 0 {
+		q.flushInterval = 3 * time.Second
+	}
+	q.policy = beego.AppConfig.DefaultString("log_full_policy", LoggerPolicyBlock)
+	q.blockTimeout = time.Duration(beego.AppConfig.DefaultInt("log_block_timeout", 500)) * time.Millisecond
+
+	q.mu.Lock()
+	q.channel = make(chan *Logger, size)
+	q.stop = make(chan struct{})
+	q.done = make(chan struct{})
+	q.mu.Unlock()
+
+	go q.run()
+}
+
+//将日志放入队列，队列已满时按配置的策略丢弃或等待.
+func (q *logQueue) push(logger *Logger) error {
+	q.once.Do(q.start)
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	//队列关闭后直接写入数据库，避免丢失日志
+	if q.isClosed {
+		_, err := orm.NewOrm().Insert(logger)
+		return err
+	}
+	select {
+	case q.channel <- logger:
+		return nil
+	default:
+	}
+	if q.policy == LoggerPolicyBlock && q.blockTimeout > 0 {
+		timer := time.NewTimer(q.blockTimeout)
+		defer timer.Stop()
+		select {
+		case q.channel <- logger:
+			return nil
+		case <-timer.C:
+		}
+	}
+	atomic.AddInt64(&q.dropped, 1)
+	beego.Warn("日志队列已满，丢弃日志 => ", logger.Content)
+	return ErrLoggerQueueFull
+}
+
+//写入协程，日志达到批量大小或到达刷新间隔时写入数据库.
+func (q *logQueue) run() {
+	defer close(q.done)
+
+	ticker := time.NewTicker(q.flushInterval)
+	defer ticker.Stop()
 
+	batch := make([]*Logger, 0, q.batchSize)
+	var reported int64
+	flush := func() {
+		if len(batch) > 0 {
+			q.flush(batch)
+			batch = batch[:0]
+		}
+	}
 	for {
-		logger := <-loggerQueue.channel
+		select {
+		case logger := <-q.channel:
+			batch = append(batch, logger)
+			if len(batch) >= q.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			//定期报告新增的丢弃日志，便于及时调整队列配置
+			if dropped := atomic.LoadInt64(&q.dropped); dropped > reported {
+				beego.Error("日志队列已满，累计丢弃日志 => ", dropped)
+				reported = dropped
+			}
+		case <-q.stop:
+			//写入队列中剩余的日志后退出
+			for {
+				select {
+				case logger := <-q.channel:
+					batch = append(batch, logger)
+					if len(batch) >= q.batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+//批量写入日志，失败时逐条重试，仍然失败的日志记录到系统日志中.
+func (q *logQueue) flush(batch []*Logger) {
+	o := orm.NewOrm()
+	if _, err := o.InsertMulti(len(batch), batch); err == nil {
+		atomic.AddInt64(&q.written, int64(len(batch)))
+		return
+	} else {
+		beego.Error("批量写入日志失败，逐条重试 => ", err)
+	}
+	for _, logger := range batch {
+		logger.LoggerId = 0
+		if _, err := o.Insert(logger); err != nil {
+			atomic.AddInt64(&q.failed, 1)
+			beego.Error("写入日志失败 => ", err, logger.Category, logger.MemberId, logger.Content)
+		} else {
+			atomic.AddInt64(&q.written, 1)
+		}
+	}
+}
+
+//获取审计动作名称.
+func LoggerActionName(action string) string {
+	if name, ok := loggerActionNames[action]; ok {
+		return name
+	}
+	return action
+}
+
+//获取全部审计动作，按动作排序.
+func LoggerActions() []map[string]string {
+	actions := make([]string, 0, len(loggerActionNames))
+	for action := range loggerActionNames {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	result := make([]map[string]string, 0, len(actions))
+	for _, action := range actions {
+		result = append(result, map[string]string{"action": action, "name": loggerActionNames[action]})
+	}
+	return result
+}
+
+func (m *Logger) query(filter *LoggerFilter) orm.QuerySeter {
+	qs := orm.NewOrm().QueryTable(m.TableNameWithPrefix())
+	if filter == nil {
+		return qs
+	}
+	if filter.MemberId != 0 {
+		qs = qs.Filter("member_id", filter.MemberId)
+	}
+	if filter.BookId != 0 {
+		qs = qs.Filter("book_id", filter.BookId)
+	}
+	if filter.Action != "" {
+		qs = qs.Filter("action", filter.Action)
+	}
+	if filter.Category != "" {
+		qs = qs.Filter("category", filter.Category)
+	}
+	if !filter.StartTime.IsZero() {
+		qs = qs.Filter("create_time__gte", filter.StartTime)
+	}
+	if !filter.EndTime.IsZero() {
+		qs = qs.Filter("create_time__lt", filter.EndTime)
+	}
+	return qs
+}
+
+//分页查询日志.
+func (m *Logger) FindToPager(filter *LoggerFilter, pageIndex, pageSize int) (loggers []*Logger, totalCount int, err error) {
+	if pageIndex <= 0 {
+		pageIndex = 1
+	}
+	offset := (pageIndex - 1) * pageSize
+
+	count, err := m.query(filter).Count()
+	if err != nil {
+		return
+	}
+	totalCount = int(count)
+
+	_, err = m.query(filter).OrderBy("-log_id").Offset(offset).Limit(pageSize).All(&loggers)
+	if err != nil {
+		return
+	}
+	resolveLoggers(loggers)
+	return
+}
+
+//查询日志用于导出，最多返回 limit 条.
+func (m *Logger) FindAll(filter *LoggerFilter, limit int) (loggers []*Logger, err error) {
+	_, err = m.query(filter).OrderBy("-log_id").Limit(limit).All(&loggers)
+	if err != nil {
+		return
+	}
+	resolveLoggers(loggers)
+	return
+}
 
-		o.Insert(logger)
+//填充日志的用户账号和项目名称.
+func resolveLoggers(loggers []*Logger) {
+	accounts := make(map[int]string)
+	books := make(map[int]string)
+	for _, logger := range loggers {
+		logger.ActionName = LoggerActionName(logger.Action)
+		if account, ok := accounts[logger.MemberId]; ok {
+			logger.Account = account
+		} else if member, err := NewMember().Find(logger.MemberId); err == nil {
+			accounts[logger.MemberId] = member.Account
+			logger.Account = member.Account
+		}
+		if logger.BookId <= 0 {
+			continue
+		}
+		if name, ok := books[logger.BookId]; ok {
+			logger.BookName = name
+		} else if book, err := NewBook().Find(logger.BookId); err == nil {
+			books[logger.BookId] = book.BookName
+			logger.BookName = book.BookName
+		} else {
+			books[logger.BookId] = ""
+		}
 	}
 }
@@ -29,7 +29,7 @@ type Member struct {
 	Avatar        string    `orm:"column(avatar)" json:"avatar"`
 	Role          int       `orm:"column(role);type(int);default(1);index" json:"role"` //用户角色：0 超级管理员 /1 管理员/ 2 普通用户 .
 	RoleName      string    `orm:"-" json:"role_name"`
-	Status        int       `orm:"column(status);type(int);default(0)" json:"status"` //用户状态：0 正常/1 禁用
+	Status        int       `orm:"column(status);type(int);default(0)" json:"status"` //用户状态：0 正常/1 禁用/2 待验证邮箱
 	CreateTime    time.Time `orm:"type(datetime);column(create_time);auto_now_add" json:"create_time"`
 	CreateAt      int       `orm:"type(int);column(create_at)" json:"create_at"`
 	LastLoginTime time.Time `orm:"type(datetime);column(last_login_time);null" json:"last_login_time"`
@@ -64,6 +64,13 @@ func (m *Member) Login(account string, password string) (*Member, error) {
 	err := o.QueryTable(m.TableNameWithPrefix()).Filter("account", account).Filter("status", 0).One(member)
 
 	if err != nil {
+		//注册后尚未完成邮箱验证的用户，密码正确时提示验证邮箱
+		if o.QueryTable(m.TableNameWithPrefix()).Filter("account", account).Filter("status", conf.MemberStatusUnverified).One(member) == nil {
+			if ok, err := utils.PasswordVerify(member.Password, password); ok && err == nil {
+				return member, ErrMemberUnverified
+			}
+			return member, ErrorMemberPasswordError
+		}
 		if beego.AppConfig.DefaultBool("ldap_enable", false) == true {
 			logs.Info("转入LDAP登陆")
 			return member.ldapLogin(account, password)
@@ -299,7 +306,7 @@ func (m *Member) Valid(is_hash_password bool) error {
 	if m.Role != conf.MemberGeneralRole && m.Role != conf.MemberSuperRole && m.Role != conf.MemberAdminRole {
 		return ErrMemberRoleError
 	}
-	if m.Status != 0 && m.Status != 1 {
+	if m.Status != conf.MemberStatusNormal && m.Status != conf.MemberStatusDisabled && m.Status != conf.MemberStatusUnverified {
 		m.Status = 0
 	}
 	//邮箱格式校验
@@ -379,6 +386,26 @@ func (m *Member) Delete(oldId int, newId int) error {
 		o.Rollback()
 		return err
 	}
+	_, err = o.Raw("UPDATE md_document_reviews SET member_id = ? WHERE member_id = ?", newId, oldId).Exec()
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	_, err = o.Raw("UPDATE md_document_reviews SET reviewer_id = ? WHERE reviewer_id = ?", newId, oldId).Exec()
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	_, err = o.Raw("UPDATE md_book_versions SET member_id = ? WHERE member_id = ?", newId, oldId).Exec()
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	_, err = o.Raw("UPDATE md_book_branches SET member_id = ? WHERE member_id = ?", newId, oldId).Exec()
+	if err != nil {
+		o.Rollback()
+		return err
+	}
 	_, err = o.Raw("UPDATE md_documents SET member_id = ? WHERE member_id = ?;", newId, oldId).Exec()
 	if err != nil {
 		o.Rollback()
@@ -426,6 +453,39 @@ func (m *Member) Delete(oldId int, newId int) error {
 			}
 		}
 	}
+	//移出用户加入的团队
+	if _, err := o.QueryTable(NewTeamMember().TableNameWithPrefix()).Filter("member_id", oldId).Delete(); err != nil {
+		beego.Error(err)
+	}
+	//删除用户的通知、通知设置和关注
+	if _, err := o.QueryTable(NewNotification().TableNameWithPrefix()).Filter("member_id", oldId).Delete(); err != nil {
+		beego.Error(err)
+	}
+	if _, err := o.QueryTable(NewNotificationSetting().TableNameWithPrefix()).Filter("member_id", oldId).Delete(); err != nil {
+		beego.Error(err)
+	}
+	if _, err := o.QueryTable(NewWatch().TableNameWithPrefix()).Filter("member_id", oldId).Delete(); err != nil {
+		beego.Error(err)
+	}
+	//移出用户担任的项目审阅人
+	if _, err := o.QueryTable(NewBookReviewer().TableNameWithPrefix()).Filter("member_id", oldId).Delete(); err != nil {
+		beego.Error(err)
+	}
+	//用户所有的组织转给接收人，其他组织成员关系直接删除
+	var org_members []*OrganizationMember
+	if _, err := o.QueryTable(NewOrganizationMember().TableNameWithPrefix()).Filter("member_id", oldId).All(&org_members); err == nil {
+		for _, org_member := range org_members {
+			if org_member.RoleId == OrgRoleOwner {
+				o.QueryTable(org_member.TableNameWithPrefix()).Filter("org_id", org_member.OrgId).Filter("member_id", newId).Delete()
+				org_member.MemberId = newId
+				if _, err := o.Update(org_member, "member_id"); err != nil {
+					beego.Error(err)
+				}
+			} else if _, err := o.Delete(org_member); err != nil {
+				beego.Error(err)
+			}
+		}
+	}
 
 	if err = o.Commit(); err != nil {
 		o.Rollback()
@@ -12,6 +12,7 @@ type MemberToken struct {
 	MemberId  int       `orm:"column(member_id);type(int)" json:"member_id"`
 	Token     string    `orm:"column(token);size(150);index" json:"token"`
 	Email     string    `orm:"column(email);size(255)" json:"email"`
+	Action    string    `orm:"column(action);size(50);default(find_password)" json:"action"` //令牌用途：find_password 找回密码/verify_email 验证邮箱
 	IsValid   bool      `orm:"column(is_valid)" json:"is_valid"`
 	ValidTime time.Time `orm:"column(valid_time);null" json:"valid_time"`
 	SendTime  time.Time `orm:"column(send_time);auto_now_add;type(datetime)" json:"send_time"`
@@ -35,11 +36,20 @@ func NewMemberToken() *MemberToken {
 	return &MemberToken{}
 }
 
+//保存令牌，生成新令牌或令牌被使用时，同一邮箱同一用途的其他未使用令牌立即失效.
 func (m *MemberToken) InsertOrUpdate() (*MemberToken, error) {
 	o := orm.NewOrm()
 
 	if m.TokenId > 0 {
-		_, err := o.Update(m)
+		if _, err := o.Update(m); err != nil {
+			return m, err
+		}
+		if !m.ValidTime.IsZero() {
+			return m, m.invalidateOthers(o)
+		}
+		return m, nil
+	}
+	if err := m.invalidateOthers(o); err != nil {
 		return m, err
 	}
 	_, err := o.Insert(m)
@@ -47,6 +57,17 @@ func (m *MemberToken) InsertOrUpdate() (*MemberToken, error) {
 	return m, err
 }
 
+//使同一邮箱同一用途的其他未使用令牌失效.
+func (m *MemberToken) invalidateOthers(o orm.Ormer) error {
+	_, err := o.QueryTable(m.TableNameWithPrefix()).
+		Filter("email", m.Email).
+		Filter("action", m.Action).
+		Filter("valid_time__isnull", true).
+		Exclude("token_id", m.TokenId).
+		Update(orm.Params{"valid_time": time.Now()})
+	return err
+}
+
 func (m *MemberToken) FindByFieldFirst(field string, value interface{}) (*MemberToken, error) {
 	o := orm.NewOrm()
 
@@ -55,10 +76,25 @@ func (m *MemberToken) FindByFieldFirst(field string, value interface{}) (*Member
 	return m, err
 }
 
+//根据令牌和用途查询令牌.
+func (m *MemberToken) FindByToken(token, action string) (*MemberToken, error) {
+	o := orm.NewOrm()
+
+	err := o.QueryTable(m.TableNameWithPrefix()).Filter("token", token).Filter("action", action).OrderBy("-token_id").One(m)
+
+	return m, err
+}
+
+//令牌是否已过期或已使用.
+func (m *MemberToken) IsExpired(expired int) bool {
+	return !m.ValidTime.IsZero() || time.Now().Sub(m.SendTime).Minutes() > float64(expired)
+}
+
+//统计指定邮箱在时间段内的发送次数.
 func (m *MemberToken) FindSendCount(mail string, start_time time.Time, end_time time.Time) (int, error) {
 	o := orm.NewOrm()
 
-	c, err := o.QueryTable(m.TableNameWithPrefix()).Filter("send_time__gte", start_time.Format("2006-01-02 15:04:05")).Filter("send_time__lte", end_time.Format("2006-01-02 15:04:05")).Count()
+	c, err := o.QueryTable(m.TableNameWithPrefix()).Filter("email", mail).Filter("send_time__gte", start_time.Format("2006-01-02 15:04:05")).Filter("send_time__lte", end_time.Format("2006-01-02 15:04:05")).Count()
 
 	if err != nil {
 		return 0, err
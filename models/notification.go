@@ -0,0 +1,469 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/utils"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+var (
+	mentionRegexp = regexp.MustCompile(`(?:^|[^\w@.])@([a-zA-Z][a-zA-Z0-9.]{2,50})`)
+	digestOnce    sync.Once
+)
+
+//站内通知.
+type Notification struct {
+	NotificationId int       `orm:"pk;auto;column(notification_id)" json:"notification_id"`
+	MemberId       int       `orm:"column(member_id);type(int);index" json:"member_id"` //接收通知的用户
+	SenderId       int       `orm:"column(sender_id);type(int);default(0)" json:"sender_id"`
+	Type           string    `orm:"column(type);size(50);index" json:"type"`
+	BookId         int       `orm:"column(book_id);type(int);default(0)" json:"book_id"`
+	DocumentId     int       `orm:"column(document_id);type(int);default(0)" json:"doc_id"`
+	ObjectId       int       `orm:"column(object_id);type(int);default(0)" json:"object_id"`
+	Title          string    `orm:"column(title);size(500)" json:"title"`
+	Url            string    `orm:"column(url);size(1000)" json:"url"`
+	IsRead         int       `orm:"column(is_read);type(int);default(0);index" json:"is_read"`
+	IsMailed       int       `orm:"column(is_mailed);type(int);default(0)" json:"is_mailed"` //是否已通过邮件摘要发送
+	CreateTime     time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+	SenderAccount  string    `orm:"-" json:"sender_account"`
+	TypeName       string    `orm:"-" json:"type_name"`
+}
+
+//用户的通知设置.
+type NotificationSetting struct {
+	MemberId int `orm:"pk;column(member_id);type(int)" json:"member_id"`
+	//邮件摘要频率：none/daily/weekly
+	Digest       string    `orm:"column(digest);size(20);default(none)" json:"digest"`
+	DigestTypes  string    `orm:"column(digest_types);size(500)" json:"digest_types"` //摘要包含的通知类型，多个用逗号分隔，为空表示全部
+	LastDigestAt time.Time `orm:"column(last_digest_at);type(datetime);null" json:"last_digest_at"`
+}
+
+var notificationTypeNames = map[string]string{
+	conf.NotifyMention:  "提及了你",
+	conf.NotifyReply:    "回复了你",
+	conf.NotifyDocument: "文档更新",
+	conf.NotifyMember:   "成员变更",
+	conf.NotifyReview:   "审阅请求",
+}
+
+// TableName 获取对应数据库表名.
+func (m *Notification) TableName() string {
+	return "notifications"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *Notification) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *Notification) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewNotification() *Notification {
+	return &Notification{}
+}
+
+// TableName 获取对应数据库表名.
+func (m *NotificationSetting) TableName() string {
+	return "notification_settings"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *NotificationSetting) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *NotificationSetting) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewNotificationSetting() *NotificationSetting {
+	return &NotificationSetting{}
+}
+
+//获取通知类型名称.
+func NotificationTypeName(typ string) string {
+	if name, ok := notificationTypeNames[typ]; ok {
+		return name
+	}
+	return typ
+}
+
+//获取全部通知类型.
+func NotificationTypes() []map[string]string {
+	types := []string{conf.NotifyMention, conf.NotifyReply, conf.NotifyDocument, conf.NotifyMember, conf.NotifyReview}
+	result := make([]map[string]string, 0, len(types))
+	for _, typ := range types {
+		result = append(result, map[string]string{"type": typ, "name": notificationTypeNames[typ]})
+	}
+	return result
+}
+
+//解析内容中 @ 提及的账号.
+func ParseMentions(content string) []string {
+	accounts := make([]string, 0)
+	exists := make(map[string]bool)
+	for _, match := range mentionRegexp.FindAllStringSubmatch(content, -1) {
+		account := strings.TrimRight(match[1], ".")
+		if !exists[account] {
+			exists[account] = true
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
+}
+
+//向多个用户发送通知，不会通知发送者本人.
+func (m *Notification) Send(member_ids []int) error {
+	o := orm.NewOrm()
+	sent := make(map[int]bool)
+	for _, member_id := range member_ids {
+		if member_id <= 0 || member_id == m.SenderId || sent[member_id] {
+			continue
+		}
+		sent[member_id] = true
+
+		//文档更新通知在用户阅读之前只保留一条
+		if m.Type == conf.NotifyDocument && o.QueryTable(m.TableNameWithPrefix()).
+			Filter("member_id", member_id).
+			Filter("type", m.Type).
+			Filter("document_id", m.DocumentId).
+			Filter("is_read", 0).Exist() {
+			continue
+		}
+		notification := *m
+		notification.NotificationId = 0
+		notification.MemberId = member_id
+		notification.IsRead = 0
+		notification.IsMailed = 0
+		if _, err := o.Insert(&notification); err != nil {
+			beego.Error("发送通知失败 => ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+//通知内容中被提及并且可以访问该文档的用户，participant_only 为 true 时只通知项目参与者，exclude 中的账号不会被通知.
+func (m *Notification) SendMentions(content string, participant_only bool, exclude ...string) error {
+	accounts := ParseMentions(content)
+	if len(accounts) == 0 {
+		return nil
+	}
+	excluded := make(map[string]bool, len(exclude))
+	for _, account := range exclude {
+		excluded[account] = true
+	}
+	book, _ := NewBook().Find(m.BookId)
+	member_ids := make([]int, 0, len(accounts))
+	for _, account := range accounts {
+		if excluded[account] {
+			continue
+		}
+		member, err := NewMember().FindByAccount(account)
+		if err == nil && m.canRead(book, member, participant_only) {
+			member_ids = append(member_ids, member.MemberId)
+		}
+	}
+	notification := *m
+	notification.Type = conf.NotifyMention
+	return notification.Send(member_ids)
+}
+
+//通知收藏了项目并且可以访问该文档的用户.
+func (m *Notification) SendToStars() error {
+	book, err := NewBook().Find(m.BookId)
+	if err != nil {
+		return err
+	}
+	var stars []*Star
+	if _, err := orm.NewOrm().QueryTable(new(Star)).Filter("bid", m.BookId).Limit(-1).All(&stars, "uid"); err != nil {
+		return err
+	}
+	member_ids := make([]int, 0, len(stars))
+	for _, star := range stars {
+		if star.Uid == m.SenderId {
+			continue
+		}
+		member, err := NewMember().Find(star.Uid)
+		if err == nil && m.canRead(book, member, false) {
+			member_ids = append(member_ids, member.MemberId)
+		}
+	}
+	return m.Send(member_ids)
+}
+
+//用户是否可以访问通知关联的项目和文档，私有项目或 participant_only 为 true 时只允许项目参与者.
+func (m *Notification) canRead(book *Book, member *Member, participant_only bool) bool {
+	return canMemberRead(book, member, m.DocumentId, participant_only)
+}
+
+//用户是否可以阅读指定项目，doc_id 大于 0 时同时校验文档权限，非项目参与者只能阅读已发布的文档.
+func canMemberRead(book *Book, member *Member, doc_id int, participant_only bool) bool {
+	if member.Status != conf.MemberStatusNormal {
+		return false
+	}
+	if book == nil || book.BookId <= 0 {
+		return !participant_only
+	}
+	if (participant_only || book.PrivatelyOwned == 1) && !member.IsAdministrator() {
+		if _, err := NewRelationship().FindEffectiveRoleId(book.BookId, member.MemberId); err != nil {
+			return false
+		}
+	}
+	if doc_id <= 0 {
+		return true
+	}
+	access := NewDocumentAccessForMember(book.BookId, member)
+	if access.RoleId < 0 && !member.IsAdministrator() {
+		access.PublishedOnly()
+	}
+	return access.CanRead(doc_id)
+}
+
+//获取文档的阅读地址.
+func NotificationDocumentUrl(book_identify string, doc *Document) string {
+	if doc.Identify != "" {
+		return beego.URLFor("DocumentController.Read", ":key", book_identify, ":id", doc.Identify)
+	}
+	return beego.URLFor("DocumentController.Read", ":key", book_identify, ":id", doc.DocumentId)
+}
+
+//截取通知中显示的内容摘要.
+func NotificationSummary(content string) string {
+	runes := []rune(utils.NormalizeSpace(content))
+	if len(runes) > 50 {
+		return string(runes[:50]) + "..."
+	}
+	return string(runes)
+}
+
+//保存文档草稿后通知本次修改中新提及的用户，草稿只有项目参与者可以看到，因此只通知项目参与者.
+func NotifyDocumentMentions(book_identify string, doc *Document, sender *Member, old_markdown, markdown string) error {
+	notification := NewNotification()
+	notification.SenderId = sender.MemberId
+	notification.BookId = doc.BookId
+	notification.DocumentId = doc.DocumentId
+	notification.Url = NotificationDocumentUrl(book_identify, doc)
+	//已经提及过的用户不重复通知
+	notification.Title = fmt.Sprintf("%s 在文档《%s》中提到了你", sender.Account, doc.DocumentName)
+	return notification.SendMentions(markdown, true, ParseMentions(old_markdown)...)
+}
+
+//文档发布后通知收藏了项目并且可以阅读该文档的用户.
+func NotifyDocumentPublish(doc_id, member_id int) error {
+	doc, err := NewDocument().Find(doc_id)
+	if err != nil {
+		return err
+	}
+	book, err := NewBook().Find(doc.BookId)
+	if err != nil {
+		return err
+	}
+	notification := NewNotification()
+	notification.SenderId = member_id
+	notification.BookId = doc.BookId
+	notification.DocumentId = doc.DocumentId
+	notification.Url = NotificationDocumentUrl(book.Identify, doc)
+	notification.Type = conf.NotifyDocument
+	if sender, err := NewMember().Find(member_id); err == nil {
+		notification.Title = fmt.Sprintf("%s 发布了文档《%s》", sender.Account, doc.DocumentName)
+	} else {
+		notification.Title = fmt.Sprintf("文档《%s》已发布", doc.DocumentName)
+	}
+	return notification.SendToStars()
+}
+
+//分页查询用户的通知，unread 为 true 时只查询未读通知.
+func (m *Notification) FindToPager(member_id int, unread bool, pageIndex, pageSize int) (notifications []*Notification, totalCount int, err error) {
+	if pageIndex <= 0 {
+		pageIndex = 1
+	}
+	qs := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("member_id", member_id)
+	if unread {
+		qs = qs.Filter("is_read", 0)
+	}
+	count, err := qs.Count()
+	if err != nil {
+		return
+	}
+	totalCount = int(count)
+
+	_, err = qs.OrderBy("-notification_id").Offset((pageIndex - 1) * pageSize).Limit(pageSize).All(&notifications)
+	if err == nil {
+		resolveNotifications(notifications)
+	}
+	return
+}
+
+//统计用户的未读通知数量.
+func (m *Notification) UnreadCount(member_id int) int {
+	if member_id <= 0 {
+		return 0
+	}
+	count, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("member_id", member_id).Filter("is_read", 0).Count()
+	if err != nil {
+		return 0
+	}
+	return int(count)
+}
+
+//将通知标记为已读，notification_id 为 0 时标记全部通知.
+func (m *Notification) MarkRead(member_id, notification_id int) error {
+	qs := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("member_id", member_id).Filter("is_read", 0)
+	if notification_id > 0 {
+		qs = qs.Filter("notification_id", notification_id)
+	}
+	_, err := qs.Update(orm.Params{"is_read": 1})
+	return err
+}
+
+func (m *Notification) Find(member_id, notification_id int) (*Notification, error) {
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("member_id", member_id).Filter("notification_id", notification_id).One(m)
+	return m, err
+}
+
+//获取用户的通知设置，不存在时返回默认设置.
+func (m *NotificationSetting) FindByMemberId(member_id int) *NotificationSetting {
+	m.MemberId = member_id
+	if err := orm.NewOrm().Read(m); err != nil {
+		m.MemberId = member_id
+		m.Digest = conf.DigestNone
+	}
+	return m
+}
+
+func (m *NotificationSetting) InsertOrUpdate() error {
+	if m.Digest != conf.DigestDaily && m.Digest != conf.DigestWeekly {
+		m.Digest = conf.DigestNone
+	}
+	_, err := orm.NewOrm().InsertOrUpdate(m)
+	return err
+}
+
+//摘要是否包含该类型的通知.
+func (m *NotificationSetting) HasType(typ string) bool {
+	if m.DigestTypes == "" {
+		return true
+	}
+	for _, item := range strings.Split(m.DigestTypes, ",") {
+		if item == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveNotifications(notifications []*Notification) {
+	accounts := make(map[int]string)
+	for _, item := range notifications {
+		item.TypeName = NotificationTypeName(item.Type)
+		if item.SenderId <= 0 {
+			continue
+		}
+		if account, ok := accounts[item.SenderId]; ok {
+			item.SenderAccount = account
+		} else if member, err := NewMember().Find(item.SenderId); err == nil {
+			accounts[item.SenderId] = member.Account
+			item.SenderAccount = member.Account
+		}
+	}
+}
+
+//启动通知和关注的邮件摘要任务，每小时检查一次需要发送摘要的用户.
+func StartNotificationDigest() {
+	digestOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				if err := SendNotificationDigests(now); err != nil {
+					beego.Error("发送通知摘要失败 => ", err)
+				}
+				if err := SendWatchDigests(now); err != nil {
+					beego.Error("发送关注摘要失败 => ", err)
+				}
+			}
+		}()
+	})
+}
+
+//向到期的用户发送未读通知摘要邮件.
+func SendNotificationDigests(now time.Time) error {
+	mail_conf := conf.GetMailConfig()
+	if !mail_conf.EnableMail {
+		return nil
+	}
+	o := orm.NewOrm()
+
+	var settings []*NotificationSetting
+	if _, err := o.QueryTable(NewNotificationSetting().TableNameWithPrefix()).Filter("digest__in", conf.DigestDaily, conf.DigestWeekly).Limit(-1).All(&settings); err != nil {
+		return err
+	}
+	site_name := GetOptionValue("SITE_NAME", "DocStack")
+	site_url := strings.TrimRight(beego.AppConfig.String("site_url"), "/")
+
+	for _, setting := range settings {
+		period := 24 * time.Hour
+		if setting.Digest == conf.DigestWeekly {
+			period = 7 * 24 * time.Hour
+		}
+		if !setting.LastDigestAt.IsZero() && now.Sub(setting.LastDigestAt) < period {
+			continue
+		}
+		member, err := NewMember().Find(setting.MemberId)
+		if err != nil || member.Status != conf.MemberStatusNormal || member.Email == "" {
+			continue
+		}
+		var notifications []*Notification
+		_, err = o.QueryTable(NewNotification().TableNameWithPrefix()).
+			Filter("member_id", member.MemberId).
+			Filter("is_read", 0).
+			Filter("is_mailed", 0).
+			OrderBy("-notification_id").Limit(100).All(&notifications)
+		if err != nil {
+			beego.Error("查询未读通知失败 => ", err)
+			continue
+		}
+		ids := make([]int, 0, len(notifications))
+		lists := make([]*Notification, 0, len(notifications))
+		for _, item := range notifications {
+			ids = append(ids, item.NotificationId)
+			if setting.HasType(item.Type) {
+				lists = append(lists, item)
+			}
+		}
+		if len(lists) > 0 {
+			resolveNotifications(lists)
+			body, err := utils.ExecuteViewPathTemplate("notification/mail_digest.html", map[string]interface{}{
+				"SITE_NAME": site_name,
+				"SITE_URL":  site_url,
+				"Nickname":  member.Nickname,
+				"Account":   member.Account,
+				"Lists":     lists,
+			})
+			if err != nil {
+				beego.Error("渲染通知摘要失败 => ", err)
+				continue
+			}
+			if err := utils.SendMail(mail_conf, site_name+" 通知摘要", member.Email, body); err != nil {
+				beego.Error("发送通知摘要邮件失败 => ", member.Email, err)
+				continue
+			}
+		}
+		if len(ids) > 0 {
+			o.QueryTable(NewNotification().TableNameWithPrefix()).Filter("notification_id__in", ids).Update(orm.Params{"is_mailed": 1})
+		}
+		setting.LastDigestAt = now
+		o.Update(setting, "last_digest_at")
+	}
+	return nil
+}
@@ -117,6 +117,60 @@ func (m *Option) Init() error {
 			return err
 		}
 	}
+	if !o.QueryTable(m.TableNameWithPrefix()).Filter("option_name", "HISTORY_KEEP_COUNT").Exist() {
+		option := NewOption()
+		option.OptionValue = "0"
+		option.OptionName = "HISTORY_KEEP_COUNT"
+		option.OptionTitle = "每个文档保留的历史数量"
+		if _, err := o.Insert(option); err != nil {
+			return err
+		}
+	}
+	if !o.QueryTable(m.TableNameWithPrefix()).Filter("option_name", "HISTORY_KEEP_DAYS").Exist() {
+		option := NewOption()
+		option.OptionValue = "0"
+		option.OptionName = "HISTORY_KEEP_DAYS"
+		option.OptionTitle = "文档历史保留天数"
+		if _, err := o.Insert(option); err != nil {
+			return err
+		}
+	}
+	if !o.QueryTable(m.TableNameWithPrefix()).Filter("option_name", "HISTORY_THIN_DAYS").Exist() {
+		option := NewOption()
+		option.OptionValue = "0"
+		option.OptionName = "HISTORY_THIN_DAYS"
+		option.OptionTitle = "文档历史精简天数"
+		if _, err := o.Insert(option); err != nil {
+			return err
+		}
+	}
+	if !o.QueryTable(m.TableNameWithPrefix()).Filter("option_name", "RECYCLE_KEEP_DAYS").Exist() {
+		option := NewOption()
+		option.OptionValue = "30"
+		option.OptionName = "RECYCLE_KEEP_DAYS"
+		option.OptionTitle = "回收站保留天数"
+		if _, err := o.Insert(option); err != nil {
+			return err
+		}
+	}
+	if !o.QueryTable(m.TableNameWithPrefix()).Filter("option_name", "LINK_CHECK_AFTER_RELEASE").Exist() {
+		option := NewOption()
+		option.OptionValue = "false"
+		option.OptionName = "LINK_CHECK_AFTER_RELEASE"
+		option.OptionTitle = "发布后检查链接"
+		if _, err := o.Insert(option); err != nil {
+			return err
+		}
+	}
+	if !o.QueryTable(m.TableNameWithPrefix()).Filter("option_name", "LINK_CHECK_EXTERNAL").Exist() {
+		option := NewOption()
+		option.OptionValue = "false"
+		option.OptionName = "LINK_CHECK_EXTERNAL"
+		option.OptionTitle = "发布后检查外部链接"
+		if _, err := o.Insert(option); err != nil {
+			return err
+		}
+	}
 	if !o.QueryTable(m.TableNameWithPrefix()).Filter("option_name", "ENABLED_CAPTCHA").Exist() {
 		option := NewOption()
 		option.OptionValue = "true"
@@ -126,6 +180,24 @@ func (m *Option) Init() error {
 			return err
 		}
 	}
+	if !o.QueryTable(m.TableNameWithPrefix()).Filter("option_name", "ENABLED_EMAIL_VERIFY").Exist() {
+		option := NewOption()
+		option.OptionValue = "false"
+		option.OptionName = "ENABLED_EMAIL_VERIFY"
+		option.OptionTitle = "注册是否需要验证邮箱"
+		if _, err := o.Insert(option); err != nil {
+			return err
+		}
+	}
+	if !o.QueryTable(m.TableNameWithPrefix()).Filter("option_name", "ENABLED_COMMENT_AUDIT").Exist() {
+		option := NewOption()
+		option.OptionValue = "false"
+		option.OptionName = "ENABLED_COMMENT_AUDIT"
+		option.OptionTitle = "评论是否需要审核"
+		if _, err := o.Insert(option); err != nil {
+			return err
+		}
+	}
 	if !o.QueryTable(m.TableNameWithPrefix()).Filter("option_name", "ENABLE_ANONYMOUS").Exist() {
 		option := NewOption()
 		option.OptionValue = "true"
@@ -0,0 +1,383 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/astaxie/beego/orm"
+)
+
+const (
+	//组织所有者.
+	OrgRoleOwner = 0
+	//组织管理员，可以管理组织成员和组织下的全部项目.
+	OrgRoleAdmin = 1
+	//组织成员，可以阅读组织下的全部项目.
+	OrgRoleMember = 2
+)
+
+//组织.
+type Organization struct {
+	OrgId          int       `orm:"pk;auto;column(org_id)" json:"org_id"`
+	OrgName        string    `orm:"column(org_name);size(100)" json:"org_name"`
+	Identify       string    `orm:"column(identify);size(100);unique" json:"identify"` //组织唯一标识，用于组织的访问地址
+	Description    string    `orm:"column(description);size(2000)" json:"description"`
+	Logo           string    `orm:"column(logo);size(1000)" json:"logo"`                                 //组织Logo
+	ThemeColor     string    `orm:"column(theme_color);size(20)" json:"theme_color"`                    //组织主页的主题色
+	PrivatelyOwned int       `orm:"column(privately_owned);type(int);default(1)" json:"privately_owned"` //新建项目的默认可见性：0 公开/1 私有
+	MemberId       int       `orm:"column(member_id);type(int)" json:"member_id"`                       //创建人
+	CreateTime     time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+	ModifyTime     time.Time `orm:"column(modify_time);type(datetime);auto_now" json:"modify_time"`
+	MemberCount    int       `orm:"-" json:"member_count"`
+	BookCount      int       `orm:"-" json:"book_count"`
+	RoleId         int       `orm:"-" json:"role_id"` //当前用户在组织中的角色
+}
+
+// TableName 获取对应数据库表名.
+func (m *Organization) TableName() string {
+	return "organizations"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *Organization) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *Organization) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewOrganization() *Organization {
+	return &Organization{}
+}
+
+//校验组织信息.
+func (m *Organization) valid() error {
+	m.OrgName = strings.TrimSpace(m.OrgName)
+	m.Identify = strings.TrimSpace(m.Identify)
+	if m.OrgName == "" {
+		return errors.New("组织名称不能为空")
+	}
+	if strings.Count(m.OrgName, "") > 100 {
+		return errors.New("组织名称不能超过100字")
+	}
+	if ok, err := regexp.MatchString(`^[a-zA-Z0-9_\-]+$`, m.Identify); !ok || err != nil {
+		return errors.New("组织标识只能包含字母、数字，以及“-”和“_”符号，且不能是纯数字")
+	}
+	if num, _ := strconv.Atoi(m.Identify); strconv.Itoa(num) == m.Identify {
+		return errors.New("组织标识只能包含字母、数字，以及“-”和“_”符号，且不能是纯数字")
+	}
+	if strings.Count(m.Identify, "") > 50 {
+		return errors.New("组织标识不能超过50字")
+	}
+	if strings.Count(m.Description, "") > 2000 {
+		return errors.New("组织描述不能超过2000字")
+	}
+	if m.ThemeColor != "" {
+		if ok, _ := regexp.MatchString(`^#[0-9a-fA-F]{3}([0-9a-fA-F]{3})?$`, m.ThemeColor); !ok {
+			return errors.New("主题色格式不正确")
+		}
+	}
+	if m.PrivatelyOwned != 0 {
+		m.PrivatelyOwned = 1
+	}
+	return nil
+}
+
+//添加组织，创建人自动成为组织所有者.
+func (m *Organization) Insert() error {
+	if err := m.valid(); err != nil {
+		return err
+	}
+	o := orm.NewOrm()
+	if o.QueryTable(m.TableNameWithPrefix()).Filter("identify", m.Identify).Exist() {
+		return errors.New("组织标识已存在")
+	}
+	o.Begin()
+	if _, err := o.Insert(m); err != nil {
+		o.Rollback()
+		return err
+	}
+	owner := NewOrganizationMember()
+	owner.OrgId = m.OrgId
+	owner.MemberId = m.MemberId
+	owner.RoleId = OrgRoleOwner
+	if _, err := o.Insert(owner); err != nil {
+		o.Rollback()
+		return err
+	}
+	return o.Commit()
+}
+
+//更新组织信息，组织标识创建后不能修改.
+func (m *Organization) Update(cols ...string) error {
+	if err := m.valid(); err != nil {
+		return err
+	}
+	_, err := orm.NewOrm().Update(m, cols...)
+	return err
+}
+
+func (m *Organization) Find(id int) (*Organization, error) {
+	if id <= 0 {
+		return m, ErrInvalidParameter
+	}
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("org_id", id).One(m)
+	return m, err
+}
+
+func (m *Organization) FindByIdentify(identify string) (*Organization, error) {
+	if identify == "" {
+		return m, ErrInvalidParameter
+	}
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("identify", identify).One(m)
+	return m, err
+}
+
+//删除组织，组织下的项目转为创始人的个人项目.
+func (m *Organization) Delete(id int) error {
+	o := orm.NewOrm()
+	o.Begin()
+	if _, err := o.QueryTable(NewBook().TableNameWithPrefix()).Filter("org_id", id).Update(orm.Params{"org_id": 0}); err != nil {
+		o.Rollback()
+		return err
+	}
+	if _, err := o.QueryTable(NewOrganizationMember().TableNameWithPrefix()).Filter("org_id", id).Delete(); err != nil {
+		o.Rollback()
+		return err
+	}
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("org_id", id).Delete(); err != nil {
+		o.Rollback()
+		return err
+	}
+	return o.Commit()
+}
+
+//分页查询组织.
+func (m *Organization) FindToPager(pageIndex, pageSize int) (orgs []*Organization, totalCount int, err error) {
+	qs := orm.NewOrm().QueryTable(m.TableNameWithPrefix())
+
+	count, err := qs.Count()
+	if err != nil {
+		return
+	}
+	totalCount = int(count)
+
+	offset := (pageIndex - 1) * pageSize
+	_, err = qs.OrderBy("-org_id").Offset(offset).Limit(pageSize).All(&orgs)
+	if err != nil {
+		return
+	}
+	for _, org := range orgs {
+		org.resolve()
+	}
+	return
+}
+
+//查询用户加入的全部组织.
+func (m *Organization) FindByMemberId(member_id int) (orgs []*Organization, err error) {
+	sql := "SELECT org.* FROM " + m.TableNameWithPrefix() + " AS org INNER JOIN " +
+		NewOrganizationMember().TableNameWithPrefix() + " AS om ON org.org_id = om.org_id WHERE om.member_id = ? ORDER BY org.org_id DESC"
+
+	_, err = orm.NewOrm().Raw(sql, member_id).QueryRows(&orgs)
+	if err != nil {
+		return
+	}
+	for _, org := range orgs {
+		org.RoleId, _ = NewOrganizationMember().FindRoleId(org.OrgId, member_id)
+		org.resolve()
+	}
+	return
+}
+
+//查询用户可以管理项目的组织.
+func (m *Organization) FindManageableByMemberId(member_id int) ([]*Organization, error) {
+	orgs, err := m.FindByMemberId(member_id)
+	if err != nil {
+		return orgs, err
+	}
+	result := make([]*Organization, 0, len(orgs))
+	for _, org := range orgs {
+		if org.RoleId == OrgRoleOwner || org.RoleId == OrgRoleAdmin {
+			result = append(result, org)
+		}
+	}
+	return result, nil
+}
+
+//分页查询组织下的项目，非组织成员只能看到公开项目.
+func (m *Organization) FindBooksToPager(org_id, member_id, pageIndex, pageSize int, is_admin bool) (books []*BookResult, totalCount int, err error) {
+	o := orm.NewOrm()
+
+	condition := "book.org_id = ? AND book.status = 0"
+	args := []interface{}{org_id}
+	if !is_admin {
+		if _, err := NewOrganizationMember().FindRoleId(org_id, member_id); err != nil {
+			condition += " AND (book.privately_owned = 0 OR book.book_id IN (SELECT rel.book_id FROM " + NewRelationship().TableNameWithPrefix() + " AS rel WHERE rel.member_id = ?) OR book.book_id IN (" + grantedBookIdSubQuery() + "))"
+			args = append(args, member_id, member_id, member_id)
+		}
+	}
+
+	sql1 := "SELECT COUNT(*) FROM " + NewBook().TableNameWithPrefix() + " AS book WHERE " + condition
+	if err = o.Raw(sql1, args...).QueryRow(&totalCount); err != nil {
+		return
+	}
+
+	sql2 := "SELECT book.*,rel.relationship_id,rel.role_id,member.account AS create_name FROM " + NewBook().TableNameWithPrefix() + " AS book" +
+		" LEFT JOIN " + NewRelationship().TableNameWithPrefix() + " AS rel ON rel.book_id = book.book_id AND rel.role_id = 0" +
+		" LEFT JOIN " + NewMember().TableNameWithPrefix() + " AS member ON rel.member_id = member.member_id" +
+		" WHERE " + condition + " ORDER BY book.order_index DESC, book.book_id DESC LIMIT ?,?"
+
+	offset := (pageIndex - 1) * pageSize
+	_, err = o.Raw(sql2, append(args, offset, pageSize)...).QueryRows(&books)
+	return
+}
+
+//填充成员数量和项目数量.
+func (m *Organization) resolve() {
+	o := orm.NewOrm()
+	if count, err := o.QueryTable(NewOrganizationMember().TableNameWithPrefix()).Filter("org_id", m.OrgId).Count(); err == nil {
+		m.MemberCount = int(count)
+	}
+	if count, err := o.QueryTable(NewBook().TableNameWithPrefix()).Filter("org_id", m.OrgId).Count(); err == nil {
+		m.BookCount = int(count)
+	}
+}
+
+//组织成员.
+type OrganizationMember struct {
+	OrgMemberId int       `orm:"pk;auto;column(org_member_id)" json:"org_member_id"`
+	OrgId       int       `orm:"column(org_id);type(int);index" json:"org_id"`
+	MemberId    int       `orm:"column(member_id);type(int);index" json:"member_id"`
+	RoleId      int       `orm:"column(role_id);type(int);default(2)" json:"role_id"` //组织角色：0 所有者/1 管理员/2 成员
+	CreateTime  time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+	Account     string    `orm:"-" json:"account"`
+	Nickname    string    `orm:"-" json:"nickname"`
+	Avatar      string    `orm:"-" json:"avatar"`
+	RoleName    string    `orm:"-" json:"role_name"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *OrganizationMember) TableName() string {
+	return "organization_member"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *OrganizationMember) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *OrganizationMember) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+// 联合唯一键
+func (m *OrganizationMember) TableUnique() [][]string {
+	return [][]string{
+		[]string{"OrgId", "MemberId"},
+	}
+}
+
+func NewOrganizationMember() *OrganizationMember {
+	return &OrganizationMember{}
+}
+
+//添加组织成员.
+func (m *OrganizationMember) Insert() error {
+	if m.OrgId <= 0 || m.MemberId <= 0 || (m.RoleId != OrgRoleAdmin && m.RoleId != OrgRoleMember) {
+		return ErrInvalidParameter
+	}
+	o := orm.NewOrm()
+	if o.QueryTable(m.TableNameWithPrefix()).Filter("org_id", m.OrgId).Filter("member_id", m.MemberId).Exist() {
+		return errors.New("用户已是组织成员")
+	}
+	_, err := o.Insert(m)
+	return err
+}
+
+//查询用户在组织中的角色.
+func (m *OrganizationMember) FindRoleId(org_id, member_id int) (int, error) {
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("org_id", org_id).Filter("member_id", member_id).One(m)
+	if err != nil {
+		return -1, err
+	}
+	return m.RoleId, nil
+}
+
+//查询用户通过组织在项目中获得的角色：组织所有者和管理员为项目管理员，组织成员为观察者.
+func (m *OrganizationMember) FindBookRoleId(book_id, member_id int) (int, error) {
+	var org_role_id int
+	sql := "SELECT om.role_id FROM " + m.TableNameWithPrefix() + " AS om INNER JOIN " + NewBook().TableNameWithPrefix() +
+		" AS book ON book.org_id = om.org_id WHERE book.book_id = ? AND book.org_id > 0 AND om.member_id = ? LIMIT 1"
+
+	if err := orm.NewOrm().Raw(sql, book_id, member_id).QueryRow(&org_role_id); err != nil {
+		return -1, err
+	}
+	if org_role_id == OrgRoleOwner || org_role_id == OrgRoleAdmin {
+		return conf.BookAdmin, nil
+	}
+	return conf.BookObserver, nil
+}
+
+//变更组织成员角色，所有者的角色不能变更.
+func (m *OrganizationMember) ChangeRole(org_id, member_id, role_id int) (*OrganizationMember, error) {
+	if role_id != OrgRoleAdmin && role_id != OrgRoleMember {
+		return m, ErrInvalidParameter
+	}
+	o := orm.NewOrm()
+	if err := o.QueryTable(m.TableNameWithPrefix()).Filter("org_id", org_id).Filter("member_id", member_id).One(m); err != nil {
+		return m, errors.New("用户不是组织成员")
+	}
+	if m.RoleId == OrgRoleOwner {
+		return m, errors.New("不能变更组织所有者的角色")
+	}
+	m.RoleId = role_id
+	_, err := o.Update(m, "role_id")
+	return m.resolve(), err
+}
+
+//移除组织成员，所有者不能被移除.
+func (m *OrganizationMember) Delete(org_id, member_id int) error {
+	o := orm.NewOrm()
+	if err := o.QueryTable(m.TableNameWithPrefix()).Filter("org_id", org_id).Filter("member_id", member_id).One(m); err != nil {
+		return errors.New("用户不是组织成员")
+	}
+	if m.RoleId == OrgRoleOwner {
+		return errors.New("不能移除组织所有者")
+	}
+	_, err := o.Delete(m)
+	return err
+}
+
+//查询组织的全部成员.
+func (m *OrganizationMember) FindByOrgId(org_id int) (members []*OrganizationMember, err error) {
+	_, err = orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("org_id", org_id).OrderBy("role_id", "org_member_id").Limit(-1).All(&members)
+	if err != nil {
+		return
+	}
+	for _, member := range members {
+		member.resolve()
+	}
+	return
+}
+
+//填充用户信息和角色名称.
+func (m *OrganizationMember) resolve() *OrganizationMember {
+	if member, err := NewMember().Find(m.MemberId); err == nil {
+		m.Account = member.Account
+		m.Nickname = member.Nickname
+		m.Avatar = member.Avatar
+	}
+	if m.RoleId == OrgRoleOwner {
+		m.RoleName = "所有者"
+	} else if m.RoleId == OrgRoleAdmin {
+		m.RoleName = "管理员"
+	} else {
+		m.RoleName = "成员"
+	}
+	return m
+}
@@ -0,0 +1,320 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+var recyclePurgeOnce sync.Once
+
+//回收站条目，删除的文档连同子文档一起放入回收站，删除的项目标记为已删除.
+//回收站中的文档 book_id 为条目ID的相反数，所属项目中不再可见，文档内容、附件和历史都保持不变.
+type RecycleBin struct {
+	RecycleId  int       `orm:"pk;auto;column(recycle_id)" json:"recycle_id"`
+	ItemType   string    `orm:"column(item_type);size(20);index" json:"item_type"` //document 或 book
+	BookId     int       `orm:"column(book_id);type(int);index" json:"book_id"`
+	DocumentId int       `orm:"column(document_id);type(int);default(0)" json:"doc_id"` //被删除的顶层文档
+	ItemName   string    `orm:"column(item_name);size(500)" json:"item_name"`
+	ParentId   int       `orm:"column(parent_id);type(int);default(0)" json:"parent_id"` //删除前的上级文档
+	DocCount   int       `orm:"column(doc_count);type(int);default(0)" json:"doc_count"`
+	MemberId   int       `orm:"column(member_id);type(int)" json:"member_id"`
+	DeleteTime time.Time `orm:"column(delete_time);type(datetime);auto_now_add" json:"delete_time"`
+	BookName   string    `orm:"-" json:"book_name"`
+	Identify   string    `orm:"-" json:"identify"`
+	Account    string    `orm:"-" json:"account"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *RecycleBin) TableName() string {
+	return "recycle_bin"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *RecycleBin) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *RecycleBin) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewRecycleBin() *RecycleBin {
+	return &RecycleBin{}
+}
+
+func (m *RecycleBin) Find(id int) (*RecycleBin, error) {
+	if id <= 0 {
+		return m, ErrInvalidParameter
+	}
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("recycle_id", id).One(m)
+	return m, err
+}
+
+//分页查询回收站，book_id 为 0 时查询全站，item_type 为空时查询全部类型.
+func (m *RecycleBin) FindToPager(book_id int, item_type string, pageIndex, pageSize int) (items []*RecycleBin, totalCount int, err error) {
+	o := orm.NewOrm()
+
+	condition := "1 = 1"
+	args := make([]interface{}, 0, 4)
+	if book_id > 0 {
+		condition += " AND recycle.book_id = ?"
+		args = append(args, book_id)
+	}
+	if item_type != "" {
+		condition += " AND recycle.item_type = ?"
+		args = append(args, item_type)
+	}
+
+	sql1 := "SELECT COUNT(*) FROM " + m.TableNameWithPrefix() + " AS recycle WHERE " + condition
+	if err = o.Raw(sql1, args...).QueryRow(&totalCount); err != nil {
+		return
+	}
+	sql2 := "SELECT recycle.*,book.book_name,book.identify,member.account FROM " + m.TableNameWithPrefix() + " AS recycle" +
+		" LEFT JOIN " + NewBook().TableNameWithPrefix() + " AS book ON recycle.book_id = book.book_id" +
+		" LEFT JOIN " + NewMember().TableNameWithPrefix() + " AS member ON recycle.member_id = member.member_id" +
+		" WHERE " + condition + " ORDER BY recycle.recycle_id DESC LIMIT ?,?"
+
+	offset := (pageIndex - 1) * pageSize
+	_, err = o.Raw(sql2, append(args, offset, pageSize)...).QueryRows(&items)
+	return
+}
+
+//查询文档及其全部子文档的ID.
+func findSubtreeIds(book_id, doc_id int) ([]interface{}, error) {
+	o := orm.NewOrm()
+
+	ids := []interface{}{doc_id}
+	parents := []interface{}{doc_id}
+	for len(parents) > 0 {
+		var children orm.ParamsList
+		_, err := o.QueryTable(NewDocument().TableNameWithPrefix()).Filter("book_id", book_id).Filter("parent_id__in", parents...).Limit(-1).ValuesFlat(&children, "document_id")
+		if err != nil {
+			return nil, err
+		}
+		parents = make([]interface{}, 0, len(children))
+		for _, child := range children {
+			parents = append(parents, child)
+		}
+		ids = append(ids, parents...)
+	}
+	return ids, nil
+}
+
+//将文档及其子文档放入回收站.
+func (m *RecycleBin) DeleteDocument(doc *Document, member_id int) error {
+	ids, err := findSubtreeIds(doc.BookId, doc.DocumentId)
+	if err != nil {
+		return err
+	}
+	o := orm.NewOrm()
+	if err := o.Begin(); err != nil {
+		return err
+	}
+	m.ItemType = conf.RecycleDocument
+	m.BookId = doc.BookId
+	m.DocumentId = doc.DocumentId
+	m.ItemName = doc.DocumentName
+	m.ParentId = doc.ParentId
+	m.DocCount = len(ids)
+	m.MemberId = member_id
+	if _, err := o.Insert(m); err != nil {
+		o.Rollback()
+		return err
+	}
+	_, err = o.QueryTable(NewDocument().TableNameWithPrefix()).Filter("book_id", doc.BookId).Filter("document_id__in", ids...).Update(orm.Params{"book_id": -m.RecycleId})
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	if err := o.Commit(); err != nil {
+		return err
+	}
+	NewBook().ResetDocumentNumber(doc.BookId)
+	resetLabelNumber()
+	return nil
+}
+
+//将项目放入回收站.
+func (m *RecycleBin) DeleteBook(book *Book, member_id int) error {
+	o := orm.NewOrm()
+	if err := o.Begin(); err != nil {
+		return err
+	}
+	m.ItemType = conf.RecycleBook
+	m.BookId = book.BookId
+	m.ItemName = book.BookName
+	m.DocCount = book.DocCount
+	m.MemberId = member_id
+	if _, err := o.Insert(m); err != nil {
+		o.Rollback()
+		return err
+	}
+	if _, err := o.QueryTable(book.TableNameWithPrefix()).Filter("book_id", book.BookId).Update(orm.Params{"status": 1}); err != nil {
+		o.Rollback()
+		return err
+	}
+	if err := o.Commit(); err != nil {
+		return err
+	}
+	resetLabelNumber()
+	return nil
+}
+
+//恢复回收站条目，文档恢复到原来的位置，原上级文档已不存在时恢复到根目录.
+func (m *RecycleBin) Restore() error {
+	o := orm.NewOrm()
+
+	book, err := NewBook().Find(m.BookId)
+	if err != nil {
+		return err
+	}
+	if m.ItemType == conf.RecycleBook {
+		if _, err := o.QueryTable(book.TableNameWithPrefix()).Filter("book_id", book.BookId).Update(orm.Params{"status": 0}); err != nil {
+			return err
+		}
+		if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("recycle_id", m.RecycleId).Delete(); err != nil {
+			return err
+		}
+		resetLabelNumber()
+		return nil
+	}
+	if book.Status != 0 {
+		return ErrRecycleBookDeleted
+	}
+	table := NewDocument().TableNameWithPrefix()
+
+	var docs []*Document
+	if _, err := o.QueryTable(table).Filter("book_id", -m.RecycleId).Limit(-1).All(&docs, "document_id", "identify"); err != nil {
+		return err
+	}
+	parent_id := 0
+	if m.ParentId > 0 {
+		if n, _ := o.QueryTable(table).Filter("book_id", m.BookId).Filter("document_id", m.ParentId).Count(); n > 0 {
+			parent_id = m.ParentId
+		}
+	}
+	if err := o.Begin(); err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		params := orm.Params{"book_id": m.BookId}
+		//文档标识已被项目中的其他文档使用时追加文档ID
+		if doc.Identify != "" {
+			if n, _ := o.QueryTable(table).Filter("book_id", m.BookId).Filter("identify", doc.Identify).Count(); n > 0 {
+				params["identify"] = doc.Identify + "-" + strconv.Itoa(doc.DocumentId)
+			}
+		}
+		if doc.DocumentId == m.DocumentId {
+			params["parent_id"] = parent_id
+		}
+		if _, err := o.QueryTable(table).Filter("document_id", doc.DocumentId).Update(params); err != nil {
+			o.Rollback()
+			return err
+		}
+	}
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("recycle_id", m.RecycleId).Delete(); err != nil {
+		o.Rollback()
+		return err
+	}
+	if err := o.Commit(); err != nil {
+		return err
+	}
+	NewBook().ResetDocumentNumber(m.BookId)
+	resetLabelNumber()
+	return nil
+}
+
+//彻底删除回收站条目，删除项目时先删除该项目在回收站中的文档.
+func (m *RecycleBin) Purge() error {
+	o := orm.NewOrm()
+
+	if m.ItemType == conf.RecycleBook {
+		var items []*RecycleBin
+		if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("book_id", m.BookId).Filter("item_type", conf.RecycleDocument).Limit(-1).All(&items); err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := item.Purge(); err != nil {
+				return err
+			}
+		}
+		if err := NewBook().ThoroughDeleteBook(m.BookId); err != nil && err != orm.ErrNoRows {
+			return err
+		}
+	} else {
+		var doc_ids orm.ParamsList
+		if _, err := o.QueryTable(NewDocument().TableNameWithPrefix()).Filter("book_id", -m.RecycleId).Limit(-1).ValuesFlat(&doc_ids, "document_id"); err != nil {
+			return err
+		}
+		for _, value := range doc_ids {
+			doc_id, _ := strconv.Atoi(fmt.Sprint(value))
+			attaches, err := NewAttachment().FindListByDocumentId(doc_id)
+			if err != nil {
+				return err
+			}
+			for _, attach := range attaches {
+				if err := attach.Delete(); err != nil {
+					beego.Error("删除附件失败 => ", attach.AttachmentId, err)
+				}
+			}
+		}
+		if err := NewDocument().RecursiveDocument(m.DocumentId); err != nil {
+			return err
+		}
+	}
+	_, err := o.QueryTable(m.TableNameWithPrefix()).Filter("recycle_id", m.RecycleId).Delete()
+	return err
+}
+
+//彻底删除超过保留天数的回收站条目.
+func PurgeRecycleBin(now time.Time) error {
+	days, _ := strconv.Atoi(GetOptionValue("RECYCLE_KEEP_DAYS", "30"))
+	if days <= 0 {
+		return nil
+	}
+	var items []*RecycleBin
+	_, err := orm.NewOrm().QueryTable(NewRecycleBin().TableNameWithPrefix()).
+		Filter("delete_time__lt", now.AddDate(0, 0, -days)).
+		OrderBy("recycle_id").Limit(-1).All(&items)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		//项目被删除时会同时删除其中的文档条目
+		if _, err := NewRecycleBin().Find(item.RecycleId); err == orm.ErrNoRows {
+			continue
+		}
+		if err := item.Purge(); err != nil {
+			beego.Error("清理回收站失败 => ", item.RecycleId, err)
+		}
+	}
+	return nil
+}
+
+//放入或恢复回收站后重新统计标签数量.
+func resetLabelNumber() {
+	if err := NewLabel().ResetNumber(); err != nil {
+		beego.Error("更新标签数量失败 => ", err)
+	}
+}
+
+//启动回收站清理任务，每小时清理一次过期的条目.
+func StartRecyclePurge() {
+	recyclePurgeOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				if err := PurgeRecycleBin(now); err != nil {
+					beego.Error("清理回收站失败 => ", err)
+				}
+			}
+		}()
+	})
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"errors"
+	"strconv"
 
 	"github.com/JermineHu/DocStack/conf"
 	"github.com/astaxie/beego/logs"
@@ -103,6 +104,31 @@ func (m *Relationship) FindForRoleId(book_id, member_id int) (int, error) {
 	return relationship.RoleId, nil
 }
 
+//查询用户在项目中的有效角色，取直接授权、团队授权和组织授权中最高的角色.
+func (m *Relationship) FindEffectiveRoleId(book_id, member_id int) (int, error) {
+	role_id, err := m.FindForRoleId(book_id, member_id)
+	if err != nil && err != orm.ErrNoRows {
+		return 0, err
+	}
+	indirect_role_id, err2 := m.FindIndirectRoleId(book_id, member_id)
+	if err2 == nil && (err != nil || indirect_role_id < role_id) {
+		return indirect_role_id, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return role_id, nil
+}
+
+//查询用户通过团队或组织在项目中获得的最高角色.
+func (m *Relationship) FindIndirectRoleId(book_id, member_id int) (int, error) {
+	role_id, err := NewTeamRelationship().FindRoleIdByMemberId(book_id, member_id)
+	if org_role_id, err2 := NewOrganizationMember().FindBookRoleId(book_id, member_id); err2 == nil && (err != nil || org_role_id < role_id) {
+		return org_role_id, nil
+	}
+	return role_id, err
+}
+
 func (m *Relationship) FindByBookIdAndMemberId(book_id, member_id int) (*Relationship, error) {
 	o := orm.NewOrm()
 
@@ -194,3 +220,19 @@ func (m *Relationship) Transfer(book_id, founder_id, receive_id int) error {
 
 	return o.Commit()
 }
+
+//用于拼接查询用户通过团队或组织参与的项目的子查询，需要传入两次用户ID.
+//指定 max_role_id 时只查询获得的角色不低于该角色的项目，组织所有者和管理员在项目中是管理员，组织成员是观察者.
+func grantedBookIdSubQuery(max_role_id ...int) string {
+	team_filter, org_filter := "", ""
+	if len(max_role_id) > 0 {
+		team_filter = " AND tr.role_id <= " + strconv.Itoa(max_role_id[0])
+		if max_role_id[0] < conf.BookObserver {
+			org_filter = " AND om.role_id <= " + strconv.Itoa(OrgRoleAdmin)
+		}
+	}
+	return "SELECT tr.book_id FROM " + NewTeamRelationship().TableNameWithPrefix() + " AS tr INNER JOIN " +
+		NewTeamMember().TableNameWithPrefix() + " AS tm ON tr.team_id = tm.team_id WHERE tm.member_id = ?" + team_filter +
+		" UNION SELECT ob.book_id FROM " + NewBook().TableNameWithPrefix() + " AS ob INNER JOIN " +
+		NewOrganizationMember().TableNameWithPrefix() + " AS om ON ob.org_id = om.org_id WHERE ob.org_id > 0 AND om.member_id = ?" + org_filter
+}
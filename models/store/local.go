@@ -1,6 +1,7 @@
 package store
 
 import (
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -36,6 +37,30 @@ func (this *Local) MoveToStore(tmpfile, save string) (err error) {
 	return
 }
 
+//复制存储中的文件
+//@param            src              源文件
+//@param            save             复制后的文件
+func (this *Local) CopyToStore(src, save string) (err error) {
+	src = strings.TrimLeft(src, "./")
+	save = strings.TrimLeft(save, "./")
+	if strings.ToLower(src) == strings.ToLower(save) {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	os.MkdirAll(filepath.Dir(save), os.ModePerm)
+	out, err := os.Create(save)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return
+}
+
 //从OSS中删除文件
 //@param           object                     文件对象
 //@param           IsPreview                  是否是预览的OSS
@@ -139,6 +139,29 @@ func (this *Oss) MoveToOss(local, save string, IsDel bool, IsGzip ...bool) error
 	return err
 }
 
+//复制OSS中的文件
+//@param            src              源文件对象
+//@param            save             复制后的文件对象
+func (this *Oss) CopyOssObject(src, save string) error {
+	config := this.Config()
+
+	endpoint := config.EndpointOuter
+	//如果是内网，则使用内网endpoint
+	if config.IsInternal {
+		endpoint = config.EndpointInternal
+	}
+	client, err := oss.New(endpoint, config.AccessKeyId, config.AccessKeySecret)
+	if err != nil {
+		return err
+	}
+	Bucket, err := client.Bucket(config.Bucket)
+	if err != nil {
+		return err
+	}
+	_, err = Bucket.CopyObject(strings.TrimLeft(src, "/"), strings.TrimLeft(save, "/"))
+	return err
+}
+
 //从OSS中删除文件
 //@param           object                     文件对象
 //@param           IsPreview                  是否是预览的OSS
@@ -0,0 +1,404 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/astaxie/beego/logs"
+	"github.com/astaxie/beego/orm"
+)
+
+const (
+	//团队维护者，可以管理团队成员.
+	TeamRoleMaintainer = 0
+	//团队普通成员.
+	TeamRoleMember = 1
+)
+
+//团队.
+type Team struct {
+	TeamId      int       `orm:"pk;auto;column(team_id)" json:"team_id"`
+	TeamName    string    `orm:"column(team_name);size(100);unique" json:"team_name"`
+	Description string    `orm:"column(description);size(500)" json:"description"`
+	MemberId    int       `orm:"column(member_id);type(int)" json:"member_id"` //创建人
+	CreateTime  time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+	ModifyTime  time.Time `orm:"column(modify_time);type(datetime);auto_now" json:"modify_time"`
+	CreateName  string    `orm:"-" json:"create_name"`
+	MemberCount int       `orm:"-" json:"member_count"`
+	BookCount   int       `orm:"-" json:"book_count"`
+	RoleId      int       `orm:"-" json:"role_id"` //当前用户在团队中的角色
+}
+
+// TableName 获取对应数据库表名.
+func (m *Team) TableName() string {
+	return "teams"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *Team) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *Team) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+func NewTeam() *Team {
+	return &Team{}
+}
+
+//添加团队，创建人自动成为团队维护者.
+func (m *Team) Insert() error {
+	m.TeamName = strings.TrimSpace(m.TeamName)
+	if m.TeamName == "" {
+		return errors.New("团队名称不能为空")
+	}
+	if strings.Count(m.TeamName, "") > 100 {
+		return errors.New("团队名称不能超过100字")
+	}
+	o := orm.NewOrm()
+	if o.QueryTable(m.TableNameWithPrefix()).Filter("team_name", m.TeamName).Exist() {
+		return errors.New("团队名称已存在")
+	}
+	o.Begin()
+	if _, err := o.Insert(m); err != nil {
+		o.Rollback()
+		return err
+	}
+	if m.MemberId > 0 {
+		member := NewTeamMember()
+		member.TeamId = m.TeamId
+		member.MemberId = m.MemberId
+		member.RoleId = TeamRoleMaintainer
+		if _, err := o.Insert(member); err != nil {
+			o.Rollback()
+			return err
+		}
+	}
+	return o.Commit()
+}
+
+//更新团队信息.
+func (m *Team) Update(cols ...string) error {
+	m.TeamName = strings.TrimSpace(m.TeamName)
+	if m.TeamName == "" {
+		return errors.New("团队名称不能为空")
+	}
+	o := orm.NewOrm()
+	if o.QueryTable(m.TableNameWithPrefix()).Filter("team_name", m.TeamName).Exclude("team_id", m.TeamId).Exist() {
+		return errors.New("团队名称已存在")
+	}
+	_, err := o.Update(m, cols...)
+	return err
+}
+
+func (m *Team) Find(id int) (*Team, error) {
+	if id <= 0 {
+		return m, ErrInvalidParameter
+	}
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("team_id", id).One(m)
+	return m, err
+}
+
+//删除团队以及团队成员和项目授权.
+func (m *Team) Delete(id int) error {
+	o := orm.NewOrm()
+	o.Begin()
+	if _, err := o.QueryTable(NewTeamMember().TableNameWithPrefix()).Filter("team_id", id).Delete(); err != nil {
+		o.Rollback()
+		return err
+	}
+	if _, err := o.QueryTable(NewTeamRelationship().TableNameWithPrefix()).Filter("team_id", id).Delete(); err != nil {
+		o.Rollback()
+		return err
+	}
+	if _, err := o.QueryTable(m.TableNameWithPrefix()).Filter("team_id", id).Delete(); err != nil {
+		o.Rollback()
+		return err
+	}
+	return o.Commit()
+}
+
+//分页查询团队.
+func (m *Team) FindToPager(pageIndex, pageSize int) (teams []*Team, totalCount int, err error) {
+	o := orm.NewOrm()
+	qs := o.QueryTable(m.TableNameWithPrefix())
+
+	count, err := qs.Count()
+	if err != nil {
+		return
+	}
+	totalCount = int(count)
+
+	offset := (pageIndex - 1) * pageSize
+	_, err = qs.OrderBy("-team_id").Offset(offset).Limit(pageSize).All(&teams)
+	if err != nil {
+		return
+	}
+	for _, team := range teams {
+		team.resolve()
+	}
+	return
+}
+
+//查询用户加入的全部团队.
+func (m *Team) FindByMemberId(member_id int) (teams []*Team, err error) {
+	sql := "SELECT team.* FROM " + m.TableNameWithPrefix() + " AS team INNER JOIN " +
+		NewTeamMember().TableNameWithPrefix() + " AS tm ON team.team_id = tm.team_id WHERE tm.member_id = ? ORDER BY team.team_id DESC"
+
+	_, err = orm.NewOrm().Raw(sql, member_id).QueryRows(&teams)
+	if err != nil {
+		return
+	}
+	for _, team := range teams {
+		team.RoleId, _ = NewTeamMember().FindRoleId(team.TeamId, member_id)
+		team.resolve()
+	}
+	return
+}
+
+//填充创建人、成员数量和项目数量.
+func (m *Team) resolve() {
+	o := orm.NewOrm()
+	if member, err := NewMember().Find(m.MemberId); err == nil {
+		m.CreateName = member.Account
+	}
+	if count, err := o.QueryTable(NewTeamMember().TableNameWithPrefix()).Filter("team_id", m.TeamId).Count(); err == nil {
+		m.MemberCount = int(count)
+	}
+	if count, err := o.QueryTable(NewTeamRelationship().TableNameWithPrefix()).Filter("team_id", m.TeamId).Count(); err == nil {
+		m.BookCount = int(count)
+	}
+}
+
+//团队成员.
+type TeamMember struct {
+	TeamMemberId int       `orm:"pk;auto;column(team_member_id)" json:"team_member_id"`
+	TeamId       int       `orm:"column(team_id);type(int);index" json:"team_id"`
+	MemberId     int       `orm:"column(member_id);type(int);index" json:"member_id"`
+	RoleId       int       `orm:"column(role_id);type(int);default(1)" json:"role_id"` //团队角色：0 维护者/1 成员
+	CreateTime   time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+	Account      string    `orm:"-" json:"account"`
+	Nickname     string    `orm:"-" json:"nickname"`
+	Avatar       string    `orm:"-" json:"avatar"`
+	RoleName     string    `orm:"-" json:"role_name"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *TeamMember) TableName() string {
+	return "team_member"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *TeamMember) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *TeamMember) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+// 联合唯一键
+func (m *TeamMember) TableUnique() [][]string {
+	return [][]string{
+		[]string{"TeamId", "MemberId"},
+	}
+}
+
+func NewTeamMember() *TeamMember {
+	return &TeamMember{}
+}
+
+//添加团队成员.
+func (m *TeamMember) Insert() error {
+	if m.TeamId <= 0 || m.MemberId <= 0 || (m.RoleId != TeamRoleMaintainer && m.RoleId != TeamRoleMember) {
+		return ErrInvalidParameter
+	}
+	o := orm.NewOrm()
+	if o.QueryTable(m.TableNameWithPrefix()).Filter("team_id", m.TeamId).Filter("member_id", m.MemberId).Exist() {
+		return errors.New("用户已是团队成员")
+	}
+	_, err := o.Insert(m)
+	return err
+}
+
+//查询用户在团队中的角色.
+func (m *TeamMember) FindRoleId(team_id, member_id int) (int, error) {
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("team_id", team_id).Filter("member_id", member_id).One(m)
+	if err != nil {
+		return -1, err
+	}
+	return m.RoleId, nil
+}
+
+//变更团队成员角色，团队至少保留一个维护者.
+func (m *TeamMember) ChangeRole(team_id, member_id, role_id int) (*TeamMember, error) {
+	if role_id != TeamRoleMaintainer && role_id != TeamRoleMember {
+		return m, ErrInvalidParameter
+	}
+	o := orm.NewOrm()
+	if err := o.QueryTable(m.TableNameWithPrefix()).Filter("team_id", team_id).Filter("member_id", member_id).One(m); err != nil {
+		return m, errors.New("用户不是团队成员")
+	}
+	if m.RoleId == TeamRoleMaintainer && role_id != TeamRoleMaintainer && m.maintainerCount(team_id) <= 1 {
+		return m, errors.New("团队至少需要一个维护者")
+	}
+	m.RoleId = role_id
+	_, err := o.Update(m, "role_id")
+	return m.resolve(), err
+}
+
+//移除团队成员，团队至少保留一个维护者.
+func (m *TeamMember) Delete(team_id, member_id int) error {
+	o := orm.NewOrm()
+	if err := o.QueryTable(m.TableNameWithPrefix()).Filter("team_id", team_id).Filter("member_id", member_id).One(m); err != nil {
+		return errors.New("用户不是团队成员")
+	}
+	if m.RoleId == TeamRoleMaintainer && m.maintainerCount(team_id) <= 1 {
+		return errors.New("团队至少需要一个维护者")
+	}
+	_, err := o.Delete(m)
+	return err
+}
+
+func (m *TeamMember) maintainerCount(team_id int) int64 {
+	count, _ := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("team_id", team_id).Filter("role_id", TeamRoleMaintainer).Count()
+	return count
+}
+
+//查询团队的全部成员.
+func (m *TeamMember) FindByTeamId(team_id int) (members []*TeamMember, err error) {
+	_, err = orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("team_id", team_id).OrderBy("role_id", "team_member_id").Limit(-1).All(&members)
+	if err != nil {
+		return
+	}
+	for _, member := range members {
+		member.resolve()
+	}
+	return
+}
+
+//填充用户信息和角色名称.
+func (m *TeamMember) resolve() *TeamMember {
+	if member, err := NewMember().Find(m.MemberId); err == nil {
+		m.Account = member.Account
+		m.Nickname = member.Nickname
+		m.Avatar = member.Avatar
+	}
+	if m.RoleId == TeamRoleMaintainer {
+		m.RoleName = "维护者"
+	} else {
+		m.RoleName = "成员"
+	}
+	return m
+}
+
+//团队在项目中的授权.
+type TeamRelationship struct {
+	TeamRelationshipId int `orm:"pk;auto;column(team_relationship_id)" json:"team_relationship_id"`
+	TeamId             int `orm:"column(team_id);type(int);index" json:"team_id"`
+	BookId             int `orm:"column(book_id);type(int);index" json:"book_id"`
+	// RoleId 角色：1 管理员/2 编辑者/3 观察者，团队不能成为创始人
+	RoleId      int       `orm:"column(role_id);type(int)" json:"role_id"`
+	CreateTime  time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+	TeamName    string    `orm:"-" json:"team_name"`
+	MemberCount int       `orm:"-" json:"member_count"`
+	RoleName    string    `orm:"-" json:"role_name"`
+}
+
+// TableName 获取对应数据库表名.
+func (m *TeamRelationship) TableName() string {
+	return "team_relationship"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *TeamRelationship) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *TeamRelationship) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+// 联合唯一键
+func (m *TeamRelationship) TableUnique() [][]string {
+	return [][]string{
+		[]string{"TeamId", "BookId"},
+	}
+}
+
+func NewTeamRelationship() *TeamRelationship {
+	return &TeamRelationship{}
+}
+
+//将团队加入项目，已加入时更新角色.
+func (m *TeamRelationship) InsertOrUpdate() (*TeamRelationship, error) {
+	if m.RoleId < conf.BookAdmin || m.RoleId > conf.BookObserver {
+		return m, ErrInvalidParameter
+	}
+	o := orm.NewOrm()
+	var rel TeamRelationship
+	err := o.QueryTable(m.TableNameWithPrefix()).Filter("team_id", m.TeamId).Filter("book_id", m.BookId).One(&rel)
+	if err == nil {
+		rel.RoleId = m.RoleId
+		_, err = o.Update(&rel, "role_id")
+		*m = rel
+	} else if err == orm.ErrNoRows {
+		_, err = o.Insert(m)
+	}
+	if err != nil {
+		logs.Error("TeamRelationship.InsertOrUpdate => ", err)
+		return m, err
+	}
+	return m.resolve(), nil
+}
+
+//将团队从项目中移除.
+func (m *TeamRelationship) Delete(book_id, team_id int) error {
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).Filter("team_id", team_id).Delete()
+	return err
+}
+
+//查询项目中的全部团队.
+func (m *TeamRelationship) FindByBookId(book_id int) (rels []*TeamRelationship, err error) {
+	_, err = orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).OrderBy("-team_relationship_id").Limit(-1).All(&rels)
+	if err != nil {
+		return
+	}
+	for _, rel := range rels {
+		rel.resolve()
+	}
+	return
+}
+
+//查询用户通过团队在项目中获得的最高角色.
+func (m *TeamRelationship) FindRoleIdByMemberId(book_id, member_id int) (int, error) {
+	var role_id int
+	sql := "SELECT tr.role_id FROM " + m.TableNameWithPrefix() + " AS tr INNER JOIN " +
+		NewTeamMember().TableNameWithPrefix() + " AS tm ON tr.team_id = tm.team_id WHERE tr.book_id = ? AND tm.member_id = ? ORDER BY tr.role_id ASC LIMIT 1"
+
+	if err := orm.NewOrm().Raw(sql, book_id, member_id).QueryRow(&role_id); err != nil {
+		return -1, err
+	}
+	return role_id, nil
+}
+
+//填充团队名称、成员数量和角色名称.
+func (m *TeamRelationship) resolve() *TeamRelationship {
+	if team, err := NewTeam().Find(m.TeamId); err == nil {
+		m.TeamName = team.TeamName
+	}
+	if count, err := orm.NewOrm().QueryTable(NewTeamMember().TableNameWithPrefix()).Filter("team_id", m.TeamId).Count(); err == nil {
+		m.MemberCount = int(count)
+	}
+	if m.RoleId == conf.BookAdmin {
+		m.RoleName = "管理者"
+	} else if m.RoleId == conf.BookEditor {
+		m.RoleName = "编辑者"
+	} else if m.RoleId == conf.BookObserver {
+		m.RoleName = "观察者"
+	}
+	return m
+}
@@ -0,0 +1,346 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/JermineHu/DocStack/conf"
+	"github.com/JermineHu/DocStack/utils"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+//关注项目或文档，定期通过邮件发送变更摘要.
+type Watch struct {
+	WatchId    int `orm:"pk;auto;column(watch_id)" json:"watch_id"`
+	MemberId   int `orm:"column(member_id);type(int);index" json:"member_id"`
+	BookId     int `orm:"column(book_id);type(int);index" json:"book_id"`
+	DocumentId int `orm:"column(document_id);type(int);default(0)" json:"doc_id"` //为 0 时表示关注整个项目
+	//摘要频率：daily/weekly
+	Frequency    string    `orm:"column(frequency);size(20)" json:"frequency"`
+	LastSentTime time.Time `orm:"column(last_sent_time);type(datetime);null" json:"last_sent_time"`
+	CreateTime   time.Time `orm:"column(create_time);type(datetime);auto_now_add" json:"create_time"`
+
+	BookName     string `orm:"-" json:"book_name"`
+	Identify     string `orm:"-" json:"identify"`
+	DocumentName string `orm:"-" json:"doc_name"`
+}
+
+//摘要中的文档变更.
+type WatchChange struct {
+	BookName     string
+	DocumentId   int
+	DocumentName string
+	Url          string
+	Editors      []string
+	EditCount    int
+	Added        int
+	Removed      int
+	IsNew        bool
+	ModifyTime   time.Time
+}
+
+// TableName 获取对应数据库表名.
+func (m *Watch) TableName() string {
+	return "watches"
+}
+
+// TableEngine 获取数据使用的引擎.
+func (m *Watch) TableEngine() string {
+	return "INNODB"
+}
+
+func (m *Watch) TableNameWithPrefix() string {
+	return conf.GetDatabasePrefix() + m.TableName()
+}
+
+// 多字段唯一键
+func (m *Watch) TableUnique() [][]string {
+	return [][]string{
+		[]string{"MemberId", "BookId", "DocumentId"},
+	}
+}
+
+func NewWatch() *Watch {
+	return &Watch{}
+}
+
+//查询用户对项目或文档的关注.
+func (m *Watch) Find(member_id, book_id, doc_id int) (*Watch, error) {
+	err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).
+		Filter("member_id", member_id).
+		Filter("book_id", book_id).
+		Filter("document_id", doc_id).One(m)
+	return m, err
+}
+
+//关注项目或文档，frequency 不是 daily 或 weekly 时取消关注.
+func (m *Watch) Save(member_id, book_id, doc_id int, frequency string) error {
+	o := orm.NewOrm()
+	if frequency != conf.DigestDaily && frequency != conf.DigestWeekly {
+		_, err := o.QueryTable(m.TableNameWithPrefix()).
+			Filter("member_id", member_id).
+			Filter("book_id", book_id).
+			Filter("document_id", doc_id).Delete()
+		return err
+	}
+	if _, err := m.Find(member_id, book_id, doc_id); err == nil {
+		m.Frequency = frequency
+		_, err := o.Update(m, "frequency")
+		return err
+	} else if err != orm.ErrNoRows {
+		return err
+	}
+	m.MemberId = member_id
+	m.BookId = book_id
+	m.DocumentId = doc_id
+	m.Frequency = frequency
+	//从关注时开始统计变更
+	m.LastSentTime = time.Now()
+	_, err := o.Insert(m)
+	return err
+}
+
+//用户是否可以关注项目或文档，私有项目只允许项目参与者关注.
+func CanWatch(book *Book, member *Member, doc_id int) bool {
+	return member.MemberId > 0 && canMemberRead(book, member, doc_id, false)
+}
+
+//查询用户的全部关注.
+func (m *Watch) FindByMemberId(member_id int) (watches []*Watch, err error) {
+	_, err = orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("member_id", member_id).OrderBy("-watch_id").Limit(-1).All(&watches)
+	if err != nil {
+		return
+	}
+	for _, watch := range watches {
+		if book, err := NewBook().Find(watch.BookId); err == nil {
+			watch.BookName = book.BookName
+			watch.Identify = book.Identify
+		}
+		if watch.DocumentId > 0 {
+			if doc, err := NewDocument().Find(watch.DocumentId); err == nil {
+				watch.DocumentName = doc.DocumentName
+			}
+		}
+	}
+	return
+}
+
+//删除项目的全部关注.
+func (m *Watch) DeleteByBookId(book_id int) error {
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("book_id", book_id).Delete()
+	return err
+}
+
+//删除文档的全部关注.
+func (m *Watch) DeleteByDocumentId(doc_id int) error {
+	if doc_id <= 0 {
+		return nil
+	}
+	_, err := orm.NewOrm().QueryTable(m.TableNameWithPrefix()).Filter("document_id", doc_id).Delete()
+	return err
+}
+
+//关注是否到了发送摘要的时间.
+func (m *Watch) isDue(now time.Time) bool {
+	period := 24 * time.Hour
+	if m.Frequency == conf.DigestWeekly {
+		period = 7 * 24 * time.Hour
+	}
+	return m.LastSentTime.IsZero() || now.Sub(m.LastSentTime) >= period
+}
+
+//向到期的用户发送关注的项目和文档的变更摘要邮件.
+func SendWatchDigests(now time.Time) error {
+	mail_conf := conf.GetMailConfig()
+	if !mail_conf.EnableMail {
+		return nil
+	}
+	o := orm.NewOrm()
+
+	var watches []*Watch
+	if _, err := o.QueryTable(NewWatch().TableNameWithPrefix()).OrderBy("member_id").Limit(-1).All(&watches); err != nil {
+		return err
+	}
+	group := make(map[int][]*Watch)
+	member_ids := make([]int, 0)
+	for _, watch := range watches {
+		if !watch.isDue(now) {
+			continue
+		}
+		if _, ok := group[watch.MemberId]; !ok {
+			member_ids = append(member_ids, watch.MemberId)
+		}
+		group[watch.MemberId] = append(group[watch.MemberId], watch)
+	}
+	site_name := GetOptionValue("SITE_NAME", "DocStack")
+	site_url := strings.TrimRight(beego.AppConfig.String("site_url"), "/")
+
+	for _, member_id := range member_ids {
+		due := group[member_id]
+		member, err := NewMember().Find(member_id)
+		if err == nil && member.Status == conf.MemberStatusNormal && member.Email != "" {
+			changes := findWatchChanges(member, due)
+			if len(changes) > 0 {
+				body, err := utils.ExecuteViewPathTemplate("notification/mail_watch.html", map[string]interface{}{
+					"SITE_NAME": site_name,
+					"SITE_URL":  site_url,
+					"Nickname":  member.Nickname,
+					"Account":   member.Account,
+					"Lists":     changes,
+				})
+				if err != nil {
+					beego.Error("渲染关注摘要失败 => ", err)
+					continue
+				}
+				if err := utils.SendMail(mail_conf, site_name+" 关注的文档变更", member.Email, body); err != nil {
+					beego.Error("发送关注摘要邮件失败 => ", member.Email, err)
+					continue
+				}
+			}
+		}
+		for _, watch := range due {
+			watch.LastSentTime = now
+			if _, err := o.Update(watch, "last_sent_time"); err != nil {
+				beego.Error("更新关注摘要时间失败 => ", err)
+			}
+		}
+	}
+	return nil
+}
+
+//查找用户关注的文档自上次摘要以来已发布的变更，只统计用户可以阅读的已发布文档.
+//编辑次数、编辑者和修改行数根据文档历史统计.
+func findWatchChanges(member *Member, watches []*Watch) []*WatchChange {
+	o := orm.NewOrm()
+	changes := make([]*WatchChange, 0)
+	//同一文档同时被项目关注和文档关注时只统计一次
+	since := make(map[int]time.Time)
+	books := make(map[int]*Book)
+	accesses := make(map[int]*DocumentAccess)
+
+	for _, watch := range watches {
+		book, err := NewBook().Find(watch.BookId)
+		if err != nil || !canMemberRead(book, member, 0, false) {
+			continue
+		}
+		books[book.BookId] = book
+		access, ok := accesses[book.BookId]
+		if !ok {
+			access = NewDocumentAccessForMember(book.BookId, member).PublishedOnly()
+			accesses[book.BookId] = access
+		}
+		start := watch.LastSentTime
+		if start.IsZero() {
+			start = watch.CreateTime
+		}
+		qs := o.QueryTable(NewDocument().TableNameWithPrefix()).
+			Filter("book_id", book.BookId).
+			Filter("publish_status", conf.DocumentPublished).
+			Filter("publish_time__gt", start)
+		if watch.DocumentId > 0 {
+			qs = qs.Filter("document_id", watch.DocumentId)
+		}
+		var docs []*Document
+		if _, err := qs.Limit(-1).All(&docs, "document_id"); err != nil {
+			beego.Error("查询项目文档失败 => ", err)
+			continue
+		}
+		for _, doc := range docs {
+			if !access.CanRead(doc.DocumentId) {
+				continue
+			}
+			if t, ok := since[doc.DocumentId]; !ok || start.Before(t) {
+				since[doc.DocumentId] = start
+			}
+		}
+	}
+	accounts := make(map[int]string)
+	account := func(member_id int) string {
+		if _, ok := accounts[member_id]; !ok {
+			if editor, err := NewMember().Find(member_id); err == nil {
+				accounts[member_id] = editor.Account
+			} else {
+				accounts[member_id] = ""
+			}
+		}
+		return accounts[member_id]
+	}
+
+	for doc_id, start := range since {
+		doc, err := NewDocument().Find(doc_id)
+		if err != nil {
+			continue
+		}
+		book, ok := books[doc.BookId]
+		if !ok {
+			continue
+		}
+		change := &WatchChange{
+			BookName:     book.BookName,
+			DocumentId:   doc.DocumentId,
+			DocumentName: doc.DocumentName,
+			Url:          NotificationDocumentUrl(book.Identify, doc),
+			ModifyTime:   doc.PublishTime,
+			IsNew:        doc.CreateTime.After(start),
+			Editors:      make([]string, 0),
+		}
+		//只统计发布时已有的修改，发布之后的草稿不计入摘要
+		var histories []*DocumentHistory
+		_, err = o.QueryTable(NewDocumentHistory().TableNameWithPrefix()).
+			Filter("document_id", doc_id).
+			Filter("modify_time__gt", start).
+			Filter("modify_time__lte", doc.PublishTime).
+			OrderBy("modify_time", "history_id").Limit(-1).All(&histories)
+		if err != nil {
+			beego.Error("查询文档历史失败 => ", err)
+		}
+		change.EditCount = len(histories)
+		editors := make(map[int]bool)
+		for _, history := range histories {
+			if editors[history.ModifyAt] {
+				continue
+			}
+			editors[history.ModifyAt] = true
+			if name := account(history.ModifyAt); name != "" {
+				change.Editors = append(change.Editors, name)
+			}
+		}
+		if len(histories) == 0 {
+			//未开启内容历史时没有修改记录，按发布一次统计
+			change.EditCount = 1
+			if name := account(doc.ModifyAt); name != "" {
+				change.Editors = append(change.Editors, name)
+			}
+			changes = append(changes, change)
+			continue
+		}
+		last := histories[len(histories)-1]
+		if _, err := last.resolve(); err != nil {
+			beego.Error("DocumentHistory.resolve => ", err)
+			changes = append(changes, change)
+			continue
+		}
+		//与上次摘要时的版本比较
+		var base DocumentHistory
+		err = o.QueryTable(NewDocumentHistory().TableNameWithPrefix()).
+			Filter("document_id", doc_id).
+			Filter("modify_time__lte", start).
+			OrderBy("-modify_time", "-history_id").One(&base)
+		if err == nil {
+			base.resolve()
+			change.Added, change.Removed = utils.LineDiffStat(base.Markdown, last.Markdown)
+		} else if change.IsNew {
+			change.Added, change.Removed = utils.LineDiffStat("", last.Markdown)
+		} else {
+			histories[0].resolve()
+			change.Added, change.Removed = utils.LineDiffStat(histories[0].Markdown, last.Markdown)
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].ModifyTime.After(changes[j].ModifyTime)
+	})
+	return changes
+}
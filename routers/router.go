@@ -12,9 +12,10 @@ func init() {
 	beego.Router("/login/:oauth", &controllers.AccountController{}, "*:Oauth")
 	beego.Router("/logout", &controllers.AccountController{}, "*:Logout")
 	beego.Router("/bind", &controllers.AccountController{}, "post:Bind")
-	//beego.Router("/find_password", &controllers.AccountController{}, "*:FindPassword")
+	beego.Router("/find_password", &controllers.AccountController{}, "*:FindPassword")
 	beego.Router("/valid_email", &controllers.AccountController{}, "post:ValidEmail")
-	//beego.Router("/captcha", &controllers.AccountController{}, "*:Captcha")
+	beego.Router("/verify_email", &controllers.AccountController{}, "get:VerifyEmail")
+	beego.Router("/verify_email/resend", &controllers.AccountController{}, "post:ResendVerifyEmail")
 
 	beego.Router("/manager", &controllers.ManagerController{}, "*:Index")
 	beego.Router("/manager/users", &controllers.ManagerController{}, "*:Users")
@@ -23,10 +24,22 @@ func init() {
 	beego.Router("/manager/member/delete", &controllers.ManagerController{}, "post:DeleteMember")
 	beego.Router("/manager/member/update-member-status", &controllers.ManagerController{}, "post:UpdateMemberStatus")
 	beego.Router("/manager/member/change-member-role", &controllers.ManagerController{}, "post:ChangeMemberRole")
+	beego.Router("/manager/teams", &controllers.ManagerController{}, "*:Teams")
+	beego.Router("/manager/organizations", &controllers.ManagerController{}, "*:Organizations")
+	beego.Router("/manager/logs", &controllers.ManagerController{}, "get:Logs")
+	beego.Router("/manager/logs/list", &controllers.ManagerController{}, "get:LogList")
+	beego.Router("/manager/logs/export", &controllers.ManagerController{}, "get:ExportLogs")
 	beego.Router("/manager/books", &controllers.ManagerController{}, "*:Books")
 	beego.Router("/manager/books/edit/:key", &controllers.ManagerController{}, "*:EditBook")
 	beego.Router("/manager/books/delete", &controllers.ManagerController{}, "*:DeleteBook")
+	beego.Router("/manager/recycle", &controllers.ManagerController{}, "get:Recycle")
 	beego.Router("/manager/comments", &controllers.ManagerController{}, "*:Comments")
+	beego.Router("/manager/comment/moderate", &controllers.ManagerController{}, "post:ModerateComment")
+	beego.Router("/manager/comment/delete", &controllers.ManagerController{}, "post:DeleteComment")
+	beego.Router("/manager/labels", &controllers.ManagerController{}, "get:Labels")
+	beego.Router("/manager/label/rename", &controllers.ManagerController{}, "post:RenameLabel")
+	beego.Router("/manager/label/merge", &controllers.ManagerController{}, "post:MergeLabel")
+	beego.Router("/manager/label/delete", &controllers.ManagerController{}, "post:DeleteLabel")
 	beego.Router("/manager/books/token", &controllers.ManagerController{}, "post:CreateToken")
 	beego.Router("/manager/setting", &controllers.ManagerController{}, "*:Setting")
 	beego.Router("/manager/books/transfer", &controllers.ManagerController{}, "post:Transfer")
@@ -42,10 +55,20 @@ func init() {
 	beego.Router("/setting/password", &controllers.SettingController{}, "*:Password")
 	beego.Router("/setting/upload", &controllers.SettingController{}, "*:Upload")
 	beego.Router("/setting/star", &controllers.SettingController{}, "*:Star")
+	beego.Router("/setting/watches", &controllers.SettingController{}, "get:Watches")
+	beego.Router("/setting/notifications", &controllers.SettingController{}, "get:Notifications")
+	beego.Router("/setting/notifications/:id:int", &controllers.SettingController{}, "get:OpenNotification")
+	beego.Router("/setting/notifications/read", &controllers.SettingController{}, "post:ReadNotification")
+	beego.Router("/setting/notifications/unread", &controllers.SettingController{}, "get:UnreadNotification")
+	beego.Router("/setting/notifications/setting", &controllers.SettingController{}, "get,post:NotificationSetting")
 	beego.Router("/setting/qrcode", &controllers.SettingController{}, "*:Qrcode")
+	beego.Router("/setting/organizations", &controllers.SettingController{}, "*:Organizations")
+	beego.Router("/setting/teams", &controllers.SettingController{}, "*:Teams")
+	beego.Router("/setting/teams/:id", &controllers.SettingController{}, "*:TeamMembers")
 
 	beego.Router("/book", &controllers.BookController{}, "*:Index")
 	beego.Router("/book/star/:id", &controllers.BookController{}, "*:Star")          //收藏
+	beego.Router("/book/watch", &controllers.BookController{}, "get,post:Watch")
 	beego.Router("/book/score/:id", &controllers.BookController{}, "*:Score")        //收藏
 	beego.Router("/book/comment/:id", &controllers.BookController{}, "post:Comment") //收藏
 	beego.Router("/book/uploadProject", &controllers.BookController{}, "post:UploadProject")
@@ -53,6 +76,12 @@ func init() {
 	beego.Router("/book/:key/dashboard", &controllers.BookController{}, "*:Dashboard")
 	beego.Router("/book/:key/setting", &controllers.BookController{}, "*:Setting")
 	beego.Router("/book/:key/users", &controllers.BookController{}, "*:Users")
+	beego.Router("/book/:key/permission", &controllers.BookController{}, "*:Permission")
+	beego.Router("/book/:key/share", &controllers.BookController{}, "*:Share")
+	beego.Router("/book/:key/reviews", &controllers.BookController{}, "get:Reviews")
+	beego.Router("/book/:key/versions", &controllers.BookController{}, "get:Versions")
+	beego.Router("/book/:key/branches", &controllers.BookController{}, "get:Branches")
+	beego.Router("/book/:key/recycle", &controllers.BookController{}, "get:Recycle")
 	beego.Router("/book/:key/release", &controllers.BookController{}, "post:Release")
 	beego.Router("/book/:key/generate", &controllers.BookController{}, "get,post:Generate")
 	beego.Router("/book/:key/sort", &controllers.BookController{}, "post:SaveSort")
@@ -61,6 +90,23 @@ func init() {
 	beego.Router("/book/users/create", &controllers.BookMemberController{}, "post:AddMember")
 	beego.Router("/book/users/change", &controllers.BookMemberController{}, "post:ChangeRole")
 	beego.Router("/book/users/delete", &controllers.BookMemberController{}, "post:RemoveMember")
+	beego.Router("/book/users/team/create", &controllers.BookMemberController{}, "post:AddTeam")
+	beego.Router("/book/users/team/change", &controllers.BookMemberController{}, "post:ChangeTeamRole")
+	beego.Router("/book/users/team/delete", &controllers.BookMemberController{}, "post:RemoveTeam")
+	beego.Router("/book/permission/create", &controllers.DocumentPermissionController{}, "post:Create")
+	beego.Router("/book/permission/delete", &controllers.DocumentPermissionController{}, "post:Delete")
+	beego.Router("/book/share/create", &controllers.BookShareController{}, "post:Create")
+	beego.Router("/book/share/revoke", &controllers.BookShareController{}, "post:Revoke")
+	beego.Router("/book/share/delete", &controllers.BookShareController{}, "post:Delete")
+	beego.Router("/book/review/approve", &controllers.DocumentReviewController{}, "post:Approve")
+	beego.Router("/book/review/reject", &controllers.DocumentReviewController{}, "post:Reject")
+	beego.Router("/book/version/create", &controllers.BookVersionController{}, "post:Create")
+	beego.Router("/book/version/generate", &controllers.BookVersionController{}, "post:Generate")
+	beego.Router("/book/version/delete", &controllers.BookVersionController{}, "post:Delete")
+	beego.Router("/book/branch/create", &controllers.BookBranchController{}, "post:Create")
+	beego.Router("/book/branch/merge", &controllers.BookBranchController{}, "post:Merge")
+	beego.Router("/book/recycle/restore", &controllers.RecycleBinController{}, "post:Restore")
+	beego.Router("/book/recycle/purge", &controllers.RecycleBinController{}, "post:Purge")
 
 	beego.Router("/book/setting/save", &controllers.BookController{}, "post:SaveBook")
 	beego.Router("/book/setting/open", &controllers.BookController{}, "post:PrivatelyOwned")
@@ -75,16 +121,60 @@ func init() {
 	beego.Router("/api/:key/create", &controllers.DocumentController{}, "post:Create")
 	beego.Router("/api/create_multi", &controllers.DocumentController{}, "post:CreateMulti")
 	beego.Router("/api/:key/delete", &controllers.DocumentController{}, "post:Delete")
+	beego.Router("/api/:key/transfer", &controllers.DocumentTransferController{}, "get:Targets;post:Transfer")
+	beego.Router("/api/:key/used_by", &controllers.DocumentController{}, "get:UsedBy")
+	beego.Router("/api/:key/linkcheck", &controllers.LinkCheckController{}, "get:Report;post:Run")
+	beego.Router("/api/:key/backlinks", &controllers.DocumentController{}, "get:Backlinks")
+	beego.Router("/api/:key/link_graph", &controllers.DocumentController{}, "get:LinkGraph")
+	beego.Router("/api/:key/labels", &controllers.DocumentController{}, "get:Labels;post:SaveLabels")
+	beego.Router("/api/:key/meta", &controllers.DocumentController{}, "get:Meta;post:SaveMeta")
 	beego.Router("/api/:key/content/?:id", &controllers.DocumentController{}, "*:Content")
 	beego.Router("/api/:key/compare/:id", &controllers.DocumentController{}, "*:Compare")
+	beego.Router("/api/:key/diff/:id", &controllers.DocumentController{}, "get:Diff")
+	beego.Router("/api/:key/review/request", &controllers.DocumentController{}, "post:RequestReview")
+	beego.Router("/api/:key/publish", &controllers.DocumentController{}, "post:Publish")
+	beego.Router("/api/:key/schedule", &controllers.DocumentController{}, "post:SchedulePublish")
+	beego.Router("/api/:key/permission", &controllers.DocumentPermissionController{}, "get:List")
+	beego.Router("/api/:key/share", &controllers.BookShareController{}, "get:List")
+	beego.Router("/api/:key/versions", &controllers.BookVersionController{}, "get:List")
+	beego.Router("/api/:key/branch/compare", &controllers.BookBranchController{}, "get:Compare")
+	beego.Router("/api/:key/branch/diff/:id", &controllers.BookBranchController{}, "get:Diff")
+	beego.Router("/api/:key/annotations", &controllers.AnnotationController{}, "get:List")
+	beego.Router("/api/:key/annotation/create", &controllers.AnnotationController{}, "post:Create")
+	beego.Router("/api/:key/annotation/reply", &controllers.AnnotationController{}, "post:Reply")
+	beego.Router("/api/:key/annotation/resolve", &controllers.AnnotationController{}, "post:Resolve")
+	beego.Router("/api/:key/annotation/delete", &controllers.AnnotationController{}, "post:Delete")
+
+	beego.Router("/api/team/list", &controllers.TeamController{}, "get:List")
+	beego.Router("/api/team/create", &controllers.TeamController{}, "post:Create")
+	beego.Router("/api/team/update", &controllers.TeamController{}, "post:Update")
+	beego.Router("/api/team/delete", &controllers.TeamController{}, "post:Delete")
+	beego.Router("/api/team/members", &controllers.TeamController{}, "get:Members")
+	beego.Router("/api/team/member/add", &controllers.TeamController{}, "post:AddMember")
+	beego.Router("/api/team/member/role", &controllers.TeamController{}, "post:ChangeMemberRole")
+	beego.Router("/api/team/member/remove", &controllers.TeamController{}, "post:RemoveMember")
 
 	beego.Router("/history/get", &controllers.DocumentController{}, "get:History")
 	beego.Router("/history/delete", &controllers.DocumentController{}, "*:DeleteHistory")
 	beego.Router("/history/restore", &controllers.DocumentController{}, "*:RestoreHistory")
 
+	beego.Router("/share/:token", &controllers.DocumentController{}, "get,post:Share")
+
+	beego.Router("/org/create", &controllers.OrganizationController{}, "post:Create")
+	beego.Router("/org/delete", &controllers.OrganizationController{}, "post:Delete")
+	beego.Router("/org/member/add", &controllers.OrganizationController{}, "post:AddMember")
+	beego.Router("/org/member/role", &controllers.OrganizationController{}, "post:ChangeRole")
+	beego.Router("/org/member/remove", &controllers.OrganizationController{}, "post:RemoveMember")
+	beego.Router("/org/:org", &controllers.OrganizationController{}, "get:Index")
+	beego.Router("/org/:org/setting", &controllers.OrganizationController{}, "get,post:Setting")
+	//项目标识全站唯一，组织下的项目地址是 /books/:key 的别名
+	beego.Router("/org/:org/books/:key", &controllers.DocumentController{}, "*:OrgIndex")
+	beego.Router("/org/:org/read/:key/:id", &controllers.DocumentController{}, "*:OrgRead")
+
 	beego.Router("/books/:key", &controllers.DocumentController{}, "*:Index")
 	beego.Router("/read/:key/:id", &controllers.DocumentController{}, "*:Read")
 	beego.Router("/read/:key/search", &controllers.DocumentController{}, "post:Search")
+	beego.Router("/preview/:key/:id", &controllers.DocumentController{}, "get:Preview")
 
 	beego.Router("/export/:key", &controllers.DocumentController{}, "*:Export")
 	beego.Router("/qrcode/:key.png", &controllers.DocumentController{}, "get:QrCode")
@@ -93,6 +183,8 @@ func init() {
 
 	beego.Router("/comment/create", &controllers.CommentController{}, "post:Create")
 	beego.Router("/comment/lists", &controllers.CommentController{}, "get:Lists")
+	beego.Router("/comment/delete", &controllers.CommentController{}, "post:Delete")
+	beego.Router("/comment/vote", &controllers.CommentController{}, "post:Vote")
 	beego.Router("/comment/index", &controllers.CommentController{}, "*:Index")
 
 	beego.Router("/search", &controllers.SearchController{}, "get:Index")
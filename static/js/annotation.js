@@ -0,0 +1,309 @@
+/**
+ * 文档批注：选中文字添加批注，批注列表和原文高亮
+ */
+$(function () {
+    var $panel = $("#annotationPanel");
+    if ($panel.length <= 0) {
+        return;
+    }
+    var $content = $("#page-content");
+    var $add = $(".annotation-add");
+    var memberId = parseInt($panel.attr("data-member")) || 0;
+    var annotations = [];
+    var selection = null;
+
+    function escapeHtml(text) {
+        return $("<div>").text(text || "").html();
+    }
+
+    function normalize(text) {
+        return $.trim((text || "").replace(/\s+/g, " "));
+    }
+
+    //将正文的文本节点合并为空白已合并的纯文本，并记录每个字符所在的节点
+    function textMap() {
+        var text = "", map = [], space = true;
+        var walker = document.createTreeWalker($content[0], NodeFilter.SHOW_TEXT, null, false);
+        while (walker.nextNode()) {
+            var node = walker.currentNode;
+            var value = node.nodeValue;
+            for (var i = 0; i < value.length; i++) {
+                if (/\s/.test(value.charAt(i))) {
+                    if (space) {
+                        continue;
+                    }
+                    text += " ";
+                    space = true;
+                } else {
+                    text += value.charAt(i);
+                    space = false;
+                }
+                map.push({ node: node, offset: i });
+            }
+        }
+        return { text: text, map: map };
+    }
+
+    function commonPrefix(a, b) {
+        var n = 0;
+        while (n < a.length && n < b.length && a.charAt(n) === b.charAt(n)) {
+            n++;
+        }
+        return n;
+    }
+
+    function commonSuffix(a, b) {
+        var n = 0;
+        while (n < a.length && n < b.length && a.charAt(a.length - 1 - n) === b.charAt(b.length - 1 - n)) {
+            n++;
+        }
+        return n;
+    }
+
+    //查找批注在正文中的位置，多处匹配时选择上下文最接近的位置
+    function locate(text, item) {
+        var best = -1, bestContext = -1, index = text.indexOf(item.quote);
+        while (index >= 0) {
+            var context = commonSuffix(text.substring(0, index), item.prefix) + commonPrefix(text.substring(index + item.quote.length), item.suffix);
+            if (context > bestContext) {
+                best = index;
+                bestContext = context;
+            }
+            index = text.indexOf(item.quote, index + 1);
+        }
+        return best;
+    }
+
+    function highlight(item) {
+        if (item.orphaned === 1 || !item.quote) {
+            return;
+        }
+        var result = textMap();
+        var start = locate(result.text, item);
+        if (start < 0) {
+            return;
+        }
+        var end = start + item.quote.length;
+        //按文本节点分组后逐段包裹
+        var segments = [];
+        for (var i = start; i < end && i < result.map.length; i++) {
+            var pos = result.map[i];
+            var last = segments[segments.length - 1];
+            if (last && last.node === pos.node) {
+                last.end = pos.offset + 1;
+            } else {
+                segments.push({ node: pos.node, start: pos.offset, end: pos.offset + 1 });
+            }
+        }
+        for (var j = segments.length - 1; j >= 0; j--) {
+            var segment = segments[j];
+            var node = segment.node;
+            if (segment.end < node.nodeValue.length) {
+                node.splitText(segment.end);
+            }
+            if (segment.start > 0) {
+                node = node.splitText(segment.start);
+            }
+            var mark = document.createElement("mark");
+            mark.className = "annotation-mark";
+            mark.setAttribute("data-id", item.annotation_id);
+            node.parentNode.insertBefore(mark, node);
+            mark.appendChild(node);
+        }
+    }
+
+    function clearHighlight() {
+        $content.find("mark.annotation-mark").each(function () {
+            $(this).replaceWith(this.childNodes);
+        });
+        $content[0].normalize();
+    }
+
+    function renderMessage(account, content, date) {
+        return '<div class="annotation-message"><span class="name">' + escapeHtml(account) + '</span>'
+            + '<span class="date">' + new Date(date).format("yyyy-MM-dd hh:mm") + '</span>'
+            + '<div>' + escapeHtml(content).replace(/\n/g, "<br/>") + '</div></div>';
+    }
+
+    function renderItem(item) {
+        var html = '<div class="annotation-item' + (item.resolved === 1 ? ' resolved' : '') + '" data-id="' + item.annotation_id + '">';
+        html += '<div class="annotation-quote' + (item.orphaned === 1 ? ' orphaned' : '') + '" title="' + (item.orphaned === 1 ? '原文已修改或删除' : '') + '">' + escapeHtml(item.quote) + '</div>';
+        html += renderMessage(item.account, item.content, item.create_time);
+        for (var i = 0; i < item.replies.length; i++) {
+            html += renderMessage(item.replies[i].account, item.replies[i].content, item.replies[i].create_time);
+        }
+        if (item.resolved === 1 && item.resolved_account) {
+            html += '<div class="text-muted" style="font-size: 12px;">' + escapeHtml(item.resolved_account) + ' 已标记为解决</div>';
+        }
+        html += '<div class="annotation-reply" style="display: none;"><textarea class="form-control input-sm" rows="2" placeholder="回复"></textarea>'
+            + '<button type="button" class="btn btn-success btn-xs btn-reply-submit" style="margin-top: 4px;">回复</button></div>';
+        html += '<div class="annotation-util"><a href="javascript:;" class="btn-reply">回复</a>';
+        html += '<a href="javascript:;" class="btn-resolve" data-resolved="' + (item.resolved === 1 ? 'false' : 'true') + '">' + (item.resolved === 1 ? '重新打开' : '解决') + '</a>';
+        if (item.member_id === memberId) {
+            html += '<a href="javascript:;" class="btn-delete text-danger">删除</a>';
+        }
+        html += '</div></div>';
+        return html;
+    }
+
+    function render() {
+        clearHighlight();
+        var html = "";
+        for (var i = 0; i < annotations.length; i++) {
+            html += renderItem(annotations[i]);
+            if (annotations[i].resolved === 0) {
+                highlight(annotations[i]);
+            }
+        }
+        $panel.find(".annotation-list").html(html || '<div class="text-center text-muted" style="padding: 30px 0;">暂无批注</div>');
+    }
+
+    function load() {
+        $.get($panel.attr("data-list"), { "doc_id": $panel.attr("data-id"), "resolved": $panel.find(".annotation-filter").val() }, function (res) {
+            if (res.errcode === 0) {
+                annotations = res.data;
+                render();
+            }
+        }, "json");
+    }
+
+    function post(url, data) {
+        $.post(url, data, function (res) {
+            if (res.errcode === 0) {
+                load();
+            } else {
+                alert(res.message);
+            }
+        }, "json");
+    }
+
+    function activate(id) {
+        $panel.addClass("panel-active");
+        $panel.find(".annotation-item").removeClass("active");
+        $content.find("mark.annotation-mark").removeClass("active");
+        var $item = $panel.find(".annotation-item[data-id='" + id + "']").addClass("active");
+        var $mark = $content.find("mark.annotation-mark[data-id='" + id + "']").addClass("active");
+        if ($item.length > 0) {
+            $panel.scrollTop($panel.scrollTop() + $item.position().top - 60);
+        }
+        if ($mark.length > 0) {
+            $(".manual-right").scrollTop($(".manual-right").scrollTop() + $mark.first().position().top - 100);
+        }
+    }
+
+    //选中正文文字后显示批注按钮
+    $content.on("mouseup", function (e) {
+        setTimeout(function () {
+            var sel = window.getSelection();
+            if (!sel || sel.isCollapsed || sel.rangeCount === 0) {
+                $add.hide();
+                return;
+            }
+            var range = sel.getRangeAt(0);
+            if (!$.contains($content[0], range.commonAncestorContainer) && $content[0] !== range.commonAncestorContainer) {
+                $add.hide();
+                return;
+            }
+            var quote = normalize(sel.toString());
+            if (quote === "") {
+                $add.hide();
+                return;
+            }
+            var before = document.createRange();
+            before.selectNodeContents($content[0]);
+            before.setEnd(range.startContainer, range.startOffset);
+            var after = document.createRange();
+            after.selectNodeContents($content[0]);
+            after.setStart(range.endContainer, range.endOffset);
+
+            var prefix = normalize(before.toString());
+            selection = {
+                quote: quote,
+                prefix: prefix.substring(prefix.length - 32),
+                suffix: normalize(after.toString()).substring(0, 32),
+                offset: prefix.length
+            };
+            $add.css({ top: e.pageY + 10, left: e.pageX }).show();
+        }, 0);
+    });
+
+    $add.on("mousedown", function (e) {
+        e.preventDefault();
+    }).on("click", function () {
+        if (!selection) {
+            return;
+        }
+        var $form = $("#annotationForm");
+        $form.find("input[name='doc_id']").val($panel.attr("data-id"));
+        $form.find("input[name='quote']").val(selection.quote);
+        $form.find("input[name='prefix']").val(selection.prefix);
+        $form.find("input[name='suffix']").val(selection.suffix);
+        $form.find("input[name='offset']").val(selection.offset);
+        $form.find(".annotation-selected").text(selection.quote);
+        $form.find("textarea[name='content']").val("");
+        $("#annotation-error-message").text("");
+        $add.hide();
+        $("#annotationModal").modal("show");
+    });
+
+    $("#annotationForm").ajaxForm({
+        beforeSubmit: function () {
+            if ($.trim($("#annotationForm").find("textarea[name='content']").val()) === "") {
+                return showError("批注内容不能为空", "#annotation-error-message");
+            }
+        },
+        success: function (res) {
+            if (res.errcode === 0) {
+                $("#annotationModal").modal("hide");
+                $panel.addClass("panel-active");
+                load();
+            } else {
+                showError(res.message, "#annotation-error-message");
+            }
+        },
+        error: function () {
+            showError("服务器错误", "#annotation-error-message");
+        }
+    });
+
+    $(".annotation-toggle").on("click", function () {
+        $panel.toggleClass("panel-active");
+    });
+    $panel.on("click", ".annotation-close", function () {
+        $panel.removeClass("panel-active");
+    }).on("change", ".annotation-filter", function () {
+        load();
+    }).on("click", ".annotation-quote", function () {
+        activate($(this).closest(".annotation-item").attr("data-id"));
+    }).on("click", ".btn-reply", function () {
+        $(this).closest(".annotation-item").find(".annotation-reply").toggle().find("textarea").focus();
+    }).on("click", ".btn-reply-submit", function () {
+        var $item = $(this).closest(".annotation-item");
+        var content = $.trim($item.find(".annotation-reply textarea").val());
+        if (content === "") {
+            return;
+        }
+        post($panel.attr("data-reply"), { "annotation_id": $item.attr("data-id"), "content": content });
+    }).on("click", ".btn-resolve", function () {
+        post($panel.attr("data-resolve"), { "annotation_id": $(this).closest(".annotation-item").attr("data-id"), "resolved": $(this).attr("data-resolved") });
+    }).on("click", ".btn-delete", function () {
+        if (!confirm("确定删除该批注吗？")) {
+            return;
+        }
+        post($panel.attr("data-delete"), { "annotation_id": $(this).closest(".annotation-item").attr("data-id") });
+    });
+    $content.on("click", "mark.annotation-mark", function () {
+        activate($(this).attr("data-id"));
+    });
+
+    //切换文档时重新加载批注
+    events.on("article.open", function (event, $param) {
+        if ($param.$id) {
+            $panel.attr("data-id", $param.$id);
+            $add.hide();
+            load();
+        }
+    });
+
+    load();
+});
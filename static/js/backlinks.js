@@ -0,0 +1,53 @@
+/**
+ * 文档的标签、反向链接和相关文档
+ */
+$(function () {
+    var $panel = $("#articleLinks");
+    if ($panel.length <= 0) {
+        return;
+    }
+
+    function escapeHtml(text) {
+        return $("<div>").text(text || "").html();
+    }
+
+    function renderList($list, items, bookId) {
+        var html = "";
+        $.each(items, function (i, item) {
+            html += '<li><a href="' + escapeHtml(item.url) + '" title="' + escapeHtml(item.doc_name) + '">' + escapeHtml(item.doc_name) + '</a>';
+            if (item.book_id != bookId) {
+                html += ' <span class="text-muted">- ' + escapeHtml(item.book_name) + '</span>';
+            }
+            html += '</li>';
+        });
+        $list.html(html).closest(".links-group").toggle(items.length > 0);
+    }
+
+    function load(docId) {
+        $.get($panel.attr("data-url"), { "doc_id": docId }, function (res) {
+            if (res.errcode !== 0 || $panel.attr("data-id") != docId) {
+                $panel.hide();
+                return;
+            }
+            var bookId = $panel.attr("data-book");
+            var labels = "";
+            $.each(res.data.labels || [], function (i, item) {
+                labels += '<a href="' + escapeHtml(item.url) + '" class="label label-default">' + escapeHtml(item.label_name) + '</a> ';
+            });
+            $panel.find(".labels-list").html(labels).closest(".links-group").toggle(labels !== "");
+            renderList($panel.find(".backlinks-list"), res.data.backlinks, bookId);
+            renderList($panel.find(".related-list"), res.data.related, bookId);
+            $panel.toggle(labels !== "" || res.data.backlinks.length > 0 || res.data.related.length > 0);
+        }, "json");
+    }
+
+    //切换文档时重新加载
+    events.on("article.open", function (event, $param) {
+        if ($param.$id && $param.$id != $panel.attr("data-id")) {
+            $panel.attr("data-id", $param.$id).hide();
+            load($param.$id);
+        }
+    });
+
+    load($panel.attr("data-id"));
+});
@@ -0,0 +1,170 @@
+/**
+ * 文档评论
+ */
+$(function () {
+    var $comment = $("#articleComment");
+    if ($comment.length <= 0) {
+        return;
+    }
+    var memberId = parseInt($comment.attr("data-member")) || 0;
+    var page = 1;
+
+    function escapeHtml(text) {
+        return $("<div>").text(text || "").html();
+    }
+
+    function renderItem(item) {
+        var html = '<div class="comment-item has-avatar" data-id="' + item.comment_id + '">';
+        html += '<span class="avatar"><img src="' + escapeHtml(item.avatar) + '" width="36" height="36"></span>';
+        html += '<p class="info"><span class="name">' + escapeHtml(item.author) + '</span>';
+        if (item.reply_author) {
+            html += ' 回复 <span class="name">' + escapeHtml(item.reply_author) + '</span>';
+        }
+        html += '<span class="date">' + new Date(item.comment_date).format("yyyy-MM-dd hh:mm") + '</span>';
+        if (item.approved === 0) {
+            html += '<span class="pending">待审核</span>';
+        }
+        html += '</p>';
+        html += '<div class="content">' + escapeHtml(item.content).replace(/\n/g, "<br/>") + '</div>';
+        html += '<p class="util">';
+        if (item.approved === 1) {
+            html += '<span class="vote">';
+            html += '<a class="agree e-agree' + (item.vote_state === 1 ? ' active' : '') + '" href="javascript:;" data-id="' + item.comment_id + '" data-state="1" title="赞成"><i class="fa fa-thumbs-o-up"></i></a><b class="count agree-count">' + item.agree_count + '</b>';
+            html += '<a class="oppose e-oppose' + (item.vote_state === 2 ? ' active' : '') + '" href="javascript:;" data-id="' + item.comment_id + '" data-state="2" title="反对"><i class="fa fa-thumbs-o-down"></i></a><b class="count against-count">' + item.against_count + '</b>';
+            html += '</span>';
+            html += '<a class="reply e-reply" href="javascript:;" data-id="' + item.comment_id + '" data-author="' + escapeHtml(item.author) + '">回复</a>';
+        }
+        html += '<span class="operate toggle">';
+        if (memberId > 0 && item.member_id === memberId) {
+            html += '<a class="delete e-delete" href="javascript:;" data-id="' + item.comment_id + '"><i class="fa fa-trash-o"></i></a>';
+        }
+        if (item.floor > 0) {
+            html += '<span class="number">' + item.floor + '#</span>';
+        }
+        html += '</span></p>';
+        if (item.replies && item.replies.length > 0) {
+            html += '<div class="comment-replies">';
+            for (var i = 0; i < item.replies.length; i++) {
+                html += renderItem(item.replies[i]);
+            }
+            html += '</div>';
+        }
+        html += '</div>';
+        return html;
+    }
+
+    function loadComments(docId, pageIndex) {
+        $.ajax({
+            url: "/comment/lists",
+            type: "GET",
+            data: { "doc_id": docId, "page": pageIndex },
+            dataType: "json",
+            success: function (res) {
+                if (res.errcode !== 0) {
+                    $comment.hide();
+                    return;
+                }
+                $comment.show();
+                var $list = $comment.find(".comment-list");
+                if (pageIndex === 1) {
+                    $list.empty();
+                }
+                $comment.find(".comment-total").text(res.data.count);
+                $comment.toggleClass("comment-disabled", !res.data.can_comment);
+                if (res.data.total === 0) {
+                    $list.html('<div class="comment-empty"><b class="text">暂无相关评论</b></div>');
+                }
+                for (var i = 0; i < res.data.lists.length; i++) {
+                    $list.append(renderItem(res.data.lists[i]));
+                }
+                page = pageIndex;
+                $comment.find(".comment-more").toggleClass("more-active", pageIndex * res.data.size < res.data.total);
+            }
+        });
+    }
+
+    function resetReply() {
+        $("#commentForm").find("input[name='parent_id']").val(0);
+        $comment.find(".reply-tip").hide();
+    }
+
+    $("#commentForm").ajaxForm({
+        beforeSubmit: function () {
+            var content = $.trim($("#commentForm").find("textarea[name='content']").val());
+            if (content === "") {
+                $comment.find(".comment-error").text("评论内容不能为空");
+                return false;
+            }
+            $comment.find(".comment-error").text("");
+        },
+        success: function (res) {
+            if (res.errcode === 0) {
+                $("#commentForm").find("textarea[name='content']").val("");
+                resetReply();
+                if (res.message !== "ok") {
+                    $comment.find(".comment-error").text(res.message);
+                }
+                loadComments($comment.attr("data-id"), 1);
+            } else {
+                $comment.find(".comment-error").text(res.message);
+            }
+        },
+        error: function () {
+            $comment.find(".comment-error").text("服务器错误");
+        }
+    });
+
+    $("#commentForm").on("keydown", "textarea", function (e) {
+        if (e.ctrlKey && e.keyCode === 13) {
+            $("#commentForm").submit();
+        }
+    });
+
+    $comment.on("click", ".e-reply", function () {
+        $("#commentForm").find("input[name='parent_id']").val($(this).attr("data-id"));
+        $comment.find(".reply-author").text($(this).attr("data-author"));
+        $comment.find(".reply-tip").show();
+        $("#commentForm").find("textarea[name='content']").focus();
+    }).on("click", ".reply-cancel", function () {
+        resetReply();
+    }).on("click", ".e-agree,.e-oppose", function () {
+        if (memberId <= 0) {
+            $comment.find(".comment-error").text("请先登录后再投票");
+            return;
+        }
+        var $vote = $(this).closest(".vote");
+        $.post("/comment/vote", { "comment_id": $(this).attr("data-id"), "state": $(this).attr("data-state") }, function (res) {
+            if (res.errcode === 0) {
+                $vote.find(".agree-count").text(res.data.agree_count);
+                $vote.find(".against-count").text(res.data.against_count);
+            } else {
+                $comment.find(".comment-error").text(res.message);
+            }
+        }, "json");
+    }).on("click", ".e-delete", function () {
+        if (!confirm("确定删除该评论吗？")) {
+            return;
+        }
+        $.post("/comment/delete", { "comment_id": $(this).attr("data-id") }, function (res) {
+            if (res.errcode === 0) {
+                loadComments($comment.attr("data-id"), 1);
+            } else {
+                $comment.find(".comment-error").text(res.message);
+            }
+        }, "json");
+    }).on("click", ".comment-more .more-inner", function () {
+        loadComments($comment.attr("data-id"), page + 1);
+    });
+
+    //切换文档时重新加载评论
+    events.on("article.open", function (event, $param) {
+        if ($param.$id && $param.$id != $comment.attr("data-id")) {
+            $comment.attr("data-id", $param.$id);
+            $("#commentForm").find("input[name='doc_id']").val($param.$id);
+            resetReply();
+            loadComments($param.$id, 1);
+        }
+    });
+
+    loadComments($comment.attr("data-id"), 1);
+});
@@ -86,7 +86,7 @@ function RenderByMarkdown($content) {
  */
 function loadDocument($url,$id,$callback) {
     $.ajax({
-        url : $url+"?fr=docstack",
+        url : $url + ($url.indexOf("?") > -1 ? "&" : "?") + "fr=docstack",
         type : "GET",
         beforeSend :function (xhr) {
             var body = events.data('body_' + $id);
@@ -224,7 +224,7 @@ $(function () {
         //上一篇和下一篇的链接
         var links=$("#menu-hidden a"),link_active=location.pathname,l=links.length;
         for(var i=0;i<l;i++){
-            if (encodeURI($(links[i]).attr("href"))==link_active){
+            if (encodeURI($(links[i]).attr("href").split("?")[0])==link_active){
                 $(".hung-read-link .col-xs-12").hide();
                 var link_pre=$(links[i-1]),link_next=$(links[i+1]);
                 if(link_pre && link_pre.text()){
@@ -100,6 +100,84 @@ function openDeleteDocumentDialog($node) {
     });
 }
 
+/**
+ * 移动或复制文档到其他项目
+ * @param $node
+ */
+function openTransferDocumentDialog($node) {
+    var $then = $("#transferDocumentModal");
+    var $book = $then.find("select[name='target']");
+
+    $then.find("input[name='doc_id']").val($node.id);
+    $then.find("select[name='parent_id']").html('<option value="0">根目录</option>');
+    $then.find("#transfer-error-message").text("");
+    $book.html('<option value="">加载中...</option>');
+
+    $.get(window.transferURL).done(function (res) {
+        if (res.errcode !== 0) {
+            $book.html('<option value="">' + res.message + '</option>');
+            return;
+        }
+        var html = '<option value="">请选择项目</option>';
+        $.each(res.data || [], function (i, item) {
+            html += '<option value="' + item.identify + '">' + $("<div>").text(item.book_name).html() + '</option>';
+        });
+        $book.html(html);
+    });
+    $then.modal("show");
+}
+
+/**
+ * 加载目标项目中的文档作为上级文档
+ * @param identify
+ */
+function loadTransferParents(identify) {
+    var $parent = $("#transferDocumentModal").find("select[name='parent_id']");
+    $parent.html('<option value="0">根目录</option>');
+    if (!identify) {
+        return;
+    }
+    $.get(window.transferURL, { "target" : identify }).done(function (res) {
+        if (res.errcode !== 0) {
+            return;
+        }
+        var html = '<option value="0">根目录</option>';
+        $.each(res.data || [], function (i, item) {
+            html += '<option value="' + item.doc_id + '">' + $("<div>").text(item.doc_name).html() + '</option>';
+        });
+        $parent.html(html);
+    });
+}
+
+/**
+ * 显示引用了文档的文档，修改文档会影响这些文档的下次发布
+ * @param $node
+ */
+function openUsedByDialog($node) {
+    var $then = $("#usedByModal");
+    var $list = $then.find(".used-by-list");
+
+    $list.html('<li>加载中...</li>');
+    $.get(window.usedByURL, { "doc_id" : $node.id }).done(function (res) {
+        if (res.errcode !== 0) {
+            $list.html('<li>' + $("<div>").text(res.message).html() + '</li>');
+            return;
+        }
+        if (!res.data || res.data.length === 0) {
+            $list.html('<li>没有文档引用此文档</li>');
+            return;
+        }
+        var html = '';
+        $.each(res.data, function (i, item) {
+            var name = $("<div>").text(item.book_name + " / " + item.doc_name).html();
+            var section = item.section ? ' <small>#' + $("<div>").text(item.section).html() + '</small>' : '';
+            html += '<li><a href="' + item.url + '" target="_blank">' + name + '</a>' + section + '</li>';
+        });
+        $list.html(html);
+    });
+    $then.modal("show");
+}
+
 /**
  * 打开文档编辑界面
  * @param $node
@@ -123,6 +201,15 @@ function openEditCatalogDialog($node) {
             break;
         }
     }
+    //标签加载完成前禁用输入框，避免未加载的标签被清空
+    if (doc_id > 0) {
+        var $label = $then.find("input[name='doc_label']").prop("disabled", true);
+        $.get(window.labelsURL, { "doc_id" : doc_id }).done(function (res) {
+            if (res.errcode === 0) {
+                $label.val($.map(res.data || [], function (item) { return item.label_name; }).join(",")).prop("disabled", false);
+            }
+        });
+    }
 
     $then.modal({ show : true });
 }
@@ -185,6 +272,36 @@ $("#addDocumentModal").on("hidden.bs.modal",function () {
 }).on("shown.bs.modal",function () {
     $(this).find("input[name='doc_name']").focus();
 });
+//移动或复制文档到其他项目
+$("#transferDocumentForm").find("select[name='target']").on("change",function () {
+    loadTransferParents($(this).val());
+});
+$("#transferDocumentForm").on("submit",function (e) {
+    e.preventDefault();
+    var $form = $(this);
+    var doc_id = $form.find("input[name='doc_id']").val();
+    var mode = $form.find("input[name='mode']:checked").val();
+    if (!$form.find("select[name='target']").val()) {
+        return showError("请选择目标项目","#transfer-error-message");
+    }
+    $("#btnTransferDocument").button("loading");
+    $.post(window.transferURL, $form.serialize()).done(function (res) {
+        $("#btnTransferDocument").button("reset");
+        if (res.errcode !== 0) {
+            return showError(res.message,"#transfer-error-message");
+        }
+        $("#transferDocumentModal").modal("hide");
+        if (mode === "move") {
+            window.treeCatalog.delete_node(window.treeCatalog.get_node(doc_id));
+            layer.msg("已移动 " + res.data.doc_count + " 篇文档");
+        } else {
+            layer.msg("已复制 " + res.data.doc_count + " 篇文档");
+        }
+    }).fail(function () {
+        $("#btnTransferDocument").button("reset");
+        showError("操作失败","#transfer-error-message");
+    });
+});
 
 function showError($msg,$id) {
     if(!$id){
@@ -250,6 +250,30 @@ $(function () {
                         openEditCatalogDialog(node);
                     }
                 },
+                "引用": {
+                    "separator_before": false,
+                    "separator_after": false,
+                    "_disabled": false,
+                    "label": "查看引用此文档的文档",
+                    "icon": "fa fa-link",
+                    "action": function (data) {
+                        var inst = $.jstree.reference(data.reference);
+                        var node = inst.get_node(data.reference);
+                        openUsedByDialog(node);
+                    }
+                },
+                "移动或复制": {
+                    "separator_before": false,
+                    "separator_after": true,
+                    "_disabled": false,
+                    "label": "移动或复制到其他项目",
+                    "icon": "fa fa-share-square-o",
+                    "action": function (data) {
+                        var inst = $.jstree.reference(data.reference);
+                        var node = inst.get_node(data.reference);
+                        openTransferDocumentDialog(node);
+                    }
+                },
                 "删除": {
                     "separator_before": false,
                     "separator_after": true,
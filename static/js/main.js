@@ -233,6 +233,12 @@ $(function () {
     });
 
 
+    //切换项目版本
+    $(".version-switch").on("change", function () {
+        var version = $(this).val();
+        location.href = $(this).attr("data-url") + (version ? "?version=" + version : "");
+    });
+
     //文档下载
     $(".btn-filedown").click(function (e) {
         e.preventDefault();
@@ -169,6 +169,28 @@ $(function () {
             $("#ModalMulti").modal("show");
        }else if(name=="spider"){//爬虫采集
             $("#ModalSpider").modal("show");
+       }else if(name=="preview-draft"){//预览草稿
+            if(!window.selectNode){
+                layer.msg("请先选择要预览的文档");
+            }else if($("#markdown-save").hasClass("change")){
+                layer.msg("请先保存文档再预览");
+            }else{
+                window.open(window.previewURL+window.selectNode.id);
+            }
+       }else if(name=="review"){//请求审阅
+            if(!window.selectNode){
+                layer.msg("请先选择要审阅的文档");
+            }else{
+                $("#ModalReview").modal("show");
+            }
+       }else if(name=="meta"){//文档元数据
+            if(!window.selectNode){
+                layer.msg("请先选择文档");
+            }else if($("#markdown-save").hasClass("change")){
+                layer.msg("请先保存文档再修改元数据");
+            }else{
+                openMetaDialog(window.selectNode.id);
+            }
        }else{
            var action = window.editor.toolbarHandlers[name];
 
@@ -217,7 +239,7 @@ $(function () {
         var index = null;
         var node = window.selectNode;
         var content = window.editor.getMarkdown();
-        var html = window.editor.getPreviewedHTML();
+        var html = renderContent(content);
         var version = "";
 
         if(!node){
@@ -274,6 +296,41 @@ $(function () {
         });
     }
 
+    /**
+     * 获取保存的HTML内容，文档开头的元数据不显示在文档中
+     * @param markdown
+     */
+    function renderContent(markdown) {
+        var match = /^---[ \t]*\r?\n([\s\S]*?)\r?\n(?:---|\.\.\.)[ \t]*(?:\r?\n|$)/.exec(markdown);
+        if (!match) {
+            return window.editor.getPreviewedHTML();
+        }
+        //与服务端的解析规则一致，每一行都是“键: 值”、列表项或缩进的内容时才是元数据
+        var lines = match[1].split(/\r?\n/);
+        for (var i = 0; i < lines.length; i++) {
+            var line = lines[i];
+            if ($.trim(line) === "" || /^\s*#/.test(line) || /^\s/.test(line) || /^-(\s|$)/.test(line)) {
+                continue;
+            }
+            if (!/^[^\s\-"'#\[\]{}][^:"'#\[\]{}]*:(\s|$)/.test(line)) {
+                return window.editor.getPreviewedHTML();
+            }
+        }
+        var $render = $('<div id="frontMatterRender" style="position:absolute;left:-9999px;top:0;width:800px;"></div>').appendTo("body");
+        editormd.markdownToHTML("frontMatterRender", {
+            markdown        : markdown.substr(match[0].length),
+            htmlDecode      : "style,script,iframe,title,onmouseover,onmouseout,style",
+            tocm            : true,
+            tocStartLevel   : 1,
+            taskList        : true,
+            flowChart       : true
+        });
+        $render.find("textarea").remove();
+        var html = $render.html();
+        $render.remove();
+        return html;
+    }
+
     function resetEditor($node) {
 
     }
@@ -361,6 +418,111 @@ $(function () {
         }
     });
 
+    $("#btnReview").click(function (e) {
+        e.preventDefault();
+        var form=$("#ModalReview form"),accounts=form.find("[name=accounts]").val();
+        if($.trim(accounts)=="" && !window.enableReview){
+            form.find("[name=accounts]").focus();
+            layer.msg("请填写审阅人");
+            return false;
+        }
+        if(window.enableReview && $("#markdown-save").hasClass("change")){
+            layer.msg("请先保存文档再提交审阅");
+            return false;
+        }
+        var node_id=window.selectNode.id;
+        $.post(window.reviewURL,form.serialize()+"&doc_id="+node_id,function (res) {
+            if (res.errcode==0){
+                if(window.enableReview){
+                    //在目录中标记为待审阅
+                    var node=window.treeCatalog.get_node(node_id);
+                    if(node){
+                        window.treeCatalog.rename_node(node,node.text.replace(/<small class=.text-warning.>.*?<\/small>/g,"")+"<small class='text-warning'>[待审阅]</small>");
+                    }
+                }
+                layer.msg(window.enableReview ? "已提交审阅" : "已通知审阅人");
+                $("#ModalReview").modal("hide");
+                form.find("[type=reset]").trigger("click");
+            }else{
+                layer.msg(res.message);
+            }
+        });
+    });
+
+    /**
+     * 添加一行自定义字段
+     * @param key
+     * @param value
+     */
+    function addMetaField(key, value) {
+        var $row = $('<div class="row" style="margin-bottom: 5px;">' +
+            '<div class="col-sm-4"><input type="text" name="field_key" placeholder="键" class="form-control" maxlength="100"></div>' +
+            '<div class="col-sm-6"><input type="text" name="field_value" placeholder="值" class="form-control" maxlength="1000"></div>' +
+            '<div class="col-sm-2"><button type="button" class="btn btn-default btn-sm remove-meta-field" title="删除"><i class="fa fa-trash"></i></button></div>' +
+            '</div>');
+        $row.find("[name=field_key]").val(key || "");
+        $row.find("[name=field_value]").val(value || "");
+        $("#ModalMeta .meta-fields").append($row);
+    }
+
+    /**
+     * 打开文档元数据编辑窗口
+     * @param doc_id
+     */
+    function openMetaDialog(doc_id) {
+        var form = $("#ModalMeta form");
+        $.get(window.metaURL, { "doc_id" : doc_id }).done(function (res) {
+            if (res.errcode !== 0) {
+                layer.msg(res.message);
+                return;
+            }
+            var meta = res.data;
+            $.each(["title", "description", "tags", "author", "owner", "review_by"], function (i, name) {
+                form.find("[name=" + name + "]").val(meta[name] || "");
+            });
+            form.find(".meta-fields").empty();
+            $.each(meta.fields || [], function (i, field) {
+                addMetaField(field.key, field.value);
+            });
+            $("#ModalMeta").modal("show");
+        });
+    }
+
+    $("#btnAddMetaField").click(function () {
+        addMetaField();
+    });
+    $("#ModalMeta").on("click", ".remove-meta-field", function () {
+        $(this).closest(".row").remove();
+    });
+
+    $("#btnSaveMeta").click(function (e) {
+        e.preventDefault();
+        var $btn = $(this);
+        var form = $("#ModalMeta form");
+        var doc_id = window.selectNode.id;
+        $btn.button("loading");
+        $.post(window.metaURL, form.serialize() + "&doc_id=" + doc_id, function (res) {
+            $btn.button("reset");
+            if (res.errcode !== 0) {
+                layer.msg(res.message);
+                return;
+            }
+            //元数据写入了文档开头，重新载入文档内容
+            window.isLoad = true;
+            window.editor.clear();
+            window.editor.insertValue(res.data.markdown);
+            window.editor.setCursor({line:0, ch:0});
+            for (var i in window.documentCategory) {
+                if (window.documentCategory[i].id === parseInt(doc_id)) {
+                    window.documentCategory[i].version = res.data.version;
+                    break;
+                }
+            }
+            layer.msg("保存成功");
+            $("#ModalMeta").modal("hide");
+        });
+    });
+
     $("#btnMulti").click(function (e) {
         e.preventDefault();
         if($(this).hasClass("disabled")) return false;
@@ -429,6 +591,30 @@ $(function () {
                         openEditCatalogDialog(node);
                     }
                 },
+                "引用": {
+                    "separator_before": false,
+                    "separator_after": false,
+                    "_disabled": false,
+                    "label": "查看引用此文档的文档",
+                    "icon": "fa fa-link",
+                    "action": function (data) {
+                        var inst = $.jstree.reference(data.reference);
+                        var node = inst.get_node(data.reference);
+                        openUsedByDialog(node);
+                    }
+                },
+                "移动或复制": {
+                    "separator_before": false,
+                    "separator_after": true,
+                    "_disabled": false,
+                    "label": "移动或复制到其他项目",
+                    "icon": "fa fa-share-square-o",
+                    "action": function (data) {
+                        var inst = $.jstree.reference(data.reference);
+                        var node = inst.get_node(data.reference);
+                        openTransferDocumentDialog(node);
+                    }
+                },
                 "删除": {
                     "separator_before": false,
                     "separator_after": true,
@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+//模糊匹配的最低匹配度，低于该值认为原文已被删除.
+const AnchorThreshold = 0.7
+
+//模糊匹配时参与计算的最大字符数，避免超长文本消耗过多资源.
+const anchorMaxCells = 20000000
+
+var (
+	markdownFenceRegexp  = regexp.MustCompile("(?m)^\\s*(```|~~~).*$")
+	markdownImageRegexp  = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	markdownLinkRegexp   = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	markdownPrefixRegexp = regexp.MustCompile(`(?m)^\s*(#{1,6}\s+|>\s?|[-*+]\s+|\d+\.\s+)`)
+	markdownTagRegexp    = regexp.MustCompile(`<[^>]+>`)
+	markdownMarkReplacer = strings.NewReplacer("**", "", "__", "", "~~", "", "`", "")
+)
+
+//文本锚点，位置按字符计算.
+type TextAnchor struct {
+	Start int
+	End   int
+	//匹配度，1 表示完全匹配
+	Score float64
+}
+
+//将 Markdown 转换为阅读时看到的纯文本，并合并连续的空白字符.
+func MarkdownText(markdown string) string {
+	text := markdownFenceRegexp.ReplaceAllString(markdown, "")
+	text = markdownImageRegexp.ReplaceAllString(text, "$1")
+	text = markdownLinkRegexp.ReplaceAllString(text, "$1")
+	text = markdownPrefixRegexp.ReplaceAllString(text, "")
+	text = markdownTagRegexp.ReplaceAllString(text, "")
+	text = markdownMarkReplacer.Replace(text)
+	return NormalizeSpace(text)
+}
+
+//合并连续的空白字符.
+func NormalizeSpace(text string) string {
+	return strings.Join(strings.FieldsFunc(text, unicode.IsSpace), " ")
+}
+
+//在 text 中定位 quote，prefix 和 suffix 为 quote 前后的上下文，hint 为上次定位的位置.
+//优先完全匹配，多处匹配时选择上下文最接近的位置；无法完全匹配时按编辑距离模糊匹配.
+func FindAnchor(text, quote, prefix, suffix string, hint int) (TextAnchor, bool) {
+	t := []rune(text)
+	q := []rune(quote)
+	if len(q) == 0 || len(t) == 0 {
+		return TextAnchor{}, false
+	}
+	if anchor, ok := exactAnchor(t, q, []rune(prefix), []rune(suffix), hint); ok {
+		return anchor, true
+	}
+	anchor := fuzzyAnchor(t, q, hint)
+	return anchor, anchor.Score >= AnchorThreshold
+}
+
+func exactAnchor(t, q, prefix, suffix []rune, hint int) (TextAnchor, bool) {
+	best, bestContext := -1, -1
+	for i := 0; i+len(q) <= len(t); i++ {
+		if !runesEqual(t[i:i+len(q)], q) {
+			continue
+		}
+		context := commonSuffix(t[:i], prefix) + commonPrefix(t[i+len(q):], suffix)
+		if context > bestContext || (context == bestContext && absInt(i-hint) < absInt(best-hint)) {
+			best, bestContext = i, context
+		}
+	}
+	if best < 0 {
+		return TextAnchor{}, false
+	}
+	return TextAnchor{Start: best, End: best + len(q), Score: 1}, true
+}
+
+//近似子串匹配：计算 quote 与 text 任意子串的最小编辑距离.
+func fuzzyAnchor(t, q []rune, hint int) TextAnchor {
+	offset := 0
+	//文本过长时只在上次位置附近查找
+	if len(t)*len(q) > anchorMaxCells {
+		window := anchorMaxCells / len(q) / 2
+		start, end := hint-window, hint+window
+		if start < 0 {
+			start = 0
+		}
+		if end > len(t) {
+			end = len(t)
+		}
+		if start >= end {
+			return TextAnchor{}
+		}
+		t, offset = t[start:end], start
+	}
+	m := len(q)
+	prev, cur := make([]int, m+1), make([]int, m+1)
+	prevStart, curStart := make([]int, m+1), make([]int, m+1)
+	for j := 0; j <= m; j++ {
+		prev[j] = j
+	}
+	bestDist, bestStart, bestEnd := m+1, 0, 0
+	for i := 1; i <= len(t); i++ {
+		cur[0], curStart[0] = 0, i
+		for j := 1; j <= m; j++ {
+			cost := 1
+			if t[i-1] == q[j-1] {
+				cost = 0
+			}
+			cur[j], curStart[j] = prev[j-1]+cost, prevStart[j-1]
+			if prev[j]+1 < cur[j] {
+				cur[j], curStart[j] = prev[j]+1, prevStart[j]
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j], curStart[j] = cur[j-1]+1, curStart[j-1]
+			}
+		}
+		if cur[m] < bestDist || (cur[m] == bestDist && absInt(curStart[m]+offset-hint) < absInt(bestStart+offset-hint)) {
+			bestDist, bestStart, bestEnd = cur[m], curStart[m], i
+		}
+		prev, cur = cur, prev
+		prevStart, curStart = curStart, prevStart
+	}
+	if bestEnd <= bestStart {
+		return TextAnchor{}
+	}
+	return TextAnchor{Start: bestStart + offset, End: bestEnd + offset, Score: 1 - float64(bestDist)/float64(m)}
+}
+
+func runesEqual(a, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func commonPrefix(a, b []rune) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+func commonSuffix(a, b []rune) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	return n
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,508 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//计算差异时允许的最大编辑距离，超过后按全部替换统计.
+const diffMaxEdits = 5000
+
+//统计由 a 修改为 b 时新增和删除的行数.
+func LineDiffStat(a, b string) (added, removed int) {
+	x, y := splitLines(a), splitLines(b)
+
+	//去掉相同的首尾行，减少计算量
+	for len(x) > 0 && len(y) > 0 && x[0] == y[0] {
+		x, y = x[1:], y[1:]
+	}
+	for len(x) > 0 && len(y) > 0 && x[len(x)-1] == y[len(y)-1] {
+		x, y = x[:len(x)-1], y[:len(y)-1]
+	}
+	d := editDistance(x, y)
+	if d < 0 {
+		return len(y), len(x)
+	}
+	added = (d + len(y) - len(x)) / 2
+	return added, d - added
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+}
+
+//Myers 算法计算只包含插入和删除的最小编辑距离，超过 diffMaxEdits 时返回 -1.
+func editDistance(x, y []string) int {
+	n, m := len(x), len(y)
+	if n == 0 || m == 0 {
+		return n + m
+	}
+	max := n + m
+	if max > diffMaxEdits {
+		max = diffMaxEdits
+	}
+	v := make([]int, 2*max+2)
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var i int
+			if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+				i = v[max+k+1]
+			} else {
+				i = v[max+k-1] + 1
+			}
+			j := i - k
+			for i < n && j < m && x[i] == y[j] {
+				i, j = i+1, j+1
+			}
+			v[max+k] = i
+			if i >= n && j >= m {
+				return d
+			}
+		}
+	}
+	return -1
+}
+
+//生成编辑脚本时允许的最大编辑距离，需要保存每一步的状态，比统计时更小.
+const diffScriptMaxEdits = 2000
+
+//按词比较时为删除行查找相似新增行的范围.
+const diffPairWindow = 20
+
+//差异中的行或词的类型.
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+//行内按词比较的结果.
+type DiffWord struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+//差异中的一行，OldLine 和 NewLine 为 0 表示该侧没有对应的行.
+type DiffLine struct {
+	Type    string      `json:"type"`
+	OldLine int         `json:"old_line"`
+	NewLine int         `json:"new_line"`
+	Text    string      `json:"text"`
+	Words   []*DiffWord `json:"words,omitempty"`
+}
+
+//连续修改及其上下文组成的差异块.
+type DiffHunk struct {
+	OldStart int         `json:"old_start"`
+	OldLines int         `json:"old_lines"`
+	NewStart int         `json:"new_start"`
+	NewLines int         `json:"new_lines"`
+	Lines    []*DiffLine `json:"lines"`
+}
+
+//左右对照显示的一行，Left 或 Right 为 nil 表示该侧为空.
+type DiffRow struct {
+	Left  *DiffLine
+	Right *DiffLine
+}
+
+type diffOp struct {
+	kind string
+	i, j int
+}
+
+//Myers 算法生成由 x 修改为 y 的编辑脚本，超过 diffScriptMaxEdits 时返回 nil.
+func diffScript(x, y []string) []diffOp {
+	n, m := len(x), len(y)
+	max := n + m
+	if max > diffScriptMaxEdits {
+		max = diffScriptMaxEdits
+	}
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	trace := make([][]int, 0)
+	found := -1
+	for d := 0; d <= max && found < 0; d++ {
+		for k := -d; k <= d; k += 2 {
+			var i int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				i = v[offset+k+1]
+			} else {
+				i = v[offset+k-1] + 1
+			}
+			j := i - k
+			for i < n && j < m && x[i] == y[j] {
+				i, j = i+1, j+1
+			}
+			v[offset+k] = i
+			if i >= n && j >= m {
+				found = d
+			}
+		}
+		snapshot := make([]int, 2*d+1)
+		copy(snapshot, v[offset-d:offset+d+1])
+		trace = append(trace, snapshot)
+	}
+	if found < 0 {
+		return nil
+	}
+	//从终点回溯编辑路径
+	ops := make([]diffOp, 0, n+m)
+	i, j := n, m
+	for d := found; d > 0; d-- {
+		prev := trace[d-1]
+		k := i - j
+		var pk int
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			pk = k + 1
+		} else {
+			pk = k - 1
+		}
+		pi := prev[pk+d-1]
+		pj := pi - pk
+		for i > pi && j > pj {
+			i, j = i-1, j-1
+			ops = append(ops, diffOp{DiffEqual, i, j})
+		}
+		if i == pi {
+			j--
+			ops = append(ops, diffOp{DiffInsert, i, j})
+		} else {
+			i--
+			ops = append(ops, diffOp{DiffDelete, i, j})
+		}
+	}
+	for i > 0 && j > 0 {
+		i, j = i-1, j-1
+		ops = append(ops, diffOp{DiffEqual, i, j})
+	}
+	for l, r := 0, len(ops)-1; l < r; l, r = l+1, r-1 {
+		ops[l], ops[r] = ops[r], ops[l]
+	}
+	return ops
+}
+
+//生成编辑脚本，差异过大时按全部删除后全部新增处理.
+func diffOps(x, y []string) []diffOp {
+	//去掉相同的首尾，减少计算量
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+	ops := make([]diffOp, 0, len(x)+len(y))
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{DiffEqual, i, i})
+	}
+	mx, my := x[prefix:len(x)-suffix], y[prefix:len(y)-suffix]
+	middle := diffScript(mx, my)
+	if middle == nil {
+		for i := range mx {
+			middle = append(middle, diffOp{DiffDelete, i, 0})
+		}
+		for j := range my {
+			middle = append(middle, diffOp{DiffInsert, len(mx), j})
+		}
+	}
+	for _, op := range middle {
+		ops = append(ops, diffOp{op.kind, op.i + prefix, op.j + prefix})
+	}
+	for s := suffix; s > 0; s-- {
+		ops = append(ops, diffOp{DiffEqual, len(x) - s, len(y) - s})
+	}
+	return ops
+}
+
+//按行比较由 a 修改为 b 的差异，每个差异块保留 context 行上下文，words 为 true 时对修改的行按词比较.
+func LineDiff(a, b string, context int, words bool) []*DiffHunk {
+	x, y := splitLines(a), splitLines(b)
+	ops := diffOps(x, y)
+
+	lines := make([]*DiffLine, 0, len(ops))
+	for _, op := range ops {
+		switch op.kind {
+		case DiffEqual:
+			lines = append(lines, &DiffLine{Type: DiffEqual, OldLine: op.i + 1, NewLine: op.j + 1, Text: x[op.i]})
+		case DiffDelete:
+			lines = append(lines, &DiffLine{Type: DiffDelete, OldLine: op.i + 1, Text: x[op.i]})
+		case DiffInsert:
+			lines = append(lines, &DiffLine{Type: DiffInsert, NewLine: op.j + 1, Text: y[op.j]})
+		}
+	}
+	if words {
+		pairWordDiff(lines)
+	}
+
+	hunks := make([]*DiffHunk, 0)
+	var hunk *DiffHunk
+	last := -1
+	for index, line := range lines {
+		if line.Type == DiffEqual {
+			continue
+		}
+		start := index - context
+		if start < 0 {
+			start = 0
+		}
+		if hunk == nil || start > last+1 {
+			hunk = &DiffHunk{}
+			hunks = append(hunks, hunk)
+		} else {
+			start = last + 1
+		}
+		end := index + context
+		if end >= len(lines) {
+			end = len(lines) - 1
+		}
+		for ; start <= end; start++ {
+			hunk.Lines = append(hunk.Lines, lines[start])
+		}
+		last = end
+	}
+	for _, hunk := range hunks {
+		for _, line := range hunk.Lines {
+			if line.OldLine > 0 {
+				if hunk.OldStart == 0 {
+					hunk.OldStart = line.OldLine
+				}
+				hunk.OldLines++
+			}
+			if line.NewLine > 0 {
+				if hunk.NewStart == 0 {
+					hunk.NewStart = line.NewLine
+				}
+				hunk.NewLines++
+			}
+		}
+	}
+	return hunks
+}
+
+//将相邻的删除行和新增行逐行配对后按词比较，相似度过低的行保持整行差异.
+func pairWordDiff(lines []*DiffLine) {
+	fenced := false
+	for index := 0; index < len(lines); {
+		line := lines[index]
+		if line.Type == DiffEqual {
+			if isCodeFence(line.Text) {
+				fenced = !fenced
+			}
+			index++
+			continue
+		}
+		deleted := make([]*DiffLine, 0)
+		for index < len(lines) && lines[index].Type == DiffDelete {
+			deleted = append(deleted, lines[index])
+			index++
+		}
+		inserted := make([]*DiffLine, 0)
+		for index < len(lines) && lines[index].Type == DiffInsert {
+			inserted = append(inserted, lines[index])
+			index++
+		}
+		//按顺序为每个删除行查找第一个相似的新增行，代码块内的行不按词比较
+		next := 0
+		for k := 0; k < len(deleted) && next < len(inserted) && !fenced; k++ {
+			if isCodeFence(deleted[k].Text) {
+				continue
+			}
+			for l := next; l < len(inserted) && l < next+diffPairWindow; l++ {
+				if isCodeFence(inserted[l].Text) {
+					continue
+				}
+				old_words, new_words := WordDiff(deleted[k].Text, inserted[l].Text)
+				if old_words != nil {
+					deleted[k].Words, inserted[l].Words = old_words, new_words
+					next = l + 1
+					break
+				}
+			}
+		}
+		for _, line := range inserted {
+			if isCodeFence(line.Text) {
+				fenced = !fenced
+			}
+		}
+	}
+}
+
+//是否是 Markdown 代码块的开始或结束行.
+func isCodeFence(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~")
+}
+
+//按词比较两行文本，返回两侧的分词差异，相同的词少于一半时返回 nil.
+func WordDiff(a, b string) (old_words, new_words []*DiffWord) {
+	x, y := splitWords(a), splitWords(b)
+	ops := diffOps(x, y)
+
+	same := 0
+	for _, op := range ops {
+		switch op.kind {
+		case DiffEqual:
+			if strings.TrimSpace(x[op.i]) != "" {
+				same++
+			}
+			old_words = appendWord(old_words, DiffEqual, x[op.i])
+			new_words = appendWord(new_words, DiffEqual, y[op.j])
+		case DiffDelete:
+			old_words = appendWord(old_words, DiffDelete, x[op.i])
+		case DiffInsert:
+			new_words = appendWord(new_words, DiffInsert, y[op.j])
+		}
+	}
+	total := 0
+	for _, word := range append(x, y...) {
+		if strings.TrimSpace(word) != "" {
+			total++
+		}
+	}
+	if same*4 < total {
+		return nil, nil
+	}
+	return
+}
+
+//合并相邻的同类型分词.
+func appendWord(words []*DiffWord, kind, text string) []*DiffWord {
+	if n := len(words); n > 0 && words[n-1].Type == kind {
+		words[n-1].Text += text
+		return words
+	}
+	return append(words, &DiffWord{Type: kind, Text: text})
+}
+
+//按 Markdown 语法分词：连续的字母数字为一个词，中文逐字分开，空白和标记符号单独成词.
+func splitWords(text string) []string {
+	words := make([]string, 0)
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		j := i + 1
+		switch {
+		case isWordRune(runes[i]):
+			for j < len(runes) && isWordRune(runes[j]) {
+				j++
+			}
+		case runes[i] == ' ' || runes[i] == '\t':
+			for j < len(runes) && (runes[j] == ' ' || runes[j] == '\t') {
+				j++
+			}
+		case strings.ContainsRune("*_~`#>|=-", runes[i]):
+			//强调、标题、表格等连续的标记符号作为一个词
+			for j < len(runes) && runes[j] == runes[i] {
+				j++
+			}
+		}
+		words = append(words, string(runes[i:j]))
+		i = j
+	}
+	return words
+}
+
+func isWordRune(r rune) bool {
+	return r < 0x80 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+}
+
+//转换为左右对照显示的行，相邻的删除行和新增行并排显示.
+func (h *DiffHunk) SideBySide() []*DiffRow {
+	rows := make([]*DiffRow, 0, len(h.Lines))
+	for index := 0; index < len(h.Lines); {
+		if h.Lines[index].Type == DiffEqual {
+			rows = append(rows, &DiffRow{Left: h.Lines[index], Right: h.Lines[index]})
+			index++
+			continue
+		}
+		start := len(rows)
+		for index < len(h.Lines) && h.Lines[index].Type == DiffDelete {
+			rows = append(rows, &DiffRow{Left: h.Lines[index]})
+			index++
+		}
+		row := start
+		for index < len(h.Lines) && h.Lines[index].Type == DiffInsert {
+			if row < len(rows) {
+				rows[row].Right = h.Lines[index]
+			} else {
+				rows = append(rows, &DiffRow{Right: h.Lines[index]})
+			}
+			row++
+			index++
+		}
+	}
+	return rows
+}
+
+//生成由 base 修改为 text 的行差异，用于压缩存储历史内容，可以通过 ApplyLineDelta 还原.
+//每个操作占一行：=N 表示保留 N 行，-N 表示删除 N 行，+N 表示插入其后的 N 行.
+func LineDelta(base, text string) string {
+	x, y := strings.Split(base, "\n"), strings.Split(text, "\n")
+	ops := diffOps(x, y)
+
+	var buf bytes.Buffer
+	for index := 0; index < len(ops); {
+		kind := ops[index].kind
+		end := index
+		for end < len(ops) && ops[end].kind == kind {
+			end++
+		}
+		switch kind {
+		case DiffEqual:
+			fmt.Fprintf(&buf, "=%d\n", end-index)
+		case DiffDelete:
+			fmt.Fprintf(&buf, "-%d\n", end-index)
+		case DiffInsert:
+			fmt.Fprintf(&buf, "+%d\n", end-index)
+			for _, op := range ops[index:end] {
+				buf.WriteString(y[op.j])
+				buf.WriteByte('\n')
+			}
+		}
+		index = end
+	}
+	return buf.String()
+}
+
+//将 LineDelta 生成的差异应用到 base，还原修改后的内容.
+func ApplyLineDelta(base, delta string) (string, error) {
+	x := strings.Split(base, "\n")
+	ops := strings.Split(delta, "\n")
+	lines := make([]string, 0, len(x))
+	i := 0
+	for index := 0; index < len(ops); index++ {
+		if ops[index] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(ops[index][1:])
+		if err != nil || n < 0 {
+			return "", errors.New("差异格式错误")
+		}
+		switch ops[index][0] {
+		case '=':
+			if i+n > len(x) {
+				return "", errors.New("差异与原内容不匹配")
+			}
+			lines = append(lines, x[i:i+n]...)
+			i += n
+		case '-':
+			i += n
+		case '+':
+			if index+n >= len(ops) {
+				return "", errors.New("差异格式错误")
+			}
+			lines = append(lines, ops[index+1:index+1+n]...)
+			index += n
+		default:
+			return "", errors.New("差异格式错误")
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
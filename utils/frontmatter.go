@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+//文档开头的 YAML 元数据中的一项.
+//只支持常用的简单语法：标量值、[a, b] 或 "- 项" 形式的列表，以及缩进的一层键值对.
+type FrontMatterField struct {
+	Key      string
+	Value    string
+	IsList   bool
+	List     []string
+	Children []FrontMatterField
+}
+
+//解析 Markdown 开头以 --- 包围的元数据，返回元数据和去掉元数据后的正文.
+//无法按支持的语法解析时认为文档没有元数据，原样返回内容.
+func ParseFrontMatter(markdown string) ([]FrontMatterField, string, bool) {
+	text := strings.Replace(markdown, "\r\n", "\n", -1)
+	lines := strings.Split(text, "\n")
+	if len(lines) < 2 || strings.TrimRight(lines[0], " \t") != "---" {
+		return nil, markdown, false
+	}
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if line := strings.TrimRight(lines[i], " \t"); line == "---" || line == "..." {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, markdown, false
+	}
+	var fields []FrontMatterField
+	//值为空的顶级字段可以包含缩进的列表或键值对
+	open := false
+	for _, line := range lines[1:end] {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		item := trimmed == "-" || strings.HasPrefix(trimmed, "- ")
+		if line[0] == ' ' || line[0] == '\t' || (item && open) {
+			if !open {
+				return nil, markdown, false
+			}
+			parent := &fields[len(fields)-1]
+			if item {
+				if len(parent.Children) > 0 {
+					return nil, markdown, false
+				}
+				parent.IsList = true
+				parent.List = append(parent.List, frontMatterScalar(strings.TrimSpace(strings.TrimPrefix(trimmed, "-"))))
+				continue
+			}
+			key, value, ok := splitFrontMatterLine(trimmed)
+			if !ok || parent.IsList {
+				return nil, markdown, false
+			}
+			parent.Children = append(parent.Children, FrontMatterField{Key: key, Value: frontMatterScalar(value)})
+			continue
+		}
+		key, value, ok := splitFrontMatterLine(trimmed)
+		if !ok {
+			return nil, markdown, false
+		}
+		field := FrontMatterField{Key: key}
+		if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			field.IsList = true
+			field.List = make([]string, 0)
+			for _, s := range strings.Split(value[1:len(value)-1], ",") {
+				if s = frontMatterScalar(strings.TrimSpace(s)); s != "" {
+					field.List = append(field.List, s)
+				}
+			}
+		} else {
+			field.Value = frontMatterScalar(value)
+		}
+		fields = append(fields, field)
+		open = value == ""
+	}
+	if len(fields) == 0 {
+		return nil, markdown, false
+	}
+	return fields, strings.TrimLeft(strings.Join(lines[end+1:], "\n"), "\n"), true
+}
+
+//拆分 "键: 值" 形式的一行.
+func splitFrontMatterLine(line string) (string, string, bool) {
+	i := strings.Index(line, ":")
+	if i <= 0 || (i < len(line)-1 && line[i+1] != ' ' && line[i+1] != '\t') {
+		return "", "", false
+	}
+	key := strings.TrimSpace(line[:i])
+	if key == "" || strings.ContainsAny(key, "\"'#[]{}") || strings.HasPrefix(key, "-") {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(line[i+1:]), true
+}
+
+//解析标量值，去掉引号和行尾注释.
+func frontMatterScalar(value string) string {
+	if len(value) >= 2 {
+		if value[0] == '"' && value[len(value)-1] == '"' {
+			if s, err := strconv.Unquote(value); err == nil {
+				return s
+			}
+			return value[1 : len(value)-1]
+		}
+		if value[0] == '\'' && value[len(value)-1] == '\'' {
+			return strings.Replace(value[1:len(value)-1], "''", "'", -1)
+		}
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
+
+//生成元数据文本，没有字段时返回空字符串.
+func FormatFrontMatter(fields []FrontMatterField) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	var buf strings.Builder
+	buf.WriteString("---\n")
+	for _, field := range fields {
+		switch {
+		case field.IsList && len(field.List) == 0:
+			buf.WriteString(field.Key + ": []\n")
+		case field.IsList:
+			buf.WriteString(field.Key + ":\n")
+			for _, item := range field.List {
+				buf.WriteString("  - " + quoteFrontMatter(item) + "\n")
+			}
+		case len(field.Children) > 0:
+			buf.WriteString(field.Key + ":\n")
+			for _, child := range field.Children {
+				buf.WriteString("  " + child.Key + ": " + quoteFrontMatter(child.Value) + "\n")
+			}
+		default:
+			buf.WriteString(field.Key + ": " + quoteFrontMatter(field.Value) + "\n")
+		}
+	}
+	buf.WriteString("---\n")
+	return buf.String()
+}
+
+//替换 Markdown 开头的元数据，fields 为空时删除元数据.
+func ReplaceFrontMatter(markdown string, fields []FrontMatterField) string {
+	_, body, _ := ParseFrontMatter(markdown)
+	if len(fields) == 0 {
+		return body
+	}
+	return FormatFrontMatter(fields) + "\n" + body
+}
+
+//值包含特殊字符时加上双引号.
+func quoteFrontMatter(value string) string {
+	if value == "" || value != strings.TrimSpace(value) || strings.ContainsAny(value[:1], "\"'[]{}#&*!|>%@`-,?") ||
+		strings.Contains(value, ": ") || strings.Contains(value, " #") || strings.HasSuffix(value, ":") || strings.ContainsAny(value, "\n\t") {
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/TruthHun/html2article"
 	"github.com/alexcesaro/mail/mailer"
 
+	"mime"
 	"net/mail"
 
 	"path/filepath"
@@ -86,21 +87,35 @@ func ScoreFloat(score int) string {
 //@param            email           收件人
 //@param            body            邮件内容
 func SendMail(conf *conf.SmtpConf, subject, email string, body string) error {
+	from := conf.FormUserName
+	if !strings.Contains(from, "@") {
+		//发件人只配置了显示名称时，使用SMTP账号作为发件地址
+		address := conf.SmtpUserName
+		if !strings.Contains(address, "@") {
+			address = "no-reply@" + conf.SmtpHost
+		}
+		from = (&mail.Address{Name: conf.FormUserName, Address: address}).String()
+	}
+	header := mail.Header{
+		"From":         {from},
+		"To":           {email},
+		"Subject":      {mime.QEncoding.Encode("UTF-8", subject)},
+		"Content-Type": {"text/html; charset=UTF-8"},
+	}
+	if conf.ReplyUserName != "" {
+		header["Reply-To"] = []string{conf.ReplyUserName}
+	}
 	msg := &mail.Message{
-		mail.Header{
-			"From":         {conf.FormUserName},
-			"To":           {email},
-			"Reply-To":     {conf.ReplyUserName},
-			"Subject":      {subject},
-			"Content-Type": {"text/html"},
-		},
-		strings.NewReader(body),
+		Header: header,
+		Body:   strings.NewReader(body),
 	}
 	port := conf.SmtpPort
 	host := conf.SmtpHost
-	username := conf.FormUserName
-	password := conf.SmtpPassword
-	m := mailer.NewMailer(host, username, password, port)
+	//未配置SMTP账号时不进行认证，便于使用本地SMTP服务进行调试
+	if conf.SmtpUserName == "" {
+		return mailer.NewCustomMailer(nil, fmt.Sprintf("%s:%d", host, port)).Send(msg)
+	}
+	m := mailer.NewMailer(host, conf.SmtpUserName, conf.SmtpPassword, port)
 	return m.Send(msg)
 }
 